@@ -0,0 +1,189 @@
+// Command script runs a drawing script, see package script, and writes the resulting canvas.
+// The script is run on a blank canvas, or on a document of a canvas server with --server and --doc.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	flags "github.com/spf13/pflag"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+	"github.com/hexbee-net/sketch-canvas/pkg/formats"
+	"github.com/hexbee-net/sketch-canvas/pkg/script"
+)
+
+// Default size of the canvas the scripts are run on.
+const (
+	defaultWidth  = 80
+	defaultHeight = 25
+)
+
+func main() {
+	var args struct {
+		width  uint
+		height uint
+		format string
+		output string
+		server string
+		doc    string
+	}
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] FILE\n\nRun a drawing script and write the resulting canvas.\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	flags.UintVar(&args.width, "width", defaultWidth, "the width of the blank canvas the script is run on")
+	flags.UintVar(&args.height, "height", defaultHeight, "the height of the blank canvas the script is run on")
+	flags.StringVarP(&args.format, "format", "f", formats.Text, "the format of the resulting canvas - txt, html, ans, xp or asciiflow")
+	flags.StringVarP(&args.output, "output", "o", "", "the file the resulting canvas is written to, instead of the standard output")
+	flags.StringVar(&args.server, "server", "http://localhost:8800", "the URL of the canvas server the document is on")
+	flags.StringVar(&args.doc, "doc", "", "the id of a document the script is run on and saved to, instead of a blank canvas")
+
+	flags.Parse()
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2) //nolint:gomnd
+	}
+
+	file := flags.Arg(0)
+
+	format, ok := formats.Lookup(args.format)
+	if !ok || !format.CanEncode() {
+		fail("unsupported format %q", args.format)
+	}
+
+	src, err := os.ReadFile(file)
+	if err != nil {
+		fail("%v", err)
+	}
+
+	var doc *canvas.Canvas
+
+	if args.doc != "" {
+		doc, err = runRemote(args.server, args.doc, src)
+	} else {
+		doc, err = runLocal(args.width, args.height, src)
+	}
+
+	if err != nil {
+		var errs script.ErrorList
+		if xerrors.As(err, &errs) {
+			for _, e := range errs {
+				fmt.Fprintf(os.Stderr, "%s:%d:%d: %v\n", file, e.Line, e.Column, e.Err)
+			}
+
+			os.Exit(1)
+		}
+
+		var posErr *script.PositionError
+		if xerrors.As(err, &posErr) {
+			fail("%s:%d: %v", file, posErr.Line, posErr.Err)
+		}
+
+		fail("%v", err)
+	}
+
+	out := io.Writer(os.Stdout)
+
+	if args.output != "" {
+		f, err := os.Create(args.output)
+		if err != nil {
+			fail("%v", err)
+		}
+
+		defer f.Close()
+
+		out = f
+	}
+
+	if err := format.Encode(out, doc); err != nil {
+		fail("failed to write canvas: %v", err)
+	}
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+// runLocal runs a script on a blank canvas.
+func runLocal(width, height uint, src []byte) (*canvas.Canvas, error) {
+	s, err := script.Parse(bytes.NewReader(src))
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	doc := &canvas.Canvas{Width: width, Height: height}
+
+	return doc, s.Run(doc)
+}
+
+// runRemote runs a script on a document of a canvas server. The errors of the script are returned as an ErrorList,
+// or a PositionError for a failed command, the other errors of the server with the detail of their problem.
+func runRemote(server, docID string, src []byte) (*canvas.Canvas, error) {
+	url := strings.TrimSuffix(server, "/") + "/v1/docs/" + docID + "/script"
+
+	resp, err := http.Post(url, "text/plain", bytes.NewReader(src)) //nolint:gosec,noctx
+	if err != nil {
+		return nil, xerrors.Errorf("failed to send script: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read response: %w", err)
+	}
+
+	var result struct {
+		Detail string `json:"detail"`
+		Errors []struct {
+			Line    int    `json:"line"`
+			Column  int    `json:"column"`
+			Message string `json:"message"`
+		} `json:"errors"`
+		Results []struct {
+			Line   int    `json:"line"`
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		} `json:"results"`
+		Canvas *canvas.Canvas `json:"canvas"`
+	}
+
+	if json.Unmarshal(body, &result) != nil {
+		return nil, xerrors.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if len(result.Errors) > 0 {
+		errs := make(script.ErrorList, 0, len(result.Errors))
+		for _, e := range result.Errors {
+			errs = append(errs, &script.PositionError{Line: e.Line, Column: e.Column, Err: script.Error(e.Message)})
+		}
+
+		return nil, errs
+	}
+
+	for _, r := range result.Results {
+		if r.Error != "" {
+			return nil, &script.PositionError{Line: r.Line, Column: 1, Err: script.Error(r.Error)}
+		}
+	}
+
+	if result.Canvas == nil {
+		if result.Detail != "" {
+			return nil, xerrors.Errorf("%s: %s", resp.Status, result.Detail)
+		}
+
+		return nil, xerrors.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	return result.Canvas, nil
+}
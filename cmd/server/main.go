@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"time"
 
@@ -19,6 +20,15 @@ const (
 	defaultPort = 8800
 )
 
+// Defaults of the Redis client.
+const (
+	defaultDialTimeout     = time.Second * 5
+	defaultReadTimeout     = time.Second * 3
+	defaultMaxRetries      = 3
+	defaultMinRetryBackoff = time.Millisecond * 8
+	defaultMaxRetryBackoff = time.Millisecond * 512
+)
+
 func main() {
 	log.SetHandler(logJson.New(os.Stdout))
 
@@ -28,7 +38,10 @@ func main() {
 		verbose bool
 		debug   bool
 
-		storeOptions datastore.RedisOptions
+		store        string
+		storeOptions datastore.Options
+
+		migrateKeys bool
 	}
 
 	flags.DurationVarP(&args.wait, "graceful-timeout", "w", defaultWait, "the duration for which the server gracefully wait for existing connections to finish - e.g. 15s or 1m")
@@ -37,9 +50,29 @@ func main() {
 	flags.BoolVarP(&args.verbose, "verbose", "v", false, "verbose mode")
 	flags.BoolVar(&args.debug, "debug", false, "debug mode")
 
-	flags.StringVarP(&args.storeOptions.Addr, "datastore", "s", "localhost:6379", "the hostname of the redis datastore")
-	flags.StringVar(&args.storeOptions.Password, "datastore-password", "", "the password of the redis server")
-	flags.IntVar(&args.storeOptions.DB, "datastore-db", 0, "the database to be selected on the redis server")
+	flags.StringVarP(&args.store, "datastore", "s", "localhost:6379", "the datastore, as the hostname of a redis server or a data source name - e.g. redis://:password@localhost:6379/0, file:///var/lib/sketch-canvas, sqlite:///var/lib/sketch-canvas.db or memory://")
+	flags.StringVar(&args.storeOptions.Driver, "datastore-driver", datastore.DriverRedis, "the datastore implementation, redis, file, sqlite or memory - the memory datastore loses the documents on exit")
+	flags.StringVar(&args.storeOptions.Path, "datastore-path", "data", "the directory of the file datastore or the database file of the sqlite datastore")
+	flags.StringVar(&args.storeOptions.Redis.Password, "datastore-password", "", "the password of the redis server")
+	flags.StringVar(&args.storeOptions.Redis.Layout, "datastore-redis-layout", datastore.RedisLayoutHash, "the layout of the documents written in redis, hash or string - documents are read in both layouts")
+	flags.StringVar(&args.storeOptions.Redis.Prefix, "datastore-redis-prefix", datastore.DefaultRedisPrefix, "the prefix of the keys written in redis, so that the database can be shared with other applications")
+	flags.IntVar(&args.storeOptions.Redis.DB, "datastore-db", 0, "the database to be selected on the redis server")
+	flags.IntVar(&args.storeOptions.Redis.PoolSize, "datastore-pool-size", 0, "the maximum number of connections to the redis server - 0 uses 10 connections per CPU")
+	flags.IntVar(&args.storeOptions.Redis.MinIdleConns, "datastore-min-idle-conns", 0, "the minimum number of idle connections kept open to the redis server")
+	flags.DurationVar(&args.storeOptions.Redis.DialTimeout, "datastore-dial-timeout", defaultDialTimeout, "the timeout for establishing new connections to the redis server")
+	flags.DurationVar(&args.storeOptions.Redis.ReadTimeout, "datastore-read-timeout", defaultReadTimeout, "the timeout for reading a reply from the redis server")
+	flags.DurationVar(&args.storeOptions.Redis.WriteTimeout, "datastore-write-timeout", defaultReadTimeout, "the timeout for sending a command to the redis server")
+	flags.DurationVar(&args.storeOptions.Redis.PoolTimeout, "datastore-pool-timeout", 0, "the time a request waits for a free connection when all of them are busy - 0 uses the read timeout plus 1s")
+	flags.IntVar(&args.storeOptions.Redis.MaxRetries, "datastore-max-retries", defaultMaxRetries, "the maximum number of retries of a failed redis command - -1 disables retries")
+	flags.DurationVar(&args.storeOptions.Redis.MinRetryBackoff, "datastore-min-retry-backoff", defaultMinRetryBackoff, "the minimum backoff between retries of a redis command")
+	flags.DurationVar(&args.storeOptions.Redis.MaxRetryBackoff, "datastore-max-retry-backoff", defaultMaxRetryBackoff, "the maximum backoff between retries of a redis command")
+
+	flags.DurationVar(&args.storeOptions.Retention, "retention", 0, "the lifetime without edits of the documents created without ttl, e.g. 720h - 0 keeps them forever")
+	flags.DurationVar(&args.storeOptions.PurgeDelay, "trash-purge-delay", datastore.DefaultPurgeDelay, "the time the deleted documents stay in the trash before being purged - 0 keeps them until purged explicitly")
+	flags.IntVar(&args.storeOptions.UndoDepth, "undo-depth", server.DefaultUndoDepth, "the number of their last drawing operations the callers identified by the X-Caller-ID header can undo on a document - 0 disables undo")
+	flags.DurationVar(&args.storeOptions.SweepInterval, "sweep-interval", datastore.DefaultSweepInterval, "the interval at which the expired documents are deleted and the trash is purged - redis expires the documents by itself, only its indexes are cleaned up")
+
+	flags.BoolVar(&args.migrateKeys, "migrate-redis-keys", false, "move the documents stored without prefix by previous versions under the key prefix, then exit")
 
 	flags.Parse()
 
@@ -54,6 +87,16 @@ func main() {
 		log.SetLevel(log.DebugLevel)
 	}
 
+	if err := args.storeOptions.ParseDSN(args.store); err != nil {
+		log.WithError(err).Fatal("invalid datastore")
+	}
+
+	if args.migrateKeys {
+		migrateKeys(&args.storeOptions)
+
+		os.Exit(0)
+	}
+
 	srv, err := server.New(args.port, &args.storeOptions)
 	if err != nil {
 		log.WithError(err).Fatal("failed to instantiate server")
@@ -64,3 +107,26 @@ func main() {
 
 	os.Exit(0)
 }
+
+// migrateKeys moves the documents of a Redis datastore under the key prefix.
+func migrateKeys(options *datastore.Options) {
+	if options.Driver != datastore.DriverRedis {
+		log.Fatal("only redis datastores have keys to migrate")
+	}
+
+	ctx := context.Background()
+
+	store, err := datastore.New(&options.Redis, ctx)
+	if err != nil {
+		log.WithError(err).Fatal("failed to connect to datastore")
+	}
+
+	migrated, err := store.MigrateKeys(ctx)
+	_ = store.Close()
+
+	if err != nil {
+		log.WithError(err).WithField("migrated", migrated).Fatal("failed to migrate keys")
+	}
+
+	fmt.Printf("migrated %d documents\n", migrated)
+}
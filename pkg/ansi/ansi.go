@@ -0,0 +1,357 @@
+// Package ansi reads and writes canvases as ANSI art files.
+//
+// The decoder emulates the subset of the ANSI.SYS terminal used by ANSI art editors:
+// cursor movement, screen and line erasure and SGR color attributes. The characters
+// are kept as code page 437 bytes and the colors are stored in the color layer of the canvas.
+package ansi
+
+import (
+	"io"
+
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+	"github.com/hexbee-net/sketch-canvas/pkg/sauce"
+)
+
+const (
+	// DefaultWidth is the width of the canvas when the file doesn't have a SAUCE record specifying it.
+	DefaultWidth = 80
+	// MaxHeight is the maximum number of lines of an imported file.
+	MaxHeight = 4096
+	// MaxCells is the maximum number of cells of an imported file, the same limit as formats.MaxCells.
+	MaxCells = 1 << 22
+
+	tabSize = 8
+	blank   = ' '
+)
+
+const (
+	esc = 0x1b
+	csi = '['
+)
+
+// ansiColors maps the ANSI color indexes to the palette indexes of the canvas.
+var ansiColors = [8]byte{0, 4, 2, 6, 1, 5, 3, 7} //nolint:gochecknoglobals
+
+// Decode parses an ANSI art file into a canvas.
+// The SAUCE record of the file, if any, provides the width, name and author of the canvas.
+func Decode(r io.Reader) (*canvas.Canvas, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read ansi content: %w", err)
+	}
+
+	rec, content := sauce.Split(data)
+
+	var width, height uint = DefaultWidth, 0
+	if rec != nil {
+		if rec.Width() > 0 {
+			width = rec.Width()
+		}
+
+		height = rec.Height()
+	}
+
+	if height > MaxHeight {
+		height = MaxHeight
+	}
+
+	if width > MaxCells || width*height > MaxCells {
+		return nil, canvas.ObjectTooLarge
+	}
+
+	t := newTerminal(width)
+	if err := t.write(content); err != nil {
+		return nil, err
+	}
+
+	c := t.canvas(height)
+	if c.Height == 0 {
+		return nil, canvas.EmptyContent
+	}
+
+	if rec != nil {
+		c.Name = rec.Title
+		c.Author = rec.Author
+	}
+
+	return c, nil
+}
+
+type cell struct {
+	char byte
+	attr byte
+}
+
+// terminal is the state of the emulated screen.
+type terminal struct {
+	width uint
+	// maxHeight is the number of lines of the screen, bounded by MaxHeight and MaxCells.
+	maxHeight uint
+	rows      [][]cell
+	x, y      uint
+	sx, sy    uint
+
+	// wrap is set when the cursor reached the end of a line,
+	// the next printed character goes to the start of the following line.
+	wrap bool
+
+	fg, bg  byte
+	bold    bool
+	blink   bool
+	colored bool
+}
+
+func newTerminal(width uint) *terminal {
+	t := &terminal{width: width, maxHeight: MaxCells / width}
+	if t.maxHeight > MaxHeight {
+		t.maxHeight = MaxHeight
+	}
+
+	t.reset()
+
+	return t
+}
+
+func (t *terminal) reset() {
+	t.fg, t.bg = canvas.AttrColors(canvas.DefaultAttr)
+	t.bold = false
+	t.blink = false
+}
+
+func (t *terminal) attr() byte {
+	fg, bg := t.fg, t.bg
+	if t.bold {
+		fg |= 0x08
+	}
+
+	// High intensity backgrounds replace blinking (iCE colors).
+	if t.blink {
+		bg |= 0x08
+	}
+
+	return canvas.Attr(fg, bg)
+}
+
+func (t *terminal) write(data []byte) error {
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; c {
+		case esc:
+			if i+1 < len(data) && data[i+1] == csi {
+				i = t.sequence(data, i+2)
+			}
+		case '\r':
+			t.x, t.wrap = 0, false
+		case '\n':
+			t.x, t.y, t.wrap = 0, t.y+1, false
+		case '\t':
+			t.x = (t.x/tabSize + 1) * tabSize
+			if t.x >= t.width {
+				t.x = t.width - 1
+			}
+		case sauce.EOF:
+			return nil
+		default:
+			t.print(c)
+		}
+
+		if t.y >= t.maxHeight {
+			return canvas.ObjectTooLarge
+		}
+	}
+
+	return nil
+}
+
+func (t *terminal) print(c byte) {
+	if t.wrap {
+		t.x, t.y, t.wrap = 0, t.y+1, false
+	}
+
+	attr := t.attr()
+	if attr != canvas.DefaultAttr {
+		t.colored = true
+	}
+
+	t.line(t.y)[t.x] = cell{char: c, attr: attr}
+
+	if t.x+1 < t.width {
+		t.x++
+	} else {
+		t.wrap = true
+	}
+}
+
+// line returns the cells of a line, growing the screen if needed.
+func (t *terminal) line(y uint) []cell {
+	for uint(len(t.rows)) <= y {
+		row := make([]cell, t.width)
+		for i := range row {
+			row[i] = cell{char: blank, attr: canvas.DefaultAttr}
+		}
+
+		t.rows = append(t.rows, row)
+	}
+
+	return t.rows[y]
+}
+
+// sequence executes the control sequence starting at data[start] and returns the index of its final byte.
+func (t *terminal) sequence(data []byte, start int) int {
+	var (
+		params []uint
+		cur    uint
+		hasCur bool
+		i      int
+	)
+
+	for i = start; i < len(data); i++ {
+		c := data[i]
+
+		switch {
+		case c >= '0' && c <= '9':
+			cur = cur*10 + uint(c-'0') //nolint:gomnd
+			hasCur = true
+		case c == ';':
+			params = append(params, cur)
+			cur, hasCur = 0, false
+		case c >= 0x40 && c <= 0x7e:
+			if hasCur || len(params) > 0 {
+				params = append(params, cur)
+			}
+
+			t.execute(c, params)
+
+			return i
+		}
+	}
+
+	return i
+}
+
+func param(params []uint, i int, def uint) uint {
+	if i >= len(params) || params[i] == 0 {
+		return def
+	}
+
+	return params[i]
+}
+
+func (t *terminal) execute(cmd byte, params []uint) {
+	switch cmd {
+	case 'A':
+		t.move(0, -int(param(params, 0, 1)))
+	case 'B':
+		t.move(0, int(param(params, 0, 1)))
+	case 'C':
+		t.move(int(param(params, 0, 1)), 0)
+	case 'D':
+		t.move(-int(param(params, 0, 1)), 0)
+	case 'H', 'f':
+		t.x, t.y, t.wrap = 0, 0, false
+		t.move(int(param(params, 1, 1))-1, int(param(params, 0, 1))-1)
+	case 'J':
+		if param(params, 0, 0) == 2 { //nolint:gomnd
+			t.rows = nil
+			t.x, t.y, t.wrap = 0, 0, false
+		}
+	case 'K':
+		line := t.line(t.y)
+		for x := t.x; x < t.width; x++ {
+			line[x] = cell{char: blank, attr: canvas.DefaultAttr}
+		}
+	case 'm':
+		t.sgr(params)
+	case 's':
+		t.sx, t.sy = t.x, t.y
+	case 'u':
+		t.x, t.y, t.wrap = t.sx, t.sy, false
+	}
+}
+
+func (t *terminal) move(dx, dy int) {
+	x, y := int(t.x)+dx, int(t.y)+dy
+
+	switch {
+	case x < 0:
+		x = 0
+	case x >= int(t.width):
+		x = int(t.width) - 1
+	}
+
+	if y < 0 {
+		y = 0
+	}
+
+	t.x, t.y, t.wrap = uint(x), uint(y), false
+}
+
+// sgr applies a Select Graphic Rendition sequence.
+func (t *terminal) sgr(params []uint) {
+	if len(params) == 0 {
+		t.reset()
+
+		return
+	}
+
+	for _, p := range params {
+		switch {
+		case p == 0:
+			t.reset()
+		case p == 1:
+			t.bold = true
+		case p == 5: //nolint:gomnd
+			t.blink = true
+		case p == 22: //nolint:gomnd
+			t.bold = false
+		case p == 25: //nolint:gomnd
+			t.blink = false
+		case p >= 30 && p <= 37:
+			t.fg = ansiColors[p-30]
+		case p == 39: //nolint:gomnd
+			t.fg, _ = canvas.AttrColors(canvas.DefaultAttr)
+		case p >= 40 && p <= 47:
+			t.bg = ansiColors[p-40]
+		case p == 49: //nolint:gomnd
+			_, t.bg = canvas.AttrColors(canvas.DefaultAttr)
+		case p >= 90 && p <= 97:
+			t.fg = ansiColors[p-90] | 0x08
+		case p >= 100 && p <= 107:
+			t.bg = ansiColors[p-100] | 0x08
+		}
+	}
+}
+
+// canvas returns the content of the screen, with at least minHeight lines.
+func (t *terminal) canvas(minHeight uint) *canvas.Canvas {
+	if minHeight > t.maxHeight {
+		minHeight = t.maxHeight
+	}
+
+	if uint(len(t.rows)) < minHeight && len(t.rows) > 0 {
+		t.line(minHeight - 1)
+	}
+
+	c := &canvas.Canvas{
+		Width:  t.width,
+		Height: uint(len(t.rows)),
+		Data:   make([]byte, 0, t.width*uint(len(t.rows))),
+	}
+
+	if t.colored {
+		c.Attrs = make([]byte, 0, cap(c.Data))
+	}
+
+	for _, row := range t.rows {
+		for _, cl := range row {
+			c.Data = append(c.Data, cl.char)
+
+			if t.colored {
+				c.Attrs = append(c.Attrs, cl.attr)
+			}
+		}
+	}
+
+	return c
+}
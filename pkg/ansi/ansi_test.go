@@ -0,0 +1,148 @@
+package ansi
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+	"github.com/hexbee-net/sketch-canvas/pkg/sauce"
+)
+
+func TestDecode(t *testing.T) {
+	red := canvas.Attr(4, 0)
+	brightOnBlue := canvas.Attr(14, 1)
+	def := canvas.DefaultAttr
+
+	tests := []struct {
+		name     string
+		data     string
+		width    uint
+		expected *canvas.Canvas
+		wantErr  bool
+	}{
+		{
+			name: "plain",
+			data: "ab\r\ncd",
+			expected: &canvas.Canvas{
+				Width:  DefaultWidth,
+				Height: 2,
+				Data:   []byte("ab" + strings.Repeat(" ", 78) + "cd" + strings.Repeat(" ", 78)),
+			},
+		},
+		{
+			name: "colors",
+			data: "\x1b[31ma\x1b[1;33;44mb\x1b[0mc",
+			expected: &canvas.Canvas{
+				Width:  DefaultWidth,
+				Height: 1,
+				Data:   []byte("abc" + strings.Repeat(" ", 77)),
+				Attrs:  append([]byte{red, brightOnBlue}, []byte(strings.Repeat(string(def), 78))...),
+			},
+		},
+		{
+			name: "cursor movement",
+			data: "\x1b[2;3Hx\x1b[1;1Hy\x1b[2Cz\x1b[By\x1b[4Dw",
+			expected: &canvas.Canvas{
+				Width:  DefaultWidth,
+				Height: 2,
+				Data:   []byte("y  z" + strings.Repeat(" ", 76) + " wx y" + strings.Repeat(" ", 75)),
+			},
+		},
+		{
+			name: "wrap at width",
+			data: strings.Repeat("x", DefaultWidth) + "\r\ny",
+			expected: &canvas.Canvas{
+				Width:  DefaultWidth,
+				Height: 2,
+				Data:   []byte(strings.Repeat("x", DefaultWidth) + "y" + strings.Repeat(" ", 79)),
+			},
+		},
+		{
+			name: "eof marker",
+			data: "a\x1aignored",
+			expected: &canvas.Canvas{
+				Width:  DefaultWidth,
+				Height: 1,
+				Data:   []byte("a" + strings.Repeat(" ", 79)),
+			},
+		},
+		{
+			name:    "empty",
+			data:    "\x1b[0m",
+			wantErr: true,
+		},
+		{
+			name:    "too large",
+			data:    "\x1b[5000Bx",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Decode(strings.NewReader(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.expected, c)
+		})
+	}
+}
+
+func TestDecodeTooLarge(t *testing.T) {
+	wide := func(height uint16, content string) []byte {
+		rec := &sauce.Record{DataType: sauce.DataTypeCharacter, TInfo1: 65535, TInfo2: height}
+
+		return append([]byte(content+"\x1a"), rec.Bytes()...)
+	}
+
+	// The size given by the SAUCE record is checked before the screen is allocated.
+	_, err := Decode(bytes.NewReader(wide(MaxHeight, "x")))
+	assert.ErrorIs(t, err, canvas.ObjectTooLarge)
+
+	// The lines of the content are limited by the number of cells of the screen.
+	_, err = Decode(bytes.NewReader(wide(0, strings.Repeat("\r\n", MaxCells/65535)+"x")))
+	assert.ErrorIs(t, err, canvas.ObjectTooLarge)
+
+	c, err := Decode(bytes.NewReader(wide(2, "x")))
+	assert.NoError(t, err)
+	assert.Equal(t, uint(65535), c.Width)
+	assert.Equal(t, uint(2), c.Height)
+}
+
+func TestEncode(t *testing.T) {
+	c := &canvas.Canvas{
+		Name:   "art",
+		Author: "me",
+		Width:  3,
+		Height: 2,
+		Data:   []byte("ab\xdbcd "),
+		Attrs:  []byte{canvas.DefaultAttr, canvas.Attr(12, 1), canvas.Attr(12, 1), canvas.Attr(0, 15), canvas.DefaultAttr, canvas.DefaultAttr},
+	}
+
+	buf := &bytes.Buffer{}
+	err := Encode(buf, c)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), "\x1b[0ma\x1b[0;1;31;44mb\xdb\r\n\x1b[0;5;30;47mc\x1b[0;37;40md \r\n"))
+
+	// Round trip, the SAUCE record gives back the size, name and author of the canvas.
+	decoded, err := Decode(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, c, decoded)
+}
+
+func TestEncodeUTF8(t *testing.T) {
+	c := &canvas.Canvas{
+		Width:  3,
+		Height: 2,
+		Data:   []byte("a\x00\xdb\xc4\xc4\xbf"),
+		Attrs:  []byte{canvas.DefaultAttr, canvas.DefaultAttr, canvas.Attr(12, 1), canvas.DefaultAttr, canvas.DefaultAttr, canvas.DefaultAttr},
+	}
+
+	buf := &bytes.Buffer{}
+	err := EncodeUTF8(buf, c)
+	assert.NoError(t, err)
+	assert.Equal(t, "\x1b[0ma \x1b[0;1;31;44m█\r\n\x1b[0;37;40m──┐\r\n\x1b[0m", buf.String())
+}
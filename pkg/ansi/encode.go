@@ -0,0 +1,104 @@
+package ansi
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+	"github.com/hexbee-net/sketch-canvas/pkg/sauce"
+)
+
+const (
+	colorMask = 0x07
+	intensity = 0x08
+)
+
+// Encode writes a canvas as ANSI art.
+// The file ends with a SAUCE record holding the dimensions, name and author of the canvas.
+func Encode(w io.Writer, c *canvas.Canvas) error {
+	buf := bufio.NewWriter(w)
+
+	writeScreen(buf, c, func(b byte) { _ = buf.WriteByte(b) })
+	_ = buf.WriteByte(sauce.EOF)
+
+	rec := sauce.Record{
+		Title:    c.Name,
+		Author:   c.Author,
+		DataType: sauce.DataTypeCharacter,
+		FileType: sauce.FileTypeANSI,
+		TInfo1:   uint16(c.Width),
+		TInfo2:   uint16(c.Height),
+	}
+
+	_, _ = buf.Write(rec.Bytes())
+
+	if err := buf.Flush(); err != nil {
+		return xerrors.Errorf("failed to write ansi content: %w", err)
+	}
+
+	return nil
+}
+
+// EncodeUTF8 writes a canvas as colored text for a terminal.
+// The characters are converted from code page 437 to UTF-8 and no SAUCE record is added.
+func EncodeUTF8(w io.Writer, c *canvas.Canvas) error {
+	buf := bufio.NewWriter(w)
+
+	writeScreen(buf, c, func(b byte) {
+		if b == 0 {
+			b = blank
+		}
+
+		_, _ = buf.WriteRune(canvas.DecodeRune(b))
+	})
+
+	if err := buf.Flush(); err != nil {
+		return xerrors.Errorf("failed to write ansi content: %w", err)
+	}
+
+	return nil
+}
+
+// writeScreen writes the lines of a canvas with the sequences selecting their colors.
+// The characters are written with the given function.
+func writeScreen(buf *bufio.Writer, c *canvas.Canvas, write func(b byte)) {
+	_, _ = buf.WriteString("\x1b[0m")
+
+	current := canvas.DefaultAttr
+
+	for y, line := range c.Split() {
+		for x := 0; x < len(line); x++ {
+			if attr := c.GetAttr(uint(x), uint(y)); attr != current {
+				writeSGR(buf, attr)
+				current = attr
+			}
+
+			write(line[x])
+		}
+
+		_, _ = buf.WriteString("\r\n")
+	}
+
+	_, _ = buf.WriteString("\x1b[0m")
+}
+
+// writeSGR writes the sequence selecting the colors of an attribute.
+func writeSGR(w *bufio.Writer, attr byte) {
+	fg, bg := canvas.AttrColors(attr)
+
+	_, _ = w.WriteString("\x1b[0")
+
+	if fg&intensity != 0 {
+		_, _ = w.WriteString(";1")
+	}
+
+	if bg&intensity != 0 {
+		_, _ = w.WriteString(";5")
+	}
+
+	// The color order of the palette and ANSI are mirrored, the same table converts both ways.
+	_, _ = fmt.Fprintf(w, ";%d;%dm", 30+ansiColors[fg&colorMask], 40+ansiColors[bg&colorMask]) //nolint:gomnd
+}
@@ -0,0 +1,75 @@
+package canvas
+
+import (
+	"image/color"
+)
+
+// Cells can have an optional color attribute, stored in the Attrs layer of the canvas.
+// An attribute uses the layout of the PC text mode attribute byte with high intensity
+// backgrounds (iCE colors): the low nibble is the foreground color and the high nibble
+// the background color, both indexing Palette.
+
+// DefaultAttr is the attribute of cells without explicit colors: light gray on black.
+const DefaultAttr byte = 0x07
+
+const (
+	attrColorMask  = 0x0f
+	attrBackground = 4
+)
+
+// Palette holds the 16 colors of the PC text mode, in attribute order.
+var Palette = [16]color.RGBA{ //nolint:gochecknoglobals
+	{0x00, 0x00, 0x00, 0xff}, // black
+	{0x00, 0x00, 0xaa, 0xff}, // blue
+	{0x00, 0xaa, 0x00, 0xff}, // green
+	{0x00, 0xaa, 0xaa, 0xff}, // cyan
+	{0xaa, 0x00, 0x00, 0xff}, // red
+	{0xaa, 0x00, 0xaa, 0xff}, // magenta
+	{0xaa, 0x55, 0x00, 0xff}, // brown
+	{0xaa, 0xaa, 0xaa, 0xff}, // light gray
+	{0x55, 0x55, 0x55, 0xff}, // dark gray
+	{0x55, 0x55, 0xff, 0xff}, // light blue
+	{0x55, 0xff, 0x55, 0xff}, // light green
+	{0x55, 0xff, 0xff, 0xff}, // light cyan
+	{0xff, 0x55, 0x55, 0xff}, // light red
+	{0xff, 0x55, 0xff, 0xff}, // light magenta
+	{0xff, 0xff, 0x55, 0xff}, // yellow
+	{0xff, 0xff, 0xff, 0xff}, // white
+}
+
+// Attr returns the attribute for the given foreground and background palette indexes.
+func Attr(fg, bg byte) byte {
+	return (bg&attrColorMask)<<attrBackground | fg&attrColorMask
+}
+
+// AttrColors returns the foreground and background palette indexes of an attribute.
+func AttrColors(attr byte) (fg, bg byte) {
+	return attr & attrColorMask, attr >> attrBackground
+}
+
+// HasAttrs reports whether the canvas has a color layer.
+func (c *Canvas) HasAttrs() bool {
+	return len(c.Attrs) != 0
+}
+
+// GetAttr returns the color attribute of a cell, or DefaultAttr if the canvas has no color layer
+// or if its color layer doesn't reach the cell.
+func (c *Canvas) GetAttr(x, y uint) byte {
+	if i := y*c.Width + x; i < uint(len(c.Attrs)) {
+		return c.Attrs[i]
+	}
+
+	return DefaultAttr
+}
+
+// setAttr sets the color attribute of a cell, adding a color layer to the canvas if it has none.
+func (c *Canvas) setAttr(x, y uint, attr byte) {
+	if !c.HasAttrs() {
+		c.Attrs = make([]byte, c.Width*c.Height)
+		for i := range c.Attrs {
+			c.Attrs[i] = DefaultAttr
+		}
+	}
+
+	c.Attrs[y*c.Width+x] = attr
+}
@@ -2,32 +2,133 @@ package canvas
 
 import (
 	"encoding/json"
-
-	"golang.org/x/xerrors"
+	"time"
 )
 
-const backgroundChar = '-'
+// BackgroundChar fills the cells of a canvas without content.
+const BackgroundChar = '-'
 
 type Canvas struct {
 	Name   string `json:"name,omitempty"`
+	Author string `json:"author,omitempty"`
 	Width  uint   `json:"width"`
 	Height uint   `json:"height"`
 	Data   []byte `json:"data,omitempty"`
+	// Attrs is the optional color layer of the canvas, see Attr.
+	// Drawing operations only change the characters of the cells, not their colors.
+	Attrs []byte `json:"attrs,omitempty"`
+	// Revision is incremented by the datastore each time the document is updated.
+	Revision uint64 `json:"revision,omitempty"`
+
+	// Metadata of the document, the timestamps and creator are maintained by the server.
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+	Creator     string    `json:"creator,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+
+	// Retention is the lifetime of the document without updates, 0 to keep it forever.
+	// The document is deleted at Expires, pushed back by Touch each time it is updated.
+	Retention time.Duration `json:"-"`
+	Expires   time.Time     `json:"expires"`
+
+	// Parent is the document the document was forked from, nil if it wasn't forked.
+	Parent *Parent `json:"parent,omitempty"`
+}
+
+// Parent identifies the document and the revision a fork was copied from.
+type Parent struct {
+	ID       string `json:"id"`
+	Revision uint64 `json:"revision"`
+}
+
+// MarshalJSON encodes the canvas, without the timestamps of documents created by previous versions
+// or without expiry, and with the retention in seconds.
+func (c Canvas) MarshalJSON() ([]byte, error) {
+	type canvas Canvas
+
+	v := struct {
+		canvas
+		Created   *time.Time `json:"created,omitempty"`
+		Updated   *time.Time `json:"updated,omitempty"`
+		Retention int64      `json:"retention,omitempty"`
+		Expires   *time.Time `json:"expires,omitempty"`
+	}{canvas: canvas(c), Retention: int64(c.Retention / time.Second)}
+
+	if !c.Created.IsZero() {
+		v.Created = &c.Created
+	}
+
+	if !c.Updated.IsZero() {
+		v.Updated = &c.Updated
+	}
+
+	if !c.Expires.IsZero() {
+		v.Expires = &c.Expires
+	}
+
+	return json.Marshal(v) //nolint:wrapcheck
+}
+
+// Touch records an update of the canvas at t, and postpones its expiry accordingly.
+func (c *Canvas) Touch(t time.Time) {
+	c.Updated = t
+	c.Expires = time.Time{}
+
+	if c.Retention > 0 {
+		c.Expires = t.Add(c.Retention)
+	}
+}
+
+// Clone returns a deep copy of the canvas.
+func (c *Canvas) Clone() *Canvas {
+	clone := *c
+
+	if c.Data != nil {
+		clone.Data = append(make([]byte, 0, len(c.Data)), c.Data...)
+	}
+
+	if c.Attrs != nil {
+		clone.Attrs = append(make([]byte, 0, len(c.Attrs)), c.Attrs...)
+	}
+
+	if c.Tags != nil {
+		clone.Tags = append(make([]string, 0, len(c.Tags)), c.Tags...)
+	}
+
+	if c.Parent != nil {
+		parent := *c.Parent
+		clone.Parent = &parent
+	}
+
+	return &clone
 }
 
-func (c *Canvas) MarshalBinary() (data []byte, err error) {
-	data, err = json.Marshal(c)
-	if err != nil {
-		return data, xerrors.Errorf("failed to marshal canvas to json: %w", err)
+// Validate checks that the data of the canvas is either empty or holds one character per cell,
+// and that its color layer is either empty or holds one attribute per cell.
+func (c *Canvas) Validate() error {
+	if !c.holdsCells(c.Data) {
+		return MalformedData
+	}
+
+	if !c.holdsCells(c.Attrs) {
+		return MalformedAttrs
 	}
 
-	return data, nil
+	return nil
+}
+
+// holdsCells reports whether a layer of the canvas is either empty or holds one byte per cell.
+func (c *Canvas) holdsCells(layer []byte) bool {
+	n := uint(len(layer))
+
+	return n == 0 || (c.Width != 0 && n%c.Width == 0 && n/c.Width == c.Height)
 }
 
 // Split returns the content of the canvas split into lines.
 func (c *Canvas) Split() []string {
 	if len(c.Data) == 0 {
-		c.initData(backgroundChar)
+		c.initData(BackgroundChar)
 	}
 
 	data := make([]string, 0, c.Height)
@@ -60,7 +161,7 @@ func (c *Canvas) DrawRect(rect *Rectangle, fill string, outline string) error {
 	}
 
 	if len(c.Data) == 0 {
-		c.initData(backgroundChar)
+		c.initData(BackgroundChar)
 	}
 
 	// We make a copy of the rectangle for the filling operation.
@@ -76,7 +177,7 @@ func (c *Canvas) DrawRect(rect *Rectangle, fill string, outline string) error {
 		upperOffset := rect.Origin.Y * c.Width
 		lowerOffset := (rect.Origin.Y + rect.Height - 1) * c.Width
 
-		for x := rect.Origin.X + rect.Width - 1; x >= rect.Origin.X; x-- {
+		for x := rect.Origin.X; x < rect.Origin.X+rect.Width; x++ {
 			c.Data[upperOffset+x] = outlineChar
 			c.Data[lowerOffset+x] = outlineChar
 		}
@@ -86,7 +187,7 @@ func (c *Canvas) DrawRect(rect *Rectangle, fill string, outline string) error {
 		leftOffset := rect.Origin.X
 		rightOffset := rect.Origin.X + rect.Width - 1
 
-		for y := rect.Origin.Y + rect.Height - 1 - 1; y > rect.Origin.Y; y-- {
+		for y := rect.Origin.Y + 1; y+1 < rect.Origin.Y+rect.Height; y++ {
 			yOffset := y * c.Width
 			c.Data[yOffset+leftOffset] = outlineChar
 			c.Data[yOffset+rightOffset] = outlineChar
@@ -96,17 +197,21 @@ func (c *Canvas) DrawRect(rect *Rectangle, fill string, outline string) error {
 		fillOrigin.X++
 		fillOrigin.Y++
 
-		fillWidth -= 2
-		fillHeight -= 2
+		if fillWidth < 2 || fillHeight < 2 {
+			fillWidth, fillHeight = 0, 0
+		} else {
+			fillWidth -= 2
+			fillHeight -= 2
+		}
 	}
 
 	if fill != "" {
 		fillChar := fill[0]
 
-		for y := fillOrigin.Y + fillHeight - 1; y >= fillOrigin.Y; y-- {
+		for y := fillOrigin.Y; y < fillOrigin.Y+fillHeight; y++ {
 			yOffset := y * c.Width
 
-			for x := fillOrigin.X + fillWidth - 1; x >= fillOrigin.X; x-- {
+			for x := fillOrigin.X; x < fillOrigin.X+fillWidth; x++ {
 				c.Data[yOffset+x] = fillChar
 			}
 		}
@@ -115,8 +220,31 @@ func (c *Canvas) DrawRect(rect *Rectangle, fill string, outline string) error {
 	return nil
 }
 
+// DrawText writes a line of text from origin to the right, one cell per byte.
+func (c *Canvas) DrawText(origin *Point, text string) error {
+	if origin.X >= c.Width || origin.Y >= c.Height {
+		return PointOutOfBound
+	}
+
+	if text == "" {
+		return EmptyContent
+	}
+
+	if origin.X+uint(len(text)) > c.Width {
+		return ObjectTooLarge
+	}
+
+	if len(c.Data) == 0 {
+		c.initData(BackgroundChar)
+	}
+
+	copy(c.Data[origin.Y*c.Width+origin.X:], text)
+
+	return nil
+}
+
 func (c *Canvas) FloodFill(origin *Point, fill string) error {
-	if origin.X > c.Width || origin.Y > c.Height {
+	if origin.X >= c.Width || origin.Y >= c.Height {
 		return PointOutOfBound
 	}
 
@@ -154,7 +282,7 @@ func (c *Canvas) FloodFill(origin *Point, fill string) error {
 
 		for _, d := range dir {
 			if int(x)+d.x < 0 || int(y)+d.y < 0 {
-				return
+				continue
 			}
 
 			dx := uint(int(x) + d.x)
@@ -1,9 +1,12 @@
 package canvas
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCanvas_Split(t *testing.T) {
@@ -17,6 +20,29 @@ func TestCanvas_Split(t *testing.T) {
 	assert.Equal(t, []string{"1234", "5678", "abcd", "efgh"}, lines)
 }
 
+func TestCanvas_Validate(t *testing.T) {
+	assert.NoError(t, (&Canvas{Width: 3, Height: 2}).Validate())
+	assert.NoError(t, (&Canvas{Width: 3, Height: 2, Data: []byte("abcdef")}).Validate())
+	assert.ErrorIs(t, (&Canvas{Width: 3, Height: 2, Data: []byte("ab")}).Validate(), MalformedData)
+	assert.ErrorIs(t, (&Canvas{Width: 3, Height: 2, Data: []byte("abcdefg")}).Validate(), MalformedData)
+	assert.ErrorIs(t, (&Canvas{Height: 2, Data: []byte("ab")}).Validate(), MalformedData)
+	assert.NoError(t, (&Canvas{Width: 3, Height: 2, Attrs: []byte{7, 7, 7, 7, 7, 7}}).Validate())
+	assert.ErrorIs(t, (&Canvas{Width: 3, Height: 2, Data: []byte("abcdef"), Attrs: []byte{7}}).Validate(), MalformedAttrs)
+}
+
+func TestCanvas_GetAttr(t *testing.T) {
+	c := Canvas{Width: 3, Height: 2}
+	assert.Equal(t, DefaultAttr, c.GetAttr(2, 1))
+
+	c.Attrs = []byte{1, 2, 3, 4, 5, 6}
+	assert.Equal(t, byte(6), c.GetAttr(2, 1))
+
+	// Cells past the end of a short color layer have the default colors.
+	c.Attrs = []byte{1}
+	assert.Equal(t, byte(1), c.GetAttr(0, 0))
+	assert.Equal(t, DefaultAttr, c.GetAttr(2, 1))
+}
+
 func TestCanvas_DrawRect(t *testing.T) {
 	c := Canvas{
 		Width:  10,
@@ -56,6 +82,73 @@ func TestCanvas_DrawRect(t *testing.T) {
 	assert.Equal(t, expected, string(c.Data))
 }
 
+func TestCanvas_DrawRect_Edges(t *testing.T) {
+	tests := []struct {
+		name     string
+		rect     Rectangle
+		fill     string
+		outline  string
+		expected []string
+	}{
+		{
+			name:     "origin",
+			rect:     Rectangle{Width: 3, Height: 3},
+			fill:     "#",
+			outline:  "*",
+			expected: []string{"***-", "*#*-", "***-"},
+		},
+		{
+			name:     "origin filled",
+			rect:     Rectangle{Width: 2, Height: 2},
+			fill:     "#",
+			expected: []string{"##--", "##--", "----"},
+		},
+		{
+			name:     "one cell wide",
+			rect:     Rectangle{Origin: Point{X: 3}, Width: 1, Height: 3},
+			fill:     "#",
+			outline:  "*",
+			expected: []string{"---*", "---*", "---*"},
+		},
+		{
+			name:     "one cell high",
+			rect:     Rectangle{Origin: Point{Y: 2}, Width: 4, Height: 1},
+			fill:     "#",
+			outline:  "*",
+			expected: []string{"----", "----", "****"},
+		},
+		{
+			name:     "one cell wide filled",
+			rect:     Rectangle{Width: 1, Height: 3},
+			fill:     "#",
+			expected: []string{"#---", "#---", "#---"},
+		},
+		{
+			name:     "two cells wide",
+			rect:     Rectangle{Origin: Point{X: 2, Y: 0}, Width: 2, Height: 3},
+			fill:     "#",
+			outline:  "*",
+			expected: []string{"--**", "--**", "--**"},
+		},
+		{
+			name:     "single cell",
+			rect:     Rectangle{Width: 1, Height: 1},
+			fill:     "#",
+			outline:  "*",
+			expected: []string{"*---", "----", "----"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Canvas{Width: 4, Height: 3}
+
+			assert.NoError(t, c.DrawRect(&tt.rect, tt.fill, tt.outline))
+			assert.Equal(t, tt.expected, c.Split())
+		})
+	}
+}
+
 func TestCanvas_FloodFill(t *testing.T) {
 	startState :=
 		"" +
@@ -98,3 +191,82 @@ func TestCanvas_FloodFill(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, expectedState, string(c.Data))
 }
+
+func TestCanvas_DrawText(t *testing.T) {
+	c := Canvas{Width: 6, Height: 2}
+
+	require.NoError(t, c.DrawText(&Point{X: 1, Y: 1}, "hello"))
+	assert.Equal(t, []string{"------", "-hello"}, c.Split())
+
+	assert.ErrorIs(t, c.DrawText(&Point{X: 2, Y: 1}, "hello"), ObjectTooLarge)
+	assert.ErrorIs(t, c.DrawText(&Point{X: 6, Y: 0}, "a"), PointOutOfBound)
+	assert.ErrorIs(t, c.DrawText(&Point{X: 0, Y: 0}, ""), EmptyContent)
+}
+
+func TestCanvas_FloodFill_Edges(t *testing.T) {
+	tests := []struct {
+		name     string
+		origin   Point
+		expected []string
+	}{
+		{
+			name:     "top left corner",
+			origin:   Point{},
+			expected: []string{".*..", ".*..", "...."},
+		},
+		{
+			name:     "bottom right corner",
+			origin:   Point{X: 3, Y: 2},
+			expected: []string{".*..", ".*..", "...."},
+		},
+		{
+			name:     "last column",
+			origin:   Point{X: 3, Y: 0},
+			expected: []string{".*..", ".*..", "...."},
+		},
+		{
+			name:     "last row",
+			origin:   Point{X: 1, Y: 2},
+			expected: []string{".*..", ".*..", "...."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Canvas{Width: 4, Height: 3, Data: []byte("-*---*------")}
+
+			assert.NoError(t, c.FloodFill(&tt.origin, "."))
+			assert.Equal(t, tt.expected, c.Split())
+		})
+	}
+
+	c := Canvas{Width: 4, Height: 3}
+	assert.ErrorIs(t, c.FloodFill(&Point{X: 4, Y: 0}, "."), PointOutOfBound)
+	assert.ErrorIs(t, c.FloodFill(&Point{X: 0, Y: 3}, "."), PointOutOfBound)
+}
+
+func TestCanvas_Touch(t *testing.T) {
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	doc := &Canvas{Expires: now}
+	doc.Touch(now)
+	assert.Equal(t, now, doc.Updated)
+	assert.True(t, doc.Expires.IsZero())
+
+	doc.Retention = time.Hour
+	doc.Touch(now)
+	assert.Equal(t, now.Add(time.Hour), doc.Expires)
+}
+
+func TestCanvas_MarshalJSON(t *testing.T) {
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	data, err := json.Marshal(Canvas{Width: 1, Height: 1})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"width":1,"height":1}`, string(data))
+
+	data, err = json.Marshal(&Canvas{Width: 1, Height: 1, Created: now, Updated: now, Retention: time.Hour, Expires: now.Add(time.Hour)})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"width":1,"height":1,"created":"2021-10-01T12:00:00Z","updated":"2021-10-01T12:00:00Z",`+
+		`"retention":3600,"expires":"2021-10-01T13:00:00Z"}`, string(data))
+}
@@ -0,0 +1,391 @@
+package canvas
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// Binary encoding of the canvases, used to store them:
+//
+//	magic     "SKC"
+//	version   byte
+//	flags     byte
+//	width     uvarint
+//	height    uvarint
+//	revision  uvarint
+//	name      uvarint length, bytes
+//	author    uvarint length, bytes
+//	metadata  if flagMeta is set, since version 2:
+//	  created      varint, nanoseconds since the Unix epoch, 0 if unknown
+//	  updated      varint, nanoseconds since the Unix epoch, 0 if unknown
+//	  creator      uvarint length, bytes
+//	  description  uvarint length, bytes
+//	  tags         uvarint count, then each tag as uvarint length, bytes
+//	expiry    if flagExpiry is set, since version 3:
+//	  retention    varint, nanoseconds
+//	  expires      varint, nanoseconds since the Unix epoch, 0 if unknown
+//	parent    if flagParent is set, since version 4:
+//	  id           uvarint length, bytes
+//	  revision     uvarint
+//	data      cells, if flagData is set
+//	attrs     cells, if flagAttrs is set
+//
+// Cells are encoded as their uvarint count followed by runs of identical bytes,
+// each one a uvarint length and the repeated byte. Diagrams being mostly made of
+// background and lines, runs are much smaller than the base64 content of the JSON encoding.
+const (
+	codecMagic   = "SKC"
+	codecVersion = 4
+
+	// codecMinVersion is the oldest version still decoded.
+	codecMinVersion = 1
+)
+
+const (
+	flagData = 1 << iota
+	flagAttrs
+	flagMeta
+	flagExpiry
+	flagParent
+)
+
+const InvalidEncoding = Error("invalid canvas encoding")
+
+// MarshalBinary encodes the canvas in the binary format.
+func (c *Canvas) MarshalBinary() (data []byte, err error) {
+	var flags byte
+
+	if len(c.Data) > 0 {
+		flags |= flagData
+	}
+
+	if len(c.Attrs) > 0 {
+		flags |= flagAttrs
+	}
+
+	if c.hasMetadata() {
+		flags |= flagMeta
+	}
+
+	if c.Retention != 0 || !c.Expires.IsZero() {
+		flags |= flagExpiry
+	}
+
+	if c.Parent != nil {
+		flags |= flagParent
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(codecMagic)+len(c.Name)+len(c.Author)+32)) //nolint:gomnd
+	buf.WriteString(codecMagic)
+	buf.WriteByte(codecVersion)
+	buf.WriteByte(flags)
+	writeUvarint(buf, uint64(c.Width))
+	writeUvarint(buf, uint64(c.Height))
+	writeUvarint(buf, c.Revision)
+	writeString(buf, c.Name)
+	writeString(buf, c.Author)
+
+	if flags&flagMeta != 0 {
+		writeVarint(buf, unixNano(c.Created))
+		writeVarint(buf, unixNano(c.Updated))
+		writeString(buf, c.Creator)
+		writeString(buf, c.Description)
+		writeUvarint(buf, uint64(len(c.Tags)))
+
+		for _, tag := range c.Tags {
+			writeString(buf, tag)
+		}
+	}
+
+	if flags&flagExpiry != 0 {
+		writeVarint(buf, int64(c.Retention))
+		writeVarint(buf, unixNano(c.Expires))
+	}
+
+	if flags&flagParent != 0 {
+		writeString(buf, c.Parent.ID)
+		writeUvarint(buf, c.Parent.Revision)
+	}
+
+	if flags&flagData != 0 {
+		writeRuns(buf, c.Data)
+	}
+
+	if flags&flagAttrs != 0 {
+		writeRuns(buf, c.Attrs)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a canvas encoded by MarshalBinary,
+// or by previous versions storing canvases as JSON.
+func (c *Canvas) UnmarshalBinary(data []byte) error {
+	if len(data) > 0 && data[0] == '{' {
+		doc := Canvas{}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return xerrors.Errorf("failed to unmarshal canvas from json: %w", err)
+		}
+
+		*c = doc
+
+		return nil
+	}
+
+	if !bytes.HasPrefix(data, []byte(codecMagic)) || len(data) < len(codecMagic)+2 {
+		return InvalidEncoding
+	}
+
+	r := bytes.NewReader(data[len(codecMagic):])
+	version, _ := r.ReadByte()
+	flags, _ := r.ReadByte()
+
+	if version < codecMinVersion || version > codecVersion {
+		return xerrors.Errorf("unsupported canvas encoding version %d: %w", version, InvalidEncoding)
+	}
+
+	doc := Canvas{}
+
+	width, err := binary.ReadUvarint(r)
+	if err != nil {
+		return xerrors.Errorf("failed to read width: %w", InvalidEncoding)
+	}
+
+	height, err := binary.ReadUvarint(r)
+	if err != nil {
+		return xerrors.Errorf("failed to read height: %w", InvalidEncoding)
+	}
+
+	if doc.Revision, err = binary.ReadUvarint(r); err != nil {
+		return xerrors.Errorf("failed to read revision: %w", InvalidEncoding)
+	}
+
+	doc.Width, doc.Height = uint(width), uint(height)
+
+	if doc.Name, err = readString(r); err != nil {
+		return xerrors.Errorf("failed to read name: %w", err)
+	}
+
+	if doc.Author, err = readString(r); err != nil {
+		return xerrors.Errorf("failed to read author: %w", err)
+	}
+
+	if flags&flagMeta != 0 {
+		if err := readMetadata(r, &doc); err != nil {
+			return xerrors.Errorf("failed to read metadata: %w", err)
+		}
+	}
+
+	if flags&flagExpiry != 0 {
+		if err := readExpiry(r, &doc); err != nil {
+			return xerrors.Errorf("failed to read expiry: %w", err)
+		}
+	}
+
+	if flags&flagParent != 0 {
+		if err := readParent(r, &doc); err != nil {
+			return xerrors.Errorf("failed to read parent: %w", err)
+		}
+	}
+
+	if flags&flagData != 0 {
+		if doc.Data, err = readRuns(r); err != nil {
+			return xerrors.Errorf("failed to read cells: %w", err)
+		}
+	}
+
+	if flags&flagAttrs != 0 {
+		if doc.Attrs, err = readRuns(r); err != nil {
+			return xerrors.Errorf("failed to read attributes: %w", err)
+		}
+	}
+
+	if r.Len() != 0 {
+		return xerrors.Errorf("unexpected trailing bytes: %w", InvalidEncoding)
+	}
+
+	*c = doc
+
+	return nil
+}
+
+func (c *Canvas) hasMetadata() bool {
+	return !c.Created.IsZero() || !c.Updated.IsZero() || c.Creator != "" || c.Description != "" || len(c.Tags) > 0
+}
+
+func readMetadata(r *bytes.Reader, doc *Canvas) error {
+	created, err := binary.ReadVarint(r)
+	if err != nil {
+		return InvalidEncoding
+	}
+
+	updated, err := binary.ReadVarint(r)
+	if err != nil {
+		return InvalidEncoding
+	}
+
+	doc.Created, doc.Updated = fromUnixNano(created), fromUnixNano(updated)
+
+	if doc.Creator, err = readString(r); err != nil {
+		return err
+	}
+
+	if doc.Description, err = readString(r); err != nil {
+		return err
+	}
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil || count > uint64(r.Len()) {
+		return InvalidEncoding
+	}
+
+	if count > 0 {
+		doc.Tags = make([]string, 0, count)
+	}
+
+	for i := uint64(0); i < count; i++ {
+		tag, err := readString(r)
+		if err != nil {
+			return err
+		}
+
+		doc.Tags = append(doc.Tags, tag)
+	}
+
+	return nil
+}
+
+func readExpiry(r *bytes.Reader, doc *Canvas) error {
+	retention, err := binary.ReadVarint(r)
+	if err != nil {
+		return InvalidEncoding
+	}
+
+	expires, err := binary.ReadVarint(r)
+	if err != nil {
+		return InvalidEncoding
+	}
+
+	doc.Retention, doc.Expires = time.Duration(retention), fromUnixNano(expires)
+
+	return nil
+}
+
+func readParent(r *bytes.Reader, doc *Canvas) error {
+	id, err := readString(r)
+	if err != nil {
+		return err
+	}
+
+	revision, err := binary.ReadUvarint(r)
+	if err != nil {
+		return InvalidEncoding
+	}
+
+	doc.Parent = &Parent{ID: id, Revision: revision}
+
+	return nil
+}
+
+// unixNano returns the timestamp in nanoseconds since the Unix epoch, 0 for the zero time.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano()
+}
+
+// fromUnixNano returns the UTC time of a timestamp encoded by unixNano.
+func fromUnixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, ns).UTC()
+}
+
+func writeVarint(buf *bytes.Buffer, v int64) {
+	var b [binary.MaxVarintLen64]byte
+
+	n := binary.PutVarint(b[:], v)
+	buf.Write(b[:n])
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+
+	n := binary.PutUvarint(b[:], v)
+	buf.Write(b[:n])
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	writeUvarint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+func writeRuns(buf *bytes.Buffer, cells []byte) {
+	writeUvarint(buf, uint64(len(cells)))
+
+	for i := 0; i < len(cells); {
+		j := i + 1
+		for j < len(cells) && cells[j] == cells[i] {
+			j++
+		}
+
+		writeUvarint(buf, uint64(j-i))
+		buf.WriteByte(cells[i])
+
+		i = j
+	}
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return "", InvalidEncoding
+	}
+
+	b := make([]byte, n)
+	_, _ = r.Read(b)
+
+	return string(b), nil
+}
+
+func readRuns(r *bytes.Reader) ([]byte, error) {
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, InvalidEncoding
+	}
+
+	// The count can't be trusted before the runs are read, the initial allocation is bounded by the size of the data.
+	cells := make([]byte, 0, minUint64(count, uint64(r.Len())))
+
+	for uint64(len(cells)) < count {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n == 0 || n > count-uint64(len(cells)) {
+			return nil, InvalidEncoding
+		}
+
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, InvalidEncoding
+		}
+
+		cells = append(cells, bytes.Repeat([]byte{b}, int(n))...)
+	}
+
+	return cells, nil
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
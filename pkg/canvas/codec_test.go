@@ -0,0 +1,152 @@
+package canvas
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanvas_MarshalBinary(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  *Canvas
+	}{
+		{
+			name: "empty",
+			doc:  &Canvas{},
+		},
+		{
+			name: "without cells",
+			doc:  &Canvas{Name: "doc", Author: "me", Width: 80, Height: 25, Revision: 300},
+		},
+		{
+			name: "with cells",
+			doc:  &Canvas{Name: "doc", Width: 4, Height: 2, Data: []byte("--##-@@@")},
+		},
+		{
+			name: "with metadata",
+			doc: &Canvas{
+				Name:        "doc",
+				Width:       1,
+				Height:      1,
+				Created:     time.Date(2021, 10, 1, 12, 0, 0, 1, time.UTC),
+				Updated:     time.Date(2021, 10, 2, 12, 0, 0, 0, time.UTC),
+				Creator:     "me",
+				Description: "a diagram",
+				Tags:        []string{"arch", "draft"},
+			},
+		},
+		{
+			name: "with expiry",
+			doc: &Canvas{
+				Width:     1,
+				Height:    1,
+				Retention: 24 * time.Hour,
+				Expires:   time.Date(2021, 10, 3, 12, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "with parent",
+			doc:  &Canvas{Width: 1, Height: 1, Parent: &Parent{ID: "original", Revision: 12}},
+		},
+		{
+			name: "with attributes",
+			doc:  &Canvas{Width: 2, Height: 2, Data: []byte("abcd"), Attrs: []byte{0, 0, 3, 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.doc.MarshalBinary()
+			require.NoError(t, err)
+
+			got := Canvas{}
+			require.NoError(t, got.UnmarshalBinary(data))
+			assert.Equal(t, *tt.doc, got)
+		})
+	}
+}
+
+func TestCanvas_MarshalBinary_Size(t *testing.T) {
+	doc := &Canvas{Name: "doc", Width: 200, Height: 100}
+	require.NoError(t, doc.DrawRect(&Rectangle{Origin: Point{X: 10, Y: 10}, Width: 50, Height: 20}, "#", "@"))
+
+	data, err := doc.MarshalBinary()
+	require.NoError(t, err)
+
+	legacy, err := json.Marshal(doc)
+	require.NoError(t, err)
+
+	assert.Less(t, len(data)*10, len(legacy))
+}
+
+func TestCanvas_UnmarshalBinary(t *testing.T) {
+	valid, err := (&Canvas{Width: 2, Height: 1, Data: []byte("ab")}).MarshalBinary()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected *Canvas
+		wantErr  error
+	}{
+		{
+			name:     "legacy json",
+			data:     []byte(`{"name":"doc","width":2,"height":1,"data":"YWI=","revision":3}`),
+			expected: &Canvas{Name: "doc", Width: 2, Height: 1, Data: []byte("ab"), Revision: 3},
+		},
+		{
+			name:     "version 1",
+			data:     append([]byte(codecMagic), 1, flagData, 2, 1, 3, 0, 0, 2, 1, 'a', 1, 'b'),
+			expected: &Canvas{Width: 2, Height: 1, Data: []byte("ab"), Revision: 3},
+		},
+		{
+			name:    "empty",
+			data:    nil,
+			wantErr: InvalidEncoding,
+		},
+		{
+			name:    "bad magic",
+			data:    []byte("XYZ\x01\x00"),
+			wantErr: InvalidEncoding,
+		},
+		{
+			name:    "unknown version",
+			data:    append([]byte(codecMagic), codecVersion+1, 0, 0, 0, 0, 0, 0),
+			wantErr: InvalidEncoding,
+		},
+		{
+			name:    "truncated",
+			data:    valid[:len(valid)-1],
+			wantErr: InvalidEncoding,
+		},
+		{
+			name:    "trailing bytes",
+			data:    append(append([]byte{}, valid...), 0),
+			wantErr: InvalidEncoding,
+		},
+		{
+			name:    "run overflow",
+			data:    append(bytes.TrimSuffix(valid, []byte{1, 'b'}), 2, 'b'),
+			wantErr: InvalidEncoding,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Canvas{}
+			err := got.UnmarshalBinary(tt.data)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, *tt.expected, got)
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package canvas
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// missingChar stands for the cells outside of the smaller canvas in the text of a diff.
+const missingChar = ' '
+
+// MaxDiffRegions is the maximum number of regions of a diff. Changes scattered over more groups of cells
+// are reported as a single region bounding all of them.
+const MaxDiffRegions = 1000
+
+// Diff is the cell by cell comparison of two canvases.
+// A cell changes when its character or its color differs, or when it is outside of one of the canvases.
+type Diff struct {
+	From *Canvas `json:"-"`
+	To   *Canvas `json:"-"`
+	// Width and Height cover both canvases.
+	Width   uint `json:"width"`
+	Height  uint `json:"height"`
+	Changed int  `json:"changed"`
+	// Regions are the bounding rectangles of the groups of adjacent changed cells, top to bottom and left to right.
+	// They don't overlap. Beyond MaxDiffRegions groups, a single region bounds all the changes.
+	Regions []Rectangle `json:"regions"`
+
+	changed []bool
+}
+
+// Compare returns the changes from one canvas to another.
+// It fails if the data or the color layer of one of the canvases doesn't match its size, see Canvas.Validate.
+func Compare(from, to *Canvas) (*Diff, error) {
+	for _, c := range []*Canvas{from, to} {
+		if err := c.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	d := &Diff{
+		From:   from,
+		To:     to,
+		Width:  maxUint(from.Width, to.Width),
+		Height: maxUint(from.Height, to.Height),
+	}
+
+	d.changed = make([]bool, d.Width*d.Height)
+	a, b := from.cells(), to.cells()
+
+	for y := uint(0); y < d.Height; y++ {
+		for x := uint(0); x < d.Width; x++ {
+			inFrom, inTo := x < from.Width && y < from.Height, x < to.Width && y < to.Height
+
+			same := inFrom && inTo &&
+				a[y*from.Width+x] == b[y*to.Width+x] &&
+				from.GetAttr(x, y) == to.GetAttr(x, y)
+			if !same {
+				d.changed[y*d.Width+x] = true
+				d.Changed++
+			}
+		}
+	}
+
+	d.Regions = regions(d.changed, d.Width)
+
+	return d, nil
+}
+
+// IsChanged reports whether a cell changed.
+func (d *Diff) IsChanged(x, y uint) bool {
+	return x < d.Width && y < d.Height && d.changed[y*d.Width+x]
+}
+
+// regions groups the cells set in a mask of a width wide grid that touch each other, diagonals included,
+// and merges the bounding rectangles of the groups until none of them overlap. Beyond MaxDiffRegions groups,
+// it returns the bounding rectangle of all the groups instead.
+func regions(mask []bool, width uint) []Rectangle {
+	rects := make([]Rectangle, 0)
+	seen := make([]bool, len(mask))
+
+	for i, set := range mask {
+		if !set || seen[i] {
+			continue
+		}
+
+		height := uint(len(mask)) / width
+		x0, y0 := uint(i)%width, uint(i)/width
+		minX, minY, maxX, maxY := x0, y0, x0, y0
+		stack := []uint{uint(i)}
+		seen[i] = true
+
+		for len(stack) > 0 {
+			cell := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := cell%width, cell/width
+			minX, minY, maxX, maxY = minUint(minX, x), minUint(minY, y), maxUint(maxX, x), maxUint(maxY, y)
+
+			for ny := y - 1; ny != y+2; ny++ {
+				for nx := x - 1; nx != x+2; nx++ {
+					// Neighbors out of the grid wrap around to large values.
+					if nx >= width || ny >= height || seen[ny*width+nx] || !mask[ny*width+nx] {
+						continue
+					}
+
+					seen[ny*width+nx] = true
+					stack = append(stack, ny*width+nx)
+				}
+			}
+		}
+
+		rects = append(rects, Rectangle{Origin: Point{X: minX, Y: minY}, Width: maxX - minX + 1, Height: maxY - minY + 1})
+	}
+
+	if len(rects) > MaxDiffRegions {
+		bounds := rects[0]
+		for _, r := range rects[1:] {
+			bounds = bounds.union(r)
+		}
+
+		return []Rectangle{bounds}
+	}
+
+	rects = mergeOverlapping(rects)
+
+	sort.Slice(rects, func(i, j int) bool {
+		if rects[i].Origin.Y != rects[j].Origin.Y {
+			return rects[i].Origin.Y < rects[j].Origin.Y
+		}
+
+		return rects[i].Origin.X < rects[j].Origin.X
+	})
+
+	return rects
+}
+
+// mergeOverlapping replaces the overlapping rectangles by their union until none of them overlap.
+// Each rectangle absorbs the merged rectangles it overlaps before being added to them, so that they never overlap:
+// every merge removes a rectangle, and the run time is quadratic in the number of rectangles.
+func mergeOverlapping(rects []Rectangle) []Rectangle {
+	merged := make([]Rectangle, 0, len(rects))
+
+	for _, r := range rects {
+		for i := 0; i < len(merged); {
+			if !r.overlaps(merged[i]) {
+				i++
+
+				continue
+			}
+
+			// The union may overlap the rectangles already checked, start again.
+			r = r.union(merged[i])
+			merged[i] = merged[len(merged)-1]
+			merged = merged[:len(merged)-1]
+			i = 0
+		}
+
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+func (c Rectangle) overlaps(o Rectangle) bool {
+	return c.Origin.X < o.Origin.X+o.Width && o.Origin.X < c.Origin.X+c.Width &&
+		c.Origin.Y < o.Origin.Y+o.Height && o.Origin.Y < c.Origin.Y+c.Height
+}
+
+func (c Rectangle) union(o Rectangle) Rectangle {
+	minX, minY := minUint(c.Origin.X, o.Origin.X), minUint(c.Origin.Y, o.Origin.Y)
+	maxX, maxY := maxUint(c.Origin.X+c.Width, o.Origin.X+o.Width), maxUint(c.Origin.Y+c.Height, o.Origin.Y+o.Height)
+
+	return Rectangle{Origin: Point{X: minX, Y: minY}, Width: maxX - minX, Height: maxY - minY}
+}
+
+// WriteText writes the diff as text hunks, one per region, with the lines of the region before the change
+// prefixed by - and after the change prefixed by +. Cells outside of a canvas are written as spaces.
+func (d *Diff) WriteText(w io.Writer) error {
+	var sb strings.Builder
+
+	for _, r := range d.Regions {
+		fmt.Fprintf(&sb, "@@ x=%d y=%d width=%d height=%d @@\n", r.Origin.X, r.Origin.Y, r.Width, r.Height)
+		writeLines(&sb, "-", crop(d.From, r).Text())
+		writeLines(&sb, "+", crop(d.To, r).Text())
+	}
+
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		return xerrors.Errorf("failed to write diff: %w", err)
+	}
+
+	return nil
+}
+
+func writeLines(sb *strings.Builder, prefix, text string) {
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if line != "" {
+			sb.WriteString(prefix)
+			sb.WriteString(line)
+		}
+	}
+}
+
+// crop returns the cells of a canvas in a rectangle, filled with missingChar outside of the canvas.
+func crop(c *Canvas, r Rectangle) *Canvas {
+	cells := c.cells()
+	out := &Canvas{Width: r.Width, Height: r.Height, Data: make([]byte, r.Width*r.Height)}
+
+	for y := uint(0); y < r.Height; y++ {
+		for x := uint(0); x < r.Width; x++ {
+			cx, cy := r.Origin.X+x, r.Origin.Y+y
+			if cx < c.Width && cy < c.Height {
+				out.Data[y*r.Width+x] = cells[cy*c.Width+cx]
+			} else {
+				out.Data[y*r.Width+x] = missingChar
+			}
+		}
+	}
+
+	return out
+}
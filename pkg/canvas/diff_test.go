@@ -0,0 +1,148 @@
+package canvas
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompare(t *testing.T) {
+	from := &Canvas{Width: 6, Height: 4}
+	to := &Canvas{Width: 6, Height: 4, Data: []byte("" +
+		"##----" +
+		"-#----" +
+		"----@-" +
+		"---@@-")}
+
+	d, err := Compare(from, to)
+	require.NoError(t, err)
+	assert.Equal(t, 6, d.Changed)
+	assert.Equal(t, []Rectangle{
+		{Origin: Point{X: 0, Y: 0}, Width: 2, Height: 2},
+		{Origin: Point{X: 3, Y: 2}, Width: 2, Height: 2},
+	}, d.Regions)
+	assert.True(t, d.IsChanged(1, 1))
+	assert.False(t, d.IsChanged(0, 1))
+	assert.False(t, d.IsChanged(6, 0))
+
+	// Diagonal neighbors belong to the same region.
+	to.Data[2*6+2] = '@'
+	d, err = Compare(from, to)
+	require.NoError(t, err)
+	assert.Equal(t, []Rectangle{
+		{Origin: Point{X: 0, Y: 0}, Width: 5, Height: 4},
+	}, d.Regions)
+
+	d, err = Compare(to, to.Clone())
+	require.NoError(t, err)
+	assert.Empty(t, d.Regions)
+}
+
+func TestCompare_Overlapping(t *testing.T) {
+	// The bounding rectangles of the two groups overlap, they are merged.
+	to := &Canvas{Width: 4, Height: 4, Data: []byte("" +
+		"#---" +
+		"#-@-" +
+		"###-" +
+		"--@-")}
+
+	d, err := Compare(&Canvas{Width: 4, Height: 4}, to)
+	require.NoError(t, err)
+	assert.Equal(t, []Rectangle{{Origin: Point{X: 0, Y: 0}, Width: 3, Height: 4}}, d.Regions)
+}
+
+func TestCompare_Size(t *testing.T) {
+	from := &Canvas{Width: 2, Height: 1, Data: []byte("ab")}
+	to := &Canvas{Width: 3, Height: 1, Data: []byte("abc")}
+
+	d, err := Compare(from, to)
+	require.NoError(t, err)
+	assert.Equal(t, uint(3), d.Width)
+	assert.Equal(t, 1, d.Changed)
+
+	// Colors are compared too.
+	to = &Canvas{Width: 2, Height: 1, Data: []byte("ab"), Attrs: []byte{DefaultAttr, Attr(1, 0)}}
+	d, err = Compare(from, to)
+	require.NoError(t, err)
+	assert.Equal(t, []Rectangle{{Origin: Point{X: 1, Y: 0}, Width: 1, Height: 1}}, d.Regions)
+}
+
+func TestCompare_Malformed(t *testing.T) {
+	from := &Canvas{Width: 3, Height: 2, Data: []byte("abcdef")}
+
+	// Documents stored before their layers were validated may not hold one cell per character.
+	_, err := Compare(from, &Canvas{Width: 3, Height: 2, Data: []byte("abc")})
+	assert.ErrorIs(t, err, MalformedData)
+
+	_, err = Compare(&Canvas{Width: 3, Height: 2, Attrs: []byte{DefaultAttr}}, from)
+	assert.ErrorIs(t, err, MalformedAttrs)
+}
+
+func TestDiff_WriteText(t *testing.T) {
+	from := &Canvas{Width: 3, Height: 2, Data: []byte("a--b--")}
+	to := &Canvas{Width: 2, Height: 2, Data: []byte("a-c-")}
+
+	d, err := Compare(from, to)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, d.WriteText(&buf))
+	assert.Equal(t, ""+
+		"@@ x=2 y=0 width=1 height=2 @@\n"+
+		"--\n"+
+		"--\n"+
+		"+ \n"+
+		"+ \n"+
+		"@@ x=0 y=1 width=1 height=1 @@\n"+
+		"-b\n"+
+		"+c\n", buf.String())
+}
+
+func TestCompare_Scattered(t *testing.T) {
+	// Isolated changes beyond MaxDiffRegions are reported as a single region.
+	from, to := scatteredChanges(400, 400)
+
+	d, err := Compare(from, to)
+	require.NoError(t, err)
+	assert.Equal(t, 200*200, d.Changed)
+	assert.Equal(t, []Rectangle{{Width: 399, Height: 399}}, d.Regions)
+
+	from, to = scatteredChanges(60, 60)
+
+	d, err = Compare(from, to)
+	require.NoError(t, err)
+	assert.Len(t, d.Regions, 30*30)
+}
+
+// scatteredChanges returns two width × height canvases differing by isolated cells, one every two rows and columns.
+func scatteredChanges(width, height uint) (*Canvas, *Canvas) {
+	from := &Canvas{Width: width, Height: height}
+	from.initData(BackgroundChar)
+
+	to := from.Clone()
+
+	for y := uint(0); y < height; y += 2 {
+		for x := uint(0); x < width; x += 2 {
+			to.Data[y*width+x] = '#'
+		}
+	}
+
+	return from, to
+}
+
+func BenchmarkCompare(b *testing.B) {
+	for _, size := range []uint{60, 400} {
+		from, to := scatteredChanges(size, size)
+
+		b.Run(fmt.Sprintf("%dx%d", size, size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := Compare(from, to); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -10,4 +10,8 @@ const (
 	PointOutOfBound = Error("point out of bound")
 	ObjectTooLarge  = Error("object too large")
 	BadPattern      = Error("the drawing pattern is invalid")
+	EmptyContent    = Error("the content is empty")
+	PatchMismatch   = Error("the change doesn't match the canvas")
+	MalformedData   = Error("the canvas data must be empty or hold width × height cells")
+	MalformedAttrs  = Error("the canvas attrs must be empty or hold width × height cells")
 )
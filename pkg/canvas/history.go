@@ -0,0 +1,82 @@
+package canvas
+
+import (
+	"sort"
+
+	"golang.org/x/xerrors"
+)
+
+const UnknownRevision = Error("revision not found in the history")
+
+// ProducedRevision returns the revision of the document produced by the operation,
+// false if it was recorded without it by a previous version.
+func (o *Operation) ProducedRevision() (uint64, bool) {
+	if o.Canvas != nil {
+		return o.Canvas.Revision, true
+	}
+
+	return o.Revision, o.Revision != 0
+}
+
+// History returns the operations of a journal that recorded the revision they produced, ordered by revision.
+// Operations can be recorded out of order when a document is updated concurrently.
+func History(ops []*Operation) []*Operation {
+	history := make([]*Operation, 0, len(ops))
+
+	for _, op := range ops {
+		if _, ok := op.ProducedRevision(); ok {
+			history = append(history, op)
+		}
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		ri, _ := history[i].ProducedRevision()
+		rj, _ := history[j].ProducedRevision()
+
+		return ri < rj
+	})
+
+	return history
+}
+
+// AtRevision rebuilds a canvas as it was at a revision from its journal. The operations are replayed from the last
+// operation holding the whole canvas at or before the revision, a snapshot, a revert, a merge or a checkpoint,
+// so that the checkpoints recorded along the drawing operations keep the replays short.
+func AtRevision(ops []*Operation, revision uint64) (*Canvas, error) {
+	history := History(ops)
+
+	base := -1
+
+	for i, op := range history {
+		if r, _ := op.ProducedRevision(); r > revision {
+			break
+		}
+
+		if op.Canvas != nil {
+			base = i
+		}
+	}
+
+	if base < 0 {
+		return nil, UnknownRevision
+	}
+
+	c := history[base].Canvas.Clone()
+
+	for _, op := range history[base+1:] {
+		if r, _ := op.ProducedRevision(); r > revision {
+			break
+		}
+
+		if err := op.Apply(c); err != nil {
+			return nil, xerrors.Errorf("failed to apply %s operation: %w", op.Type, err)
+		}
+
+		c.Updated = op.Time
+	}
+
+	// Revisions changing only the metadata of the document, like its lifetime, have no operation.
+	c.Revision = revision
+
+	return c, nil
+}
@@ -0,0 +1,70 @@
+package canvas
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAtRevision(t *testing.T) {
+	rect := func(x uint, fill string, revision uint64) *Operation {
+		op := NewRect(Rectangle{Origin: Point{X: x, Y: 1}, Width: 1, Height: 1}, fill, "")
+		op.Revision = revision
+
+		return op
+	}
+
+	checkpoint := rect(3, "c", 3)
+	checkpoint.Canvas = &Canvas{Name: "doc", Width: 4, Height: 2, Data: []byte("-----abc"), Revision: 3}
+
+	ops := []*Operation{
+		NewSnapshot(&Canvas{Name: "doc", Width: 4, Height: 2}),
+		// Recorded by a previous version, without revision.
+		NewFill(Point{X: 1, Y: 1}, "#"),
+		rect(2, "b", 2),
+		// Recorded out of order by concurrent updates.
+		rect(1, "a", 1),
+		checkpoint,
+		rect(1, "d", 4),
+	}
+
+	history := History(ops)
+	require.Len(t, history, 5)
+
+	for i, op := range history {
+		revision, ok := op.ProducedRevision()
+		assert.True(t, ok)
+		assert.Equal(t, uint64(i), revision)
+	}
+
+	tests := []struct {
+		revision uint64
+		want     []string
+	}{
+		{revision: 0, want: []string{"----", "----"}},
+		{revision: 1, want: []string{"----", "-a--"}},
+		{revision: 2, want: []string{"----", "-ab-"}},
+		{revision: 3, want: []string{"----", "-abc"}},
+		{revision: 4, want: []string{"----", "-dbc"}},
+		// Revisions without operation have the content of the previous one.
+		{revision: 5, want: []string{"----", "-dbc"}},
+	}
+
+	for _, tt := range tests {
+		c, err := AtRevision(ops, tt.revision)
+		require.NoError(t, err, tt.revision)
+		assert.Equal(t, tt.want, c.Split(), tt.revision)
+		assert.Equal(t, tt.revision, c.Revision)
+		assert.Equal(t, "doc", c.Name)
+	}
+
+	// Replays start from the checkpoint, its content isn't changed by them.
+	c, err := AtRevision(ops, 4)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"----", "-abc"}, checkpoint.Canvas.Split())
+	assert.NotEqual(t, c.Data, checkpoint.Canvas.Data)
+
+	_, err = AtRevision(ops[1:4], 2)
+	assert.ErrorIs(t, err, UnknownRevision)
+}
@@ -0,0 +1,93 @@
+package canvas
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+const htmlPreStyle = "margin:0;padding:0.5em;" +
+	"font-family:ui-monospace,Menlo,Consolas,'DejaVu Sans Mono',monospace;" +
+	"font-size:14px;line-height:1.2;" +
+	"color:#000;background:#fff;" +
+	"white-space:pre;overflow:auto"
+
+// RenderHTML writes the content of the canvas as a self-contained HTML <pre> block.
+// All the styling is inlined so the block can be pasted as-is in any page.
+// When the canvas has a color layer, runs of cells sharing the same colors are wrapped in styled spans.
+func (c *Canvas) RenderHTML(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+
+	if _, err := fmt.Fprintf(buf, `<pre class="sketch-canvas" style="%s">`, htmlPreStyle); err != nil {
+		return xerrors.Errorf("failed to write html block: %w", err)
+	}
+
+	for y, line := range c.Split() {
+		if y > 0 {
+			_ = buf.WriteByte('\n')
+		}
+
+		if !c.HasAttrs() {
+			for x := 0; x < len(line); x++ {
+				writeHTMLChar(buf, line[x])
+			}
+
+			continue
+		}
+
+		for x := 0; x < len(line); {
+			attr := c.GetAttr(uint(x), uint(y))
+			fg, bg := AttrColors(attr)
+
+			_, _ = fmt.Fprintf(buf, `<span style="color:%s;background:%s">`, htmlColor(Palette[fg]), htmlColor(Palette[bg]))
+
+			for ; x < len(line) && c.GetAttr(uint(x), uint(y)) == attr; x++ {
+				writeHTMLChar(buf, line[x])
+			}
+
+			_, _ = buf.WriteString("</span>")
+		}
+	}
+
+	_, _ = buf.WriteString("</pre>")
+
+	if err := buf.Flush(); err != nil {
+		return xerrors.Errorf("failed to write html block: %w", err)
+	}
+
+	return nil
+}
+
+// writeHTMLChar writes the HTML representation of a single canvas cell.
+// Markup characters are replaced by their entity, characters outside of the
+// printable ASCII range are written as numeric character references so the
+// output stays valid regardless of the page encoding.
+func writeHTMLChar(w *bufio.Writer, c byte) {
+	switch c {
+	case '<':
+		_, _ = w.WriteString("&lt;")
+	case '>':
+		_, _ = w.WriteString("&gt;")
+	case '&':
+		_, _ = w.WriteString("&amp;")
+	case '"':
+		_, _ = w.WriteString("&#34;")
+	case '\'':
+		_, _ = w.WriteString("&#39;")
+	case 0:
+		_ = w.WriteByte(' ')
+	default:
+		if r := DecodeRune(c); r < 0x80 {
+			_ = w.WriteByte(c)
+		} else {
+			_, _ = fmt.Fprintf(w, "&#x%X;", r)
+		}
+	}
+}
+
+func htmlColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,43 @@
+package canvas
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanvas_RenderHTML(t *testing.T) {
+	c := Canvas{
+		Width:  4,
+		Height: 3,
+		Data:   []byte("<a>&\"'--\x00\xc4\x7f-"),
+	}
+
+	buf := &bytes.Buffer{}
+	err := c.RenderHTML(buf)
+	assert.NoError(t, err)
+
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, `<pre class="sketch-canvas" style="`))
+	assert.True(t, strings.HasSuffix(out, "</pre>"))
+
+	body := out[strings.Index(out, ">")+1 : len(out)-len("</pre>")]
+	assert.Equal(t, "&lt;a&gt;&amp;\n&#34;&#39;--\n &#x2500;&#x2302;-", body)
+}
+
+func TestCanvas_RenderHTML_Attrs(t *testing.T) {
+	c := Canvas{
+		Width:  3,
+		Height: 1,
+		Data:   []byte("ab<"),
+		Attrs:  []byte{DefaultAttr, DefaultAttr, Attr(14, 1)},
+	}
+
+	buf := &bytes.Buffer{}
+	err := c.RenderHTML(buf)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `<span style="color:#aaaaaa;background:#000000">ab</span>`+
+		`<span style="color:#ffff55;background:#0000aa">&lt;</span></pre>`)
+}
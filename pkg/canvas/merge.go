@@ -0,0 +1,67 @@
+package canvas
+
+const SizeMismatch = Error("the canvases don't have the same size")
+
+// Merge is the result of a three-way merge of the changes made to a canvas and to one of its forks
+// since their common base.
+type Merge struct {
+	Canvas *Canvas `json:"-"`
+	// Merged is the number of cells changed by the fork applied to the canvas.
+	Merged int `json:"merged"`
+	// Conflicts are the bounding rectangles of the groups of adjacent cells changed differently by both sides,
+	// which keep the content of the canvas. They don't overlap.
+	Conflicts []Rectangle `json:"conflicts"`
+}
+
+// ThreeWayMerge applies to a copy of ours the cells changed by theirs since base, their character or their color,
+// unless ours changed them differently. The three canvases must have the same size, and their data and color layers
+// must match it, see Canvas.Validate.
+func ThreeWayMerge(base, ours, theirs *Canvas) (*Merge, error) {
+	for _, c := range []*Canvas{base, ours, theirs} {
+		if err := c.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if ours.Width != base.Width || ours.Height != base.Height ||
+		theirs.Width != base.Width || theirs.Height != base.Height {
+		return nil, SizeMismatch
+	}
+
+	m := &Merge{Canvas: ours.Clone()}
+	conflicts := make([]bool, base.Width*base.Height)
+	b, o, t := base.cells(), ours.cells(), theirs.cells()
+
+	if len(m.Canvas.Data) == 0 {
+		m.Canvas.initData(BackgroundChar)
+	}
+
+	for y := uint(0); y < base.Height; y++ {
+		for x := uint(0); x < base.Width; x++ {
+			i := y*base.Width + x
+			baseAttr, ourAttr, theirAttr := base.GetAttr(x, y), ours.GetAttr(x, y), theirs.GetAttr(x, y)
+
+			if t[i] == b[i] && theirAttr == baseAttr || t[i] == o[i] && theirAttr == ourAttr {
+				continue
+			}
+
+			if o[i] != b[i] || ourAttr != baseAttr {
+				conflicts[i] = true
+
+				continue
+			}
+
+			m.Canvas.set(x, y, t[i])
+
+			if theirAttr != ourAttr {
+				m.Canvas.setAttr(x, y, theirAttr)
+			}
+
+			m.Merged++
+		}
+	}
+
+	m.Conflicts = regions(conflicts, base.Width)
+
+	return m, nil
+}
@@ -0,0 +1,50 @@
+package canvas
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThreeWayMerge(t *testing.T) {
+	base := &Canvas{Width: 6, Height: 3}
+	ours := &Canvas{Width: 6, Height: 3, Data: []byte("" +
+		"a-----" +
+		"----x-" +
+		"------")}
+	theirs := &Canvas{Width: 6, Height: 3, Data: []byte("" +
+		"a-bb--" +
+		"----y-" +
+		"---yy-")}
+
+	m, err := ThreeWayMerge(base, ours, theirs)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a-bb--", "----x-", "---yy-"}, m.Canvas.Split())
+	// The same change on both sides doesn't conflict, and isn't counted.
+	assert.Equal(t, 4, m.Merged)
+	assert.Equal(t, []Rectangle{{Origin: Point{X: 4, Y: 1}, Width: 1, Height: 1}}, m.Conflicts)
+	// The cells changed only on their side next to the conflict aren't part of it, ours is left untouched.
+	assert.Equal(t, []string{"a-----", "----x-", "------"}, ours.Split())
+
+	// Colors are merged too.
+	theirs = &Canvas{Width: 6, Height: 3, Data: ours.Data, Attrs: make([]byte, 18)}
+	for i := range theirs.Attrs {
+		theirs.Attrs[i] = DefaultAttr
+	}
+
+	theirs.Attrs[5] = Attr(1, 0)
+
+	m, err = ThreeWayMerge(base, ours, theirs)
+	require.NoError(t, err)
+	assert.Empty(t, m.Conflicts)
+	assert.Equal(t, 1, m.Merged)
+	assert.Equal(t, Attr(1, 0), m.Canvas.GetAttr(5, 0))
+	assert.Equal(t, ours.Data, m.Canvas.Data)
+
+	_, err = ThreeWayMerge(base, ours, &Canvas{Width: 5, Height: 3})
+	assert.ErrorIs(t, err, SizeMismatch)
+
+	_, err = ThreeWayMerge(base, ours, &Canvas{Width: 6, Height: 3, Data: []byte("a-bb--")})
+	assert.ErrorIs(t, err, MalformedData)
+}
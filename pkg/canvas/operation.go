@@ -0,0 +1,231 @@
+package canvas
+
+import (
+	"encoding/json"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// Types of the operations applied to a canvas.
+const (
+	// OpSnapshot replaces the whole content of the canvas, when it is created or overwritten.
+	OpSnapshot = "snapshot"
+	OpRect     = "rect"
+	OpFill     = "fill"
+	OpText     = "text"
+	// OpUndo and OpRedo revert and reapply the patch of an earlier operation of the same caller.
+	OpUndo = "undo"
+	OpRedo = "redo"
+	// OpRevert replaces the content of the canvas with its content at an earlier revision.
+	OpRevert = "revert"
+	// OpMerge replaces the content of the canvas with the result of the merge of one of its forks.
+	OpMerge = "merge"
+	// OpBatch applies a list of drawing operations as a single change.
+	OpBatch = "batch"
+)
+
+const UnknownOperation = Error("unknown operation")
+
+// Operation is a change applied to a canvas.
+// Only the fields relevant to its type are set.
+// Caller and Patch are recorded with the drawing operations so that their caller can undo them,
+// Target is the revision produced by the operation undone or redone, which stays valid when the journal is trimmed.
+// Ref is the index in the journal of the operation undone or redone when it has no revision, recorded by a previous version.
+// Revision is the revision of the document produced by the operation, Source the revision restored by a revert,
+// and Fork the fork and the revision of the fork applied by a merge. Ops are the operations applied by a batch, in order.
+// Canvas holds the content of the canvas after snapshots, reverts and merges, and after the drawing operations
+// checkpointing the history.
+type Operation struct {
+	Type     string       `json:"type"`
+	Time     time.Time    `json:"time"`
+	Revision uint64       `json:"revision,omitempty"`
+	Caller   string       `json:"caller,omitempty"`
+	Rect     *Rectangle   `json:"rect,omitempty"`
+	Origin   *Point       `json:"origin,omitempty"`
+	Fill     string       `json:"fill,omitempty"`
+	Outline  string       `json:"outline,omitempty"`
+	Text     string       `json:"text,omitempty"`
+	Canvas   *Canvas      `json:"canvas,omitempty"`
+	Patch    *Patch       `json:"patch,omitempty"`
+	Ref      *int         `json:"ref,omitempty"`
+	Target   *uint64      `json:"target,omitempty"`
+	Source   *uint64      `json:"source,omitempty"`
+	Fork     *Parent      `json:"fork,omitempty"`
+	Ops      []*Operation `json:"ops,omitempty"`
+}
+
+// NewSnapshot returns an operation replacing the content of a canvas with a copy of c.
+func NewSnapshot(c *Canvas) *Operation {
+	return &Operation{
+		Type:   OpSnapshot,
+		Time:   time.Now().UTC(),
+		Canvas: c.Clone(),
+	}
+}
+
+// NewRevert returns an operation replacing the content of a canvas with a copy of c, its content at revision source.
+func NewRevert(c *Canvas, source uint64) *Operation {
+	return &Operation{
+		Type:   OpRevert,
+		Time:   time.Now().UTC(),
+		Canvas: c.Clone(),
+		Source: &source,
+	}
+}
+
+// NewMerge returns an operation replacing the content of a canvas with a copy of c, its merge with a fork.
+func NewMerge(c *Canvas, fork Parent) *Operation {
+	return &Operation{
+		Type:   OpMerge,
+		Time:   time.Now().UTC(),
+		Canvas: c.Clone(),
+		Fork:   &fork,
+	}
+}
+
+// NewRect returns an operation drawing a rectangle.
+func NewRect(rect Rectangle, fill, outline string) *Operation {
+	return &Operation{
+		Type:    OpRect,
+		Time:    time.Now().UTC(),
+		Rect:    &rect,
+		Fill:    fill,
+		Outline: outline,
+	}
+}
+
+// NewFill returns a flood fill operation.
+func NewFill(origin Point, fill string) *Operation {
+	return &Operation{
+		Type:   OpFill,
+		Time:   time.Now().UTC(),
+		Origin: &origin,
+		Fill:   fill,
+	}
+}
+
+// NewText returns an operation writing a line of text.
+func NewText(origin Point, text string) *Operation {
+	return &Operation{
+		Type:   OpText,
+		Time:   time.Now().UTC(),
+		Origin: &origin,
+		Text:   text,
+	}
+}
+
+// NewBatch returns an operation applying ops in order.
+func NewBatch(ops []*Operation) *Operation {
+	return &Operation{
+		Type: OpBatch,
+		Time: time.Now().UTC(),
+		Ops:  ops,
+	}
+}
+
+// NewUndo returns an operation reverting op, the operation at index ref of the journal.
+func NewUndo(ref int, op *Operation) *Operation {
+	undo := &Operation{
+		Type:   OpUndo,
+		Time:   time.Now().UTC(),
+		Caller: op.Caller,
+		Patch:  op.Patch.Invert(),
+	}
+	undo.setTarget(ref, op)
+
+	return undo
+}
+
+// NewRedo returns an operation reapplying op, the operation at index ref of the journal.
+func NewRedo(ref int, op *Operation) *Operation {
+	redo := &Operation{
+		Type:   OpRedo,
+		Time:   time.Now().UTC(),
+		Caller: op.Caller,
+		Patch:  op.Patch,
+	}
+	redo.setTarget(ref, op)
+
+	return redo
+}
+
+// setTarget references op, the operation at index ref of the journal, by its revision, or by its index
+// if it was recorded without revision.
+func (o *Operation) setTarget(ref int, op *Operation) {
+	if revision, ok := op.ProducedRevision(); ok {
+		o.Target = &revision
+	} else {
+		o.Ref = &ref
+	}
+}
+
+// Targets reports whether the undo or redo operation o references op, the operation at index i of the journal.
+func (o *Operation) Targets(i int, op *Operation) bool {
+	if o.Target != nil {
+		revision, ok := op.ProducedRevision()
+
+		return ok && revision == *o.Target
+	}
+
+	return o.Ref != nil && *o.Ref == i
+}
+
+// Apply executes the operation on a canvas.
+func (o *Operation) Apply(c *Canvas) error {
+	switch o.Type {
+	case OpSnapshot, OpRevert, OpMerge:
+		if o.Canvas == nil {
+			return UnknownOperation
+		}
+
+		*c = *o.Canvas.Clone()
+
+		return nil
+	case OpRect:
+		if o.Rect == nil {
+			return UnknownOperation
+		}
+
+		return c.DrawRect(o.Rect, o.Fill, o.Outline)
+	case OpFill:
+		if o.Origin == nil {
+			return UnknownOperation
+		}
+
+		return c.FloodFill(o.Origin, o.Fill)
+	case OpText:
+		if o.Origin == nil {
+			return UnknownOperation
+		}
+
+		return c.DrawText(o.Origin, o.Text)
+	case OpUndo, OpRedo:
+		if o.Patch == nil {
+			return UnknownOperation
+		}
+
+		_, err := o.Patch.Apply(c)
+
+		return err
+	case OpBatch:
+		for _, op := range o.Ops {
+			if err := op.Apply(c); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	default:
+		return UnknownOperation
+	}
+}
+
+func (o *Operation) MarshalBinary() (data []byte, err error) {
+	data, err = json.Marshal(o)
+	if err != nil {
+		return data, xerrors.Errorf("failed to marshal operation to json: %w", err)
+	}
+
+	return data, nil
+}
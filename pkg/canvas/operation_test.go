@@ -0,0 +1,78 @@
+package canvas
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOperation_Apply(t *testing.T) {
+	c := &Canvas{Width: 4, Height: 3}
+
+	snapshot := NewSnapshot(&Canvas{Name: "doc", Width: 4, Height: 3})
+	require.NoError(t, snapshot.Apply(c))
+	assert.Equal(t, "doc", c.Name)
+
+	rect := NewRect(Rectangle{Origin: Point{X: 1, Y: 1}, Width: 2, Height: 1}, "#", "")
+	require.NoError(t, rect.Apply(c))
+	assert.Equal(t, []string{"----", "-##-", "----"}, c.Split())
+
+	fill := NewFill(Point{X: 2, Y: 1}, "@")
+	require.NoError(t, fill.Apply(c))
+	assert.Equal(t, []string{"----", "-@@-", "----"}, c.Split())
+
+	fill.Caller, fill.Patch = "alice", &Patch{
+		Rect:   Rectangle{Origin: Point{X: 1, Y: 1}, Width: 2, Height: 1},
+		Before: []byte("##"),
+		After:  []byte("@@"),
+	}
+
+	require.NoError(t, NewUndo(2, fill).Apply(c))
+	assert.Equal(t, []string{"----", "-##-", "----"}, c.Split())
+
+	redo := NewRedo(2, fill)
+	assert.Equal(t, "alice", redo.Caller)
+	assert.Equal(t, 2, *redo.Ref)
+	assert.Nil(t, redo.Target)
+	require.NoError(t, redo.Apply(c))
+	assert.Equal(t, []string{"----", "-@@-", "----"}, c.Split())
+
+	fill.Revision = 7
+	undo := NewUndo(2, fill)
+	assert.Nil(t, undo.Ref)
+	assert.Equal(t, uint64(7), *undo.Target)
+	assert.True(t, undo.Targets(0, fill))
+	assert.False(t, undo.Targets(2, &Operation{Revision: 8}))
+	assert.False(t, undo.Targets(2, &Operation{}))
+
+	batch := NewBatch([]*Operation{
+		NewRect(Rectangle{Origin: Point{X: 1, Y: 1}, Width: 1, Height: 2}, "*", ""),
+		NewFill(Point{X: 3, Y: 2}, "."),
+	})
+	require.NoError(t, batch.Apply(c))
+	assert.Equal(t, []string{"....", ".*@.", ".*.."}, c.Split())
+
+	// Later changes of the canvas don't affect the snapshot.
+	assert.Empty(t, snapshot.Canvas.Data)
+
+	assert.ErrorIs(t, (&Operation{Type: "unknown"}).Apply(c), UnknownOperation)
+	assert.ErrorIs(t, (&Operation{Type: OpRect}).Apply(c), UnknownOperation)
+	assert.ErrorIs(t, (&Operation{Type: OpUndo}).Apply(c), UnknownOperation)
+	assert.ErrorIs(t, NewBatch([]*Operation{rect, {Type: OpFill}}).Apply(c), UnknownOperation)
+}
+
+func TestOperation_MarshalBinary(t *testing.T) {
+	op := NewRect(Rectangle{Origin: Point{X: 1, Y: 2}, Width: 3, Height: 4}, "", "*")
+
+	data, err := op.MarshalBinary()
+	require.NoError(t, err)
+
+	var decoded Operation
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.True(t, op.Time.Equal(decoded.Time))
+
+	decoded.Time = op.Time
+	assert.Equal(t, *op, decoded)
+}
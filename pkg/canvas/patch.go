@@ -0,0 +1,131 @@
+package canvas
+
+// Patch is the change of the cells of a region of a canvas made by an operation,
+// recorded so that the operation can be undone and redone.
+type Patch struct {
+	Rect   Rectangle `json:"rect"`
+	Before []byte    `json:"before"`
+	After  []byte    `json:"after"`
+}
+
+// NewPatch returns the change from before to after over the smallest rectangle holding the changed cells.
+// It returns nil if the canvases have the same cells, or if their sizes differ.
+func NewPatch(before, after *Canvas) *Patch {
+	if before.Width != after.Width || before.Height != after.Height {
+		return nil
+	}
+
+	b, a := before.cells(), after.cells()
+
+	var (
+		minX, minY = before.Width, before.Height
+		maxX, maxY uint
+		changed    bool
+	)
+
+	for y := uint(0); y < before.Height; y++ {
+		for x := uint(0); x < before.Width; x++ {
+			i := y*before.Width + x
+			if b[i] == a[i] {
+				continue
+			}
+
+			changed = true
+			minX, maxX = minUint(minX, x), maxUint(maxX, x)
+			minY, maxY = minUint(minY, y), maxUint(maxY, y)
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	p := &Patch{
+		Rect: Rectangle{
+			Origin: Point{X: minX, Y: minY},
+			Width:  maxX - minX + 1,
+			Height: maxY - minY + 1,
+		},
+	}
+
+	for y := minY; y <= maxY; y++ {
+		row := y * before.Width
+		p.Before = append(p.Before, b[row+minX:row+maxX+1]...)
+		p.After = append(p.After, a[row+minX:row+maxX+1]...)
+	}
+
+	return p
+}
+
+// Invert returns the patch reverting p.
+func (p *Patch) Invert() *Patch {
+	return &Patch{
+		Rect:   p.Rect,
+		Before: p.After,
+		After:  p.Before,
+	}
+}
+
+// Apply changes the cells of the region that still hold their value before the patch.
+// Cells changed since by other operations are left untouched, so that undoing an operation
+// doesn't revert the changes made concurrently by others. It returns the number of changed cells.
+func (p *Patch) Apply(c *Canvas) (int, error) {
+	r := p.Rect
+	if r.Origin.X+r.Width > c.Width || r.Origin.Y+r.Height > c.Height {
+		return 0, PatchMismatch
+	}
+
+	if uint(len(p.Before)) != r.Width*r.Height || len(p.After) != len(p.Before) {
+		return 0, PatchMismatch
+	}
+
+	if len(c.Data) == 0 {
+		c.initData(BackgroundChar)
+	}
+
+	changed := 0
+
+	for y := uint(0); y < r.Height; y++ {
+		for x := uint(0); x < r.Width; x++ {
+			i := y*r.Width + x
+			if p.Before[i] == p.After[i] || c.get(r.Origin.X+x, r.Origin.Y+y) != p.Before[i] {
+				continue
+			}
+
+			c.set(r.Origin.X+x, r.Origin.Y+y, p.After[i])
+			changed++
+		}
+	}
+
+	return changed, nil
+}
+
+// cells returns the cells of the canvas, filled with the background if it is uninitialized.
+func (c *Canvas) cells() []byte {
+	if len(c.Data) != 0 {
+		return c.Data
+	}
+
+	data := make([]byte, c.Width*c.Height)
+	for i := range data {
+		data[i] = BackgroundChar
+	}
+
+	return data
+}
+
+func minUint(a, b uint) uint {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func maxUint(a, b uint) uint {
+	if a > b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,49 @@
+package canvas
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPatch(t *testing.T) {
+	before := &Canvas{Width: 4, Height: 3}
+	after := before.Clone()
+	require.NoError(t, after.DrawRect(&Rectangle{Origin: Point{X: 1, Y: 1}, Width: 2, Height: 2}, "#", ""))
+
+	p := NewPatch(before, after)
+	require.NotNil(t, p)
+	assert.Equal(t, Rectangle{Origin: Point{X: 1, Y: 1}, Width: 2, Height: 2}, p.Rect)
+	assert.Equal(t, []byte("----"), p.Before)
+	assert.Equal(t, []byte("####"), p.After)
+
+	assert.Nil(t, NewPatch(after, after.Clone()))
+	assert.Nil(t, NewPatch(before, &Canvas{Width: 3, Height: 3}))
+}
+
+func TestPatch_Apply(t *testing.T) {
+	before := &Canvas{Width: 4, Height: 2, Data: []byte("--------")}
+	after := &Canvas{Width: 4, Height: 2, Data: []byte("--------")}
+	require.NoError(t, after.DrawRect(&Rectangle{Origin: Point{X: 1, Y: 1}, Width: 3, Height: 1}, "#", ""))
+
+	p := NewPatch(before, after)
+	require.NotNil(t, p)
+
+	// A cell changed since by another operation is kept when the patch is reverted.
+	c := after.Clone()
+	c.Data[6] = '@'
+
+	n, err := p.Invert().Apply(c)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"----", "--@-"}, c.Split())
+
+	n, err = p.Apply(c)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"----", "-#@#"}, c.Split())
+
+	_, err = p.Apply(&Canvas{Width: 2, Height: 2})
+	assert.ErrorIs(t, err, PatchMismatch)
+}
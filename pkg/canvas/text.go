@@ -0,0 +1,133 @@
+package canvas
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/xerrors"
+)
+
+// unknownChar replaces the characters of a text that have no representation in the canvas character set.
+const unknownChar = '?'
+
+// MaxTextCells is the maximum number of cells of a canvas created from text, the same limit as formats.MaxCells.
+const MaxTextCells = 1 << 22
+
+// Text returns the content of the canvas as newline-terminated lines of UTF-8 text.
+// Cells outside of the printable ASCII range are converted from code page 437.
+func (c *Canvas) Text() string {
+	lines := c.Split()
+	if len(lines) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+
+	sb.Grow(len(c.Data) + len(lines))
+
+	for _, line := range lines {
+		for i := 0; i < len(line); i++ {
+			if b := line[i]; b >= ' ' && b < 0x7f {
+				sb.WriteByte(b)
+			} else {
+				sb.WriteRune(DecodeRune(b))
+			}
+		}
+
+		sb.WriteByte('\n')
+	}
+
+	return sb.String()
+}
+
+// FromText creates a canvas from newline-separated lines of text.
+// The width of the canvas is the length of the longest line and its height the number of lines.
+// Shorter lines are padded with the background character.
+// Texts covering more than MaxTextCells cells are rejected with ObjectTooLarge.
+//
+// UTF-8 text is converted to code page 437, other content is used as-is.
+func FromText(r io.Reader) (*Canvas, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read text content: %w", err)
+	}
+
+	if utf8.Valid(data) {
+		data = encodeText(string(data))
+	}
+
+	var (
+		lines   []string
+		width   int
+		scanner = bufio.NewScanner(strings.NewReader(string(data)))
+	)
+
+	// Longer lines can't fit in the canvas. The buffer holds the CRLF line break following the line too.
+	scanner.Buffer(nil, MaxTextCells+2)
+
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if len(line) > width {
+			width = len(line)
+		}
+
+		lines = append(lines, line)
+
+		// Checked before padding the lines, which would allocate width × height cells.
+		if uint64(width)*uint64(len(lines)) > MaxTextCells {
+			return nil, ObjectTooLarge
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if xerrors.Is(err, bufio.ErrTooLong) {
+			return nil, ObjectTooLarge
+		}
+
+		return nil, xerrors.Errorf("failed to read text content: %w", err)
+	}
+
+	if width == 0 {
+		return nil, EmptyContent
+	}
+
+	return fromLines(lines, uint(width)), nil
+}
+
+// encodeText converts UTF-8 text to code page 437, keeping line breaks.
+func encodeText(s string) []byte {
+	data := make([]byte, 0, len(s))
+
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			data = append(data, byte(r))
+
+			continue
+		}
+
+		b, ok := EncodeRune(r)
+		if !ok {
+			b = unknownChar
+		}
+
+		data = append(data, b)
+	}
+
+	return data
+}
+
+func fromLines(lines []string, width uint) *Canvas {
+	c := &Canvas{
+		Width:  width,
+		Height: uint(len(lines)),
+	}
+	c.initData(BackgroundChar)
+
+	for y, line := range lines {
+		copy(c.Data[uint(y)*c.Width:], line)
+	}
+
+	return c
+}
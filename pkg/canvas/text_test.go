@@ -0,0 +1,101 @@
+package canvas
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanvas_Text(t *testing.T) {
+	c := Canvas{
+		Width:  4,
+		Height: 2,
+		Data:   []byte("1234abcd"),
+	}
+
+	assert.Equal(t, "1234\nabcd\n", c.Text())
+
+	c = Canvas{
+		Width:  3,
+		Height: 2,
+		Data:   []byte("\xda\xc4\xbf\xc0\x10\xd9"),
+	}
+
+	assert.Equal(t, "┌─┐\n└►┘\n", c.Text())
+}
+
+func TestFromText(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected *Canvas
+		wantErr  bool
+	}{
+		{
+			name: "ok",
+			text: "+--+\n|  |\n+--+\n",
+			expected: &Canvas{
+				Width:  4,
+				Height: 3,
+				Data:   []byte("+--+|  |+--+"),
+			},
+		},
+		{
+			name: "ragged lines",
+			text: "ab\r\nabcd\n\nc",
+			expected: &Canvas{
+				Width:  4,
+				Height: 4,
+				Data:   []byte("ab--abcd----c---"),
+			},
+		},
+		{
+			name: "utf-8",
+			text: "┌─┐\n└►┘\n€",
+			expected: &Canvas{
+				Width:  3,
+				Height: 3,
+				Data:   []byte("\xda\xc4\xbf\xc0\x10\xd9?--"),
+			},
+		},
+		{
+			name: "code page 437",
+			text: "\xda\xc4\xbf\n",
+			expected: &Canvas{
+				Width:  3,
+				Height: 1,
+				Data:   []byte("\xda\xc4\xbf"),
+			},
+		},
+		{
+			name:    "empty",
+			text:    "\n\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := FromText(strings.NewReader(tt.text))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("FromText() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.expected, c)
+		})
+	}
+}
+
+func TestFromText_TooLarge(t *testing.T) {
+	// Many lines padded to a long one.
+	_, err := FromText(strings.NewReader(strings.Repeat("\n", 1<<20) + strings.Repeat("-", 1<<16)))
+	assert.ErrorIs(t, err, ObjectTooLarge)
+
+	// A single line longer than the canvas can hold.
+	_, err = FromText(strings.NewReader(strings.Repeat("-", MaxTextCells+1)))
+	assert.ErrorIs(t, err, ObjectTooLarge)
+
+	c, err := FromText(strings.NewReader(strings.Repeat("-", MaxTextCells) + "\r\n"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint(MaxTextCells), c.Width)
+	}
+}
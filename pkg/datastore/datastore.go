@@ -2,6 +2,7 @@ package datastore
 
 import (
 	"context"
+	"time"
 
 	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
 )
@@ -12,13 +13,105 @@ func (s StoreError) Error() string {
 	return string(s)
 }
 
-const NotFound = StoreError("not found")
+const (
+	NotFound      = StoreError("not found")
+	InvalidKey    = StoreError("invalid key")
+	Conflict      = StoreError("document revision conflict")
+	UnknownDriver = StoreError("unknown datastore driver")
+	UnknownLayout = StoreError("unknown redis layout")
+	UnknownSort   = StoreError("unknown sort order")
+	InvalidCursor = StoreError("invalid cursor")
+	Exists        = StoreError("document already exists")
+)
+
+// AnyRevision is given to UpdateDocument to apply an operation whatever the revision of the document.
+const AnyRevision = ^uint64(0)
+
+// Drivers of the DataStore implementations.
+const (
+	DriverRedis  = "redis"
+	DriverMemory = "memory"
+	DriverFile   = "file"
+	DriverSQLite = "sqlite"
+)
 
 //go:generate mockery --name DataStore
 type DataStore interface {
 	GetSize(ctx context.Context) (int64, error)
-	GetDocList(cursor uint64, count int64, ctx context.Context) ([]string, uint64, error)
+	// ListDocuments returns a page of the metadata of the documents selected by the query,
+	// and the cursor of the next page, empty if there are no more documents.
+	// It returns UnknownSort and InvalidCursor for invalid queries.
+	ListDocuments(query *ListQuery, ctx context.Context) ([]*DocInfo, string, error)
 	SetDocument(key string, doc *canvas.Canvas, ctx context.Context) error
+	// CompareAndSwapDocument replaces a document only if the stored one is at the given revision,
+	// and sets the revision of doc to the next one. It returns Conflict if the revision doesn't match.
+	CompareAndSwapDocument(key string, revision uint64, doc *canvas.Canvas, ctx context.Context) error
+	// UpdateDocument applies an operation to a document atomically and returns the updated document.
+	// Unless revision is AnyRevision, the operation is only applied if the document is at this revision,
+	// Conflict is returned otherwise. Errors of the operation itself are returned as is.
+	UpdateDocument(key string, op *canvas.Operation, revision uint64, ctx context.Context) (*canvas.Canvas, error)
 	GetDocument(key string, ctx context.Context) (*canvas.Canvas, error)
 	DeleteDocument(key string, ctx context.Context) error
+	AddOperation(key string, op *canvas.Operation, ctx context.Context) error
+	GetOperations(key string, ctx context.Context) ([]*canvas.Operation, error)
+	// GetOperationsFrom returns the end of the journal of a document needed to rebuild it at a revision and after,
+	// starting at the last checkpoint at or before the revision, see canvas.AtRevision. The whole journal is returned
+	// if it doesn't have such a checkpoint.
+	GetOperationsFrom(key string, revision uint64, ctx context.Context) ([]*canvas.Operation, error)
+	// TrimOperations removes the start of the journal of a document only needed to rebuild it before a revision,
+	// so that the journal starts at the last checkpoint at or before the revision, and returns the number of operations
+	// removed. The references of the undo and redo operations kept are updated.
+	TrimOperations(key string, revision uint64, ctx context.Context) (int, error)
+	// DeleteExpired deletes the documents whose expiry is before now, and returns their number.
+	// It is called periodically by the stores opened with a sweep interval.
+	DeleteExpired(now time.Time, ctx context.Context) (int, error)
+	// TrashDocument moves a document and its operations to the trash, deleted at now.
+	// Trashed documents are no longer counted, listed, expired or accessible by key until they are restored.
+	TrashDocument(key string, now time.Time, ctx context.Context) error
+	// ListTrash returns the metadata of the documents in the trash, with their deletion time, most recently deleted first.
+	ListTrash(ctx context.Context) ([]*DocInfo, error)
+	// RestoreDocument moves a document back from the trash and touches it at now, restarting its lifetime.
+	// It returns NotFound if the document is not in the trash, and Exists if another document uses its key.
+	RestoreDocument(key string, now time.Time, ctx context.Context) (*canvas.Canvas, error)
+	// PurgeDocument permanently deletes a document from the trash.
+	PurgeDocument(key string, ctx context.Context) error
+	// PurgeTrash permanently deletes the documents moved to the trash before a time, and returns their number.
+	PurgeTrash(before time.Time, ctx context.Context) (int, error)
+	Close() error
+}
+
+// baseUpdater is implemented by the stores that can apply an operation to a document without reading it back,
+// see UpdateDocumentFrom.
+type baseUpdater interface {
+	updateDocumentFrom(key string, op *canvas.Operation, base *canvas.Canvas, ctx context.Context) (*canvas.Canvas, error)
+}
+
+// UpdateDocumentFrom applies an operation to a document like UpdateDocument, at the revision of base,
+// the document as read by the caller. It returns Conflict if the document was modified since.
+// The stores drawing operations in place, such as the hash layout of the Redis store, return the operation
+// applied on base along with the metadata of the stored document, instead of reading back its whole content.
+func UpdateDocumentFrom(store DataStore, key string, op *canvas.Operation, base *canvas.Canvas, ctx context.Context) (*canvas.Canvas, error) {
+	if u, ok := store.(baseUpdater); ok {
+		return u.updateDocumentFrom(key, op, base, ctx)
+	}
+
+	return store.UpdateDocument(key, op, base.Revision, ctx)
+}
+
+// applyOperation applies an operation to a document at the expected revision, increments its revision
+// and touches it at the time of the operation.
+func applyOperation(doc *canvas.Canvas, op *canvas.Operation, revision uint64) error {
+	current := doc.Revision
+	if revision != AnyRevision && current != revision {
+		return Conflict
+	}
+
+	if err := op.Apply(doc); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	doc.Revision = current + 1
+	doc.Touch(op.Time)
+
+	return nil
 }
@@ -0,0 +1,486 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+// testCompareAndSwap checks the revision handling of a store.
+func testCompareAndSwap(t *testing.T, s DataStore) {
+	t.Helper()
+
+	ctx := context.TODO()
+
+	assert.Equal(t, NotFound, s.CompareAndSwapDocument("123", 0, &canvas.Canvas{}, ctx))
+
+	require.NoError(t, s.SetDocument("123", &canvas.Canvas{Name: "doc"}, ctx))
+
+	doc, err := s.GetDocument("123", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), doc.Revision)
+
+	doc.Name = "first"
+	require.NoError(t, s.CompareAndSwapDocument("123", doc.Revision, doc, ctx))
+	assert.Equal(t, uint64(1), doc.Revision)
+
+	// A writer holding the previous revision loses.
+	stale := &canvas.Canvas{Name: "stale"}
+	assert.Equal(t, Conflict, s.CompareAndSwapDocument("123", 0, stale, ctx))
+	assert.Equal(t, uint64(0), stale.Revision)
+
+	got, err := s.GetDocument("123", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "first", got.Name)
+	assert.Equal(t, uint64(1), got.Revision)
+
+	require.NoError(t, s.CompareAndSwapDocument("123", 1, got, ctx))
+	assert.Equal(t, uint64(2), got.Revision)
+}
+
+// testUpdateDocument checks that a store applies operations like the canvas package.
+func testUpdateDocument(t *testing.T, s DataStore) {
+	t.Helper()
+
+	ctx := context.TODO()
+	rect := func(x, y, w, h uint, fill, outline string) *canvas.Operation {
+		return canvas.NewRect(canvas.Rectangle{Origin: canvas.Point{X: x, Y: y}, Width: w, Height: h}, fill, outline)
+	}
+
+	_, err := s.UpdateDocument("123", rect(1, 1, 1, 1, "#", ""), AnyRevision, ctx)
+	assert.Equal(t, NotFound, err)
+
+	want := &canvas.Canvas{Name: "doc", Width: 8, Height: 6}
+	require.NoError(t, s.SetDocument("123", want, ctx))
+
+	ops := []*canvas.Operation{
+		rect(1, 1, 0, 3, "#", "@"),
+		rect(1, 1, 5, 4, "X", "@"),
+		rect(2, 2, 3, 2, "", "o"),
+		rect(6, 1, 1, 5, "=", "|"),
+		rect(1, 5, 7, 1, "", "_"),
+		canvas.NewFill(canvas.Point{X: 2, Y: 3}, "~"),
+		rect(3, 3, 2, 2, "+", ""),
+		rect(9, 1, 1, 1, "#", ""),
+		rect(4, 4, 5, 1, "#", ""),
+		rect(1, 1, 1, 1, "##", ""),
+	}
+
+	for i, op := range ops {
+		wantErr := op.Apply(want)
+		if wantErr == nil {
+			want.Revision++
+			want.Updated = op.Time
+		}
+
+		got, err := s.UpdateDocument("123", op, AnyRevision, ctx)
+		if wantErr != nil {
+			assert.Equal(t, wantErr, err, "operation %d", i)
+
+			continue
+		}
+
+		require.NoError(t, err, "operation %d", i)
+		assert.Equal(t, want, got, "operation %d", i)
+
+		got, err = s.GetDocument("123", ctx)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, "operation %d", i)
+	}
+
+	// An operation for a previous revision is rejected.
+	_, err = s.UpdateDocument("123", rect(1, 1, 1, 1, "#", ""), want.Revision-1, ctx)
+	assert.Equal(t, Conflict, err)
+
+	got, err := s.UpdateDocument("123", rect(1, 1, 1, 1, "#", ""), want.Revision, ctx)
+	require.NoError(t, err)
+	assert.Equal(t, want.Revision+1, got.Revision)
+}
+
+// listKeys returns the keys of a page of the document list, and the cursor of the next page.
+func listKeys(t *testing.T, s DataStore, query ListQuery) ([]string, string) {
+	t.Helper()
+
+	infos, next, err := s.ListDocuments(&query, context.TODO())
+	require.NoError(t, err)
+
+	keys := make([]string, 0, len(infos))
+	for _, info := range infos {
+		keys = append(keys, info.Key)
+	}
+
+	return keys, next
+}
+
+// testListDocuments checks the orders, filters and cursors of the document list of a store.
+func testListDocuments(t *testing.T, s DataStore) {
+	t.Helper()
+
+	ctx := context.TODO()
+	base := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	// doc0 to doc4 are created in order, doc1 is the most recently updated.
+	for i := 0; i < 5; i++ {
+		doc := &canvas.Canvas{
+			Name:    fmt.Sprintf("doc%d", i),
+			Created: base.Add(time.Duration(i) * time.Second),
+			Updated: base.Add(time.Duration(i) * time.Second),
+			Creator: "me",
+		}
+
+		if i%2 == 0 {
+			doc.Tags = []string{"even", "all"}
+		}
+
+		require.NoError(t, s.SetDocument(fmt.Sprintf("doc%d", i), doc, ctx))
+	}
+
+	doc, err := s.GetDocument("doc1", ctx)
+	require.NoError(t, err)
+
+	doc.Name = "renamed"
+	doc.Description = "first"
+	doc.Updated = base.Add(time.Minute)
+	require.NoError(t, s.CompareAndSwapDocument("doc1", doc.Revision, doc, ctx))
+
+	infos, next, err := s.ListDocuments(&ListQuery{Count: 1}, ctx)
+	require.NoError(t, err)
+	assert.NotEmpty(t, next)
+	assert.Equal(t, []*DocInfo{{
+		Key:     "doc0",
+		Name:    "doc0",
+		Creator: "me",
+		Tags:    []string{"even", "all"},
+		Created: base,
+		Updated: base,
+	}}, infos)
+
+	keys, next := listKeys(t, s, ListQuery{Count: 2})
+	assert.Equal(t, []string{"doc0", "doc1"}, keys)
+
+	// Changes between two pages don't shift the following pages.
+	require.NoError(t, s.DeleteDocument("doc0", ctx))
+	require.NoError(t, s.DeleteDocument("doc2", ctx))
+	require.NoError(t, s.SetDocument("doc5", &canvas.Canvas{Name: "doc5", Created: base.Add(time.Hour), Updated: base.Add(time.Hour)}, ctx))
+
+	keys, next = listKeys(t, s, ListQuery{Cursor: next, Count: 2})
+	assert.Equal(t, []string{"doc3", "doc4"}, keys)
+
+	keys, next = listKeys(t, s, ListQuery{Cursor: next, Count: 2})
+	assert.Equal(t, []string{"doc5"}, keys)
+	assert.Empty(t, next)
+
+	keys, _ = listKeys(t, s, ListQuery{Sort: SortUpdated})
+	assert.Equal(t, []string{"doc5", "doc1", "doc4", "doc3"}, keys)
+
+	keys, next = listKeys(t, s, ListQuery{Sort: SortUpdated, Count: 3})
+	assert.Equal(t, []string{"doc5", "doc1", "doc4"}, keys)
+
+	keys, next = listKeys(t, s, ListQuery{Sort: SortUpdated, Count: 3, Cursor: next})
+	assert.Equal(t, []string{"doc3"}, keys)
+	assert.Empty(t, next)
+
+	keys, _ = listKeys(t, s, ListQuery{Tag: "even"})
+	assert.Equal(t, []string{"doc4"}, keys)
+
+	keys, _ = listKeys(t, s, ListQuery{NamePrefix: "doc"})
+	assert.Equal(t, []string{"doc3", "doc4", "doc5"}, keys)
+
+	keys, _ = listKeys(t, s, ListQuery{NamePrefix: "ren", Sort: SortUpdated})
+	assert.Equal(t, []string{"doc1"}, keys)
+
+	// Filtered pages skip the documents that don't match.
+	keys, next = listKeys(t, s, ListQuery{NamePrefix: "doc", Count: 1})
+	assert.Equal(t, []string{"doc3"}, keys)
+
+	keys, _ = listKeys(t, s, ListQuery{NamePrefix: "doc", Count: 1, Cursor: next})
+	assert.Equal(t, []string{"doc4"}, keys)
+
+	// Documents updated at the same time are sorted by key.
+	for _, key := range []string{"tie1", "tie2"} {
+		require.NoError(t, s.SetDocument(key, &canvas.Canvas{Name: "tie", Updated: base.Add(time.Minute)}, ctx))
+	}
+
+	keys, next = listKeys(t, s, ListQuery{Sort: SortUpdated, Count: 2, NamePrefix: "re"})
+	assert.Equal(t, []string{"doc1"}, keys)
+	assert.Empty(t, next)
+
+	keys, next = listKeys(t, s, ListQuery{Sort: SortUpdated, Count: 2})
+	assert.Equal(t, []string{"doc5", "tie2"}, keys)
+
+	keys, _ = listKeys(t, s, ListQuery{Sort: SortUpdated, Count: 2, Cursor: next})
+	assert.Equal(t, []string{"tie1", "doc1"}, keys)
+
+	// Counts above the maximum page size are reduced to it.
+	keys, next = listKeys(t, s, ListQuery{Count: MaxListCount + 1})
+	assert.ElementsMatch(t, []string{"doc1", "doc3", "doc4", "doc5", "tie1", "tie2"}, keys)
+	assert.Empty(t, next)
+
+	keys, next = listKeys(t, s, ListQuery{Sort: SortUpdated, Count: math.MaxInt64})
+	assert.Equal(t, []string{"doc5", "tie2", "tie1", "doc1", "doc4", "doc3"}, keys)
+	assert.Empty(t, next)
+
+	_, _, err = s.ListDocuments(&ListQuery{Sort: "name"}, ctx)
+	assert.Equal(t, UnknownSort, err)
+
+	_, _, err = s.ListDocuments(&ListQuery{Cursor: "!"}, ctx)
+	assert.Equal(t, InvalidCursor, err)
+}
+
+// testDeleteExpired checks the expiry of the documents of a store. The advance function, if any,
+// moves the clock of the backend forward for the stores expiring the documents by themselves.
+func testDeleteExpired(t *testing.T, s DataStore, advance func(time.Duration)) {
+	t.Helper()
+
+	ctx := context.TODO()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	doc := func(retention time.Duration) *canvas.Canvas {
+		doc := &canvas.Canvas{Width: 3, Height: 2, Data: []byte("abcdef"), Retention: retention}
+		doc.Touch(now)
+
+		return doc
+	}
+
+	require.NoError(t, s.SetDocument("kept", doc(0), ctx))
+	require.NoError(t, s.SetDocument("expired", doc(time.Hour), ctx))
+	require.NoError(t, s.SetDocument("extended", doc(time.Hour), ctx))
+	require.NoError(t, s.SetDocument("later", doc(3*time.Hour), ctx))
+
+	got, err := s.GetDocument("later", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(3*time.Hour), got.Expires)
+
+	// Updates extend the lifetime of the documents.
+	op := canvas.NewRect(canvas.Rectangle{Origin: canvas.Point{X: 1, Y: 1}, Width: 1, Height: 1}, "#", "")
+	op.Time = now.Add(30 * time.Minute)
+
+	got, err = s.UpdateDocument("extended", op, AnyRevision, ctx)
+	require.NoError(t, err)
+	assert.Equal(t, op.Time.Add(time.Hour), got.Expires)
+
+	if advance != nil {
+		advance(80 * time.Minute)
+	}
+
+	deleted, err := s.DeleteExpired(now.Add(80*time.Minute), ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	_, err = s.GetDocument("expired", ctx)
+	assert.Equal(t, NotFound, err)
+
+	size, err := s.GetSize(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), size)
+
+	keys, _ := listKeys(t, s, ListQuery{})
+	assert.ElementsMatch(t, []string{"extended", "kept", "later"}, keys)
+
+	// Documents kept forever lose their expiry.
+	got, err = s.GetDocument("later", ctx)
+	require.NoError(t, err)
+
+	got.Retention = 0
+	got.Touch(now)
+	require.NoError(t, s.CompareAndSwapDocument("later", got.Revision, got, ctx))
+
+	if advance != nil {
+		advance(4 * time.Hour)
+	}
+
+	deleted, err = s.DeleteExpired(now.Add(5*time.Hour+20*time.Minute), ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	keys, _ = listKeys(t, s, ListQuery{})
+	assert.ElementsMatch(t, []string{"kept", "later"}, keys)
+}
+
+// testTrash checks that a store moves the deleted documents to the trash, restores and purges them.
+func testTrash(t *testing.T, s DataStore) {
+	t.Helper()
+
+	ctx := context.TODO()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	doc := &canvas.Canvas{Name: "doc", Width: 3, Height: 2, Data: []byte("abcdef"), Retention: time.Hour}
+	doc.Touch(now)
+	require.NoError(t, s.SetDocument("a", doc, ctx))
+	require.NoError(t, s.SetDocument("b", &canvas.Canvas{Name: "other", Width: 1, Height: 1}, ctx))
+	require.NoError(t, s.AddOperation("a", canvas.NewSnapshot(doc), ctx))
+
+	require.NoError(t, s.TrashDocument("a", now, ctx))
+	assert.Equal(t, NotFound, s.TrashDocument("a", now, ctx))
+
+	_, err := s.GetDocument("a", ctx)
+	assert.Equal(t, NotFound, err)
+
+	size, err := s.GetSize(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), size)
+
+	keys, _ := listKeys(t, s, ListQuery{})
+	assert.Equal(t, []string{"b"}, keys)
+
+	// Trashed documents don't expire.
+	deleted, err := s.DeleteExpired(now.Add(2*time.Hour), ctx)
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+
+	require.NoError(t, s.TrashDocument("b", now.Add(time.Minute), ctx))
+
+	trash, err := s.ListTrash(ctx)
+	require.NoError(t, err)
+
+	if assert.Len(t, trash, 2) {
+		assert.Equal(t, "b", trash[0].Key)
+		assert.Equal(t, "a", trash[1].Key)
+		assert.Equal(t, "doc", trash[1].Name)
+		assert.True(t, now.Equal(trash[1].Deleted), "deleted at %v", trash[1].Deleted)
+	}
+
+	// Restored documents are touched, with their operations.
+	restoredAt := now.Add(2 * time.Minute)
+
+	got, err := s.RestoreDocument("a", restoredAt, ctx)
+	require.NoError(t, err)
+	assert.Equal(t, restoredAt, got.Updated)
+	assert.Equal(t, restoredAt.Add(time.Hour), got.Expires)
+
+	got, err = s.GetDocument("a", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("abcdef"), got.Data)
+
+	ops, err := s.GetOperations("a", ctx)
+	require.NoError(t, err)
+	assert.Len(t, ops, 1)
+
+	size, err = s.GetSize(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), size)
+
+	keys, _ = listKeys(t, s, ListQuery{})
+	assert.Equal(t, []string{"a"}, keys)
+
+	_, err = s.RestoreDocument("a", restoredAt, ctx)
+	assert.Equal(t, NotFound, err)
+
+	// Documents recreated under the key of a trashed one are not overwritten.
+	require.NoError(t, s.SetDocument("c", &canvas.Canvas{Name: "old", Width: 1, Height: 1}, ctx))
+	require.NoError(t, s.TrashDocument("c", now, ctx))
+	require.NoError(t, s.SetDocument("c", &canvas.Canvas{Name: "new", Width: 1, Height: 1}, ctx))
+
+	_, err = s.RestoreDocument("c", restoredAt, ctx)
+	assert.Equal(t, Exists, err)
+
+	require.NoError(t, s.PurgeDocument("c", ctx))
+	assert.Equal(t, NotFound, s.PurgeDocument("c", ctx))
+
+	got, err = s.GetDocument("c", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "new", got.Name)
+
+	// The trash is purged by deletion time.
+	purged, err := s.PurgeTrash(now.Add(30*time.Second), ctx)
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+
+	purged, err = s.PurgeTrash(now.Add(2*time.Minute), ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	trash, err = s.ListTrash(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, trash)
+
+	_, err = s.RestoreDocument("b", restoredAt, ctx)
+	assert.Equal(t, NotFound, err)
+}
+
+// testJournal checks that a store reads the end of a journal from a checkpoint, and trims its start.
+func testJournal(t *testing.T, s DataStore) {
+	t.Helper()
+
+	ctx := context.TODO()
+
+	at := func(revision uint64) *canvas.Canvas {
+		return &canvas.Canvas{Width: 1, Height: 1, Revision: revision}
+	}
+
+	ref := 4
+	journal := []*canvas.Operation{
+		{Type: canvas.OpSnapshot, Canvas: at(1)},
+		{Type: canvas.OpFill, Origin: &canvas.Point{}, Fill: "a", Revision: 2},
+		{Type: canvas.OpFill, Origin: &canvas.Point{}, Fill: "b", Revision: 3},
+		{Type: canvas.OpFill, Origin: &canvas.Point{}, Fill: "c", Revision: 4, Canvas: at(4)},
+		{Type: canvas.OpFill, Origin: &canvas.Point{}, Fill: "d", Revision: 5, Caller: "me"},
+		// Recorded before the checkpoint of the previous revision by a concurrent update.
+		{Type: canvas.OpFill, Origin: &canvas.Point{}, Fill: "f", Revision: 7},
+		{Type: canvas.OpFill, Origin: &canvas.Point{}, Fill: "e", Revision: 6, Canvas: at(6)},
+		{Type: canvas.OpUndo, Revision: 8, Caller: "me", Ref: &ref},
+	}
+
+	for _, op := range journal {
+		require.NoError(t, s.AddOperation("a", op, ctx))
+	}
+
+	fills := func(ops []*canvas.Operation) string {
+		var fills string
+		for _, op := range ops {
+			fills += op.Fill
+		}
+
+		return fills
+	}
+
+	ops, err := s.GetOperationsFrom("a", 8, ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "fe", fills(ops))
+
+	ops, err = s.GetOperationsFrom("a", 5, ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "cdfe", fills(ops))
+
+	// Without checkpoint at or before the revision, the whole journal is read.
+	ops, err = s.GetOperationsFrom("a", 0, ctx)
+	require.NoError(t, err)
+	assert.Len(t, ops, len(journal))
+
+	ops, err = s.GetOperationsFrom("missing", 5, ctx)
+	require.NoError(t, err)
+	assert.Empty(t, ops)
+
+	// The journal isn't trimmed at a checkpoint preceded by operations recorded out of order.
+	n, err := s.TrimOperations("a", 7, ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	n, err = s.TrimOperations("a", 5, ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	ops, err = s.GetOperations("a", ctx)
+	require.NoError(t, err)
+	require.Equal(t, "cdfe", fills(ops))
+	assert.Equal(t, at(4), ops[0].Canvas)
+
+	// The undo still refers to the operation it undid.
+	if assert.NotNil(t, ops[4].Ref) {
+		assert.Equal(t, 1, *ops[4].Ref)
+	}
+
+	n, err = s.TrimOperations("a", 5, ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	n, err = s.TrimOperations("missing", 5, ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
@@ -0,0 +1,80 @@
+package datastore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// DefaultSweepInterval is the period at which the expired documents are deleted.
+const DefaultSweepInterval = time.Minute
+
+// sweeper periodically deletes the expired documents of a store in a goroutine, until it is stopped.
+// Stores embed it, and stop it when they are closed.
+type sweeper struct {
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// sweepFunc deletes the documents expired or purged at now and returns their number.
+type sweepFunc func(now time.Time, ctx context.Context) (int, error)
+
+// startSweeping calls sweep every interval, nothing is done if interval is not positive.
+func (s *sweeper) startSweeping(interval time.Duration, sweep sweepFunc) {
+	if interval <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop != nil {
+		return
+	}
+
+	s.stop, s.done = make(chan struct{}), make(chan struct{})
+
+	go func(stop <-chan struct{}, done chan<- struct{}) {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				deleted, err := sweep(now, context.Background())
+				if err != nil {
+					log.WithError(err).Error("failed to delete expired or trashed documents")
+				} else if deleted > 0 {
+					log.WithField("deleted", deleted).Info("expired or trashed documents deleted")
+				}
+			}
+		}
+	}(s.stop, s.done)
+}
+
+// stopSweeping stops the goroutine and waits for the sweep in progress, if any.
+func (s *sweeper) stopSweeping() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop == nil {
+		return
+	}
+
+	close(s.stop)
+	<-s.done
+
+	s.stop, s.done = nil, nil
+}
+
+// expired reports whether a document expiring at expires is expired at now.
+func expired(expires, now time.Time) bool {
+	return !expires.IsZero() && !expires.After(now)
+}
@@ -0,0 +1,818 @@
+package datastore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+const (
+	docsDir  = "docs"
+	opsDir   = "ops"
+	trashDir = "trash"
+
+	docExt     = ".canvas"
+	journalExt = ".jsonl"
+
+	// legacyDocExt is the extension of the documents written as JSON by previous versions, renamed when the store is opened.
+	legacyDocExt = ".json"
+	tempPrefix   = ".tmp-"
+
+	// seqSeparator separates the creation sequence from the key in the name of a document file.
+	seqSeparator = "_"
+
+	dirMode  = 0o755
+	fileMode = 0o644
+)
+
+// FileDataStore is a DataStore keeping each document as a file in a directory, encoded by Canvas.MarshalBinary.
+//
+// Documents are written to a temporary file which is synced and renamed over the previous version,
+// so that a crash leaves either the old or the new content. Operations are appended to a journal file
+// per document, one JSON object per line. A line interrupted by a crash is ignored when reading.
+//
+// The name of a document file starts with its creation sequence, which orders the document list.
+// The metadata of the documents is read when the store is opened, and kept in memory to list them.
+//
+// Trashed documents are moved with their journal to the trash directory, where the name of a document file
+// starts with its deletion time in nanoseconds instead. Restored documents are added at the end of the creation order.
+type FileDataStore struct {
+	sweeper
+
+	dir string
+
+	mu  sync.RWMutex
+	seq uint64
+
+	// docs maps the keys to their creation sequence.
+	docs map[string]uint64
+	// info maps the keys to the metadata of the documents.
+	info map[string]DocInfo
+	// trash maps the keys of the trashed documents to their metadata, with their deletion time.
+	trash map[string]DocInfo
+}
+
+// NewFile opens the FileDataStore stored in dir, creating the directory if needed.
+// Temporary files and incomplete journal lines left by an interrupted write are removed,
+// and the documents written as JSON by previous versions are renamed, their content is decoded transparently.
+func NewFile(dir string) (*FileDataStore, error) {
+	if dir == "" {
+		return nil, xerrors.New("missing file datastore directory")
+	}
+
+	s := &FileDataStore{
+		dir:   dir,
+		docs:  map[string]uint64{},
+		info:  map[string]DocInfo{},
+		trash: map[string]DocInfo{},
+	}
+
+	for _, d := range []string{s.path(docsDir), s.path(opsDir), s.path(trashDir)} {
+		if err := os.MkdirAll(d, dirMode); err != nil {
+			return nil, xerrors.Errorf("failed to create datastore directory: %w", err)
+		}
+	}
+
+	entries, err := os.ReadDir(s.path(docsDir))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read datastore directory: %w", err)
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+
+		if strings.HasPrefix(name, tempPrefix) {
+			if err := os.Remove(s.path(docsDir, name)); err != nil {
+				return nil, xerrors.Errorf("failed to remove temporary file: %w", err)
+			}
+
+			continue
+		}
+
+		if strings.HasSuffix(name, legacyDocExt) {
+			legacy := name
+			name = strings.TrimSuffix(name, legacyDocExt) + docExt
+
+			if err := os.Rename(s.path(docsDir, legacy), s.path(docsDir, name)); err != nil {
+				return nil, xerrors.Errorf("failed to rename legacy document file: %w", err)
+			}
+		}
+
+		key, seq, ok := parseDocFileName(name)
+		if !ok {
+			continue
+		}
+
+		doc, err := readDocumentFile(s.path(docsDir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		s.docs[key] = seq
+		s.info[key] = newDocInfo(key, doc)
+
+		if seq > s.seq {
+			s.seq = seq
+		}
+	}
+
+	if err := s.readTrash(); err != nil {
+		return nil, err
+	}
+
+	journals, err := os.ReadDir(s.path(opsDir))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read datastore directory: %w", err)
+	}
+
+	for _, e := range journals {
+		// Journals are replaced through temporary files when they are trimmed.
+		if strings.HasPrefix(e.Name(), tempPrefix) {
+			if err := os.Remove(s.path(opsDir, e.Name())); err != nil {
+				return nil, xerrors.Errorf("failed to remove temporary file: %w", err)
+			}
+
+			continue
+		}
+
+		if err := repairJournal(s.path(opsDir, e.Name())); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Close stops the deletion of the expired documents.
+func (s *FileDataStore) Close() error {
+	s.stopSweeping()
+
+	return nil
+}
+
+func (s *FileDataStore) GetSize(ctx context.Context) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return int64(len(s.docs)), nil
+}
+
+func (s *FileDataStore) ListDocuments(query *ListQuery, ctx context.Context) ([]*DocInfo, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]indexEntry, 0, len(s.docs))
+	for k, seq := range s.docs {
+		entries = append(entries, indexEntry{seq: seq, info: s.info[k]})
+	}
+
+	return listIndex(entries, query)
+}
+
+func (s *FileDataStore) SetDocument(key string, doc *canvas.Canvas, ctx context.Context) error {
+	if !validFileKey(key) {
+		return InvalidKey
+	}
+
+	data, err := doc.MarshalBinary()
+	if err != nil {
+		return xerrors.Errorf("failed to set document in file store: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq, ok := s.docs[key]
+	if !ok {
+		seq = s.seq + 1
+	}
+
+	if err := writeFileAtomic(s.path(docsDir), docFileName(key, seq), data); err != nil {
+		return xerrors.Errorf("failed to set document in file store: %w", err)
+	}
+
+	s.docs[key] = seq
+	s.info[key] = newDocInfo(key, doc)
+
+	if seq > s.seq {
+		s.seq = seq
+	}
+
+	return nil
+}
+
+func (s *FileDataStore) CompareAndSwapDocument(key string, revision uint64, doc *canvas.Canvas, ctx context.Context) error {
+	next := *doc
+	next.Revision = revision + 1
+
+	data, err := next.MarshalBinary()
+	if err != nil {
+		return xerrors.Errorf("failed to set document in file store: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq, ok := s.docs[key]
+	if !ok {
+		return NotFound
+	}
+
+	current, err := s.readDocument(key, seq)
+	if err != nil {
+		return err
+	}
+
+	if current.Revision != revision {
+		return Conflict
+	}
+
+	if err := writeFileAtomic(s.path(docsDir), docFileName(key, seq), data); err != nil {
+		return xerrors.Errorf("failed to set document in file store: %w", err)
+	}
+
+	s.info[key] = newDocInfo(key, &next)
+	doc.Revision = next.Revision
+
+	return nil
+}
+
+func (s *FileDataStore) UpdateDocument(key string, op *canvas.Operation, revision uint64, ctx context.Context) (*canvas.Canvas, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq, ok := s.docs[key]
+	if !ok {
+		return nil, NotFound
+	}
+
+	doc, err := s.readDocument(key, seq)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := applyOperation(doc, op, revision); err != nil {
+		return nil, err
+	}
+
+	data, err := doc.MarshalBinary()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to set document in file store: %w", err)
+	}
+
+	if err := writeFileAtomic(s.path(docsDir), docFileName(key, seq), data); err != nil {
+		return nil, xerrors.Errorf("failed to set document in file store: %w", err)
+	}
+
+	s.info[key] = newDocInfo(key, doc)
+
+	return doc, nil
+}
+
+func (s *FileDataStore) GetDocument(key string, ctx context.Context) (*canvas.Canvas, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seq, ok := s.docs[key]
+	if !ok {
+		return nil, NotFound
+	}
+
+	return s.readDocument(key, seq)
+}
+
+func (s *FileDataStore) readDocument(key string, seq uint64) (*canvas.Canvas, error) {
+	return readDocumentFile(s.path(docsDir, docFileName(key, seq)))
+}
+
+func readDocumentFile(name string) (*canvas.Canvas, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read document from file store: %w", err)
+	}
+
+	doc := canvas.Canvas{}
+	if err := doc.UnmarshalBinary(data); err != nil {
+		return nil, xerrors.Errorf("failed to unmarshal document from file store: %w", err)
+	}
+
+	return &doc, nil
+}
+
+func (s *FileDataStore) DeleteDocument(key string, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.deleteDocument(key)
+}
+
+func (s *FileDataStore) DeleteExpired(now time.Time, ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+
+	for key, info := range s.info {
+		if !expired(info.Expires, now) {
+			continue
+		}
+
+		if err := s.deleteDocument(key); err != nil {
+			return deleted, err
+		}
+
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// deleteDocument deletes the files of a document, the caller must hold the write lock.
+func (s *FileDataStore) deleteDocument(key string) error {
+	seq, ok := s.docs[key]
+	if !ok {
+		return NotFound
+	}
+
+	if err := os.Remove(s.path(docsDir, docFileName(key, seq))); err != nil {
+		return xerrors.Errorf("failed to delete document from file store: %w", err)
+	}
+
+	delete(s.docs, key)
+	delete(s.info, key)
+
+	if err := os.Remove(s.path(opsDir, key+journalExt)); err != nil && !os.IsNotExist(err) {
+		return xerrors.Errorf("failed to delete operations from file store: %w", err)
+	}
+
+	return nil
+}
+
+// readTrash reads the metadata of the trashed documents, and removes the temporary files left in the trash.
+func (s *FileDataStore) readTrash() error {
+	entries, err := os.ReadDir(s.path(trashDir))
+	if err != nil {
+		return xerrors.Errorf("failed to read datastore directory: %w", err)
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+
+		if strings.HasPrefix(name, tempPrefix) {
+			if err := os.Remove(s.path(trashDir, name)); err != nil {
+				return xerrors.Errorf("failed to remove temporary file: %w", err)
+			}
+
+			continue
+		}
+
+		key, deleted, ok := parseDocFileName(name)
+		if !ok {
+			continue
+		}
+
+		doc, err := readDocumentFile(s.path(trashDir, name))
+		if err != nil {
+			return err
+		}
+
+		info := newDocInfo(key, doc)
+		info.Deleted = time.Unix(0, int64(deleted)).UTC()
+		s.trash[key] = info
+	}
+
+	return nil
+}
+
+// trashFileName returns the name of the file of a trashed document.
+func trashFileName(info *DocInfo) string {
+	return docFileName(info.Key, uint64(info.Deleted.UnixNano()))
+}
+
+func (s *FileDataStore) TrashDocument(key string, now time.Time, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq, ok := s.docs[key]
+	if !ok {
+		return NotFound
+	}
+
+	// A previous version of the document in the trash is replaced.
+	if _, ok := s.trash[key]; ok {
+		if err := s.purgeDocument(key); err != nil {
+			return err
+		}
+	}
+
+	info := s.info[key]
+	info.Deleted = now.UTC()
+
+	if err := os.Rename(s.path(docsDir, docFileName(key, seq)), s.path(trashDir, trashFileName(&info))); err != nil {
+		return xerrors.Errorf("failed to move document to trash: %w", err)
+	}
+
+	delete(s.docs, key)
+	delete(s.info, key)
+	s.trash[key] = info
+
+	err := os.Rename(s.path(opsDir, key+journalExt), s.path(trashDir, key+journalExt))
+	if err != nil && !os.IsNotExist(err) {
+		return xerrors.Errorf("failed to move operations to trash: %w", err)
+	}
+
+	return nil
+}
+
+func (s *FileDataStore) ListTrash(ctx context.Context) ([]*DocInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	infos := make([]*DocInfo, 0, len(s.trash))
+
+	for _, info := range s.trash {
+		info := info
+		infos = append(infos, &info)
+	}
+
+	sortTrash(infos)
+
+	return infos, nil
+}
+
+func (s *FileDataStore) RestoreDocument(key string, now time.Time, ctx context.Context) (*canvas.Canvas, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, ok := s.trash[key]
+	if !ok {
+		return nil, NotFound
+	}
+
+	if _, ok := s.docs[key]; ok {
+		return nil, Exists
+	}
+
+	doc, err := readDocumentFile(s.path(trashDir, trashFileName(&info)))
+	if err != nil {
+		return nil, err
+	}
+
+	doc.Touch(now)
+
+	data, err := doc.MarshalBinary()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to set document in file store: %w", err)
+	}
+
+	seq := s.seq + 1
+
+	if err := writeFileAtomic(s.path(docsDir), docFileName(key, seq), data); err != nil {
+		return nil, xerrors.Errorf("failed to restore document in file store: %w", err)
+	}
+
+	s.seq = seq
+	s.docs[key] = seq
+	s.info[key] = newDocInfo(key, doc)
+
+	err = os.Rename(s.path(trashDir, key+journalExt), s.path(opsDir, key+journalExt))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, xerrors.Errorf("failed to restore operations in file store: %w", err)
+	}
+
+	if err := s.purgeDocument(key); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+func (s *FileDataStore) PurgeDocument(key string, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.purgeDocument(key)
+}
+
+func (s *FileDataStore) PurgeTrash(before time.Time, ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+
+	for key, info := range s.trash {
+		if !purged(info.Deleted, before) {
+			continue
+		}
+
+		if err := s.purgeDocument(key); err != nil {
+			return deleted, err
+		}
+
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// purgeDocument deletes the files of a trashed document, the caller must hold the write lock.
+func (s *FileDataStore) purgeDocument(key string) error {
+	info, ok := s.trash[key]
+	if !ok {
+		return NotFound
+	}
+
+	if err := os.Remove(s.path(trashDir, trashFileName(&info))); err != nil {
+		return xerrors.Errorf("failed to delete document from file store: %w", err)
+	}
+
+	delete(s.trash, key)
+
+	if err := os.Remove(s.path(trashDir, key+journalExt)); err != nil && !os.IsNotExist(err) {
+		return xerrors.Errorf("failed to delete operations from file store: %w", err)
+	}
+
+	return nil
+}
+
+func (s *FileDataStore) AddOperation(key string, op *canvas.Operation, ctx context.Context) error {
+	if !validFileKey(key) {
+		return InvalidKey
+	}
+
+	data, err := op.MarshalBinary()
+	if err != nil {
+		return xerrors.Errorf("failed to add operation in file store: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path(opsDir, key+journalExt), os.O_WRONLY|os.O_CREATE|os.O_APPEND, fileMode)
+	if err != nil {
+		return xerrors.Errorf("failed to open operation journal: %w", err)
+	}
+
+	// The line is written in a single call so that a crash can only truncate the last line.
+	_, err = f.Write(append(data, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return xerrors.Errorf("failed to add operation in file store: %w", err)
+	}
+
+	return nil
+}
+
+func (s *FileDataStore) GetOperations(key string, ctx context.Context) ([]*canvas.Operation, error) {
+	if !validFileKey(key) {
+		return []*canvas.Operation{}, nil
+	}
+
+	s.mu.RLock()
+	lines, err := s.readJournal(key)
+	s.mu.RUnlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	ops := make([]*canvas.Operation, 0, len(lines))
+
+	for _, line := range lines {
+		op, err := decodeFileOperation(line)
+		if err != nil {
+			return nil, err
+		}
+
+		ops = append(ops, op)
+	}
+
+	return ops, nil
+}
+
+// GetOperationsFrom decodes the lines of the journal backwards, down to the first operation needed.
+func (s *FileDataStore) GetOperationsFrom(key string, revision uint64, ctx context.Context) ([]*canvas.Operation, error) {
+	if !validFileKey(key) {
+		return []*canvas.Operation{}, nil
+	}
+
+	s.mu.RLock()
+	lines, err := s.readJournal(key)
+	s.mu.RUnlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	scan := journalScan{revision: revision}
+	ops := make([]*canvas.Operation, 0)
+
+	for i := len(lines) - 1; i >= 0; i-- {
+		op, err := decodeFileOperation(lines[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if !scan.prepend(op) {
+			break
+		}
+
+		ops = append(ops, op)
+	}
+
+	reverseOperations(ops)
+
+	return ops, nil
+}
+
+// TrimOperations rewrites the journal without its first operations, replacing it atomically.
+func (s *FileDataStore) TrimOperations(key string, revision uint64, ctx context.Context) (int, error) {
+	if !validFileKey(key) {
+		return 0, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lines, err := s.readJournal(key)
+	if err != nil {
+		return 0, err
+	}
+
+	ops := make([]*canvas.Operation, 0, len(lines))
+
+	for _, line := range lines {
+		op, err := decodeFileOperation(line)
+		if err != nil {
+			return 0, err
+		}
+
+		ops = append(ops, op)
+	}
+
+	n := trimmedLength(ops, revision)
+	if n == 0 {
+		return 0, nil
+	}
+
+	lines = lines[n:]
+
+	for _, i := range shiftRefs(ops[n:], n) {
+		if lines[i], err = ops[n+i].MarshalBinary(); err != nil {
+			return 0, xerrors.Errorf("failed to trim operations in file store: %w", err)
+		}
+	}
+
+	if err := writeFileAtomic(s.path(opsDir), key+journalExt, append(bytes.Join(lines, []byte{'\n'}), '\n')); err != nil {
+		return 0, xerrors.Errorf("failed to trim operations in file store: %w", err)
+	}
+
+	return n, nil
+}
+
+// readJournal returns the lines of the journal of a document, each holding an operation.
+func (s *FileDataStore) readJournal(key string) ([][]byte, error) {
+	data, err := os.ReadFile(s.path(opsDir, key+journalExt))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, xerrors.Errorf("failed to read operations from file store: %w", err)
+	}
+
+	// A crash while appending an operation can leave an incomplete last line, which is ignored.
+	if i := bytes.LastIndexByte(data, '\n'); i+1 < len(data) {
+		data = data[:i+1]
+	}
+
+	var lines [][]byte
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+
+	for scanner.Scan() {
+		lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+	}
+
+	return lines, nil
+}
+
+func decodeFileOperation(line []byte) (*canvas.Operation, error) {
+	op := canvas.Operation{}
+	if err := json.Unmarshal(line, &op); err != nil {
+		return nil, xerrors.Errorf("failed to unmarshal operation from file store: %w", err)
+	}
+
+	return &op, nil
+}
+
+func (s *FileDataStore) path(elem ...string) string {
+	return filepath.Join(append([]string{s.dir}, elem...)...)
+}
+
+// writeFileAtomic replaces the content of a file by writing it to a synced temporary file renamed over it.
+func writeFileAtomic(dir, name string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, tempPrefix+"*")
+	if err != nil {
+		return xerrors.Errorf("failed to create temporary file: %w", err)
+	}
+
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return xerrors.Errorf("failed to write temporary file: %w", err)
+	}
+
+	if err := os.Chmod(tmp.Name(), fileMode); err != nil {
+		return xerrors.Errorf("failed to set file permissions: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return xerrors.Errorf("failed to rename temporary file: %w", err)
+	}
+
+	// Sync the directory for the rename to survive a crash.
+	d, err := os.Open(dir)
+	if err != nil {
+		return xerrors.Errorf("failed to open directory: %w", err)
+	}
+
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return xerrors.Errorf("failed to sync directory: %w", err)
+	}
+
+	return nil
+}
+
+// repairJournal truncates an incomplete last line of a journal file, so that new lines can be appended to it.
+func repairJournal(name string) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return xerrors.Errorf("failed to read operation journal: %w", err)
+	}
+
+	i := bytes.LastIndexByte(data, '\n')
+	if i+1 == len(data) {
+		return nil
+	}
+
+	if err := os.Truncate(name, int64(i+1)); err != nil {
+		return xerrors.Errorf("failed to repair operation journal: %w", err)
+	}
+
+	return nil
+}
+
+// validFileKey reports whether a key can be used in a file name.
+func validFileKey(key string) bool {
+	return key != "" && !strings.ContainsAny(key, "/\\\x00") && !strings.HasPrefix(key, ".")
+}
+
+func docFileName(key string, seq uint64) string {
+	return fmt.Sprintf("%020d%s%s%s", seq, seqSeparator, key, docExt)
+}
+
+func parseDocFileName(name string) (key string, seq uint64, ok bool) {
+	if !strings.HasSuffix(name, docExt) {
+		return "", 0, false
+	}
+
+	parts := strings.SplitN(strings.TrimSuffix(name, docExt), seqSeparator, 2) //nolint:gomnd
+	if len(parts) != 2 || parts[1] == "" {                                     //nolint:gomnd
+		return "", 0, false
+	}
+
+	seq, err := strconv.ParseUint(parts[0], 10, 64) //nolint:gomnd
+	if err != nil {
+		return "", 0, false
+	}
+
+	return parts[1], seq, true
+}
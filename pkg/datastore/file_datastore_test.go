@@ -0,0 +1,273 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+func TestNewFile(t *testing.T) {
+	_, err := NewFile("")
+	assert.Error(t, err)
+
+	dir := filepath.Join(t.TempDir(), "store")
+
+	_, err = NewFile(dir)
+	require.NoError(t, err)
+	assert.DirExists(t, filepath.Join(dir, docsDir))
+	assert.DirExists(t, filepath.Join(dir, opsDir))
+}
+
+func TestFileDataStore_Document(t *testing.T) {
+	ctx := context.TODO()
+	dir := t.TempDir()
+
+	s, err := NewFile(dir)
+	require.NoError(t, err)
+
+	_, err = s.GetDocument("123", ctx)
+	assert.Equal(t, NotFound, err)
+
+	doc := &canvas.Canvas{Name: "doc", Width: 3, Height: 1, Data: []byte("abc")}
+	require.NoError(t, s.SetDocument("123", doc, ctx))
+
+	doc.Name = "renamed"
+	require.NoError(t, s.SetDocument("123", doc, ctx))
+
+	got, err := s.GetDocument("123", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, doc, got)
+
+	// Only the document file remains, the temporary files are renamed.
+	entries, err := os.ReadDir(filepath.Join(dir, docsDir))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	size, err := s.GetSize(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), size)
+
+	require.NoError(t, s.DeleteDocument("123", ctx))
+	assert.Equal(t, NotFound, s.DeleteDocument("123", ctx))
+
+	_, err = s.GetDocument("123", ctx)
+	assert.Equal(t, NotFound, err)
+}
+
+func TestFileDataStore_InvalidKey(t *testing.T) {
+	ctx := context.TODO()
+
+	s, err := NewFile(t.TempDir())
+	require.NoError(t, err)
+
+	for _, key := range []string{"", "../doc", "a/b", ".hidden"} {
+		assert.Equal(t, InvalidKey, s.SetDocument(key, &canvas.Canvas{}, ctx), key)
+		assert.Equal(t, InvalidKey, s.AddOperation(key, canvas.NewFill(canvas.Point{}, "#"), ctx), key)
+
+		_, err := s.GetDocument(key, ctx)
+		assert.Equal(t, NotFound, err, key)
+	}
+}
+
+func TestFileDataStore_Reopen(t *testing.T) {
+	ctx := context.TODO()
+	dir := t.TempDir()
+
+	s, err := NewFile(dir)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, s.SetDocument(fmt.Sprintf("doc%d", i), &canvas.Canvas{Width: uint(i)}, ctx))
+	}
+
+	// Leftover of a write interrupted by a crash.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, docsDir, tempPrefix+"123"), []byte("{"), fileMode))
+
+	s, err = NewFile(dir)
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(dir, docsDir, tempPrefix+"123"))
+
+	keys, cursor := listKeys(t, s, ListQuery{})
+	assert.Equal(t, []string{"doc0", "doc1", "doc2"}, keys)
+	assert.Empty(t, cursor)
+
+	got, err := s.GetDocument("doc2", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint(2), got.Width)
+
+	// New documents come after the existing ones.
+	require.NoError(t, s.SetDocument("doc3", &canvas.Canvas{}, ctx))
+
+	keys, _ = listKeys(t, s, ListQuery{})
+	assert.Equal(t, []string{"doc0", "doc1", "doc2", "doc3"}, keys)
+}
+
+func TestFileDataStore_LegacyDocuments(t *testing.T) {
+	ctx := context.TODO()
+	dir := t.TempDir()
+
+	// Document written as JSON by a previous version.
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, docsDir), dirMode))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, docsDir, "00000000000000000001_doc"+legacyDocExt),
+		[]byte(`{"name":"doc","width":2,"height":1,"data":"YWI=","revision":3}`),
+		fileMode,
+	))
+
+	s, err := NewFile(dir)
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, docsDir, "00000000000000000001_doc"+docExt))
+
+	got, err := s.GetDocument("doc", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &canvas.Canvas{Name: "doc", Width: 2, Height: 1, Data: []byte("ab"), Revision: 3}, got)
+
+	require.NoError(t, s.CompareAndSwapDocument("doc", 3, got, ctx))
+
+	data, err := os.ReadFile(filepath.Join(dir, docsDir, "00000000000000000001_doc"+docExt))
+	require.NoError(t, err)
+	assert.NotEqual(t, byte('{'), data[0])
+}
+
+func TestFileDataStore_ListDocuments(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewFile(dir)
+	require.NoError(t, err)
+
+	testListDocuments(t, s)
+
+	// The metadata of the documents is read back when the store is reopened.
+	reopened, err := NewFile(dir)
+	require.NoError(t, err)
+
+	infos, _, err := reopened.ListDocuments(&ListQuery{Sort: SortUpdated, Count: 1}, context.TODO())
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "doc5", infos[0].Key)
+	assert.False(t, infos[0].Updated.IsZero())
+}
+func TestFileDataStore_Operations(t *testing.T) {
+	ctx := context.TODO()
+	dir := t.TempDir()
+
+	s, err := NewFile(dir)
+	require.NoError(t, err)
+
+	ops, err := s.GetOperations("123", ctx)
+	require.NoError(t, err)
+	assert.Empty(t, ops)
+
+	require.NoError(t, s.SetDocument("123", &canvas.Canvas{Width: 3, Height: 3}, ctx))
+	require.NoError(t, s.AddOperation("123", canvas.NewSnapshot(&canvas.Canvas{Width: 3, Height: 3}), ctx))
+	require.NoError(t, s.AddOperation("123", canvas.NewFill(canvas.Point{X: 1, Y: 1}, "#"), ctx))
+
+	// Simulate a crash in the middle of an append.
+	f, err := os.OpenFile(filepath.Join(dir, opsDir, "123"+journalExt), os.O_WRONLY|os.O_APPEND, fileMode)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"type":"fi`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	ops, err = s.GetOperations("123", ctx)
+	require.NoError(t, err)
+	require.Len(t, ops, 2)
+	assert.Equal(t, canvas.OpSnapshot, ops[0].Type)
+	assert.Equal(t, canvas.OpFill, ops[1].Type)
+
+	// Reopening the store repairs the journal before new operations are added.
+	s, err = NewFile(dir)
+	require.NoError(t, err)
+	require.NoError(t, s.AddOperation("123", canvas.NewFill(canvas.Point{X: 0, Y: 0}, "*"), ctx))
+
+	ops, err = s.GetOperations("123", ctx)
+	require.NoError(t, err)
+	require.Len(t, ops, 3)
+	assert.Equal(t, "*", ops[2].Fill)
+
+	require.NoError(t, s.DeleteDocument("123", ctx))
+	assert.NoFileExists(t, filepath.Join(dir, opsDir, "123"+journalExt))
+}
+
+func TestFileDataStore_CompareAndSwapDocument(t *testing.T) {
+	s, err := NewFile(t.TempDir())
+	require.NoError(t, err)
+
+	testCompareAndSwap(t, s)
+}
+
+func TestFileDataStore_UpdateDocument(t *testing.T) {
+	s, err := NewFile(t.TempDir())
+	require.NoError(t, err)
+
+	testUpdateDocument(t, s)
+}
+
+func TestFileDataStore_DeleteExpired(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewFile(dir)
+	require.NoError(t, err)
+
+	testDeleteExpired(t, s, nil)
+
+	// The expiry of the documents is read back when the store is reopened.
+	reopened, err := NewFile(dir)
+	require.NoError(t, err)
+
+	deleted, err := reopened.DeleteExpired(time.Now().Add(24*time.Hour), context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, 0, deleted)
+}
+
+func TestFileDataStore_Trash(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewFile(dir)
+	require.NoError(t, err)
+
+	testTrash(t, s)
+
+	// The trash is read back when the store is reopened.
+	now := time.Now()
+	require.NoError(t, s.TrashDocument("a", now, context.TODO()))
+
+	reopened, err := NewFile(dir)
+	require.NoError(t, err)
+
+	trash, err := reopened.ListTrash(context.TODO())
+	require.NoError(t, err)
+
+	if assert.Len(t, trash, 1) {
+		assert.Equal(t, "a", trash[0].Key)
+		assert.True(t, now.Equal(trash[0].Deleted))
+	}
+
+	_, err = reopened.RestoreDocument("a", now, context.TODO())
+	require.NoError(t, err)
+}
+
+func TestFileDataStore_Journal(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewFile(dir)
+	require.NoError(t, err)
+
+	testJournal(t, s)
+
+	// The trimmed journal is read back when the store is reopened.
+	s, err = NewFile(dir)
+	require.NoError(t, err)
+
+	ops, err := s.GetOperations("a", context.TODO())
+	require.NoError(t, err)
+	assert.Len(t, ops, 5)
+}
@@ -0,0 +1,84 @@
+package datastore
+
+import (
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+// journalScan finds the start of the operations of a journal needed to rebuild a document at a revision and after,
+// reading the journal backwards from its last operation.
+// The operations are needed back to the last checkpoint produced at or before the revision, an operation holding
+// the whole canvas, along with the operations preceding it but producing later revisions, as concurrent updates
+// can be recorded out of order.
+type journalScan struct {
+	revision   uint64
+	checkpoint uint64
+	found      bool
+}
+
+// prepend reports whether an operation, preceding the operations already scanned, is needed.
+// Once it returns false, the operations preceding op aren't needed either.
+func (s *journalScan) prepend(op *canvas.Operation) bool {
+	revision, ok := op.ProducedRevision()
+
+	if s.found {
+		return ok && revision > s.checkpoint
+	}
+
+	if op.Canvas != nil && revision <= s.revision {
+		s.checkpoint, s.found = revision, true
+	}
+
+	return true
+}
+
+// trimmedLength returns the number of operations at the start of a journal that are only needed to rebuild a document
+// before a revision, so that the journal starts with the last checkpoint at or before the revision.
+// It returns 0 if operations recorded out of order precede the checkpoint, the journal is trimmed at a later checkpoint.
+func trimmedLength(ops []*canvas.Operation, revision uint64) int {
+	scan := journalScan{revision: revision}
+	checkpoint := -1
+
+	for i := len(ops) - 1; i >= 0; i-- {
+		found := scan.found
+
+		if !scan.prepend(ops[i]) {
+			if i+1 != checkpoint {
+				return 0
+			}
+
+			return checkpoint
+		}
+
+		if !found && scan.found {
+			checkpoint = i
+		}
+	}
+
+	return 0
+}
+
+// shiftRefs updates the indexes referenced by the undo and redo operations of a journal whose first n operations are trimmed,
+// the operations referencing a revision are left unchanged.
+// The references to the trimmed operations become negative, their undo and redo are no longer matched.
+// It returns the positions of the updated operations.
+func shiftRefs(ops []*canvas.Operation, n int) []int {
+	var shifted []int
+
+	for i, op := range ops {
+		if op.Ref != nil {
+			ref := *op.Ref - n
+			op.Ref = &ref
+
+			shifted = append(shifted, i)
+		}
+	}
+
+	return shifted
+}
+
+// reverseOperations reverses the order of operations read backwards from a journal.
+func reverseOperations(ops []*canvas.Operation) {
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+}
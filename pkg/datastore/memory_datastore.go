@@ -0,0 +1,414 @@
+package datastore
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+// MemoryDataStore is a DataStore keeping the documents in memory.
+// The documents are lost when the process exits.
+type MemoryDataStore struct {
+	sweeper
+
+	mu  sync.RWMutex
+	seq uint64
+
+	docs  map[string]memoryDocument
+	ops   map[string][][]byte
+	trash map[string]trashedDocument
+}
+
+// memoryDocument is a document stored in serialized form, so that callers can't alter the stored copy.
+// seq is the position of the document in the creation order, and info its metadata, used to list the documents.
+type memoryDocument struct {
+	seq      uint64
+	revision uint64
+	data     []byte
+	info     DocInfo
+}
+
+// trashedDocument is a document moved to the trash with its operations, keeping its position in the creation order.
+type trashedDocument struct {
+	memoryDocument
+	ops     [][]byte
+	deleted time.Time
+}
+
+// NewMemory creates an empty MemoryDataStore.
+func NewMemory() *MemoryDataStore {
+	return &MemoryDataStore{
+		docs:  map[string]memoryDocument{},
+		ops:   map[string][][]byte{},
+		trash: map[string]trashedDocument{},
+	}
+}
+
+// Close stops the deletion of the expired documents.
+func (s *MemoryDataStore) Close() error {
+	s.stopSweeping()
+
+	return nil
+}
+
+func (s *MemoryDataStore) GetSize(ctx context.Context) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return int64(len(s.docs)), nil
+}
+
+func (s *MemoryDataStore) ListDocuments(query *ListQuery, ctx context.Context) ([]*DocInfo, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]indexEntry, 0, len(s.docs))
+	for _, d := range s.docs {
+		entries = append(entries, indexEntry{seq: d.seq, info: d.info})
+	}
+
+	return listIndex(entries, query)
+}
+
+func (s *MemoryDataStore) SetDocument(key string, doc *canvas.Canvas, ctx context.Context) error {
+	data, err := doc.MarshalBinary()
+	if err != nil {
+		return xerrors.Errorf("failed to set document in memory store: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.docs[key]
+	if !ok {
+		s.seq++
+		d.seq = s.seq
+	}
+
+	d.data = data
+	d.revision = doc.Revision
+	d.info = newDocInfo(key, doc)
+	s.docs[key] = d
+
+	return nil
+}
+
+func (s *MemoryDataStore) CompareAndSwapDocument(key string, revision uint64, doc *canvas.Canvas, ctx context.Context) error {
+	next := *doc
+	next.Revision = revision + 1
+
+	data, err := next.MarshalBinary()
+	if err != nil {
+		return xerrors.Errorf("failed to set document in memory store: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.docs[key]
+	if !ok {
+		return NotFound
+	}
+
+	if d.revision != revision {
+		return Conflict
+	}
+
+	d.data = data
+	d.revision = next.Revision
+	d.info = newDocInfo(key, &next)
+	s.docs[key] = d
+	doc.Revision = next.Revision
+
+	return nil
+}
+
+func (s *MemoryDataStore) UpdateDocument(key string, op *canvas.Operation, revision uint64, ctx context.Context) (*canvas.Canvas, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.docs[key]
+	if !ok {
+		return nil, NotFound
+	}
+
+	doc := canvas.Canvas{}
+	if err := doc.UnmarshalBinary(d.data); err != nil {
+		return nil, xerrors.Errorf("failed to unmarshal document from memory store: %w", err)
+	}
+
+	if err := applyOperation(&doc, op, revision); err != nil {
+		return nil, err
+	}
+
+	data, err := doc.MarshalBinary()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to set document in memory store: %w", err)
+	}
+
+	d.data = data
+	d.revision = doc.Revision
+	d.info = newDocInfo(key, &doc)
+	s.docs[key] = d
+
+	return &doc, nil
+}
+
+func (s *MemoryDataStore) GetDocument(key string, ctx context.Context) (*canvas.Canvas, error) {
+	s.mu.RLock()
+	d, ok := s.docs[key]
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, NotFound
+	}
+
+	doc := canvas.Canvas{}
+	if err := doc.UnmarshalBinary(d.data); err != nil {
+		return nil, xerrors.Errorf("failed to unmarshal document from memory store: %w", err)
+	}
+
+	return &doc, nil
+}
+
+func (s *MemoryDataStore) DeleteDocument(key string, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.docs[key]; !ok {
+		return NotFound
+	}
+
+	delete(s.docs, key)
+	delete(s.ops, key)
+
+	return nil
+}
+
+func (s *MemoryDataStore) DeleteExpired(now time.Time, ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+
+	for key, d := range s.docs {
+		if expired(d.info.Expires, now) {
+			delete(s.docs, key)
+			delete(s.ops, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (s *MemoryDataStore) TrashDocument(key string, now time.Time, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.docs[key]
+	if !ok {
+		return NotFound
+	}
+
+	s.trash[key] = trashedDocument{memoryDocument: d, ops: s.ops[key], deleted: now}
+
+	delete(s.docs, key)
+	delete(s.ops, key)
+
+	return nil
+}
+
+func (s *MemoryDataStore) ListTrash(ctx context.Context) ([]*DocInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	infos := make([]*DocInfo, 0, len(s.trash))
+
+	for _, d := range s.trash {
+		info := d.info
+		info.Deleted = d.deleted
+		infos = append(infos, &info)
+	}
+
+	sortTrash(infos)
+
+	return infos, nil
+}
+
+func (s *MemoryDataStore) RestoreDocument(key string, now time.Time, ctx context.Context) (*canvas.Canvas, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.trash[key]
+	if !ok {
+		return nil, NotFound
+	}
+
+	if _, ok := s.docs[key]; ok {
+		return nil, Exists
+	}
+
+	doc := canvas.Canvas{}
+	if err := doc.UnmarshalBinary(d.data); err != nil {
+		return nil, xerrors.Errorf("failed to unmarshal document from memory store: %w", err)
+	}
+
+	doc.Touch(now)
+
+	data, err := doc.MarshalBinary()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to set document in memory store: %w", err)
+	}
+
+	d.data = data
+	d.info = newDocInfo(key, &doc)
+	s.docs[key] = d.memoryDocument
+
+	if d.ops != nil {
+		s.ops[key] = d.ops
+	}
+
+	delete(s.trash, key)
+
+	return &doc, nil
+}
+
+func (s *MemoryDataStore) PurgeDocument(key string, ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.trash[key]; !ok {
+		return NotFound
+	}
+
+	delete(s.trash, key)
+
+	return nil
+}
+
+func (s *MemoryDataStore) PurgeTrash(before time.Time, ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+
+	for key, d := range s.trash {
+		if purged(d.deleted, before) {
+			delete(s.trash, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (s *MemoryDataStore) AddOperation(key string, op *canvas.Operation, ctx context.Context) error {
+	data, err := op.MarshalBinary()
+	if err != nil {
+		return xerrors.Errorf("failed to add operation in memory store: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ops[key] = append(s.ops[key], data)
+
+	return nil
+}
+
+func (s *MemoryDataStore) GetOperations(key string, ctx context.Context) ([]*canvas.Operation, error) {
+	s.mu.RLock()
+	journal := s.ops[key]
+	s.mu.RUnlock()
+
+	ops := make([]*canvas.Operation, 0, len(journal))
+
+	for _, v := range journal {
+		op, err := decodeMemoryOperation(v)
+		if err != nil {
+			return nil, err
+		}
+
+		ops = append(ops, op)
+	}
+
+	return ops, nil
+}
+
+// GetOperationsFrom decodes the journal backwards, down to the first operation needed.
+func (s *MemoryDataStore) GetOperationsFrom(key string, revision uint64, ctx context.Context) ([]*canvas.Operation, error) {
+	s.mu.RLock()
+	journal := s.ops[key]
+	s.mu.RUnlock()
+
+	scan := journalScan{revision: revision}
+	ops := make([]*canvas.Operation, 0)
+
+	for i := len(journal) - 1; i >= 0; i-- {
+		op, err := decodeMemoryOperation(journal[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if !scan.prepend(op) {
+			break
+		}
+
+		ops = append(ops, op)
+	}
+
+	reverseOperations(ops)
+
+	return ops, nil
+}
+
+func (s *MemoryDataStore) TrimOperations(key string, revision uint64, ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ops := make([]*canvas.Operation, 0, len(s.ops[key]))
+
+	for _, v := range s.ops[key] {
+		op, err := decodeMemoryOperation(v)
+		if err != nil {
+			return 0, err
+		}
+
+		ops = append(ops, op)
+	}
+
+	n := trimmedLength(ops, revision)
+	if n == 0 {
+		return 0, nil
+	}
+
+	journal := append([][]byte(nil), s.ops[key][n:]...)
+
+	for _, i := range shiftRefs(ops[n:], n) {
+		data, err := ops[n+i].MarshalBinary()
+		if err != nil {
+			return 0, xerrors.Errorf("failed to trim operations in memory store: %w", err)
+		}
+
+		journal[i] = data
+	}
+
+	s.ops[key] = journal
+
+	return n, nil
+}
+
+func decodeMemoryOperation(data []byte) (*canvas.Operation, error) {
+	op := canvas.Operation{}
+	if err := json.Unmarshal(data, &op); err != nil {
+		return nil, xerrors.Errorf("failed to unmarshal operation from memory store: %w", err)
+	}
+
+	return &op, nil
+}
@@ -0,0 +1,117 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+func TestMemoryDataStore_Document(t *testing.T) {
+	ctx := context.TODO()
+	s := NewMemory()
+
+	_, err := s.GetDocument("123", ctx)
+	assert.Equal(t, NotFound, err)
+
+	doc := &canvas.Canvas{Name: "doc", Width: 3, Height: 1, Data: []byte("abc")}
+	require.NoError(t, s.SetDocument("123", doc, ctx))
+
+	// The store keeps its own copy of the document.
+	doc.Data[0] = 'x'
+
+	got, err := s.GetDocument("123", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &canvas.Canvas{Name: "doc", Width: 3, Height: 1, Data: []byte("abc")}, got)
+
+	size, err := s.GetSize(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), size)
+
+	require.NoError(t, s.DeleteDocument("123", ctx))
+	assert.Equal(t, NotFound, s.DeleteDocument("123", ctx))
+
+	_, err = s.GetDocument("123", ctx)
+	assert.Equal(t, NotFound, err)
+}
+
+func TestMemoryDataStore_ListDocuments(t *testing.T) {
+	testListDocuments(t, NewMemory())
+}
+func TestMemoryDataStore_Operations(t *testing.T) {
+	ctx := context.TODO()
+	s := NewMemory()
+
+	require.NoError(t, s.SetDocument("123", &canvas.Canvas{Width: 3, Height: 3}, ctx))
+	require.NoError(t, s.AddOperation("123", canvas.NewSnapshot(&canvas.Canvas{Width: 3, Height: 3}), ctx))
+	require.NoError(t, s.AddOperation("123", canvas.NewFill(canvas.Point{X: 1, Y: 1}, "#"), ctx))
+
+	ops, err := s.GetOperations("123", ctx)
+	require.NoError(t, err)
+	require.Len(t, ops, 2)
+	assert.Equal(t, canvas.OpSnapshot, ops[0].Type)
+	assert.Equal(t, canvas.OpFill, ops[1].Type)
+
+	// Deleting the document removes its journal.
+	require.NoError(t, s.DeleteDocument("123", ctx))
+
+	ops, err = s.GetOperations("123", ctx)
+	require.NoError(t, err)
+	assert.Empty(t, ops)
+}
+
+func TestMemoryDataStore_Concurrency(t *testing.T) {
+	ctx := context.TODO()
+	s := NewMemory()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			key := fmt.Sprintf("doc%d", i)
+			for j := 0; j < 50; j++ {
+				assert.NoError(t, s.SetDocument(key, &canvas.Canvas{Width: uint(j)}, ctx))
+				assert.NoError(t, s.AddOperation(key, canvas.NewFill(canvas.Point{}, "#"), ctx))
+				_, err := s.GetDocument(key, ctx)
+				assert.NoError(t, err)
+				_, _, err = s.ListDocuments(&ListQuery{Count: 3}, ctx)
+				assert.NoError(t, err)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	size, err := s.GetSize(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), size)
+}
+
+func TestMemoryDataStore_CompareAndSwapDocument(t *testing.T) {
+	testCompareAndSwap(t, NewMemory())
+}
+
+func TestMemoryDataStore_UpdateDocument(t *testing.T) {
+	testUpdateDocument(t, NewMemory())
+}
+
+func TestMemoryDataStore_DeleteExpired(t *testing.T) {
+	testDeleteExpired(t, NewMemory(), nil)
+}
+
+func TestMemoryDataStore_Trash(t *testing.T) {
+	testTrash(t, NewMemory())
+}
+
+func TestMemoryDataStore_Journal(t *testing.T) {
+	testJournal(t, NewMemory())
+}
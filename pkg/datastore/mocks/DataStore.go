@@ -7,7 +7,11 @@ import (
 
 	canvas "github.com/hexbee-net/sketch-canvas/pkg/canvas"
 
+	datastore "github.com/hexbee-net/sketch-canvas/pkg/datastore"
+
 	mock "github.com/stretchr/testify/mock"
+
+	time "time"
 )
 
 // DataStore is an autogenerated mock type for the DataStore type
@@ -15,6 +19,48 @@ type DataStore struct {
 	mock.Mock
 }
 
+// AddOperation provides a mock function with given fields: key, op, ctx
+func (_m *DataStore) AddOperation(key string, op *canvas.Operation, ctx context.Context) error {
+	ret := _m.Called(key, op, ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, *canvas.Operation, context.Context) error); ok {
+		r0 = rf(key, op, ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Close provides a mock function with given fields:
+func (_m *DataStore) Close() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CompareAndSwapDocument provides a mock function with given fields: key, revision, doc, ctx
+func (_m *DataStore) CompareAndSwapDocument(key string, revision uint64, doc *canvas.Canvas, ctx context.Context) error {
+	ret := _m.Called(key, revision, doc, ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, uint64, *canvas.Canvas, context.Context) error); ok {
+		r0 = rf(key, revision, doc, ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteDocument provides a mock function with given fields: key, ctx
 func (_m *DataStore) DeleteDocument(key string, ctx context.Context) error {
 	ret := _m.Called(key, ctx)
@@ -29,34 +75,25 @@ func (_m *DataStore) DeleteDocument(key string, ctx context.Context) error {
 	return r0
 }
 
-// GetDocList provides a mock function with given fields: cursor, count, ctx
-func (_m *DataStore) GetDocList(cursor uint64, count int64, ctx context.Context) ([]string, uint64, error) {
-	ret := _m.Called(cursor, count, ctx)
+// DeleteExpired provides a mock function with given fields: now, ctx
+func (_m *DataStore) DeleteExpired(now time.Time, ctx context.Context) (int, error) {
+	ret := _m.Called(now, ctx)
 
-	var r0 []string
-	if rf, ok := ret.Get(0).(func(uint64, int64, context.Context) []string); ok {
-		r0 = rf(cursor, count, ctx)
+	var r0 int
+	if rf, ok := ret.Get(0).(func(time.Time, context.Context) int); ok {
+		r0 = rf(now, ctx)
 	} else {
-		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]string)
-		}
-	}
-
-	var r1 uint64
-	if rf, ok := ret.Get(1).(func(uint64, int64, context.Context) uint64); ok {
-		r1 = rf(cursor, count, ctx)
-	} else {
-		r1 = ret.Get(1).(uint64)
+		r0 = ret.Get(0).(int)
 	}
 
-	var r2 error
-	if rf, ok := ret.Get(2).(func(uint64, int64, context.Context) error); ok {
-		r2 = rf(cursor, count, ctx)
+	var r1 error
+	if rf, ok := ret.Get(1).(func(time.Time, context.Context) error); ok {
+		r1 = rf(now, ctx)
 	} else {
-		r2 = ret.Error(2)
+		r1 = ret.Error(1)
 	}
 
-	return r0, r1, r2
+	return r0, r1
 }
 
 // GetDocument provides a mock function with given fields: key, ctx
@@ -82,6 +119,52 @@ func (_m *DataStore) GetDocument(key string, ctx context.Context) (*canvas.Canva
 	return r0, r1
 }
 
+// GetOperations provides a mock function with given fields: key, ctx
+func (_m *DataStore) GetOperations(key string, ctx context.Context) ([]*canvas.Operation, error) {
+	ret := _m.Called(key, ctx)
+
+	var r0 []*canvas.Operation
+	if rf, ok := ret.Get(0).(func(string, context.Context) []*canvas.Operation); ok {
+		r0 = rf(key, ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*canvas.Operation)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, context.Context) error); ok {
+		r1 = rf(key, ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetOperationsFrom provides a mock function with given fields: key, revision, ctx
+func (_m *DataStore) GetOperationsFrom(key string, revision uint64, ctx context.Context) ([]*canvas.Operation, error) {
+	ret := _m.Called(key, revision, ctx)
+
+	var r0 []*canvas.Operation
+	if rf, ok := ret.Get(0).(func(string, uint64, context.Context) []*canvas.Operation); ok {
+		r0 = rf(key, revision, ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*canvas.Operation)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, uint64, context.Context) error); ok {
+		r1 = rf(key, revision, ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetSize provides a mock function with given fields: ctx
 func (_m *DataStore) GetSize(ctx context.Context) (int64, error) {
 	ret := _m.Called(ctx)
@@ -103,6 +186,117 @@ func (_m *DataStore) GetSize(ctx context.Context) (int64, error) {
 	return r0, r1
 }
 
+// ListDocuments provides a mock function with given fields: query, ctx
+func (_m *DataStore) ListDocuments(query *datastore.ListQuery, ctx context.Context) ([]*datastore.DocInfo, string, error) {
+	ret := _m.Called(query, ctx)
+
+	var r0 []*datastore.DocInfo
+	if rf, ok := ret.Get(0).(func(*datastore.ListQuery, context.Context) []*datastore.DocInfo); ok {
+		r0 = rf(query, ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*datastore.DocInfo)
+		}
+	}
+
+	var r1 string
+	if rf, ok := ret.Get(1).(func(*datastore.ListQuery, context.Context) string); ok {
+		r1 = rf(query, ctx)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(*datastore.ListQuery, context.Context) error); ok {
+		r2 = rf(query, ctx)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// ListTrash provides a mock function with given fields: ctx
+func (_m *DataStore) ListTrash(ctx context.Context) ([]*datastore.DocInfo, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []*datastore.DocInfo
+	if rf, ok := ret.Get(0).(func(context.Context) []*datastore.DocInfo); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*datastore.DocInfo)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PurgeDocument provides a mock function with given fields: key, ctx
+func (_m *DataStore) PurgeDocument(key string, ctx context.Context) error {
+	ret := _m.Called(key, ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, context.Context) error); ok {
+		r0 = rf(key, ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// PurgeTrash provides a mock function with given fields: before, ctx
+func (_m *DataStore) PurgeTrash(before time.Time, ctx context.Context) (int, error) {
+	ret := _m.Called(before, ctx)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(time.Time, context.Context) int); ok {
+		r0 = rf(before, ctx)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(time.Time, context.Context) error); ok {
+		r1 = rf(before, ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RestoreDocument provides a mock function with given fields: key, now, ctx
+func (_m *DataStore) RestoreDocument(key string, now time.Time, ctx context.Context) (*canvas.Canvas, error) {
+	ret := _m.Called(key, now, ctx)
+
+	var r0 *canvas.Canvas
+	if rf, ok := ret.Get(0).(func(string, time.Time, context.Context) *canvas.Canvas); ok {
+		r0 = rf(key, now, ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*canvas.Canvas)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, time.Time, context.Context) error); ok {
+		r1 = rf(key, now, ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // SetDocument provides a mock function with given fields: key, doc, ctx
 func (_m *DataStore) SetDocument(key string, doc *canvas.Canvas, ctx context.Context) error {
 	ret := _m.Called(key, doc, ctx)
@@ -116,3 +310,61 @@ func (_m *DataStore) SetDocument(key string, doc *canvas.Canvas, ctx context.Con
 
 	return r0
 }
+
+// TrashDocument provides a mock function with given fields: key, now, ctx
+func (_m *DataStore) TrashDocument(key string, now time.Time, ctx context.Context) error {
+	ret := _m.Called(key, now, ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, time.Time, context.Context) error); ok {
+		r0 = rf(key, now, ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// TrimOperations provides a mock function with given fields: key, revision, ctx
+func (_m *DataStore) TrimOperations(key string, revision uint64, ctx context.Context) (int, error) {
+	ret := _m.Called(key, revision, ctx)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(string, uint64, context.Context) int); ok {
+		r0 = rf(key, revision, ctx)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, uint64, context.Context) error); ok {
+		r1 = rf(key, revision, ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateDocument provides a mock function with given fields: key, op, revision, ctx
+func (_m *DataStore) UpdateDocument(key string, op *canvas.Operation, revision uint64, ctx context.Context) (*canvas.Canvas, error) {
+	ret := _m.Called(key, op, revision, ctx)
+
+	var r0 *canvas.Canvas
+	if rf, ok := ret.Get(0).(func(string, *canvas.Operation, uint64, context.Context) *canvas.Canvas); ok {
+		r0 = rf(key, op, revision, ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*canvas.Canvas)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, *canvas.Operation, uint64, context.Context) error); ok {
+		r1 = rf(key, op, revision, ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
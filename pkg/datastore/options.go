@@ -0,0 +1,147 @@
+package datastore
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/xerrors"
+)
+
+// Options selects and configures a DataStore implementation.
+type Options struct {
+	// Driver is the name of the DataStore implementation.
+	Driver string
+	// Redis configures the connection of the Redis driver.
+	Redis RedisOptions
+	// Path is the directory of the file driver or the database file of the sqlite driver.
+	Path string
+	// Retention is the lifetime without updates of the documents created without TTL, 0 to keep them forever.
+	Retention time.Duration
+	// PurgeDelay is the time the deleted documents stay in the trash before being purged, 0 to keep them until purged explicitly.
+	PurgeDelay time.Duration
+	// UndoDepth is the number of their last drawing operations the callers can undo on a document, 0 disables undo.
+	UndoDepth int
+	// SweepInterval is the period at which the store deletes the expired documents and purges the trash, 0 disables it.
+	// Redis expires the documents by itself, its sweeper only cleans up the indexes and counter.
+	SweepInterval time.Duration
+}
+
+// ParseDSN updates the options from a data source name:
+//
+//	redis://[[user]:password@]host[:port][/db]
+//	rediss://...
+//	file:///path/to/directory
+//	sqlite:///path/to/database.db
+//	sqlite://:memory:
+//	memory://
+//
+// The scheme selects the driver. A value without scheme is the address of a Redis server,
+// leaving the driver unchanged. Redis credentials and database missing from the name keep their current value.
+func (o *Options) ParseDSN(dsn string) error {
+	if !strings.Contains(dsn, "://") {
+		o.Redis.Addr = dsn
+
+		return nil
+	}
+
+	// SQLite paths are passed as is to the driver, they are not URLs.
+	if prefix := DriverSQLite + "://"; strings.HasPrefix(dsn, prefix) {
+		o.Driver = DriverSQLite
+		o.Path = strings.TrimPrefix(dsn, prefix)
+
+		return nil
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return xerrors.Errorf("invalid datastore %q: %w", dsn, err)
+	}
+
+	switch u.Scheme {
+	case "redis", "rediss":
+		opts, err := redis.ParseURL(dsn)
+		if err != nil {
+			return xerrors.Errorf("invalid datastore %q: %w", dsn, err)
+		}
+
+		o.Driver = DriverRedis
+		o.Redis.Addr = opts.Addr
+		o.Redis.TLSConfig = opts.TLSConfig
+
+		if u.User != nil {
+			o.Redis.Username, o.Redis.Password = opts.Username, opts.Password
+		}
+
+		if strings.Trim(u.Path, "/") != "" {
+			o.Redis.DB = opts.DB
+		}
+	case DriverFile:
+		o.Driver = DriverFile
+		o.Path = u.Host + u.Path
+	case DriverMemory:
+		o.Driver = DriverMemory
+	default:
+		return xerrors.Errorf("invalid datastore %q: %w", dsn, UnknownDriver)
+	}
+
+	return nil
+}
+
+// Open creates the DataStore shared by all the requests, which must be closed when the server stops.
+// The Redis driver connects lazily, the availability of the server is not checked.
+// The expired documents are deleted, and the trash purged, in the background every SweepInterval.
+func Open(options *Options) (DataStore, error) {
+	switch options.Driver {
+	case DriverRedis:
+		if err := options.Redis.validate(); err != nil {
+			return nil, err
+		}
+
+		s := NewRedis(&options.Redis)
+		s.startSweeping(options.SweepInterval, options.sweep(s))
+
+		return s, nil
+	case DriverMemory:
+		s := NewMemory()
+		s.startSweeping(options.SweepInterval, options.sweep(s))
+
+		return s, nil
+	case DriverFile:
+		s, err := NewFile(options.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		s.startSweeping(options.SweepInterval, options.sweep(s))
+
+		return s, nil
+	case DriverSQLite:
+		s, err := NewSQLite(options.Path, context.Background())
+		if err != nil {
+			return nil, err
+		}
+
+		s.startSweeping(options.SweepInterval, options.sweep(s))
+
+		return s, nil
+	default:
+		return nil, xerrors.Errorf("failed to open datastore %q: %w", options.Driver, UnknownDriver)
+	}
+}
+
+// sweep returns the function deleting the expired documents of a store, and purging its trash after PurgeDelay.
+func (o *Options) sweep(s DataStore) sweepFunc {
+	return func(now time.Time, ctx context.Context) (int, error) {
+		deleted, err := s.DeleteExpired(now, ctx)
+		if err != nil || o.PurgeDelay <= 0 {
+			return deleted, err //nolint:wrapcheck
+		}
+
+		purged, err := s.PurgeTrash(now.Add(-o.PurgeDelay), ctx)
+
+		return deleted + purged, err //nolint:wrapcheck
+	}
+}
@@ -0,0 +1,163 @@
+package datastore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+func TestOptions_ParseDSN(t *testing.T) {
+	defaults := func() Options {
+		o := Options{Driver: DriverRedis, Path: "data"}
+		o.Redis.Addr = "localhost:6379"
+		o.Redis.Password = "secret"
+		o.Redis.DB = 2
+
+		return o
+	}
+
+	tests := []struct {
+		name    string
+		dsn     string
+		want    func(o *Options)
+		wantErr error
+	}{
+		{
+			name: "plain address",
+			dsn:  "redis.local:6380",
+			want: func(o *Options) {
+				o.Redis.Addr = "redis.local:6380"
+			},
+		},
+		{
+			name: "redis url",
+			dsn:  "redis://:pass@redis.local/5",
+			want: func(o *Options) {
+				o.Redis.Addr = "redis.local:6379"
+				o.Redis.Password = "pass"
+				o.Redis.DB = 5
+			},
+		},
+		{
+			name: "redis url keeps credentials and database",
+			dsn:  "redis://redis.local:6380",
+			want: func(o *Options) {
+				o.Redis.Addr = "redis.local:6380"
+			},
+		},
+		{
+			name: "file",
+			dsn:  "file:///var/lib/sketch-canvas",
+			want: func(o *Options) {
+				o.Driver = DriverFile
+				o.Path = "/var/lib/sketch-canvas"
+			},
+		},
+		{
+			name: "relative file",
+			dsn:  "file://data/docs",
+			want: func(o *Options) {
+				o.Driver = DriverFile
+				o.Path = "data/docs"
+			},
+		},
+		{
+			name: "sqlite",
+			dsn:  "sqlite:///var/lib/sketch-canvas.db",
+			want: func(o *Options) {
+				o.Driver = DriverSQLite
+				o.Path = "/var/lib/sketch-canvas.db"
+			},
+		},
+		{
+			name: "sqlite in memory",
+			dsn:  "sqlite://:memory:",
+			want: func(o *Options) {
+				o.Driver = DriverSQLite
+				o.Path = ":memory:"
+			},
+		},
+		{
+			name: "memory",
+			dsn:  "memory://",
+			want: func(o *Options) {
+				o.Driver = DriverMemory
+			},
+		},
+		{
+			name:    "unknown scheme",
+			dsn:     "mongodb://localhost",
+			wantErr: UnknownDriver,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := defaults()
+			err := got.ParseDSN(tt.dsn)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+
+				return
+			}
+
+			want := defaults()
+			tt.want(&want)
+
+			assert.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	store, err := Open(&Options{Driver: DriverMemory})
+	assert.NoError(t, err)
+	assert.IsType(t, &MemoryDataStore{}, store)
+
+	store, err = Open(&Options{Driver: DriverFile, Path: t.TempDir()})
+	assert.NoError(t, err)
+	assert.IsType(t, &FileDataStore{}, store)
+
+	store, err = Open(&Options{Driver: DriverSQLite, Path: ":memory:"})
+	assert.NoError(t, err)
+	assert.IsType(t, &SQLiteDataStore{}, store)
+
+	store, err = Open(&Options{Driver: DriverRedis})
+	assert.NoError(t, err)
+	assert.IsType(t, &RedisDataStore{}, store)
+	assert.NoError(t, store.Close())
+
+	_, err = Open(&Options{Driver: "nosql"})
+	assert.ErrorIs(t, err, UnknownDriver)
+}
+
+func TestOptions_sweep(t *testing.T) {
+	ctx := context.TODO()
+	now := time.Now()
+	s := NewMemory()
+
+	expiring := &canvas.Canvas{Width: 1, Height: 1, Retention: time.Minute}
+	expiring.Touch(now)
+	require.NoError(t, s.SetDocument("expiring", expiring, ctx))
+	require.NoError(t, s.SetDocument("trashed", &canvas.Canvas{Width: 1, Height: 1}, ctx))
+	require.NoError(t, s.TrashDocument("trashed", now, ctx))
+
+	// Without purge delay, the trash is kept.
+	deleted, err := (&Options{}).sweep(s)(now.Add(2*time.Hour), ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	deleted, err = (&Options{PurgeDelay: time.Hour}).sweep(s)(now.Add(2*time.Hour), ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	trash, err := s.ListTrash(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, trash)
+}
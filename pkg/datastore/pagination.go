@@ -0,0 +1,233 @@
+package datastore
+
+import (
+	"encoding/base64"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+// defaultListCount is the size of a page of documents when the requested count is not positive,
+// the same default as the Redis SCAN command.
+const defaultListCount = 10
+
+// MaxListCount is the maximum size of a page of documents, larger counts are reduced to it.
+const MaxListCount = 1000
+
+// Orders of the document list.
+const (
+	// SortCreated lists the documents in creation order, oldest first.
+	SortCreated = "created"
+	// SortUpdated lists the most recently updated documents first.
+	SortUpdated = "updated"
+)
+
+// DocInfo is the metadata of a document, returned by ListDocuments without its content.
+type DocInfo struct {
+	Key         string
+	Name        string
+	Creator     string
+	Description string
+	Tags        []string
+	Created     time.Time
+	Updated     time.Time
+	Expires     time.Time
+	// Deleted is when the document was moved to the trash, only set by ListTrash.
+	Deleted time.Time
+}
+
+// newDocInfo returns the metadata of a document.
+func newDocInfo(key string, doc *canvas.Canvas) DocInfo {
+	info := DocInfo{
+		Key:         key,
+		Name:        doc.Name,
+		Creator:     doc.Creator,
+		Description: doc.Description,
+		Created:     doc.Created,
+		Updated:     doc.Updated,
+		Expires:     doc.Expires,
+	}
+
+	if len(doc.Tags) > 0 {
+		info.Tags = append([]string{}, doc.Tags...)
+	}
+
+	return info
+}
+
+// ListQuery selects a page of the document list.
+type ListQuery struct {
+	// Cursor is the position returned with the previous page, empty for the first page.
+	Cursor string
+	// Count is the maximum number of documents of the page, defaultListCount if not positive,
+	// and at most MaxListCount.
+	Count int64
+	// Sort is the order of the list, SortCreated if empty.
+	Sort string
+	// Tag only lists the documents having this tag, if set.
+	Tag string
+	// NamePrefix only lists the documents whose name starts with this prefix, if set.
+	NamePrefix string
+}
+
+// validate checks the query and sets its defaults.
+func (q *ListQuery) validate() error {
+	if q.Count <= 0 {
+		q.Count = defaultListCount
+	}
+
+	if q.Count > MaxListCount {
+		q.Count = MaxListCount
+	}
+
+	switch q.Sort {
+	case "":
+		q.Sort = SortCreated
+	case SortCreated, SortUpdated:
+	default:
+		return UnknownSort
+	}
+
+	return nil
+}
+
+// matches reports whether a document passes the filters of the query.
+func (q *ListQuery) matches(info *DocInfo) bool {
+	if q.NamePrefix != "" && !strings.HasPrefix(info.Name, q.NamePrefix) {
+		return false
+	}
+
+	if q.Tag == "" {
+		return true
+	}
+
+	for _, t := range info.Tags {
+		if t == q.Tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// listPosition is the position of a document in the list: the value it is sorted by,
+// and its key breaking ties. Cursors are the position of the last document of a page,
+// so that pages stay stable when documents are created or deleted between two calls.
+type listPosition struct {
+	value int64
+	key   string
+}
+
+// before reports whether p comes before o in the given order.
+// Documents are listed by increasing value in creation order, and by decreasing value in update order.
+func (p listPosition) before(o listPosition, order string) bool {
+	if order == SortUpdated {
+		return p.value > o.value || p.value == o.value && p.key > o.key
+	}
+
+	return p.value < o.value || p.value == o.value && p.key < o.key
+}
+
+// timeValue returns a timestamp as the number of nanoseconds since the Unix epoch, 0 for the zero time.
+func timeValue(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano()
+}
+
+// timeFromValue returns the UTC time of a timestamp returned by timeValue.
+func timeFromValue(v int64) time.Time {
+	if v == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, v).UTC()
+}
+
+// encodeCursor returns the opaque cursor of a position.
+func encodeCursor(p listPosition) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(p.value, 10) + ":" + p.key))
+}
+
+// decodeCursor returns the position of a cursor, nil for an empty cursor.
+func decodeCursor(cursor string) (*listPosition, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, InvalidCursor
+	}
+
+	parts := strings.SplitN(string(data), ":", 2) //nolint:gomnd
+	if len(parts) != 2 {                          //nolint:gomnd
+		return nil, InvalidCursor
+	}
+
+	value, err := strconv.ParseInt(parts[0], 10, 64) //nolint:gomnd
+	if err != nil {
+		return nil, InvalidCursor
+	}
+
+	return &listPosition{value: value, key: parts[1]}, nil
+}
+
+// indexEntry is a document of an in-memory index, with its position in the creation order.
+type indexEntry struct {
+	seq  uint64
+	info DocInfo
+}
+
+// position returns the position of the entry in the given order.
+func (e *indexEntry) position(order string) listPosition {
+	if order == SortUpdated {
+		return listPosition{value: timeValue(e.info.Updated), key: e.info.Key}
+	}
+
+	return listPosition{value: int64(e.seq), key: e.info.Key}
+}
+
+// listIndex returns a page of the documents of an in-memory index, with the cursor of the next page,
+// empty if the list is complete.
+func listIndex(entries []indexEntry, query *ListQuery) ([]*DocInfo, string, error) {
+	if err := query.validate(); err != nil {
+		return nil, "", err
+	}
+
+	after, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	remaining := entries[:0]
+
+	for i := range entries {
+		e := &entries[i]
+		if query.matches(&e.info) && (after == nil || after.before(e.position(query.Sort), query.Sort)) {
+			remaining = append(remaining, *e)
+		}
+	}
+
+	sort.Slice(remaining, func(i, j int) bool {
+		return remaining[i].position(query.Sort).before(remaining[j].position(query.Sort), query.Sort)
+	})
+
+	next := ""
+	if int64(len(remaining)) > query.Count {
+		remaining = remaining[:query.Count]
+		next = encodeCursor(remaining[query.Count-1].position(query.Sort))
+	}
+
+	infos := make([]*DocInfo, 0, len(remaining))
+	for i := range remaining {
+		infos = append(infos, &remaining[i].info)
+	}
+
+	return infos, next, nil
+}
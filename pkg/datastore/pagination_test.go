@@ -0,0 +1,32 @@
+package datastore
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListQuery_validate(t *testing.T) {
+	tests := []struct {
+		name  string
+		count int64
+		want  int64
+	}{
+		{name: "default", count: 0, want: defaultListCount},
+		{name: "negative", count: -1, want: defaultListCount},
+		{name: "in range", count: 5, want: 5},
+		{name: "maximum", count: MaxListCount, want: MaxListCount},
+		{name: "above maximum", count: MaxListCount + 1, want: MaxListCount},
+		{name: "max int64", count: math.MaxInt64, want: MaxListCount},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := ListQuery{Count: tt.count}
+
+			assert.NoError(t, q.validate())
+			assert.Equal(t, tt.want, q.Count)
+			assert.Equal(t, SortCreated, q.Sort)
+		})
+	}
+}
@@ -2,8 +2,6 @@ package datastore
 
 import (
 	"context"
-	"encoding/json"
-	"strings"
 
 	"github.com/apex/log"
 	"github.com/go-redis/redis/v8"
@@ -12,12 +10,54 @@ import (
 	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
 )
 
+// operationsSuffix is appended to the key of a document to get the key of its operation journal.
+const operationsSuffix = ":ops"
+
+// DefaultRedisPrefix is the prefix of the keys written by the store when none is configured.
+const DefaultRedisPrefix = "canvas:"
+
+// Keys of the store, under its prefix: the documents are stored at <prefix>doc:<id>,
+// along with their grid and operation journal, and their number is kept at <prefix>count.
+// The indexes listing the documents are sorted sets of their ids, see ListDocuments.
+// Trashed documents are moved to <prefix>trash:<id>, see TrashDocument.
+const (
+	docNamespace     = "doc:"
+	trashNamespace   = "trash:"
+	countName        = "count"
+	createdIndexName = "idx:created"
+	updatedIndexName = "idx:updated"
+	expiresIndexName = "idx:expires"
+	trashIndexName   = "idx:trash"
+)
+
+// maxTxRetries is the number of times an update is attempted when the document is modified during the transaction.
+const maxTxRetries = 3
+
 type RedisOptions struct {
 	redis.Options
+	// Layout is the way documents are written, RedisLayoutHash by default.
+	// Documents are read in both layouts, so that it can be changed on an existing database.
+	Layout string
+	// Prefix is prepended to all the keys of the store, DefaultRedisPrefix by default,
+	// so that it can share a database with other applications.
+	Prefix string
 }
 
 type RedisDataStore struct {
-	rdb *redis.Client
+	sweeper
+
+	rdb        *redis.Client
+	addr       string
+	hashLayout bool
+
+	prefix      string
+	docPrefix   string
+	trashPrefix string
+	countKey    string
+	createdKey  string
+	updatedKey  string
+	expiresKey  string
+	trashKey    string
 }
 
 // New creates a new RedisDataStore instance and check the connectivity to the Redis instance.
@@ -30,81 +70,287 @@ func New(options *RedisOptions, ctx context.Context) (*RedisDataStore, error) {
 		return nil, xerrors.New("missing execution context")
 	}
 
-	rdb := redis.NewClient(&options.Options)
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+
+	store := NewRedis(options)
+
+	if err := store.Ping(ctx); err != nil {
+		_ = store.Close()
 
-	if _, err := rdb.Ping(ctx).Result(); err != nil {
-		return nil, xerrors.Errorf("failed to connect to Redis instance at %s: %w", options.Addr, err)
+		return nil, err
 	}
 
 	log.Debugf("found Redis instance at %s", options.Addr)
 
-	store := RedisDataStore{
-		rdb: rdb,
+	return store, nil
+}
+
+// NewRedis creates a new RedisDataStore instance without connecting to the Redis instance.
+// The client keeps a pool of connections, opened when needed, and must be closed when the store is no longer used.
+func NewRedis(options *RedisOptions) *RedisDataStore {
+	return newRedisStore(redis.NewClient(&options.Options), options)
+}
+
+func newRedisStore(rdb *redis.Client, options *RedisOptions) *RedisDataStore {
+	prefix := options.Prefix
+	if prefix == "" {
+		prefix = DefaultRedisPrefix
+	}
+
+	return &RedisDataStore{
+		rdb:         rdb,
+		addr:        options.Addr,
+		hashLayout:  options.Layout != RedisLayoutString,
+		prefix:      prefix,
+		docPrefix:   prefix + docNamespace,
+		trashPrefix: prefix + trashNamespace,
+		countKey:    prefix + countName,
+		createdKey:  prefix + createdIndexName,
+		updatedKey:  prefix + updatedIndexName,
+		expiresKey:  prefix + expiresIndexName,
+		trashKey:    prefix + trashIndexName,
 	}
+}
 
-	return &store, nil
+func (o *RedisOptions) validate() error {
+	switch o.Layout {
+	case "", RedisLayoutHash, RedisLayoutString:
+		return nil
+	default:
+		return xerrors.Errorf("invalid redis layout %q: %w", o.Layout, UnknownLayout)
+	}
 }
 
-func (s *RedisDataStore) GetSize(ctx context.Context) (int64, error) {
-	dbSize := s.rdb.DBSize(ctx)
-	if err := dbSize.Err(); err != nil {
-		return 0, xerrors.Errorf("failed to retrieve size of redis store: %w", err)
+// Ping checks the connectivity to the Redis instance.
+func (s *RedisDataStore) Ping(ctx context.Context) error {
+	if err := s.rdb.Ping(ctx).Err(); err != nil {
+		return xerrors.Errorf("failed to connect to Redis instance at %s: %w", s.addr, err)
 	}
 
-	return dbSize.Val(), nil
+	return nil
 }
 
-func (s *RedisDataStore) GetDocList(cursor uint64, count int64, ctx context.Context) ([]string, uint64, error) {
-	cmd := s.rdb.Scan(ctx, cursor, "", count)
-	if err := cmd.Err(); err != nil {
-		return nil, 0, xerrors.Errorf("failed to retrieve documents from redis store: %w", err)
+// Close stops the cleanup of the expired documents and releases the connections of the client.
+func (s *RedisDataStore) Close() error {
+	s.stopSweeping()
+
+	if err := s.rdb.Close(); err != nil {
+		return xerrors.Errorf("failed to close Redis client: %w", err)
 	}
 
-	keys, cursor := cmd.Val()
+	return nil
+}
 
-	return keys, cursor, nil
+// docKey returns the Redis key of a document.
+func (s *RedisDataStore) docKey(key string) string {
+	return s.docPrefix + key
 }
 
+// GetSize returns the document counter, updated along with the documents when they are created and deleted.
+func (s *RedisDataStore) GetSize(ctx context.Context) (int64, error) {
+	size, err := s.rdb.Get(ctx, s.countKey).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, xerrors.Errorf("failed to retrieve size of redis store: %w", err)
+	}
+
+	return size, nil
+}
+
+// SetDocument writes and indexes a document, and counts it in the same transaction if it is new.
 func (s *RedisDataStore) SetDocument(key string, doc *canvas.Canvas, ctx context.Context) error {
-	if set := s.rdb.Set(ctx, key, doc, 0).Err(); set != nil {
-		return xerrors.Errorf("failed to set document in redis store: %w", set)
+	docKey := s.docKey(key)
+
+	if _, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Eval(ctx, countScript, []string{docKey, s.countKey})
+		s.writeDocument(pipe, key, doc, ctx)
+
+		return nil
+	}); err != nil {
+		return xerrors.Errorf("failed to set document in redis store: %w", err)
 	}
 
 	return nil
 }
 
-func (s *RedisDataStore) GetDocument(key string, ctx context.Context) (*canvas.Canvas, error) {
-	exists := s.rdb.Exists(ctx, key)
-	if err := exists.Err(); err != nil {
-		return nil, xerrors.Errorf("failed to check key presence in redis store: %w", err)
+// CompareAndSwapDocument checks the revision and replaces the document atomically.
+// In the hash layout, this is done by a script. In the string layout, and for documents stored as strings,
+// the keys of the document are watched so that the transaction setting the new version fails
+// if the document is modified after the revision check.
+func (s *RedisDataStore) CompareAndSwapDocument(key string, revision uint64, doc *canvas.Canvas, ctx context.Context) error {
+	docKey := s.docKey(key)
+
+	if s.hashLayout {
+		next, err := s.swapDocument(key, revision, doc, ctx)
+		if err != errStringLayout {
+			if err == nil {
+				doc.Revision = next
+			}
+
+			return err
+		}
+	}
+
+	next := *doc
+	next.Revision = revision + 1
+
+	err := s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := readDocument(tx, docKey, ctx)
+		if err != nil {
+			return err
+		}
+
+		if current.Revision != revision {
+			return Conflict
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			s.writeDocument(pipe, key, &next, ctx)
+
+			return nil
+		})
+
+		return err //nolint:wrapcheck
+	}, docKey, docKey+gridSuffix)
+
+	switch {
+	case err == nil:
+		doc.Revision = next.Revision
+
+		return nil
+	case err == redis.TxFailedErr:
+		return Conflict
+	case err == NotFound || err == Conflict:
+		return err
+	default:
+		return xerrors.Errorf("failed to update document in redis store: %w", err)
 	}
+}
 
-	if exists.Val() == 0 {
-		return nil, NotFound
+// UpdateDocument draws rectangles with a script in the hash layout.
+// Other operations, and documents stored as strings, are applied on a copy of the document
+// written back in a transaction, retried if the document is modified in the meantime.
+func (s *RedisDataStore) UpdateDocument(key string, op *canvas.Operation, revision uint64, ctx context.Context) (*canvas.Canvas, error) {
+	docKey := s.docKey(key)
+
+	if s.hashLayout && op.Type == canvas.OpRect && op.Rect != nil {
+		doc, err := s.drawRect(key, op, revision, nil, ctx)
+		if err != errStringLayout {
+			return doc, err
+		}
 	}
 
-	get := s.rdb.Get(ctx, key)
-	if err := get.Err(); err != nil {
-		return nil, xerrors.Errorf("failed to retrieve object from redis store: %w", err)
+	for i := 0; i < maxTxRetries; i++ {
+		var doc *canvas.Canvas
+
+		err := s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			var err error
+			if doc, err = readDocument(tx, docKey, ctx); err != nil {
+				return err
+			}
+
+			if err := applyOperation(doc, op, revision); err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				s.writeDocument(pipe, key, doc, ctx)
+
+				return nil
+			})
+			if err != nil && err != redis.TxFailedErr {
+				return xerrors.Errorf("failed to update document in redis store: %w", err)
+			}
+
+			return err
+		}, docKey, docKey+gridSuffix)
+
+		if err == redis.TxFailedErr {
+			continue
+		}
+
+		if err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+
+		return doc, nil
 	}
 
-	doc := canvas.Canvas{}
-	if err := json.NewDecoder(strings.NewReader(get.Val())).Decode(&doc); err != nil {
-		return nil, xerrors.Errorf("failed to unmarshal document from redis store: %w", err)
+	return nil, Conflict
+}
+
+// updateDocumentFrom draws rectangles with a script in the hash layout without reading the document back,
+// see UpdateDocumentFrom. Other operations are applied by UpdateDocument.
+func (s *RedisDataStore) updateDocumentFrom(key string, op *canvas.Operation, base *canvas.Canvas, ctx context.Context) (*canvas.Canvas, error) {
+	if s.hashLayout && op.Type == canvas.OpRect && op.Rect != nil {
+		doc, err := s.drawRect(key, op, base.Revision, base, ctx)
+		if err != errStringLayout {
+			return doc, err
+		}
 	}
 
-	return &doc, nil
+	return s.UpdateDocument(key, op, base.Revision, ctx)
 }
 
+// writeDocument queues the commands replacing a document in the layout of the store, indexing it and setting its expiry.
+func (s *RedisDataStore) writeDocument(pipe redis.Pipeliner, key string, doc *canvas.Canvas, ctx context.Context) {
+	docKey := s.docKey(key)
+
+	if s.hashLayout {
+		writeHashDocument(pipe, docKey, doc, ctx)
+	} else {
+		pipe.Del(ctx, docKey+gridSuffix)
+		pipe.Set(ctx, docKey, doc, 0)
+	}
+
+	s.indexDocument(pipe, key, doc, ctx)
+	s.expireDocument(pipe, key, doc, ctx)
+}
+
+// GetDocument reads the document in either layout.
+func (s *RedisDataStore) GetDocument(key string, ctx context.Context) (*canvas.Canvas, error) {
+	return readDocument(s.rdb, s.docKey(key), ctx)
+}
+
+// DeleteDocument deletes a document with its grid and operations, and updates the counter and indexes, see deleteScript.
 func (s *RedisDataStore) DeleteDocument(key string, ctx context.Context) error {
-	del := s.rdb.Del(ctx, key)
-	if err := del.Err(); err != nil {
+	docKey := s.docKey(key)
+
+	keys := []string{docKey, docKey + gridSuffix, docKey + operationsSuffix, s.countKey, s.createdKey, s.updatedKey, s.expiresKey}
+
+	deleted, err := deleteScript.Run(ctx, s.rdb, keys, key).Int()
+	if err != nil {
 		return xerrors.Errorf("failed to delete object from redis store: %w", err)
 	}
 
-	if del.Val() == 0 {
+	if deleted == 0 {
 		return NotFound
 	}
 
 	return nil
 }
+
+// AddOperation appends an operation to the journal of a document, which expires along with the document.
+func (s *RedisDataStore) AddOperation(key string, op *canvas.Operation, ctx context.Context) error {
+	docKey := s.docKey(key)
+
+	if err := pushScript.Run(ctx, s.rdb, []string{docKey, docKey + operationsSuffix}, op).Err(); err != nil {
+		return xerrors.Errorf("failed to add operation in redis store: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RedisDataStore) GetOperations(key string, ctx context.Context) ([]*canvas.Operation, error) {
+	lrange := s.rdb.LRange(ctx, s.docKey(key)+operationsSuffix, 0, -1)
+	if err := lrange.Err(); err != nil {
+		return nil, xerrors.Errorf("failed to retrieve operations from redis store: %w", err)
+	}
+
+	return decodeRedisOperations(lrange.Val())
+}
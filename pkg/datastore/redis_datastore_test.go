@@ -4,6 +4,7 @@ import (
 	"context"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/go-redis/redismock/v8"
@@ -13,6 +14,9 @@ import (
 	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
 )
 
+// testDocPrefix is the prefix of the document keys with the default options.
+const testDocPrefix = DefaultRedisPrefix + docNamespace
+
 func TestNew(t *testing.T) {
 	type args struct {
 		options *RedisOptions
@@ -36,7 +40,7 @@ func TestNew(t *testing.T) {
 			name: "No execution context",
 			args: args{
 				options: &RedisOptions{
-					redis.Options{
+					Options: redis.Options{
 						Addr: "localhost:6379",
 					},
 				},
@@ -44,11 +48,24 @@ func TestNew(t *testing.T) {
 			},
 			want:    nil,
 			wantErr: true,
+		}, {
+			name: "Unknown layout",
+			args: args{
+				options: &RedisOptions{
+					Options: redis.Options{
+						Addr: "localhost:6379",
+					},
+					Layout: "xml",
+				},
+				ctx: context.TODO(),
+			},
+			want:    nil,
+			wantErr: true,
 		}, {
 			name: "Server down",
 			args: args{
 				options: &RedisOptions{
-					redis.Options{
+					Options: redis.Options{
 						Addr: "invalid",
 					},
 				},
@@ -74,7 +91,7 @@ func TestNew(t *testing.T) {
 
 func TestRedisDataStore_GetSize(t *testing.T) {
 	type command struct {
-		val int64
+		val string
 		err error
 	}
 	tests := []struct {
@@ -86,16 +103,24 @@ func TestRedisDataStore_GetSize(t *testing.T) {
 		{
 			name: "redis OK",
 			cmd: command{
-				val: 12,
+				val: "12",
 				err: nil,
 			},
 			expected: 12,
 			wantErr:  false,
 		},
+		{
+			name: "no document",
+			cmd: command{
+				err: redis.Nil,
+			},
+			expected: 0,
+			wantErr:  false,
+		},
 		{
 			name: "redis error",
 			cmd: command{
-				val: 0,
+				val: "",
 				err: xerrors.New("FAILED"),
 			},
 			expected: 0,
@@ -105,11 +130,9 @@ func TestRedisDataStore_GetSize(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			db, mock := redismock.NewClientMock()
-			s := &RedisDataStore{
-				rdb: db,
-			}
+			s := newRedisStore(db, &RedisOptions{Layout: RedisLayoutString})
 
-			cmd := mock.ExpectDBSize()
+			cmd := mock.ExpectGet(DefaultRedisPrefix + countName)
 			cmd.SetVal(tt.cmd.val)
 			cmd.SetErr(tt.cmd.err)
 
@@ -177,13 +200,23 @@ func TestRedisDataStore_SetDocument(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			db, mock := redismock.NewClientMock()
-			s := &RedisDataStore{
-				rdb: db,
-			}
+			s := newRedisStore(db, &RedisOptions{Layout: RedisLayoutString})
 
-			cmd := mock.ExpectSet(tt.args.key, tt.args.value, 0)
-			cmd.SetVal(tt.cmd.val)
-			cmd.SetErr(tt.cmd.err)
+			key := testDocPrefix + tt.args.key
+
+			mock.ExpectTxPipeline()
+			mock.ExpectEval(countScript, []string{key, DefaultRedisPrefix + countName}).SetVal(int64(1))
+			mock.ExpectDel(key + gridSuffix).SetVal(0)
+			mock.ExpectSet(key, tt.args.value, 0).SetVal(tt.cmd.val)
+			mock.ExpectZAdd(DefaultRedisPrefix+createdIndexName, &redis.Z{Member: tt.args.key}).SetVal(1)
+			mock.ExpectZAdd(DefaultRedisPrefix+updatedIndexName, &redis.Z{Member: tt.args.key}).SetVal(1)
+			mock.ExpectPersist(key + operationsSuffix).SetVal(false)
+			mock.ExpectZRem(DefaultRedisPrefix+expiresIndexName, tt.args.key).SetVal(0)
+
+			exec := mock.ExpectTxPipelineExec()
+			if tt.cmd.err != nil {
+				exec.SetErr(tt.cmd.err)
+			}
 
 			if err := s.SetDocument(tt.args.key, tt.args.value, context.TODO()); (err != nil) != tt.wantErr {
 				t.Errorf("SetDocument() error = %v, wantErr %v", err, tt.wantErr)
@@ -192,175 +225,160 @@ func TestRedisDataStore_SetDocument(t *testing.T) {
 	}
 }
 
-func TestRedisDataStore_GetDocList(t *testing.T) {
+func TestRedisDataStore_ListDocuments(t *testing.T) {
+	for _, layout := range []string{RedisLayoutHash, RedisLayoutString} {
+		t.Run(layout, func(t *testing.T) {
+			s, _, _, _ := newTestRedis(t, layout)
+			testListDocuments(t, s)
+		})
+	}
+}
+
+func TestRedisDataStore_ListDocuments_Error(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	s := newRedisStore(db, &RedisOptions{Layout: RedisLayoutString})
+
+	mock.ExpectZRangeByScoreWithScores(DefaultRedisPrefix+createdIndexName, &redis.ZRangeBy{Min: "-inf", Max: "+inf", Count: defaultListCount + 1}).
+		SetErr(xerrors.New("FAILED"))
+
+	infos, next, err := s.ListDocuments(&ListQuery{}, context.TODO())
+	assert.Error(t, err)
+	assert.Nil(t, infos)
+	assert.Empty(t, next)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+func TestRedisDataStore_GetDocument(t *testing.T) {
 	type args struct {
-		cursor uint64
-		count  int64
+		key string
 	}
-	type command struct {
-		page   []string
-		cursor uint64
-		err    error
+	type readCommand struct {
+		value interface{}
+		err   error
 	}
 	type expected struct {
-		keys   []string
-		cursor uint64
+		doc *canvas.Canvas
 	}
 	tests := []struct {
-		name     string
-		args     args
-		cmd      command
-		expected expected
-		wantErr  bool
+		name        string
+		args        args
+		readCommand readCommand
+		expected    expected
+		wantErr     bool
 	}{
 		{
-			name: "redis OK",
+			name: "string layout",
 			args: args{
-				cursor: 0,
-				count:  10,
+				key: "123",
 			},
-			cmd: command{
-				page:   []string{"123", "456"},
-				cursor: 3,
-				err:    nil,
+			readCommand: readCommand{
+				value: []interface{}{"string", mustMarshal(&canvas.Canvas{Name: "doc1", Width: 2, Height: 1, Data: []byte("ab"), Revision: 7})},
+				err:   nil,
 			},
 			expected: expected{
-				keys:   []string{"123", "456"},
-				cursor: 3,
+				doc: &canvas.Canvas{
+					Name:     "doc1",
+					Width:    2,
+					Height:   1,
+					Data:     []byte("ab"),
+					Revision: 7,
+				},
 			},
 			wantErr: false,
 		},
 		{
-			name: "redis error",
+			name: "legacy json",
 			args: args{
-				cursor: 0,
-				count:  10,
+				key: "123",
 			},
-			cmd: command{
-				page:   []string{"123", "456"},
-				cursor: 3,
-				err:    xerrors.New("FAILED"),
+			readCommand: readCommand{
+				value: []interface{}{"string", `{"name":"doc1","width":80,"height":25}`},
+				err:   nil,
 			},
 			expected: expected{
-				keys:   nil,
-				cursor: 0,
+				doc: &canvas.Canvas{
+					Name:   "doc1",
+					Width:  80,
+					Height: 25,
+				},
 			},
-			wantErr: true,
+			wantErr: false,
 		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			db, mock := redismock.NewClientMock()
-			s := &RedisDataStore{
-				rdb: db,
-			}
-
-			cmd := mock.ExpectScan(tt.args.cursor, "", tt.args.count)
-			cmd.SetVal(tt.cmd.page, tt.cmd.cursor)
-			cmd.SetErr(tt.cmd.err)
-
-			keys, cursor, err := s.GetDocList(tt.args.cursor, tt.args.count, context.TODO())
-			if (err != nil) != tt.wantErr {
-				t.Errorf("GetDocList() error = %v, wantErr %v", err, tt.wantErr)
-			}
-
-			assert.Equal(t, tt.expected.keys, keys)
-			assert.Equal(t, tt.expected.cursor, cursor)
-
-			assert.NoError(t, mock.ExpectationsWereMet())
-		})
-	}
-}
-
-func TestRedisDataStore_GetDocument(t *testing.T) {
-	type args struct {
-		key string
-	}
-	type existCommand struct {
-		value int64
-		err   error
-	}
-	type getCommand struct {
-		value string
-		err   error
-	}
-	type expected struct {
-		doc *canvas.Canvas
-	}
-	tests := []struct {
-		name         string
-		args         args
-		existCommand existCommand
-		getCommand   *getCommand
-		expected     expected
-		wantErr      bool
-	}{
 		{
-			name: "ok",
+			name: "hash layout",
 			args: args{
 				key: "123",
 			},
-			existCommand: existCommand{
-				value: 1,
-				err:   nil,
+			readCommand: readCommand{
+				value: []interface{}{
+					"hash",
+					[]interface{}{"name", "doc1", "width", "2", "height", "1", "revision", "7"},
+					"ab",
+				},
+				err: nil,
 			},
-			getCommand: &getCommand{
-				value: `{"name":"doc1","width":80,"height":25}`,
+			expected: expected{
+				doc: &canvas.Canvas{
+					Name:     "doc1",
+					Width:    2,
+					Height:   1,
+					Data:     []byte("ab"),
+					Revision: 7,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "hash layout without grid",
+			args: args{
+				key: "123",
+			},
+			readCommand: readCommand{
+				value: []interface{}{"hash", []interface{}{"width", "2", "height", "1"}, nil},
 				err:   nil,
 			},
 			expected: expected{
 				doc: &canvas.Canvas{
-					Name:   "doc1",
-					Width:  80,
-					Height: 25,
+					Width:  2,
+					Height: 1,
 				},
 			},
 			wantErr: false,
 		},
 		{
-			name: "exists error",
+			name: "script error",
 			args: args{
 				"123",
 			},
-			existCommand: existCommand{
-				value: 0,
-				err:   xerrors.New("FAILED"),
+			readCommand: readCommand{
+				err: xerrors.New("FAILED"),
 			},
-			getCommand: nil,
 			expected: expected{
 				doc: nil,
 			},
 			wantErr: true,
 		},
 		{
-			name: "get error",
+			name: "bad data",
 			args: args{
 				"123",
 			},
-			existCommand: existCommand{
-				value: 1,
+			readCommand: readCommand{
+				value: []interface{}{"string", "invalid"},
 				err:   nil,
 			},
-			getCommand: &getCommand{
-				value: "",
-				err:   xerrors.New("FAILED"),
-			},
 			expected: expected{
 				doc: nil,
 			},
 			wantErr: true,
 		},
 		{
-			name: "bad data",
+			name: "bad field",
 			args: args{
 				"123",
 			},
-			existCommand: existCommand{
-				value: 1,
-				err:   nil,
-			},
-			getCommand: &getCommand{
-				value: "invalid",
+			readCommand: readCommand{
+				value: []interface{}{"hash", []interface{}{"width", "wide"}, nil},
 				err:   nil,
 			},
 			expected: expected{
@@ -373,12 +391,8 @@ func TestRedisDataStore_GetDocument(t *testing.T) {
 			args: args{
 				"123",
 			},
-			existCommand: existCommand{
-				value: 1,
-				err:   nil,
-			},
-			getCommand: &getCommand{
-				value: "",
+			readCommand: readCommand{
+				value: []interface{}{"string", ""},
 				err:   nil,
 			},
 			expected: expected{
@@ -391,11 +405,9 @@ func TestRedisDataStore_GetDocument(t *testing.T) {
 			args: args{
 				"123",
 			},
-			existCommand: existCommand{
-				value: 0,
-				err:   nil,
+			readCommand: readCommand{
+				err: redis.Nil,
 			},
-			getCommand: nil,
 			expected: expected{
 				doc: nil,
 			},
@@ -405,18 +417,15 @@ func TestRedisDataStore_GetDocument(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			db, mock := redismock.NewClientMock()
-			s := &RedisDataStore{
-				rdb: db,
-			}
+			s := newRedisStore(db, &RedisOptions{Layout: RedisLayoutString})
 
-			expectExists := mock.ExpectExists(tt.args.key)
-			expectExists.SetVal(tt.existCommand.value)
-			expectExists.SetErr(tt.existCommand.err)
+			key := testDocPrefix + tt.args.key
 
-			if tt.getCommand != nil {
-				expectGet := mock.ExpectGet(tt.args.key)
-				expectGet.SetVal(tt.getCommand.value)
-				expectGet.SetErr(tt.getCommand.err)
+			read := mock.ExpectEvalSha(readScript.Hash(), []string{key, key + gridSuffix})
+			if tt.readCommand.err != nil {
+				read.SetErr(tt.readCommand.err)
+			} else {
+				read.SetVal(tt.readCommand.value)
 			}
 
 			doc, err := s.GetDocument(tt.args.key, context.TODO())
@@ -480,18 +489,205 @@ func TestRedisDataStore_DeleteDocument(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			db, mock := redismock.NewClientMock()
-			s := &RedisDataStore{
-				rdb: db,
-			}
+			s := newRedisStore(db, &RedisOptions{Layout: RedisLayoutString})
+
+			key := testDocPrefix + tt.args.key
 
-			expectDel := mock.ExpectDel(tt.args.key)
-			expectDel.SetVal(tt.delCommand.value)
-			expectDel.SetErr(tt.delCommand.err)
+			del := mock.ExpectEvalSha(deleteScript.Hash(), []string{
+				key, key + gridSuffix, key + operationsSuffix, DefaultRedisPrefix + countName,
+				DefaultRedisPrefix + createdIndexName, DefaultRedisPrefix + updatedIndexName, DefaultRedisPrefix + expiresIndexName,
+			}, tt.args.key)
+			del.SetVal(tt.delCommand.value)
+			del.SetErr(tt.delCommand.err)
 
 			err := s.DeleteDocument(tt.args.key, context.TODO())
 			if (err != nil) != tt.wantErr {
 				t.Errorf("DeleteDocument() error = %v, wantErr %v", err, tt.wantErr)
 			}
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRedisDataStore_AddOperation(t *testing.T) {
+	op := canvas.NewFill(canvas.Point{X: 1, Y: 2}, "#")
+
+	tests := []struct {
+		name    string
+		err     error
+		wantErr bool
+	}{
+		{
+			name:    "ok",
+			err:     nil,
+			wantErr: false,
+		},
+		{
+			name:    "redis error",
+			err:     xerrors.New("FAILED"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := redismock.NewClientMock()
+			s := newRedisStore(db, &RedisOptions{Layout: RedisLayoutString})
+
+			key := testDocPrefix + "123"
+
+			cmd := mock.ExpectEvalSha(pushScript.Hash(), []string{key, key + operationsSuffix}, op)
+			cmd.SetVal(int64(1))
+			cmd.SetErr(tt.err)
+
+			if err := s.AddOperation("123", op, context.TODO()); (err != nil) != tt.wantErr {
+				t.Errorf("AddOperation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRedisDataStore_GetOperations(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []string
+		err      error
+		expected []*canvas.Operation
+		wantErr  bool
+	}{
+		{
+			name:   "ok",
+			values: []string{`{"type":"fill","time":"2021-10-24T00:00:00Z","origin":{"x":1,"y":2},"fill":"#"}`},
+			expected: []*canvas.Operation{
+				{
+					Type:   canvas.OpFill,
+					Time:   time.Date(2021, 10, 24, 0, 0, 0, 0, time.UTC),
+					Origin: &canvas.Point{X: 1, Y: 2},
+					Fill:   "#",
+				},
+			},
+		},
+		{
+			name:     "empty",
+			values:   []string{},
+			expected: []*canvas.Operation{},
+		},
+		{
+			name:    "bad data",
+			values:  []string{"invalid"},
+			wantErr: true,
+		},
+		{
+			name:    "redis error",
+			err:     xerrors.New("FAILED"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := redismock.NewClientMock()
+			s := newRedisStore(db, &RedisOptions{Layout: RedisLayoutString})
+
+			cmd := mock.ExpectLRange(testDocPrefix+"123"+operationsSuffix, 0, -1)
+			cmd.SetVal(tt.values)
+			cmd.SetErr(tt.err)
+
+			ops, err := s.GetOperations("123", context.TODO())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetOperations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			assert.Equal(t, tt.expected, ops)
+		})
+	}
+}
+
+func TestRedisDataStore_CompareAndSwapDocument(t *testing.T) {
+	type getCommand struct {
+		value string
+		err   error
+	}
+	tests := []struct {
+		name       string
+		revision   uint64
+		getCommand getCommand
+		execErr    error
+		setValue   *canvas.Canvas
+		wantErr    error
+	}{
+		{
+			name:       "ok",
+			revision:   3,
+			getCommand: getCommand{value: `{"name":"doc1","width":80,"height":25,"revision":3}`},
+			setValue:   &canvas.Canvas{Name: "doc2", Revision: 4},
+		},
+		{
+			name:       "not found",
+			revision:   3,
+			getCommand: getCommand{err: redis.Nil},
+			wantErr:    NotFound,
+		},
+		{
+			name:       "revision mismatch",
+			revision:   2,
+			getCommand: getCommand{value: `{"name":"doc1","width":80,"height":25,"revision":3}`},
+			wantErr:    Conflict,
+		},
+		{
+			name:       "concurrent update",
+			revision:   3,
+			getCommand: getCommand{value: `{"name":"doc1","width":80,"height":25,"revision":3}`},
+			setValue:   &canvas.Canvas{Name: "doc2", Revision: 4},
+			execErr:    redis.TxFailedErr,
+			wantErr:    Conflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := redismock.NewClientMock()
+			s := newRedisStore(db, &RedisOptions{Layout: RedisLayoutString})
+
+			key := testDocPrefix + "123"
+
+			mock.ExpectWatch(key, key+gridSuffix)
+
+			read := mock.ExpectEvalSha(readScript.Hash(), []string{key, key + gridSuffix})
+			if tt.getCommand.err != nil {
+				read.SetErr(tt.getCommand.err)
+			} else {
+				read.SetVal([]interface{}{"string", tt.getCommand.value})
+			}
+
+			if tt.setValue != nil {
+				mock.ExpectTxPipeline()
+				mock.ExpectDel(key + gridSuffix).SetVal(0)
+				mock.ExpectSet(key, tt.setValue, 0).SetVal("OK")
+				mock.ExpectZAdd(DefaultRedisPrefix+createdIndexName, &redis.Z{Member: "123"}).SetVal(0)
+				mock.ExpectZAdd(DefaultRedisPrefix+updatedIndexName, &redis.Z{Member: "123"}).SetVal(0)
+				mock.ExpectPersist(key + operationsSuffix).SetVal(false)
+				mock.ExpectZRem(DefaultRedisPrefix+expiresIndexName, "123").SetVal(0)
+
+				exec := mock.ExpectTxPipelineExec()
+				if tt.execErr != nil {
+					exec.SetErr(tt.execErr)
+				}
+			}
+
+			doc := &canvas.Canvas{Name: "doc2"}
+			err := s.CompareAndSwapDocument("123", tt.revision, doc, context.TODO())
+
+			if tt.wantErr != nil {
+				assert.Equal(t, tt.wantErr, err)
+				assert.Equal(t, uint64(0), doc.Revision)
+
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.revision+1, doc.Revision)
+			assert.NoError(t, mock.ExpectationsWereMet())
 		})
 	}
 }
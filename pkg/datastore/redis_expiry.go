@@ -0,0 +1,123 @@
+package datastore
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+// Documents with a retention expire natively in Redis: their keys are given the expiry of the document
+// with PEXPIREAT each time they are written. Their ids are also added to the expiry index, a sorted set
+// scored by expiry in milliseconds, so that DeleteExpired can remove the expired documents from the other
+// indexes and the counter, which Redis doesn't do by itself.
+
+// expireFunction is prepended to the scripts setting the expiry of a document. expire(at, id, index, keys...)
+// sets the expiry of the keys to at, in milliseconds since the Unix epoch, or removes it if at is 0,
+// and updates the position of the document id in the expiry index accordingly.
+const expireFunction = `
+local function expire(at, id, index, ...)
+	for _, key in ipairs({...}) do
+		if at > 0 then
+			redis.call('PEXPIREAT', key, string.format('%d', at))
+		else
+			redis.call('PERSIST', key)
+		end
+	end
+	if at > 0 then
+		redis.call('ZADD', index, string.format('%d', at), id)
+	else
+		redis.call('ZREM', index, id)
+	end
+end
+`
+
+// pushScript appends the operation ARGV[1] to the journal KEYS[2] of the document KEYS[1],
+// and gives the journal the remaining lifetime of the document.
+//
+//nolint:gochecknoglobals
+var pushScript = redis.NewScript(`
+redis.call('RPUSH', KEYS[2], ARGV[1])
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl > 0 then
+	redis.call('PEXPIRE', KEYS[2], ttl)
+end
+return 1
+`)
+
+// sweepScript removes the document ARGV[1] from the indexes KEYS[3] to KEYS[5] and decrements the counter KEYS[2]
+// if its key KEYS[1] has expired. It returns 0 if the document still exists.
+//
+//nolint:gochecknoglobals
+var sweepScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+if redis.call('ZREM', KEYS[5], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('ZREM', KEYS[3], ARGV[1])
+redis.call('ZREM', KEYS[4], ARGV[1])
+redis.call('DECR', KEYS[2])
+return 1
+`)
+
+// sweepBatch is the number of expired documents read from the expiry index at once.
+const sweepBatch = 100
+
+// expireDocument queues the commands setting the expiry of the keys of a document written by the pipeline.
+// The document and grid keys are written without expiry, only the journal may keep a previous one.
+func (s *RedisDataStore) expireDocument(pipe redis.Pipeliner, id string, doc *canvas.Canvas, ctx context.Context) {
+	key := s.docKey(id)
+
+	if doc.Expires.IsZero() {
+		pipe.Persist(ctx, key+operationsSuffix)
+		pipe.ZRem(ctx, s.expiresKey, id)
+
+		return
+	}
+
+	for _, k := range []string{key, key + gridSuffix, key + operationsSuffix} {
+		pipe.PExpireAt(ctx, k, doc.Expires)
+	}
+
+	pipe.ZAdd(ctx, s.expiresKey, &redis.Z{Score: float64(timeScore(doc.Expires)), Member: id})
+}
+
+// DeleteExpired removes the documents expired by Redis from the indexes and the counter,
+// and returns their number. The keys of the documents are already deleted by Redis.
+func (s *RedisDataStore) DeleteExpired(now time.Time, ctx context.Context) (int, error) {
+	deleted := 0
+	max := strconv.FormatInt(timeScore(now), 10) //nolint:gomnd
+
+	for {
+		ids, err := s.rdb.ZRangeByScore(ctx, s.expiresKey, &redis.ZRangeBy{Min: "-inf", Max: max, Count: sweepBatch}).Result()
+		if err != nil {
+			return deleted, xerrors.Errorf("failed to read expiry index of redis store: %w", err)
+		}
+
+		swept := 0
+
+		for _, id := range ids {
+			keys := []string{s.docKey(id), s.countKey, s.createdKey, s.updatedKey, s.expiresKey}
+
+			n, err := sweepScript.Run(ctx, s.rdb, keys, id).Int()
+			if err != nil {
+				return deleted, xerrors.Errorf("failed to remove expired document from redis store: %w", err)
+			}
+
+			swept += n
+		}
+
+		deleted += swept
+
+		// Documents still existing, whose expiry is not yet processed by Redis, are left in the index.
+		if len(ids) < sweepBatch || swept == 0 {
+			return deleted, nil
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package datastore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+func TestRedisDataStore_DeleteExpired(t *testing.T) {
+	for _, layout := range []string{RedisLayoutHash, RedisLayoutString} {
+		t.Run(layout, func(t *testing.T) {
+			s, mr, _, _ := newTestRedis(t, layout)
+			testDeleteExpired(t, s, mr.FastForward)
+		})
+	}
+}
+
+func TestRedisDataStore_Expiry(t *testing.T) {
+	ctx := context.TODO()
+	s, mr, _, _ := newTestRedis(t, RedisLayoutHash)
+	key := testDocPrefix + "123"
+
+	doc := &canvas.Canvas{Width: 3, Height: 2, Retention: time.Hour}
+	doc.Touch(time.Now())
+	require.NoError(t, s.SetDocument("123", doc, ctx))
+	require.NoError(t, s.AddOperation("123", canvas.NewFill(canvas.Point{X: 1, Y: 1}, "#"), ctx))
+
+	// The document expires with its grid and operations.
+	for _, k := range []string{key, key + operationsSuffix} {
+		assert.InDelta(t, time.Hour, mr.TTL(k), float64(time.Second), k)
+	}
+
+	mr.FastForward(30 * time.Minute)
+
+	// Rectangles drawn by the script extend the lifetime of the document.
+	op := canvas.NewRect(canvas.Rectangle{Origin: canvas.Point{X: 1, Y: 1}, Width: 1, Height: 1}, "#", "")
+	_, err := s.UpdateDocument("123", op, AnyRevision, ctx)
+	require.NoError(t, err)
+
+	for _, k := range []string{key, key + gridSuffix, key + operationsSuffix} {
+		assert.InDelta(t, time.Hour, mr.TTL(k), float64(time.Second), k)
+	}
+
+	mr.FastForward(2 * time.Hour)
+	assert.False(t, mr.Exists(key))
+	assert.False(t, mr.Exists(key+operationsSuffix))
+
+	_, err = s.GetDocument("123", ctx)
+	assert.Equal(t, NotFound, err)
+
+	// The counter and indexes are cleaned up by the sweeper.
+	deleted, err := s.DeleteExpired(time.Now().Add(3*time.Hour), ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	assert.Equal(t, "0", must(mr.Get(DefaultRedisPrefix+countName)))
+	assert.False(t, mr.Exists(DefaultRedisPrefix+expiresIndexName))
+}
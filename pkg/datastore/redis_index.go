@@ -0,0 +1,311 @@
+package datastore
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+// infoFields are the hash fields read by infoScript, in order.
+//
+//nolint:gochecknoglobals
+var infoFields = []string{"name", "creator", "description", "tags", "created", "updated", "retention"}
+
+// infoScript returns the metadata of the documents KEYS, without their grid, as {"hash", fields} in the hash layout,
+// where fields are the values of infoFields, or {"string", document}. Missing documents are returned as nil.
+//
+//nolint:gochecknoglobals
+var infoScript = redis.NewScript(`
+local result = {}
+for i, key in ipairs(KEYS) do
+	local kind = redis.call('TYPE', key)
+	kind = kind.ok or kind
+	if kind == 'hash' then
+		result[i] = {'hash', redis.call('HMGET', key, unpack(ARGV))}
+	elseif kind == 'string' then
+		result[i] = {'string', redis.call('GET', key)}
+	else
+		result[i] = false
+	end
+end
+return result
+`)
+
+// timeScore returns the score of a timestamp in the indexes, in milliseconds since the Unix epoch
+// so that it is exactly represented by the floating point scores of Redis.
+func timeScore(t time.Time) int64 {
+	return timeValue(t) / int64(time.Millisecond)
+}
+
+// indexDocument queues the commands setting the position of a document in the indexes.
+func (s *RedisDataStore) indexDocument(pipe redis.Pipeliner, key string, doc *canvas.Canvas, ctx context.Context) {
+	pipe.ZAdd(ctx, s.createdKey, &redis.Z{Score: float64(timeScore(doc.Created)), Member: key})
+	pipe.ZAdd(ctx, s.updatedKey, &redis.Z{Score: float64(timeScore(doc.Updated)), Member: key})
+}
+
+// ListDocuments walks the index of the requested order, a sorted set of the document ids scored by their
+// creation or update time, and reads the metadata of the documents by batches to filter them.
+// Documents with the same score are sorted by id, as in the sorted sets.
+func (s *RedisDataStore) ListDocuments(query *ListQuery, ctx context.Context) ([]*DocInfo, string, error) {
+	if err := query.validate(); err != nil {
+		return nil, "", err
+	}
+
+	after, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	index, desc := s.createdKey, false
+	if query.Sort == SortUpdated {
+		index, desc = s.updatedKey, true
+	}
+
+	var (
+		infos     []*DocInfo
+		positions []listPosition
+		batch     = query.Count + 1
+	)
+
+	// Fetch one more document than requested to know if there is a next page.
+	for int64(len(infos)) <= query.Count {
+		entries, err := s.indexRange(index, desc, after, batch, ctx)
+		if err != nil {
+			return nil, "", err
+		}
+
+		ids := make([]string, 0, len(entries))
+		for _, e := range entries {
+			ids = append(ids, e.Member.(string))
+		}
+
+		batchInfos, err := s.readInfos(ids, s.docKey, ctx)
+		if err != nil {
+			return nil, "", err
+		}
+
+		for i, e := range entries {
+			after = &listPosition{value: int64(e.Score), key: ids[i]}
+
+			// Documents deleted after the index was read are skipped.
+			if batchInfos[i] == nil || !query.matches(batchInfos[i]) {
+				continue
+			}
+
+			infos = append(infos, batchInfos[i])
+			positions = append(positions, *after)
+
+			if int64(len(infos)) > query.Count {
+				break
+			}
+		}
+
+		if int64(len(entries)) < batch {
+			break
+		}
+	}
+
+	next := ""
+	if int64(len(infos)) > query.Count {
+		infos = infos[:query.Count]
+		next = encodeCursor(positions[query.Count-1])
+	}
+
+	return infos, next, nil
+}
+
+// indexRange returns at most count entries of an index following the position after, nil for the start of the index.
+// The entries with the same score as the position are read first, and filtered by id.
+func (s *RedisDataStore) indexRange(index string, desc bool, after *listPosition, count int64, ctx context.Context) ([]redis.Z, error) {
+	var entries []redis.Z
+
+	from := "-inf"
+	if desc {
+		from = "+inf"
+	}
+
+	if after != nil {
+		score := strconv.FormatInt(after.value, 10)
+
+		ties, err := s.zrange(index, desc, score, score, 0, ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, z := range ties {
+			if member, _ := z.Member.(string); desc && member < after.key || !desc && member > after.key {
+				entries = append(entries, z)
+			}
+		}
+
+		if int64(len(entries)) >= count {
+			return entries[:count], nil
+		}
+
+		from = "(" + score
+	}
+
+	to := "+inf"
+	if desc {
+		to = "-inf"
+	}
+
+	rest, err := s.zrange(index, desc, from, to, count-int64(len(entries)), ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(entries, rest...), nil
+}
+
+// zrange returns the entries of an index between two scores in the order of the index, at most count if positive.
+func (s *RedisDataStore) zrange(index string, desc bool, from, to string, count int64, ctx context.Context) ([]redis.Z, error) {
+	var cmd *redis.ZSliceCmd
+
+	if desc {
+		cmd = s.rdb.ZRevRangeByScoreWithScores(ctx, index, &redis.ZRangeBy{Max: from, Min: to, Count: count})
+	} else {
+		cmd = s.rdb.ZRangeByScoreWithScores(ctx, index, &redis.ZRangeBy{Min: from, Max: to, Count: count})
+	}
+
+	entries, err := cmd.Result()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to retrieve documents from redis store: %w", err)
+	}
+
+	return entries, nil
+}
+
+// readInfos reads the metadata of documents stored at keyOf(id), nil for the missing ones.
+func (s *RedisDataStore) readInfos(ids []string, keyOf func(string) string, ctx context.Context) ([]*DocInfo, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, keyOf(id))
+	}
+
+	args := make([]interface{}, 0, len(infoFields))
+	for _, f := range infoFields {
+		args = append(args, f)
+	}
+
+	reply, err := infoScript.Run(ctx, s.rdb, keys, args...).Slice()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to retrieve documents from redis store: %w", err)
+	}
+
+	infos := make([]*DocInfo, len(ids))
+
+	for i, v := range reply {
+		if i >= len(ids) {
+			break
+		}
+
+		doc, err := decodeInfo(v)
+		if err != nil {
+			return nil, err
+		}
+
+		if doc != nil {
+			info := newDocInfo(ids[i], doc)
+			infos[i] = &info
+		}
+	}
+
+	return infos, nil
+}
+
+// decodeInfo decodes the metadata of a document returned by infoScript.
+func decodeInfo(reply interface{}) (*canvas.Canvas, error) {
+	values, ok := reply.([]interface{})
+	if !ok || len(values) < 2 {
+		return nil, nil
+	}
+
+	if values[0] == RedisLayoutString {
+		return decodeDocument(values)
+	}
+
+	doc := canvas.Canvas{}
+	fields, _ := values[1].([]interface{})
+
+	for i, f := range fields {
+		value, ok := f.(string)
+		if !ok || i >= len(infoFields) {
+			continue
+		}
+
+		if err := setDocumentField(&doc, infoFields[i], value); err != nil {
+			return nil, xerrors.Errorf("failed to unmarshal document from redis store: %w", err)
+		}
+	}
+
+	setExpiry(&doc)
+
+	return &doc, nil
+}
+
+// indexDocuments adds the documents of the store missing from the indexes, written by previous versions,
+// and returns their number.
+func (s *RedisDataStore) indexDocuments(ctx context.Context) (int, error) {
+	indexed := 0
+
+	var cursor uint64
+
+	for {
+		keys, next, err := s.rdb.Scan(ctx, cursor, escapePattern(s.docPrefix)+"*", 0).Result()
+		if err != nil {
+			return indexed, xerrors.Errorf("failed to scan redis store: %w", err)
+		}
+
+		for _, k := range keys {
+			if strings.HasSuffix(k, gridSuffix) || strings.HasSuffix(k, operationsSuffix) {
+				continue
+			}
+
+			id := strings.TrimPrefix(k, s.docPrefix)
+
+			err := s.rdb.ZScore(ctx, s.createdKey, id).Err()
+			if err == nil {
+				continue
+			}
+
+			if err != redis.Nil {
+				return indexed, xerrors.Errorf("failed to read index of redis store: %w", err)
+			}
+
+			infos, err := s.readInfos([]string{id}, s.docKey, ctx)
+			if err != nil {
+				return indexed, err
+			}
+
+			if infos[0] == nil {
+				continue
+			}
+
+			if _, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.ZAddNX(ctx, s.createdKey, &redis.Z{Score: float64(timeScore(infos[0].Created)), Member: id})
+				pipe.ZAddNX(ctx, s.updatedKey, &redis.Z{Score: float64(timeScore(infos[0].Updated)), Member: id})
+
+				return nil
+			}); err != nil {
+				return indexed, xerrors.Errorf("failed to index document in redis store: %w", err)
+			}
+
+			indexed++
+		}
+
+		if cursor = next; cursor == 0 {
+			return indexed, nil
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package datastore
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+// journalPageSize is the number of operations read at once by rangeScript.
+const journalPageSize = 64
+
+// rangeScript returns the end of the journal KEYS[1] needed to rebuild the document at the revision ARGV[1] and after,
+// reading the journal backwards by pages of ARGV[2] operations, see journalScan.
+//
+//nolint:gochecknoglobals
+var rangeScript = redis.NewScript(`
+local revision, size = tonumber(ARGV[1]), tonumber(ARGV[2])
+local checkpoint
+local last = redis.call('LLEN', KEYS[1]) - 1
+while last >= 0 do
+	local first = math.max(last - size + 1, 0)
+	local page = redis.call('LRANGE', KEYS[1], first, last)
+	for i = #page, 1, -1 do
+		local op = cjson.decode(page[i])
+		local produced, ok = op['revision'] or 0, op['revision'] ~= nil
+		if type(op['canvas']) == 'table' then
+			produced, ok = op['canvas']['revision'] or 0, true
+		end
+		if checkpoint then
+			if not ok or produced <= checkpoint then
+				return redis.call('LRANGE', KEYS[1], first + i, -1)
+			end
+		elseif type(op['canvas']) == 'table' and produced <= revision then
+			checkpoint = produced
+		end
+	end
+	last = first - 1
+end
+return redis.call('LRANGE', KEYS[1], 0, -1)
+`)
+
+// GetOperationsFrom reads the journal backwards in a script, only the operations needed are sent back.
+func (s *RedisDataStore) GetOperationsFrom(key string, revision uint64, ctx context.Context) ([]*canvas.Operation, error) {
+	values, err := rangeScript.Run(ctx, s.rdb, []string{s.docKey(key) + operationsSuffix}, revision, journalPageSize).StringSlice()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to retrieve operations from redis store: %w", err)
+	}
+
+	return decodeRedisOperations(values)
+}
+
+// TrimOperations removes the first operations of the journal and updates the references of the operations kept
+// in a transaction. The journal isn't trimmed if it is modified concurrently, it is trimmed at a later checkpoint.
+func (s *RedisDataStore) TrimOperations(key string, revision uint64, ctx context.Context) (int, error) {
+	opsKey := s.docKey(key) + operationsSuffix
+
+	var n int
+
+	err := s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+		values, err := tx.LRange(ctx, opsKey, 0, -1).Result()
+		if err != nil {
+			return xerrors.Errorf("failed to retrieve operations from redis store: %w", err)
+		}
+
+		ops, err := decodeRedisOperations(values)
+		if err != nil {
+			return err
+		}
+
+		if n = trimmedLength(ops, revision); n == 0 {
+			return nil
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.LTrim(ctx, opsKey, int64(n), -1)
+
+			for _, i := range shiftRefs(ops[n:], n) {
+				pipe.LSet(ctx, opsKey, int64(i), ops[n+i])
+			}
+
+			return nil
+		})
+		if err != nil && err != redis.TxFailedErr {
+			return xerrors.Errorf("failed to trim operations in redis store: %w", err)
+		}
+
+		return err
+	}, opsKey)
+
+	switch {
+	case err == nil:
+		return n, nil
+	case err == redis.TxFailedErr:
+		return 0, nil
+	default:
+		return 0, err //nolint:wrapcheck
+	}
+}
+
+func decodeRedisOperations(values []string) ([]*canvas.Operation, error) {
+	ops := make([]*canvas.Operation, 0, len(values))
+
+	for _, v := range values {
+		op := canvas.Operation{}
+		if err := json.Unmarshal([]byte(v), &op); err != nil {
+			return nil, xerrors.Errorf("failed to unmarshal operation from redis store: %w", err)
+		}
+
+		ops = append(ops, &op)
+	}
+
+	return ops, nil
+}
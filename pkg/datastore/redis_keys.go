@@ -0,0 +1,162 @@
+package datastore
+
+import (
+	"context"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/xerrors"
+)
+
+// countScript increments the counter KEYS[2] if the document KEYS[1] doesn't exist.
+// It is sent with EVAL in the transaction writing the document, so that the document and the counter
+// are updated together: a script missing from the cache would fail alone, after the other commands are run.
+const countScript = `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return redis.call('INCR', KEYS[2])
+end
+return 0
+`
+
+// deleteScript deletes the document KEYS[1] with its grid KEYS[2] and its operations KEYS[3],
+// decrements the counter KEYS[4] and removes the id ARGV[1] from the indexes KEYS[5] to KEYS[7].
+// It returns 0 if the document doesn't exist.
+//
+//nolint:gochecknoglobals
+var deleteScript = redis.NewScript(`
+if redis.call('DEL', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('DEL', KEYS[2], KEYS[3])
+redis.call('DECR', KEYS[4])
+redis.call('ZREM', KEYS[5], ARGV[1])
+redis.call('ZREM', KEYS[6], ARGV[1])
+redis.call('ZREM', KEYS[7], ARGV[1])
+return 1
+`)
+
+// migrateScript renames the document KEYS[1] with its grid KEYS[2] and its operations KEYS[3]
+// to KEYS[4], KEYS[5] and KEYS[6], increments the counter KEYS[7], and adds the id ARGV[1] to the indexes
+// KEYS[8] and KEYS[9] with the scores ARGV[2] and ARGV[3].
+// Documents already existing under the new key are left untouched.
+//
+//nolint:gochecknoglobals
+var migrateScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return redis.error_reply('NOT_FOUND')
+end
+if redis.call('EXISTS', KEYS[4]) == 1 then
+	return redis.error_reply('CONFLICT')
+end
+for i = 1, 3 do
+	if redis.call('EXISTS', KEYS[i]) == 1 then
+		redis.call('RENAME', KEYS[i], KEYS[i + 3])
+	end
+end
+redis.call('ZADD', KEYS[8], ARGV[2], ARGV[1])
+redis.call('ZADD', KEYS[9], ARGV[3], ARGV[1])
+return redis.call('INCR', KEYS[7])
+`)
+
+// escapePattern escapes the special characters of a SCAN pattern.
+func escapePattern(s string) string {
+	var b strings.Builder
+
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]^-\`, r) {
+			b.WriteRune('\\')
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// MigrateKeys moves the documents stored without prefix by previous versions under the prefix of the store,
+// along with their grid and operations, and counts them. It returns the number of migrated documents.
+// Keys which aren't documents are left untouched, as well as documents whose key is already used under the prefix.
+// The documents under the prefix missing from the listing indexes are indexed.
+func (s *RedisDataStore) MigrateKeys(ctx context.Context) (int, error) {
+	migrated := 0
+
+	var cursor uint64
+
+	for {
+		keys, next, err := s.rdb.Scan(ctx, cursor, "", 0).Result()
+		if err != nil {
+			return migrated, xerrors.Errorf("failed to scan redis store: %w", err)
+		}
+
+		for _, key := range keys {
+			if strings.HasPrefix(key, s.prefix) || strings.HasSuffix(key, gridSuffix) || strings.HasSuffix(key, operationsSuffix) {
+				continue
+			}
+
+			ok, err := s.migrateKey(key, ctx)
+			if err != nil {
+				return migrated, err
+			}
+
+			if ok {
+				migrated++
+			}
+		}
+
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+
+	indexed, err := s.indexDocuments(ctx)
+	if err != nil {
+		return migrated, err
+	}
+
+	log.WithField("indexed", indexed).Debug("indexed documents")
+
+	return migrated, nil
+}
+
+// migrateKey moves a legacy document under the prefix of the store, and reports whether it did.
+// Values which can't be decoded as a document, or without size, aren't documents of the store.
+func (s *RedisDataStore) migrateKey(key string, ctx context.Context) (bool, error) {
+	reply, err := readScript.Run(ctx, s.rdb, []string{key, key + gridSuffix}).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, xerrors.Errorf("failed to retrieve object from redis store: %w", err)
+	}
+
+	doc, err := decodeDocument(reply)
+	if err != nil || doc.Width == 0 || doc.Height == 0 {
+		return false, nil
+	}
+
+	target := s.docKey(key)
+	keys := []string{
+		key, key + gridSuffix, key + operationsSuffix,
+		target, target + gridSuffix, target + operationsSuffix,
+		s.countKey, s.createdKey, s.updatedKey,
+	}
+
+	if err := migrateScript.Run(ctx, s.rdb, keys, key, timeScore(doc.Created), timeScore(doc.Updated)).Err(); err != nil {
+		switch err := scriptError(err); err {
+		case NotFound:
+			return false, nil
+		case Conflict:
+			log.WithField("key", key).Warn("document already exists under the key prefix, skipping")
+
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	log.WithField("key", key).Debug("migrated document key")
+
+	return true, nil
+}
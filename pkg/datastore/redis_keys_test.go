@@ -0,0 +1,133 @@
+package datastore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+func TestRedisDataStore_Namespace(t *testing.T) {
+	ctx := context.TODO()
+	s, mr, _, _ := newTestRedis(t, RedisLayoutHash)
+
+	// Keys of other applications sharing the database.
+	require.NoError(t, mr.Set("session:42", "x"))
+	mr.HSet("canvas:docs", "width", "3")
+
+	doc := &canvas.Canvas{Width: 3, Height: 2}
+	require.NoError(t, s.SetDocument("123", doc, ctx))
+	require.NoError(t, s.SetDocument("456", doc, ctx))
+	require.NoError(t, s.SetDocument("456", doc, ctx))
+	require.NoError(t, s.AddOperation("456", canvas.NewFill(canvas.Point{X: 1, Y: 1}, "#"), ctx))
+
+	size, err := s.GetSize(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), size)
+
+	keys, _ := listKeys(t, s, ListQuery{Count: 100})
+	assert.ElementsMatch(t, []string{"123", "456"}, keys)
+
+	require.NoError(t, s.DeleteDocument("456", ctx))
+	assert.Equal(t, NotFound, s.DeleteDocument("456", ctx))
+	assert.False(t, mr.Exists(testDocPrefix+"456"+operationsSuffix))
+
+	size, err = s.GetSize(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), size)
+
+	// Stores with another prefix don't see each other's documents.
+	other := NewRedis(&RedisOptions{Options: redis.Options{Addr: mr.Addr()}, Prefix: "app[2]:"})
+	t.Cleanup(func() { _ = other.Close() })
+
+	require.NoError(t, other.SetDocument("789", doc, ctx))
+
+	keys, _ = listKeys(t, other, ListQuery{Count: 100})
+	assert.Equal(t, []string{"789"}, keys)
+
+	size, err = other.GetSize(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), size)
+
+	_, err = other.GetDocument("123", ctx)
+	assert.Equal(t, NotFound, err)
+}
+
+func TestRedisDataStore_MigrateKeys(t *testing.T) {
+	ctx := context.TODO()
+	s, mr, _, _ := newTestRedis(t, RedisLayoutHash)
+
+	// Documents written by previous versions, in both layouts.
+	legacy, err := (&canvas.Canvas{Name: "legacy", Width: 2, Height: 1, Data: []byte("ab")}).MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, mr.Set("123", string(legacy)))
+	_, err = mr.Push("123"+operationsSuffix, "{}")
+	require.NoError(t, err)
+
+	mr.HSet("456", "width", "3", "height", "1", "revision", "2")
+	require.NoError(t, mr.Set("456"+gridSuffix, "abc"))
+
+	// Already migrated.
+	require.NoError(t, mr.Set("789", string(legacy)))
+	require.NoError(t, s.SetDocument("789", &canvas.Canvas{Name: "new", Width: 1, Height: 1}, ctx))
+
+	// Namespaced, but written before the indexes.
+	mr.HSet(testDocPrefix+"abc", "width", "1", "height", "1", "updated", "1000000")
+
+	// Keys of other applications.
+	require.NoError(t, mr.Set("session:42", "x"))
+	require.NoError(t, mr.Set("config", `{"debug":true}`))
+	mr.HSet("user:1", "name", "me")
+
+	migrated, err := s.MigrateKeys(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, migrated)
+
+	for _, k := range []string{"123", "123" + operationsSuffix, "456", "456" + gridSuffix} {
+		assert.False(t, mr.Exists(k), k)
+	}
+
+	for _, k := range []string{"789", "session:42", "config", "user:1"} {
+		assert.True(t, mr.Exists(k), k)
+	}
+
+	got, err := s.GetDocument("123", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "legacy", got.Name)
+
+	ops, err := s.GetOperations("123", ctx)
+	require.NoError(t, err)
+	assert.Len(t, ops, 1)
+
+	got, err = s.GetDocument("456", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &canvas.Canvas{Width: 3, Height: 1, Data: []byte("abc"), Revision: 2}, got)
+
+	got, err = s.GetDocument("789", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "new", got.Name)
+
+	size, err := s.GetSize(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), size)
+
+	keys, _ := listKeys(t, s, ListQuery{})
+	assert.ElementsMatch(t, []string{"123", "456", "789", "abc"}, keys)
+
+	keys, _ = listKeys(t, s, ListQuery{Sort: SortUpdated})
+	assert.Equal(t, []string{"abc", "789", "456", "123"}, keys)
+
+	// Running it again does nothing.
+	migrated, err = s.MigrateKeys(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, migrated)
+}
+
+func Test_escapePattern(t *testing.T) {
+	assert.Equal(t, `canvas:`, escapePattern("canvas:"))
+	assert.Equal(t, `app\[2\]\*\?:`, escapePattern("app[2]*?:"))
+}
@@ -0,0 +1,425 @@
+package datastore
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+// Layouts of the documents in Redis.
+const (
+	// RedisLayoutHash stores the metadata of a document in a hash and its grid in a raw string,
+	// so that drawing operations update the cells in place instead of rewriting the whole document.
+	RedisLayoutHash = "hash"
+	// RedisLayoutString stores a document as a single string, encoded by Canvas.MarshalBinary.
+	// Documents written as JSON by previous versions are read in this layout.
+	RedisLayoutString = "string"
+)
+
+// gridSuffix is appended to the key of a document to get the key of its grid in the hash layout.
+const gridSuffix = ":grid"
+
+// Errors returned by the scripts, translated by scriptError.
+//
+//nolint:gochecknoglobals
+var scriptErrors = map[string]error{
+	"NOT_FOUND":          NotFound,
+	"CONFLICT":           Conflict,
+	"POINT_OUT_OF_BOUND": canvas.PointOutOfBound,
+	"OBJECT_TOO_LARGE":   canvas.ObjectTooLarge,
+	"BAD_PATTERN":        canvas.BadPattern,
+	"STRING_LAYOUT":      errStringLayout,
+}
+
+// errStringLayout is returned by the scripts working on the hash layout when a document is stored as a string.
+const errStringLayout = StoreError("STRING_LAYOUT")
+
+// The scripts check the layout of the documents with TYPE, whose status reply is a table with an ok field,
+// or a plain string in some Redis compatible servers.
+
+// readScript returns a document in either layout, as {"hash", metadata, grid} or {"string", document}.
+//
+//nolint:gochecknoglobals
+var readScript = redis.NewScript(`
+local kind = redis.call('TYPE', KEYS[1])
+kind = kind.ok or kind
+if kind == 'hash' then
+	return {'hash', redis.call('HGETALL', KEYS[1]), redis.call('GET', KEYS[2])}
+elseif kind == 'string' then
+	return {'string', redis.call('GET', KEYS[1])}
+end
+return false
+`)
+
+// swapScript replaces a document at the revision ARGV[1] with the grid ARGV[2] and the metadata from ARGV[7],
+// and sets the scores ARGV[4] and ARGV[5] of the document ARGV[3] in the creation and update indexes KEYS[3] and KEYS[4].
+// The document, its grid and its operations KEYS[5] expire at ARGV[6], see expireFunction and the expiry index KEYS[6].
+// Documents stored as strings can't be decoded by the script and are left to the caller.
+//
+//nolint:gochecknoglobals
+var swapScript = redis.NewScript(expireFunction + `
+local current
+local kind = redis.call('TYPE', KEYS[1])
+kind = kind.ok or kind
+if kind == 'hash' then
+	current = tonumber(redis.call('HGET', KEYS[1], 'revision') or '0')
+elseif kind == 'none' then
+	return redis.error_reply('NOT_FOUND')
+else
+	return redis.error_reply('STRING_LAYOUT')
+end
+if current ~= tonumber(ARGV[1]) then
+	return redis.error_reply('CONFLICT')
+end
+redis.call('DEL', KEYS[1], KEYS[2])
+redis.call('HSET', KEYS[1], 'revision', string.format('%d', current + 1), unpack(ARGV, 7))
+if ARGV[2] ~= '' then
+	redis.call('SET', KEYS[2], ARGV[2])
+end
+redis.call('ZADD', KEYS[3], ARGV[4], ARGV[3])
+redis.call('ZADD', KEYS[4], ARGV[5], ARGV[3])
+expire(tonumber(ARGV[6]), ARGV[3], KEYS[6], KEYS[1], KEYS[2], KEYS[5])
+return current + 1
+`)
+
+// rectScript draws a rectangle on a document in the hash layout with the same rules as canvas.DrawRect.
+// Each row of the rectangle is written with SETRANGE, so the request only carries the shape.
+// ARGV holds the expected revision, empty for any, the origin, size, fill and outline of the rectangle,
+// the background character of empty grids, and the update time of the document, with its score
+// in the update index KEYS[3] and the id of the document. Documents with a retention expire after it,
+// from the update time, along with their operations KEYS[4], see expireFunction and the expiry index KEYS[5].
+// The script returns the metadata of the document, without its color layer, and its grid only if ARGV[12] is set,
+// so that callers already holding the document don't read it back.
+//
+//nolint:gochecknoglobals
+var rectScript = redis.NewScript(expireFunction + `
+local kind = redis.call('TYPE', KEYS[1])
+kind = kind.ok or kind
+if kind == 'none' then
+	return redis.error_reply('NOT_FOUND')
+elseif kind ~= 'hash' then
+	return redis.error_reply('STRING_LAYOUT')
+end
+
+local meta = redis.call('HMGET', KEYS[1], 'width', 'height', 'revision')
+local width, height, revision = tonumber(meta[1]), tonumber(meta[2]), tonumber(meta[3] or '0')
+if ARGV[1] ~= '' and revision ~= tonumber(ARGV[1]) then
+	return redis.error_reply('CONFLICT')
+end
+
+local x, y, w, h = tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[4]), tonumber(ARGV[5])
+local fill, outline = ARGV[6], ARGV[7]
+if x > width or y > height then
+	return redis.error_reply('POINT_OUT_OF_BOUND')
+end
+if x + w > width or y + h > height then
+	return redis.error_reply('OBJECT_TOO_LARGE')
+end
+if #fill > 1 or #outline > 1 then
+	return redis.error_reply('BAD_PATTERN')
+end
+
+if w > 0 and h > 0 then
+	if redis.call('STRLEN', KEYS[2]) == 0 then
+		redis.call('SET', KEYS[2], string.rep(ARGV[8], width * height))
+	end
+
+	for row = y, y + h - 1 do
+		local offset = row * width
+		if outline ~= '' and (row == y or row == y + h - 1) then
+			redis.call('SETRANGE', KEYS[2], offset + x, string.rep(outline, w))
+		else
+			local x0, x1 = x, x + w - 1
+			if outline ~= '' then
+				redis.call('SETRANGE', KEYS[2], offset + x0, outline)
+				redis.call('SETRANGE', KEYS[2], offset + x1, outline)
+				x0, x1 = x0 + 1, x1 - 1
+			end
+			if fill ~= '' and x1 >= x0 then
+				redis.call('SETRANGE', KEYS[2], offset + x0, string.rep(fill, x1 - x0 + 1))
+			end
+		end
+	end
+end
+
+redis.call('HSET', KEYS[1], 'revision', string.format('%d', revision + 1), 'updated', ARGV[9])
+redis.call('ZADD', KEYS[3], ARGV[10], ARGV[11])
+
+local retention, expires = tonumber(redis.call('HGET', KEYS[1], 'retention') or '0'), 0
+if retention > 0 then
+	expires = tonumber(ARGV[10]) + math.floor(retention / 1000000)
+end
+expire(expires, ARGV[11], KEYS[5], KEYS[1], KEYS[2], KEYS[4])
+
+if ARGV[12] ~= '' then
+	return {'hash', redis.call('HGETALL', KEYS[1]), redis.call('GET', KEYS[2])}
+end
+
+local fields = redis.call('HGETALL', KEYS[1])
+local meta = {}
+for i = 1, #fields, 2 do
+	if fields[i] ~= 'attrs' then
+		meta[#meta + 1] = fields[i]
+		meta[#meta + 1] = fields[i + 1]
+	end
+end
+return {'hash', meta}
+`)
+
+// readDocument reads a document in either layout.
+func readDocument(c redis.Scripter, key string, ctx context.Context) (*canvas.Canvas, error) {
+	reply, err := readScript.Run(ctx, c, []string{key, key + gridSuffix}).Result()
+	if err == redis.Nil {
+		return nil, NotFound
+	}
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to retrieve object from redis store: %w", err)
+	}
+
+	return decodeDocument(reply)
+}
+
+// decodeDocument decodes a document returned by a script.
+func decodeDocument(reply interface{}) (*canvas.Canvas, error) {
+	values, ok := reply.([]interface{})
+	if !ok || len(values) < 2 {
+		return nil, xerrors.Errorf("failed to unmarshal document from redis store: unexpected reply %v", reply)
+	}
+
+	doc := canvas.Canvas{}
+
+	if values[0] == RedisLayoutString {
+		data, _ := values[1].(string)
+		if err := doc.UnmarshalBinary([]byte(data)); err != nil {
+			return nil, xerrors.Errorf("failed to unmarshal document from redis store: %w", err)
+		}
+
+		return &doc, nil
+	}
+
+	fields, _ := values[1].([]interface{})
+
+	for i := 0; i+1 < len(fields); i += 2 {
+		name, _ := fields[i].(string)
+		value, _ := fields[i+1].(string)
+
+		if err := setDocumentField(&doc, name, value); err != nil {
+			return nil, xerrors.Errorf("failed to unmarshal document from redis store: %w", err)
+		}
+	}
+
+	if len(values) > 2 {
+		if grid, ok := values[2].(string); ok && grid != "" {
+			doc.Data = []byte(grid)
+		}
+	}
+
+	setExpiry(&doc)
+
+	return &doc, nil
+}
+
+// documentFields returns the metadata of a document as hash fields, without its revision.
+// Timestamps are stored in nanoseconds since the Unix epoch, the retention in nanoseconds, and tags as a JSON array.
+// The expiry is not stored, see setExpiry.
+func documentFields(doc *canvas.Canvas) []interface{} {
+	fields := []interface{}{
+		"name", doc.Name,
+		"author", doc.Author,
+		"width", doc.Width,
+		"height", doc.Height,
+		"created", timeValue(doc.Created),
+		"updated", timeValue(doc.Updated),
+	}
+
+	if len(doc.Attrs) > 0 {
+		fields = append(fields, "attrs", doc.Attrs)
+	}
+
+	if doc.Creator != "" {
+		fields = append(fields, "creator", doc.Creator)
+	}
+
+	if doc.Description != "" {
+		fields = append(fields, "description", doc.Description)
+	}
+
+	if len(doc.Tags) > 0 {
+		tags, _ := json.Marshal(doc.Tags)
+		fields = append(fields, "tags", tags)
+	}
+
+	if doc.Retention > 0 {
+		fields = append(fields, "retention", int64(doc.Retention))
+	}
+
+	if doc.Parent != nil {
+		fields = append(fields, "parent", doc.Parent.ID, "parent_revision", doc.Parent.Revision)
+	}
+
+	return fields
+}
+
+// setExpiry sets the expiry of a document read from a hash, which always follows its update by its retention.
+func setExpiry(doc *canvas.Canvas) {
+	doc.Expires = time.Time{}
+
+	if doc.Retention > 0 {
+		doc.Expires = doc.Updated.Add(doc.Retention)
+	}
+}
+
+func setDocumentField(doc *canvas.Canvas, name, value string) error {
+	var err error
+
+	switch name {
+	case "name":
+		doc.Name = value
+	case "author":
+		doc.Author = value
+	case "attrs":
+		doc.Attrs = []byte(value)
+	case "width":
+		var v uint64
+		v, err = strconv.ParseUint(value, 10, 0)
+		doc.Width = uint(v)
+	case "height":
+		var v uint64
+		v, err = strconv.ParseUint(value, 10, 0)
+		doc.Height = uint(v)
+	case "revision":
+		doc.Revision, err = strconv.ParseUint(value, 10, 64)
+	case "created":
+		doc.Created, err = parseTimeField(value)
+	case "updated":
+		doc.Updated, err = parseTimeField(value)
+	case "creator":
+		doc.Creator = value
+	case "description":
+		doc.Description = value
+	case "tags":
+		err = json.Unmarshal([]byte(value), &doc.Tags)
+	case "retention":
+		var v int64
+		v, err = strconv.ParseInt(value, 10, 64)
+		doc.Retention = time.Duration(v)
+	case "parent":
+		if doc.Parent == nil {
+			doc.Parent = &canvas.Parent{}
+		}
+
+		doc.Parent.ID = value
+	case "parent_revision":
+		if doc.Parent == nil {
+			doc.Parent = &canvas.Parent{}
+		}
+
+		doc.Parent.Revision, err = strconv.ParseUint(value, 10, 64)
+	}
+
+	if err != nil {
+		return xerrors.Errorf("invalid field %s: %w", name, err)
+	}
+
+	return nil
+}
+
+func parseTimeField(value string) (time.Time, error) {
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err //nolint:wrapcheck
+	}
+
+	return timeFromValue(v), nil
+}
+
+// writeHashDocument queues the commands replacing a document with its hash layout.
+func writeHashDocument(pipe redis.Pipeliner, key string, doc *canvas.Canvas, ctx context.Context) {
+	gridKey := key + gridSuffix
+
+	pipe.Del(ctx, key, gridKey)
+	pipe.HSet(ctx, key, append(documentFields(doc), "revision", doc.Revision)...)
+
+	if len(doc.Data) > 0 {
+		pipe.Set(ctx, gridKey, doc.Data, 0)
+	}
+}
+
+// swapDocument replaces a document at the given revision with its hash layout and returns the new revision.
+func (s *RedisDataStore) swapDocument(id string, revision uint64, doc *canvas.Canvas, ctx context.Context) (uint64, error) {
+	key := s.docKey(id)
+	keys := []string{key, key + gridSuffix, s.createdKey, s.updatedKey, key + operationsSuffix, s.expiresKey}
+	args := append([]interface{}{revision, doc.Data, id, timeScore(doc.Created), timeScore(doc.Updated), timeScore(doc.Expires)}, documentFields(doc)...)
+
+	next, err := swapScript.Run(ctx, s.rdb, keys, args...).Uint64()
+	if err != nil {
+		return 0, scriptError(err)
+	}
+
+	return next, nil
+}
+
+// drawRect draws a rectangle on a document in the hash layout, see rectScript.
+// Without base, the updated document is read back from Redis. Otherwise, base is the document at the expected
+// revision: the rectangle is drawn on a copy of it, and only the metadata of the stored document is read back.
+func (s *RedisDataStore) drawRect(id string, op *canvas.Operation, revision uint64, base *canvas.Canvas, ctx context.Context) (*canvas.Canvas, error) {
+	expected := ""
+	if revision != AnyRevision {
+		expected = strconv.FormatUint(revision, 10)
+	}
+
+	withGrid := ""
+	if base == nil {
+		withGrid = "1"
+	}
+
+	args := []interface{}{
+		expected,
+		op.Rect.Origin.X, op.Rect.Origin.Y, op.Rect.Width, op.Rect.Height,
+		op.Fill, op.Outline,
+		string(canvas.BackgroundChar),
+		timeValue(op.Time), timeScore(op.Time), id,
+		withGrid,
+	}
+
+	key := s.docKey(id)
+
+	keys := []string{key, key + gridSuffix, s.updatedKey, key + operationsSuffix, s.expiresKey}
+
+	reply, err := rectScript.Run(ctx, s.rdb, keys, args...).Result()
+	if err != nil {
+		return nil, scriptError(err)
+	}
+
+	doc, err := decodeDocument(reply)
+	if err != nil || base == nil {
+		return doc, err
+	}
+
+	drawn := base.Clone()
+	if err := op.Apply(drawn); err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	doc.Data, doc.Attrs = drawn.Data, drawn.Attrs
+
+	return doc, nil
+}
+
+// scriptError translates the errors returned by the scripts.
+func scriptError(err error) error {
+	if redisErr, ok := err.(redis.Error); ok { //nolint:errorlint
+		if e, ok := scriptErrors[redisErr.Error()]; ok {
+			return e
+		}
+	}
+
+	return xerrors.Errorf("failed to run script on redis store: %w", err)
+}
@@ -0,0 +1,271 @@
+package datastore
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+// countingConn counts the bytes exchanged with the Redis server.
+type countingConn struct {
+	net.Conn
+	sent, received *int64
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(c.sent, int64(n))
+
+	return n, err
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(c.received, int64(n))
+
+	return n, err
+}
+
+// newTestRedis returns a store connected to an in-process Redis server,
+// and the counters of the bytes sent to and received from it.
+func newTestRedis(tb testing.TB, layout string) (s *RedisDataStore, mr *miniredis.Miniredis, sent, received *int64) {
+	tb.Helper()
+
+	mr, err := miniredis.Run()
+	require.NoError(tb, err)
+	tb.Cleanup(mr.Close)
+
+	sent, received = new(int64), new(int64)
+
+	s = NewRedis(&RedisOptions{
+		Options: redis.Options{
+			Addr: mr.Addr(),
+			Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+
+				return &countingConn{Conn: conn, sent: sent, received: received}, nil
+			},
+		},
+		Layout: layout,
+	})
+	tb.Cleanup(func() { _ = s.Close() })
+
+	return s, mr, sent, received
+}
+
+func TestRedisDataStore_Layouts(t *testing.T) {
+	for _, layout := range []string{RedisLayoutHash, RedisLayoutString} {
+		t.Run(layout, func(t *testing.T) {
+			t.Run("compare and swap", func(t *testing.T) {
+				s, _, _, _ := newTestRedis(t, layout)
+				testCompareAndSwap(t, s)
+			})
+
+			t.Run("update", func(t *testing.T) {
+				s, _, _, _ := newTestRedis(t, layout)
+				testUpdateDocument(t, s)
+			})
+		})
+	}
+}
+
+func TestRedisDataStore_HashLayout(t *testing.T) {
+	s, mr, _, _ := newTestRedis(t, RedisLayoutHash)
+	ctx := context.TODO()
+
+	doc := &canvas.Canvas{Name: "doc", Width: 3, Height: 2, Data: []byte("abcdef"), Attrs: []byte{1, 2, 3, 4, 5, 6}}
+	doc.Parent = &canvas.Parent{ID: "456", Revision: 7}
+	require.NoError(t, s.SetDocument("123", doc, ctx))
+
+	assert.Equal(t, "abcdef", must(mr.Get(testDocPrefix+"123"+gridSuffix)))
+	assert.Equal(t, "3", mr.HGet(testDocPrefix+"123", "width"))
+	assert.Equal(t, "\x01\x02\x03\x04\x05\x06", mr.HGet(testDocPrefix+"123", "attrs"))
+	assert.Equal(t, "456", mr.HGet(testDocPrefix+"123", "parent"))
+
+	got, err := s.GetDocument("123", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, doc, got)
+
+	keys, _ := listKeys(t, s, ListQuery{})
+	assert.Equal(t, []string{"123"}, keys)
+
+	require.NoError(t, s.DeleteDocument("123", ctx))
+	assert.False(t, mr.Exists(testDocPrefix+"123"+gridSuffix))
+}
+
+func TestRedisDataStore_LayoutMigration(t *testing.T) {
+	ctx := context.TODO()
+	hash, mr, _, _ := newTestRedis(t, RedisLayoutHash)
+	stringStore := NewRedis(&RedisOptions{Options: redis.Options{Addr: mr.Addr()}, Layout: RedisLayoutString})
+
+	t.Cleanup(func() { _ = stringStore.Close() })
+
+	// Documents written as strings are read by the hash layout and converted when updated.
+	require.NoError(t, stringStore.SetDocument("123", &canvas.Canvas{Name: "doc", Width: 3, Height: 2, Revision: 4}, ctx))
+
+	got, err := hash.GetDocument("123", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &canvas.Canvas{Name: "doc", Width: 3, Height: 2, Revision: 4}, got)
+
+	op := canvas.NewRect(canvas.Rectangle{Origin: canvas.Point{X: 1, Y: 1}, Width: 2, Height: 1}, "#", "")
+	got, err = hash.UpdateDocument("123", op, 4, ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &canvas.Canvas{Name: "doc", Width: 3, Height: 2, Data: []byte("----##"), Revision: 5, Updated: op.Time}, got)
+	assert.Equal(t, "hash", mr.Type(testDocPrefix+"123"))
+
+	// And back.
+	got.Name = "renamed"
+	require.NoError(t, stringStore.CompareAndSwapDocument("123", 5, got, ctx))
+	assert.Equal(t, "string", mr.Type(testDocPrefix+"123"))
+	assert.False(t, mr.Exists(testDocPrefix+"123"+gridSuffix))
+
+	got, err = hash.GetDocument("123", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &canvas.Canvas{Name: "renamed", Width: 3, Height: 2, Data: []byte("----##"), Revision: 6, Updated: op.Time}, got)
+}
+
+func TestRedisDataStore_LegacyDocuments(t *testing.T) {
+	ctx := context.TODO()
+	s, mr, _, _ := newTestRedis(t, RedisLayoutHash)
+
+	// Document written as JSON by a previous version.
+	require.NoError(t, mr.Set(testDocPrefix+"123", `{"name":"doc","width":2,"height":1,"data":"YWI=","revision":3}`))
+
+	got, err := s.GetDocument("123", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &canvas.Canvas{Name: "doc", Width: 2, Height: 1, Data: []byte("ab"), Revision: 3}, got)
+
+	// The swap script can't decode it, the transaction converts it to the hash layout.
+	got.Name = "renamed"
+	require.NoError(t, s.CompareAndSwapDocument("123", 3, got, ctx))
+	assert.Equal(t, uint64(4), got.Revision)
+	assert.Equal(t, "hash", mr.Type(testDocPrefix+"123"))
+	assert.Equal(t, "ab", must(mr.Get(testDocPrefix+"123"+gridSuffix)))
+
+	assert.Equal(t, Conflict, s.CompareAndSwapDocument("123", 3, got, ctx))
+}
+
+func TestRedisDataStore_UpdateDocumentFrom(t *testing.T) {
+	for _, layout := range []string{RedisLayoutHash, RedisLayoutString} {
+		t.Run(layout, func(t *testing.T) {
+			s, _, _, received := newTestRedis(t, layout)
+			ctx := context.TODO()
+
+			require.NoError(t, s.SetDocument("123", &canvas.Canvas{Name: "doc", Width: 200, Height: 100, Attrs: make([]byte, 200*100)}, ctx))
+
+			base, err := s.GetDocument("123", ctx)
+			require.NoError(t, err)
+
+			op := canvas.NewRect(canvas.Rectangle{Origin: canvas.Point{X: 1, Y: 1}, Width: 3, Height: 3}, "#", "*")
+
+			atomic.StoreInt64(received, 0)
+
+			got, err := UpdateDocumentFrom(s, "123", op, base, ctx)
+			require.NoError(t, err)
+			assert.Equal(t, base.Revision+1, got.Revision)
+
+			if layout == RedisLayoutHash {
+				// Only the metadata of the document is read back.
+				assert.Less(t, atomic.LoadInt64(received), int64(1000))
+			}
+
+			stored, err := s.GetDocument("123", ctx)
+			require.NoError(t, err)
+			assert.Equal(t, stored, got)
+
+			_, err = UpdateDocumentFrom(s, "123", op, base, ctx)
+			assert.Equal(t, Conflict, err)
+		})
+	}
+}
+
+func must(v string, err error) string {
+	if err != nil {
+		panic(err)
+	}
+
+	return v
+}
+
+func mustMarshal(doc *canvas.Canvas) string {
+	data, err := doc.MarshalBinary()
+
+	return must(string(data), err)
+}
+
+// BenchmarkRedisDataStore_DrawRect compares the cost of drawing small rectangles on a large canvas in both layouts.
+// The bytes sent to Redis only depend on the size of the rectangle in the hash layout,
+// while the string layout sends the whole document for every operation. UpdateDocument reads the whole
+// document back in both layouts, UpdateDocumentFrom only reads the metadata of the document in the hash layout.
+func BenchmarkRedisDataStore_DrawRect(b *testing.B) {
+	for _, layout := range []string{RedisLayoutString, RedisLayoutHash} {
+		for _, from := range []bool{false, true} {
+			name := layout
+			if from {
+				name += "/from"
+			}
+
+			b.Run(name, func(b *testing.B) {
+				s, _, sent, received := newTestRedis(b, layout)
+				ctx := context.Background()
+
+				require.NoError(b, s.SetDocument("123", &canvas.Canvas{Width: 200, Height: 100}, ctx))
+
+				doc, err := s.GetDocument("123", ctx)
+				require.NoError(b, err)
+
+				op := canvas.NewRect(canvas.Rectangle{Origin: canvas.Point{X: 10, Y: 10}, Width: 8, Height: 4}, "#", "@")
+
+				atomic.StoreInt64(sent, 0)
+				atomic.StoreInt64(received, 0)
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					if from {
+						doc, err = UpdateDocumentFrom(s, "123", op, doc, ctx)
+					} else {
+						doc, err = s.UpdateDocument("123", op, AnyRevision, ctx)
+					}
+
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+
+				b.ReportMetric(float64(atomic.LoadInt64(sent))/float64(b.N), "sent-B/op")
+				b.ReportMetric(float64(atomic.LoadInt64(received))/float64(b.N), "received-B/op")
+			})
+		}
+	}
+}
+
+func TestRedisDataStore_Journal(t *testing.T) {
+	s, _, _, _ := newTestRedis(t, RedisLayoutString)
+	testJournal(t, s)
+}
+
+func TestRedisDataStore_Trash(t *testing.T) {
+	for _, layout := range []string{RedisLayoutHash, RedisLayoutString} {
+		t.Run(layout, func(t *testing.T) {
+			s, mr, _, _ := newTestRedis(t, layout)
+			testTrash(t, s)
+
+			// Trashed documents don't expire in Redis either.
+			require.NoError(t, s.TrashDocument("a", time.Now(), context.TODO()))
+			mr.FastForward(2 * time.Hour)
+			assert.True(t, mr.Exists(DefaultRedisPrefix+trashNamespace+"a"))
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package datastore
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+// Trashed documents are renamed with their grid and operations under <prefix>trash:<id>, without expiry,
+// and listed in the trash index <prefix>idx:trash, a sorted set scored by deletion time in milliseconds.
+
+// trashScript moves the document KEYS[1] with its grid KEYS[2] and its operations KEYS[3] to the trash keys
+// KEYS[4] to KEYS[6], replacing a previous version, decrements the counter KEYS[7], removes the id ARGV[1]
+// from the indexes KEYS[8] to KEYS[10] and adds it to the trash index KEYS[11] with the score ARGV[2].
+// It returns 0 if the document doesn't exist.
+//
+//nolint:gochecknoglobals
+var trashScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('DEL', KEYS[4], KEYS[5], KEYS[6])
+for i = 1, 3 do
+	if redis.call('EXISTS', KEYS[i]) == 1 then
+		redis.call('RENAME', KEYS[i], KEYS[i + 3])
+		redis.call('PERSIST', KEYS[i + 3])
+	end
+end
+redis.call('DECR', KEYS[7])
+redis.call('ZREM', KEYS[8], ARGV[1])
+redis.call('ZREM', KEYS[9], ARGV[1])
+redis.call('ZREM', KEYS[10], ARGV[1])
+redis.call('ZADD', KEYS[11], ARGV[2], ARGV[1])
+return 1
+`)
+
+// purgeScript deletes the trashed document KEYS[1] with its grid KEYS[2] and its operations KEYS[3],
+// and removes the id ARGV[1] from the trash index KEYS[4]. It returns 0 if the document isn't in the trash.
+//
+//nolint:gochecknoglobals
+var purgeScript = redis.NewScript(`
+local deleted = redis.call('DEL', KEYS[1])
+redis.call('DEL', KEYS[2], KEYS[3])
+redis.call('ZREM', KEYS[4], ARGV[1])
+return deleted
+`)
+
+// trashDocKey returns the Redis key of a trashed document.
+func (s *RedisDataStore) trashDocKey(key string) string {
+	return s.trashPrefix + key
+}
+
+// TrashDocument moves a document to the trash namespace, see trashScript.
+func (s *RedisDataStore) TrashDocument(key string, now time.Time, ctx context.Context) error {
+	docKey, trashKey := s.docKey(key), s.trashDocKey(key)
+
+	keys := []string{
+		docKey, docKey + gridSuffix, docKey + operationsSuffix,
+		trashKey, trashKey + gridSuffix, trashKey + operationsSuffix,
+		s.countKey, s.createdKey, s.updatedKey, s.expiresKey, s.trashKey,
+	}
+
+	trashed, err := trashScript.Run(ctx, s.rdb, keys, key, timeScore(now)).Int()
+	if err != nil {
+		return xerrors.Errorf("failed to move document to trash in redis store: %w", err)
+	}
+
+	if trashed == 0 {
+		return NotFound
+	}
+
+	return nil
+}
+
+// ListTrash reads the trash index and the metadata of the trashed documents.
+func (s *RedisDataStore) ListTrash(ctx context.Context) ([]*DocInfo, error) {
+	entries, err := s.rdb.ZRevRangeWithScores(ctx, s.trashKey, 0, -1).Result()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to retrieve trash from redis store: %w", err)
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		ids = append(ids, e.Member.(string))
+	}
+
+	batchInfos, err := s.readInfos(ids, s.trashDocKey, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*DocInfo, 0, len(entries))
+
+	for i, info := range batchInfos {
+		// Documents purged after the index was read are skipped.
+		if info == nil {
+			continue
+		}
+
+		info.Deleted = time.UnixMilli(int64(entries[i].Score)).UTC()
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// RestoreDocument moves a document back from the trash in a transaction watching the keys of the document,
+// so that it fails if the document is restored, purged or recreated concurrently.
+// The document is written again to touch it and add it back to the indexes.
+func (s *RedisDataStore) RestoreDocument(key string, now time.Time, ctx context.Context) (*canvas.Canvas, error) {
+	var (
+		doc      *canvas.Canvas
+		docKey   = s.docKey(key)
+		trashKey = s.trashDocKey(key)
+	)
+
+	err := s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+		var err error
+		if doc, err = readDocument(tx, trashKey, ctx); err != nil {
+			return err
+		}
+
+		exists, err := tx.Exists(ctx, docKey).Result()
+		if err != nil {
+			return xerrors.Errorf("failed to check document presence in redis store: %w", err)
+		}
+
+		if exists > 0 {
+			return Exists
+		}
+
+		hasOps, err := tx.Exists(ctx, trashKey+operationsSuffix).Result()
+		if err != nil {
+			return xerrors.Errorf("failed to check operations presence in redis store: %w", err)
+		}
+
+		doc.Touch(now)
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Eval(ctx, countScript, []string{docKey, s.countKey})
+
+			if hasOps > 0 {
+				pipe.Rename(ctx, trashKey+operationsSuffix, docKey+operationsSuffix)
+			}
+
+			s.writeDocument(pipe, key, doc, ctx)
+			pipe.Del(ctx, trashKey, trashKey+gridSuffix)
+			pipe.ZRem(ctx, s.trashKey, key)
+
+			return nil
+		})
+
+		return err //nolint:wrapcheck
+	}, trashKey, trashKey+operationsSuffix, docKey)
+
+	switch {
+	case err == nil:
+		return doc, nil
+	case err == redis.TxFailedErr:
+		return nil, Conflict
+	case err == NotFound || err == Exists:
+		return nil, err
+	default:
+		return nil, xerrors.Errorf("failed to restore document in redis store: %w", err)
+	}
+}
+
+// PurgeDocument deletes a trashed document, see purgeScript.
+func (s *RedisDataStore) PurgeDocument(key string, ctx context.Context) error {
+	deleted, err := s.purgeDocument(key, ctx)
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return NotFound
+	}
+
+	return nil
+}
+
+func (s *RedisDataStore) purgeDocument(key string, ctx context.Context) (int, error) {
+	trashKey := s.trashDocKey(key)
+	keys := []string{trashKey, trashKey + gridSuffix, trashKey + operationsSuffix, s.trashKey}
+
+	deleted, err := purgeScript.Run(ctx, s.rdb, keys, key).Int()
+	if err != nil {
+		return 0, xerrors.Errorf("failed to purge document from redis store: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// PurgeTrash reads the trash index by batches, and purges the documents trashed before the given time.
+func (s *RedisDataStore) PurgeTrash(before time.Time, ctx context.Context) (int, error) {
+	deleted := 0
+	max := "(" + strconv.FormatInt(timeScore(before), 10) //nolint:gomnd
+
+	for {
+		ids, err := s.rdb.ZRangeByScore(ctx, s.trashKey, &redis.ZRangeBy{Min: "-inf", Max: max, Count: sweepBatch}).Result()
+		if err != nil {
+			return deleted, xerrors.Errorf("failed to read trash index of redis store: %w", err)
+		}
+
+		for _, id := range ids {
+			n, err := s.purgeDocument(id, ctx)
+			if err != nil {
+				return deleted, err
+			}
+
+			deleted += n
+		}
+
+		if len(ids) < sweepBatch {
+			return deleted, nil
+		}
+	}
+}
@@ -0,0 +1,891 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	// Pure Go SQLite driver, registered as "sqlite".
+	_ "modernc.org/sqlite"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+const sqliteDriverName = "sqlite"
+
+// sqliteMigrations holds the successive versions of the database schema.
+// The schema version stored in the database is the number of migrations applied,
+// new migrations must be appended to the list.
+var sqliteMigrations = [][]string{ //nolint:gochecknoglobals
+	{
+		`CREATE TABLE documents (
+			seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+			id         TEXT    NOT NULL UNIQUE,
+			name       TEXT    NOT NULL DEFAULT '',
+			width      INTEGER NOT NULL,
+			height     INTEGER NOT NULL,
+			created_at INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL,
+			canvas     BLOB    NOT NULL
+		)`,
+		`CREATE INDEX documents_name ON documents (name)`,
+		`CREATE INDEX documents_updated_at ON documents (updated_at)`,
+		`CREATE TABLE operations (
+			seq    INTEGER PRIMARY KEY AUTOINCREMENT,
+			doc_id TEXT    NOT NULL,
+			data   BLOB    NOT NULL
+		)`,
+		`CREATE INDEX operations_doc_id ON operations (doc_id, seq)`,
+	},
+	{
+		`ALTER TABLE documents ADD COLUMN revision INTEGER NOT NULL DEFAULT 0`,
+	},
+	{
+		`ALTER TABLE documents ADD COLUMN creator TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE documents ADD COLUMN description TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE documents ADD COLUMN tags TEXT NOT NULL DEFAULT '[]'`,
+		`CREATE TABLE document_tags (
+			doc_id TEXT NOT NULL,
+			tag    TEXT NOT NULL,
+			PRIMARY KEY (tag, doc_id)
+		)`,
+		`CREATE INDEX document_tags_doc_id ON document_tags (doc_id)`,
+	},
+	{
+		`ALTER TABLE documents ADD COLUMN expires_at INTEGER NOT NULL DEFAULT 0`,
+		`CREATE INDEX documents_expires_at ON documents (expires_at) WHERE expires_at > 0`,
+	},
+	{
+		`CREATE TABLE trash (
+			id         TEXT    PRIMARY KEY,
+			deleted_at INTEGER NOT NULL,
+			canvas     BLOB    NOT NULL
+		)`,
+		`CREATE INDEX trash_deleted_at ON trash (deleted_at)`,
+	},
+}
+
+// SQLiteDataStore is a DataStore keeping the documents in a SQLite database.
+//
+// The metadata and size of the documents are stored in their own columns next to the serialized canvas,
+// and their tags in a table indexing the documents by tag. The schema is migrated to the latest version when the store is opened.
+// Trashed documents are moved to the trash table as serialized canvases, their operations stay in the operations table.
+type SQLiteDataStore struct {
+	sweeper
+
+	db *sql.DB
+}
+
+// NewSQLite opens the SQLite database at path, creating it if needed, and migrates its schema.
+func NewSQLite(path string, ctx context.Context) (*SQLiteDataStore, error) {
+	if path == "" {
+		return nil, xerrors.New("missing sqlite datastore path")
+	}
+
+	db, err := sql.Open(sqliteDriverName, path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open sqlite database at %s: %w", path, err)
+	}
+
+	// SQLite only supports a single writer, serializing the connections avoids busy errors.
+	// It also keeps in-memory databases alive, each connection would otherwise get its own.
+	db.SetMaxOpenConns(1)
+
+	s := &SQLiteDataStore{db: db}
+
+	if err := s.migrate(ctx); err != nil {
+		_ = db.Close()
+
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Close stops the deletion of the expired documents and releases the database.
+func (s *SQLiteDataStore) Close() error {
+	s.stopSweeping()
+
+	if err := s.db.Close(); err != nil {
+		return xerrors.Errorf("failed to close sqlite database: %w", err)
+	}
+
+	return nil
+}
+
+// migrate applies the migrations missing from the database, each one in its own transaction.
+func (s *SQLiteDataStore) migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL)`); err != nil {
+		return xerrors.Errorf("failed to create schema version table: %w", err)
+	}
+
+	var version int
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return xerrors.Errorf("failed to retrieve schema version: %w", err)
+	}
+
+	if version > len(sqliteMigrations) {
+		return xerrors.Errorf("database schema version %d is newer than the supported version %d", version, len(sqliteMigrations))
+	}
+
+	for v := version; v < len(sqliteMigrations); v++ {
+		err := s.transaction(ctx, func(tx *sql.Tx) error {
+			for _, stmt := range sqliteMigrations[v] {
+				if _, err := tx.ExecContext(ctx, stmt); err != nil {
+					return err //nolint:wrapcheck
+				}
+			}
+
+			_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES (?)`, v+1)
+
+			return err //nolint:wrapcheck
+		})
+		if err != nil {
+			return xerrors.Errorf("failed to migrate database schema to version %d: %w", v+1, err)
+		}
+	}
+
+	return nil
+}
+
+// transaction runs fn in a transaction, committed if fn succeeds and rolled back otherwise.
+func (s *SQLiteDataStore) transaction(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return xerrors.Errorf("failed to start transaction: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return xerrors.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteDataStore) GetSize(ctx context.Context) (int64, error) {
+	var size int64
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM documents`).Scan(&size); err != nil {
+		return 0, xerrors.Errorf("failed to retrieve size of sqlite store: %w", err)
+	}
+
+	return size, nil
+}
+
+// ListDocuments filters and sorts the documents with a single query, using the indexes of the columns.
+func (s *SQLiteDataStore) ListDocuments(query *ListQuery, ctx context.Context) ([]*DocInfo, string, error) {
+	if err := query.validate(); err != nil {
+		return nil, "", err
+	}
+
+	after, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var (
+		where = []string{"1 = 1"}
+		args  []interface{}
+		order = "seq"
+	)
+
+	if query.Sort == SortUpdated {
+		order = "updated_at DESC, id DESC"
+	}
+
+	if after != nil {
+		if query.Sort == SortUpdated {
+			where = append(where, "(updated_at < ? OR updated_at = ? AND id < ?)")
+			args = append(args, after.value, after.value, after.key)
+		} else {
+			where = append(where, "seq > ?")
+			args = append(args, after.value)
+		}
+	}
+
+	// The prefix is matched as a range of names so that the documents_name index can be used.
+	if query.NamePrefix != "" {
+		where = append(where, "name >= ?")
+		args = append(args, query.NamePrefix)
+
+		if end, ok := prefixEnd(query.NamePrefix); ok {
+			where = append(where, "name < ?")
+			args = append(args, end)
+		}
+	}
+
+	if query.Tag != "" {
+		where = append(where, "id IN (SELECT doc_id FROM document_tags WHERE tag = ?)")
+		args = append(args, query.Tag)
+	}
+
+	// Fetch one more row to know if there is a next page.
+	args = append(args, query.Count+1)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, seq, name, creator, description, tags, created_at, updated_at, expires_at FROM documents
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY `+order+`
+		LIMIT ?`,
+		args...)
+	if err != nil {
+		return nil, "", xerrors.Errorf("failed to retrieve documents from sqlite store: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		infos     []*DocInfo
+		positions []listPosition
+	)
+
+	for rows.Next() {
+		var (
+			info                      DocInfo
+			seq                       int64
+			tags                      string
+			created, updated, expires int64
+		)
+
+		if err := rows.Scan(&info.Key, &seq, &info.Name, &info.Creator, &info.Description, &tags, &created, &updated, &expires); err != nil {
+			return nil, "", xerrors.Errorf("failed to retrieve documents from sqlite store: %w", err)
+		}
+
+		if err := json.Unmarshal([]byte(tags), &info.Tags); err != nil {
+			return nil, "", xerrors.Errorf("failed to unmarshal tags from sqlite store: %w", err)
+		}
+
+		if len(info.Tags) == 0 {
+			info.Tags = nil
+		}
+
+		info.Created, info.Updated, info.Expires = timeFromValue(created), timeFromValue(updated), timeFromValue(expires)
+
+		position := listPosition{value: seq, key: info.Key}
+		if query.Sort == SortUpdated {
+			position.value = updated
+		}
+
+		infos = append(infos, &info)
+		positions = append(positions, position)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", xerrors.Errorf("failed to retrieve documents from sqlite store: %w", err)
+	}
+
+	next := ""
+	if int64(len(infos)) > query.Count {
+		infos = infos[:query.Count]
+		next = encodeCursor(positions[query.Count-1])
+	}
+
+	return infos, next, nil
+}
+
+func (s *SQLiteDataStore) SetDocument(key string, doc *canvas.Canvas, ctx context.Context) error {
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		return setDocument(tx, key, doc, ctx)
+	})
+}
+
+// setDocument inserts or replaces a document with its tags.
+func setDocument(tx *sql.Tx, key string, doc *canvas.Canvas, ctx context.Context) error {
+	data, err := doc.MarshalBinary()
+	if err != nil {
+		return xerrors.Errorf("failed to set document in sqlite store: %w", err)
+	}
+
+	tags, err := marshalTags(doc)
+	if err != nil {
+		return xerrors.Errorf("failed to set document in sqlite store: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO documents (id, name, width, height, created_at, updated_at, expires_at, creator, description, tags, revision, canvas)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			name = excluded.name,
+			width = excluded.width,
+			height = excluded.height,
+			created_at = excluded.created_at,
+			updated_at = excluded.updated_at,
+			expires_at = excluded.expires_at,
+			creator = excluded.creator,
+			description = excluded.description,
+			tags = excluded.tags,
+			revision = excluded.revision,
+			canvas = excluded.canvas`,
+		key, doc.Name, doc.Width, doc.Height, timeValue(doc.Created), timeValue(doc.Updated), timeValue(doc.Expires),
+		doc.Creator, doc.Description, tags, doc.Revision, data)
+	if err != nil {
+		return xerrors.Errorf("failed to set document in sqlite store: %w", err)
+	}
+
+	return writeTags(tx, key, doc.Tags, ctx)
+}
+
+// updateDocument writes a new version of an existing document at the given revision, and returns the number of updated rows.
+func updateDocument(tx *sql.Tx, key string, revision uint64, doc *canvas.Canvas, data []byte, ctx context.Context) (int64, error) {
+	tags, err := marshalTags(doc)
+	if err != nil {
+		return 0, xerrors.Errorf("failed to update document in sqlite store: %w", err)
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE documents SET
+			name = ?, width = ?, height = ?, created_at = ?, updated_at = ?, expires_at = ?,
+			creator = ?, description = ?, tags = ?, revision = ?, canvas = ?
+		WHERE id = ? AND revision = ?`,
+		doc.Name, doc.Width, doc.Height, timeValue(doc.Created), timeValue(doc.Updated), timeValue(doc.Expires),
+		doc.Creator, doc.Description, tags, doc.Revision, data, key, revision)
+	if err != nil {
+		return 0, xerrors.Errorf("failed to update document in sqlite store: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, xerrors.Errorf("failed to update document in sqlite store: %w", err)
+	}
+
+	if n > 0 {
+		if err := writeTags(tx, key, doc.Tags, ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	return n, nil
+}
+
+// marshalTags returns the tags of a document as stored in the tags column.
+func marshalTags(doc *canvas.Canvas) (string, error) {
+	if len(doc.Tags) == 0 {
+		return "[]", nil
+	}
+
+	data, err := json.Marshal(doc.Tags)
+	if err != nil {
+		return "", xerrors.Errorf("failed to marshal tags: %w", err)
+	}
+
+	return string(data), nil
+}
+
+// writeTags replaces the tags of a document in the tag index.
+func writeTags(tx *sql.Tx, key string, tags []string, ctx context.Context) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM document_tags WHERE doc_id = ?`, key); err != nil {
+		return xerrors.Errorf("failed to update tags in sqlite store: %w", err)
+	}
+
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO document_tags (doc_id, tag) VALUES (?, ?)`, key, tag); err != nil {
+			return xerrors.Errorf("failed to update tags in sqlite store: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLiteDataStore) CompareAndSwapDocument(key string, revision uint64, doc *canvas.Canvas, ctx context.Context) error {
+	next := *doc
+	next.Revision = revision + 1
+
+	data, err := next.MarshalBinary()
+	if err != nil {
+		return xerrors.Errorf("failed to set document in sqlite store: %w", err)
+	}
+
+	err = s.transaction(ctx, func(tx *sql.Tx) error {
+		n, err := updateDocument(tx, key, revision, &next, data, ctx)
+		if err != nil {
+			return err
+		}
+
+		if n > 0 {
+			return nil
+		}
+
+		// Nothing was updated, either the document doesn't exist or it is at another revision.
+		var exists bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM documents WHERE id = ?)`, key).Scan(&exists); err != nil {
+			return xerrors.Errorf("failed to check document presence in sqlite store: %w", err)
+		}
+
+		if !exists {
+			return NotFound
+		}
+
+		return Conflict
+	})
+	if err != nil {
+		return err
+	}
+
+	doc.Revision = next.Revision
+
+	return nil
+}
+
+// UpdateDocument reads and writes the document in a single transaction,
+// the store using a single connection serializes it with the other updates.
+func (s *SQLiteDataStore) UpdateDocument(key string, op *canvas.Operation, revision uint64, ctx context.Context) (*canvas.Canvas, error) {
+	doc := canvas.Canvas{}
+
+	err := s.transaction(ctx, func(tx *sql.Tx) error {
+		var data []byte
+
+		err := tx.QueryRowContext(ctx, `SELECT canvas FROM documents WHERE id = ?`, key).Scan(&data)
+		if err == sql.ErrNoRows {
+			return NotFound
+		}
+
+		if err != nil {
+			return xerrors.Errorf("failed to retrieve document from sqlite store: %w", err)
+		}
+
+		if err := doc.UnmarshalBinary(data); err != nil {
+			return xerrors.Errorf("failed to unmarshal document from sqlite store: %w", err)
+		}
+
+		current := doc.Revision
+
+		if err := applyOperation(&doc, op, revision); err != nil {
+			return err
+		}
+
+		if data, err = doc.MarshalBinary(); err != nil {
+			return xerrors.Errorf("failed to set document in sqlite store: %w", err)
+		}
+
+		_, err = updateDocument(tx, key, current, &doc, data, ctx)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &doc, nil
+}
+
+func (s *SQLiteDataStore) GetDocument(key string, ctx context.Context) (*canvas.Canvas, error) {
+	var data []byte
+
+	err := s.db.QueryRowContext(ctx, `SELECT canvas FROM documents WHERE id = ?`, key).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, NotFound
+	}
+
+	if err != nil {
+		return nil, xerrors.Errorf("failed to retrieve document from sqlite store: %w", err)
+	}
+
+	doc := canvas.Canvas{}
+	if err := doc.UnmarshalBinary(data); err != nil {
+		return nil, xerrors.Errorf("failed to unmarshal document from sqlite store: %w", err)
+	}
+
+	return &doc, nil
+}
+
+func (s *SQLiteDataStore) DeleteDocument(key string, ctx context.Context) error {
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM documents WHERE id = ?`, key)
+		if err != nil {
+			return xerrors.Errorf("failed to delete document from sqlite store: %w", err)
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return xerrors.Errorf("failed to delete document from sqlite store: %w", err)
+		}
+
+		if n == 0 {
+			return NotFound
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM operations WHERE doc_id = ?`, key); err != nil {
+			return xerrors.Errorf("failed to delete operations from sqlite store: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM document_tags WHERE doc_id = ?`, key); err != nil {
+			return xerrors.Errorf("failed to delete tags from sqlite store: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// DeleteExpired deletes the expired documents with their operations and tags in a single transaction.
+func (s *SQLiteDataStore) DeleteExpired(now time.Time, ctx context.Context) (int, error) {
+	var deleted int64
+
+	err := s.transaction(ctx, func(tx *sql.Tx) error {
+		const expired = `SELECT id FROM documents WHERE expires_at > 0 AND expires_at <= ?`
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM operations WHERE doc_id IN (`+expired+`)`, now.UnixNano()); err != nil {
+			return xerrors.Errorf("failed to delete operations from sqlite store: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM document_tags WHERE doc_id IN (`+expired+`)`, now.UnixNano()); err != nil {
+			return xerrors.Errorf("failed to delete tags from sqlite store: %w", err)
+		}
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM documents WHERE expires_at > 0 AND expires_at <= ?`, now.UnixNano())
+		if err != nil {
+			return xerrors.Errorf("failed to delete documents from sqlite store: %w", err)
+		}
+
+		if deleted, err = res.RowsAffected(); err != nil {
+			return xerrors.Errorf("failed to delete documents from sqlite store: %w", err)
+		}
+
+		return nil
+	})
+
+	return int(deleted), err
+}
+
+// TrashDocument moves the serialized document to the trash table, replacing a previous version trashed under the same key.
+func (s *SQLiteDataStore) TrashDocument(key string, now time.Time, ctx context.Context) error {
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `
+			INSERT OR REPLACE INTO trash (id, deleted_at, canvas)
+			SELECT id, ?, canvas FROM documents WHERE id = ?`,
+			now.UnixNano(), key)
+		if err != nil {
+			return xerrors.Errorf("failed to move document to trash in sqlite store: %w", err)
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return xerrors.Errorf("failed to move document to trash in sqlite store: %w", err)
+		}
+
+		if n == 0 {
+			return NotFound
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM documents WHERE id = ?`, key); err != nil {
+			return xerrors.Errorf("failed to move document to trash in sqlite store: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM document_tags WHERE doc_id = ?`, key); err != nil {
+			return xerrors.Errorf("failed to delete tags from sqlite store: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// ListTrash decodes the trashed documents to return their metadata, the trash is expected to stay small.
+func (s *SQLiteDataStore) ListTrash(ctx context.Context) ([]*DocInfo, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, deleted_at, canvas FROM trash ORDER BY deleted_at DESC, id DESC`)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to retrieve trash from sqlite store: %w", err)
+	}
+	defer rows.Close()
+
+	infos := make([]*DocInfo, 0)
+
+	for rows.Next() {
+		var (
+			key     string
+			deleted int64
+			data    []byte
+			doc     canvas.Canvas
+		)
+
+		if err := rows.Scan(&key, &deleted, &data); err != nil {
+			return nil, xerrors.Errorf("failed to retrieve trash from sqlite store: %w", err)
+		}
+
+		if err := doc.UnmarshalBinary(data); err != nil {
+			return nil, xerrors.Errorf("failed to unmarshal document from sqlite store: %w", err)
+		}
+
+		info := newDocInfo(key, &doc)
+		info.Deleted = timeFromValue(deleted)
+		infos = append(infos, &info)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("failed to retrieve trash from sqlite store: %w", err)
+	}
+
+	return infos, nil
+}
+
+func (s *SQLiteDataStore) RestoreDocument(key string, now time.Time, ctx context.Context) (*canvas.Canvas, error) {
+	doc := canvas.Canvas{}
+
+	err := s.transaction(ctx, func(tx *sql.Tx) error {
+		var data []byte
+
+		err := tx.QueryRowContext(ctx, `SELECT canvas FROM trash WHERE id = ?`, key).Scan(&data)
+		if err == sql.ErrNoRows {
+			return NotFound
+		}
+
+		if err != nil {
+			return xerrors.Errorf("failed to retrieve document from sqlite store: %w", err)
+		}
+
+		var exists bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM documents WHERE id = ?)`, key).Scan(&exists); err != nil {
+			return xerrors.Errorf("failed to check document presence in sqlite store: %w", err)
+		}
+
+		if exists {
+			return Exists
+		}
+
+		if err := doc.UnmarshalBinary(data); err != nil {
+			return xerrors.Errorf("failed to unmarshal document from sqlite store: %w", err)
+		}
+
+		doc.Touch(now)
+
+		if err := setDocument(tx, key, &doc, ctx); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM trash WHERE id = ?`, key); err != nil {
+			return xerrors.Errorf("failed to restore document in sqlite store: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &doc, nil
+}
+
+func (s *SQLiteDataStore) PurgeDocument(key string, ctx context.Context) error {
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM trash WHERE id = ?`, key)
+		if err != nil {
+			return xerrors.Errorf("failed to purge document from sqlite store: %w", err)
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return xerrors.Errorf("failed to purge document from sqlite store: %w", err)
+		}
+
+		if n == 0 {
+			return NotFound
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM operations WHERE doc_id = ?`, key); err != nil {
+			return xerrors.Errorf("failed to delete operations from sqlite store: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// PurgeTrash deletes the trashed documents with their operations in a single transaction.
+func (s *SQLiteDataStore) PurgeTrash(before time.Time, ctx context.Context) (int, error) {
+	var deleted int64
+
+	err := s.transaction(ctx, func(tx *sql.Tx) error {
+		const trashed = `SELECT id FROM trash WHERE deleted_at < ?`
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM operations WHERE doc_id IN (`+trashed+`)`, before.UnixNano()); err != nil {
+			return xerrors.Errorf("failed to delete operations from sqlite store: %w", err)
+		}
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM trash WHERE deleted_at < ?`, before.UnixNano())
+		if err != nil {
+			return xerrors.Errorf("failed to purge documents from sqlite store: %w", err)
+		}
+
+		if deleted, err = res.RowsAffected(); err != nil {
+			return xerrors.Errorf("failed to purge documents from sqlite store: %w", err)
+		}
+
+		return nil
+	})
+
+	return int(deleted), err
+}
+
+func (s *SQLiteDataStore) AddOperation(key string, op *canvas.Operation, ctx context.Context) error {
+	data, err := op.MarshalBinary()
+	if err != nil {
+		return xerrors.Errorf("failed to add operation in sqlite store: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, `INSERT INTO operations (doc_id, data) VALUES (?, ?)`, key, data); err != nil {
+		return xerrors.Errorf("failed to add operation in sqlite store: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteDataStore) GetOperations(key string, ctx context.Context) ([]*canvas.Operation, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT data FROM operations WHERE doc_id = ? ORDER BY seq`, key)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to retrieve operations from sqlite store: %w", err)
+	}
+	defer rows.Close()
+
+	ops := make([]*canvas.Operation, 0)
+
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, xerrors.Errorf("failed to retrieve operations from sqlite store: %w", err)
+		}
+
+		op, err := decodeSQLiteOperation(data)
+		if err != nil {
+			return nil, err
+		}
+
+		ops = append(ops, op)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("failed to retrieve operations from sqlite store: %w", err)
+	}
+
+	return ops, nil
+}
+
+// GetOperationsFrom reads the journal backwards, down to the first operation needed.
+func (s *SQLiteDataStore) GetOperationsFrom(key string, revision uint64, ctx context.Context) ([]*canvas.Operation, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT data FROM operations WHERE doc_id = ? ORDER BY seq DESC`, key)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to retrieve operations from sqlite store: %w", err)
+	}
+	defer rows.Close()
+
+	scan := journalScan{revision: revision}
+	ops := make([]*canvas.Operation, 0)
+
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, xerrors.Errorf("failed to retrieve operations from sqlite store: %w", err)
+		}
+
+		op, err := decodeSQLiteOperation(data)
+		if err != nil {
+			return nil, err
+		}
+
+		if !scan.prepend(op) {
+			break
+		}
+
+		ops = append(ops, op)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("failed to retrieve operations from sqlite store: %w", err)
+	}
+
+	reverseOperations(ops)
+
+	return ops, nil
+}
+
+// TrimOperations deletes the first operations and updates the references of the operations kept in a single transaction.
+func (s *SQLiteDataStore) TrimOperations(key string, revision uint64, ctx context.Context) (int, error) {
+	var n int
+
+	err := s.transaction(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `SELECT seq, data FROM operations WHERE doc_id = ? ORDER BY seq`, key)
+		if err != nil {
+			return xerrors.Errorf("failed to retrieve operations from sqlite store: %w", err)
+		}
+		defer rows.Close()
+
+		var (
+			seqs []int64
+			ops  []*canvas.Operation
+		)
+
+		for rows.Next() {
+			var (
+				seq  int64
+				data []byte
+			)
+
+			if err := rows.Scan(&seq, &data); err != nil {
+				return xerrors.Errorf("failed to retrieve operations from sqlite store: %w", err)
+			}
+
+			op, err := decodeSQLiteOperation(data)
+			if err != nil {
+				return err
+			}
+
+			seqs, ops = append(seqs, seq), append(ops, op)
+		}
+
+		if err := rows.Err(); err != nil {
+			return xerrors.Errorf("failed to retrieve operations from sqlite store: %w", err)
+		}
+
+		if n = trimmedLength(ops, revision); n == 0 {
+			return nil
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM operations WHERE doc_id = ? AND seq < ?`, key, seqs[n]); err != nil {
+			return xerrors.Errorf("failed to trim operations in sqlite store: %w", err)
+		}
+
+		for _, i := range shiftRefs(ops[n:], n) {
+			data, err := ops[n+i].MarshalBinary()
+			if err != nil {
+				return xerrors.Errorf("failed to trim operations in sqlite store: %w", err)
+			}
+
+			if _, err := tx.ExecContext(ctx, `UPDATE operations SET data = ? WHERE seq = ?`, data, seqs[n+i]); err != nil {
+				return xerrors.Errorf("failed to trim operations in sqlite store: %w", err)
+			}
+		}
+
+		return nil
+	})
+
+	return n, err
+}
+
+func decodeSQLiteOperation(data []byte) (*canvas.Operation, error) {
+	op := canvas.Operation{}
+	if err := json.Unmarshal(data, &op); err != nil {
+		return nil, xerrors.Errorf("failed to unmarshal operation from sqlite store: %w", err)
+	}
+
+	return &op, nil
+}
+
+// prefixEnd returns the smallest string greater than all the strings starting with prefix in byte order,
+// false if there is none.
+func prefixEnd(prefix string) (string, bool) {
+	end := []byte(prefix)
+
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+
+			return string(end[:i+1]), true
+		}
+	}
+
+	return "", false
+}
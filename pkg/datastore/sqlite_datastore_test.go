@@ -0,0 +1,177 @@
+package datastore
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+func newTestSQLite(t *testing.T) *SQLiteDataStore {
+	t.Helper()
+
+	s, err := NewSQLite(":memory:", context.TODO())
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = s.Close() })
+
+	return s
+}
+
+func TestNewSQLite(t *testing.T) {
+	ctx := context.TODO()
+
+	_, err := NewSQLite("", ctx)
+	assert.Error(t, err)
+
+	// Opening an existing database doesn't apply the migrations again.
+	path := filepath.Join(t.TempDir(), "store.db")
+
+	s, err := NewSQLite(path, ctx)
+	require.NoError(t, err)
+	require.NoError(t, s.SetDocument("123", &canvas.Canvas{Name: "doc"}, ctx))
+	require.NoError(t, s.Close())
+
+	s, err = NewSQLite(path, ctx)
+	require.NoError(t, err)
+
+	defer s.Close()
+
+	var version int
+	require.NoError(t, s.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version))
+	assert.Equal(t, len(sqliteMigrations), version)
+
+	doc, err := s.GetDocument("123", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "doc", doc.Name)
+}
+
+func TestSQLiteDataStore_Document(t *testing.T) {
+	ctx := context.TODO()
+	s := newTestSQLite(t)
+
+	_, err := s.GetDocument("123", ctx)
+	assert.Equal(t, NotFound, err)
+
+	doc := &canvas.Canvas{Name: "doc", Width: 3, Height: 1, Data: []byte("abc")}
+	require.NoError(t, s.SetDocument("123", doc, ctx))
+
+	var created, updated int64
+	require.NoError(t, s.db.QueryRow(`SELECT created_at, updated_at FROM documents WHERE id = ?`, "123").Scan(&created, &updated))
+	assert.Equal(t, created, updated)
+
+	doc.Name = "renamed"
+	doc.Width = 1
+	doc.Height = 3
+	require.NoError(t, s.SetDocument("123", doc, ctx))
+
+	got, err := s.GetDocument("123", ctx)
+	require.NoError(t, err)
+	assert.Equal(t, doc, got)
+
+	// The metadata columns follow the document, the creation time is kept.
+	var (
+		name          string
+		width, height uint
+		newCreated    int64
+	)
+	require.NoError(t, s.db.QueryRow(`SELECT name, width, height, created_at, updated_at FROM documents WHERE id = ?`, "123").Scan(&name, &width, &height, &newCreated, &updated))
+	assert.Equal(t, "renamed", name)
+	assert.Equal(t, uint(1), width)
+	assert.Equal(t, uint(3), height)
+	assert.Equal(t, created, newCreated)
+	assert.GreaterOrEqual(t, updated, created)
+
+	size, err := s.GetSize(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), size)
+
+	require.NoError(t, s.DeleteDocument("123", ctx))
+	assert.Equal(t, NotFound, s.DeleteDocument("123", ctx))
+
+	_, err = s.GetDocument("123", ctx)
+	assert.Equal(t, NotFound, err)
+}
+
+func TestSQLiteDataStore_ListDocuments(t *testing.T) {
+	testListDocuments(t, newTestSQLite(t))
+}
+
+func TestSQLiteDataStore_ListDocuments_NamePrefix(t *testing.T) {
+	ctx := context.TODO()
+	s := newTestSQLite(t)
+
+	for i, name := range []string{"plan", "plan b", "planz", "Plan", "plao", "pla", "été", "étéa", "étf"} {
+		require.NoError(t, s.SetDocument(string(rune('a'+i)), &canvas.Canvas{Name: name, Width: 1, Height: 1}, ctx))
+	}
+
+	keys, _ := listKeys(t, s, ListQuery{NamePrefix: "plan"})
+	assert.Equal(t, []string{"a", "b", "c"}, keys)
+
+	keys, _ = listKeys(t, s, ListQuery{NamePrefix: "été"})
+	assert.Equal(t, []string{"g", "h"}, keys)
+}
+
+func TestPrefixEnd(t *testing.T) {
+	end, ok := prefixEnd("plan")
+	assert.True(t, ok)
+	assert.Equal(t, "plao", end)
+
+	end, ok = prefixEnd("a\xff\xff")
+	assert.True(t, ok)
+	assert.Equal(t, "b", end)
+
+	_, ok = prefixEnd("\xff")
+	assert.False(t, ok)
+}
+func TestSQLiteDataStore_Operations(t *testing.T) {
+	ctx := context.TODO()
+	s := newTestSQLite(t)
+
+	require.NoError(t, s.SetDocument("123", &canvas.Canvas{Width: 3, Height: 3}, ctx))
+	require.NoError(t, s.AddOperation("123", canvas.NewSnapshot(&canvas.Canvas{Width: 3, Height: 3}), ctx))
+	require.NoError(t, s.AddOperation("123", canvas.NewFill(canvas.Point{X: 1, Y: 1}, "#"), ctx))
+	require.NoError(t, s.AddOperation("456", canvas.NewFill(canvas.Point{X: 1, Y: 1}, "*"), ctx))
+
+	ops, err := s.GetOperations("123", ctx)
+	require.NoError(t, err)
+	require.Len(t, ops, 2)
+	assert.Equal(t, canvas.OpSnapshot, ops[0].Type)
+	assert.Equal(t, canvas.OpFill, ops[1].Type)
+
+	require.NoError(t, s.DeleteDocument("123", ctx))
+
+	ops, err = s.GetOperations("123", ctx)
+	require.NoError(t, err)
+	assert.Empty(t, ops)
+}
+
+func TestSQLiteDataStore_CompareAndSwapDocument(t *testing.T) {
+	s := newTestSQLite(t)
+
+	testCompareAndSwap(t, s)
+
+	var revision uint64
+	require.NoError(t, s.db.QueryRow(`SELECT revision FROM documents WHERE id = ?`, "123").Scan(&revision))
+	assert.Equal(t, uint64(2), revision)
+}
+
+func TestSQLiteDataStore_UpdateDocument(t *testing.T) {
+	testUpdateDocument(t, newTestSQLite(t))
+}
+
+func TestSQLiteDataStore_DeleteExpired(t *testing.T) {
+	testDeleteExpired(t, newTestSQLite(t), nil)
+}
+
+func TestSQLiteDataStore_Trash(t *testing.T) {
+	testTrash(t, newTestSQLite(t))
+}
+
+func TestSQLiteDataStore_Journal(t *testing.T) {
+	testJournal(t, newTestSQLite(t))
+}
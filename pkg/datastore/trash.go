@@ -0,0 +1,25 @@
+package datastore
+
+import (
+	"sort"
+	"time"
+)
+
+// sortTrash orders the documents of the trash, most recently deleted first.
+func sortTrash(infos []*DocInfo) {
+	sort.Slice(infos, func(i, j int) bool {
+		if !infos[i].Deleted.Equal(infos[j].Deleted) {
+			return infos[i].Deleted.After(infos[j].Deleted)
+		}
+
+		return infos[i].Key > infos[j].Key
+	})
+}
+
+// purged reports whether a document deleted at deleted is purged by PurgeTrash(before).
+func purged(deleted, before time.Time) bool {
+	return deleted.Before(before)
+}
+
+// DefaultPurgeDelay is the time the deleted documents stay in the trash when none is configured.
+const DefaultPurgeDelay = 30 * 24 * time.Hour
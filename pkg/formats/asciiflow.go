@@ -0,0 +1,68 @@
+package formats
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+const asciiflowVersion = 2
+
+// asciiflowDocument is the JSON serialization of a drawing layer by ASCIIFlow.
+// The text holds the drawing as lines, X and Y are the position of its top-left corner
+// on the infinite ASCIIFlow grid, which is irrelevant once imported.
+type asciiflowDocument struct {
+	Version int    `json:"version"`
+	X       int    `json:"x"`
+	Y       int    `json:"y"`
+	Text    string `json:"text"`
+}
+
+// asciiflowRunes maps the characters drawn by ASCIIFlow which are missing from code page 437
+// to their closest equivalent.
+var asciiflowRunes = strings.NewReplacer( //nolint:gochecknoglobals
+	"▶", "►",
+	"◀", "◄",
+	"╭", "┌",
+	"╮", "┐",
+	"╰", "└",
+	"╯", "┘",
+)
+
+// DecodeASCIIFlow reads a canvas from an ASCIIFlow JSON drawing.
+func DecodeASCIIFlow(r io.Reader) (*canvas.Canvas, error) {
+	var doc asciiflowDocument
+
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, xerrors.Errorf("failed to decode asciiflow content: %w", err)
+	}
+
+	if doc.Text == "" {
+		return nil, InvalidContent
+	}
+
+	c, err := canvas.FromText(strings.NewReader(asciiflowRunes.Replace(doc.Text)))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to decode asciiflow drawing: %w", err)
+	}
+
+	return c, nil
+}
+
+// EncodeASCIIFlow writes a canvas as an ASCIIFlow JSON drawing.
+func EncodeASCIIFlow(w io.Writer, c *canvas.Canvas) error {
+	doc := asciiflowDocument{
+		Version: asciiflowVersion,
+		Text:    strings.TrimSuffix(c.Text(), "\n"),
+	}
+
+	if err := json.NewEncoder(w).Encode(doc); err != nil {
+		return xerrors.Errorf("failed to encode asciiflow content: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package formats
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+func TestDecodeASCIIFlow(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected *canvas.Canvas
+		wantErr  bool
+	}{
+		{
+			name: "unicode",
+			data: `{"version":2,"x":10,"y":4,"text":"╭──╮\n│ab├─▶\n└──┘"}`,
+			expected: &canvas.Canvas{
+				Width:  6,
+				Height: 3,
+				Data:   []byte("\xda\xc4\xc4\xbf--\xb3ab\xc3\xc4\x10\xc0\xc4\xc4\xd9--"),
+			},
+		},
+		{
+			name: "ascii",
+			data: `{"version":2,"x":0,"y":0,"text":"+-+\n| |\n+-+"}`,
+			expected: &canvas.Canvas{
+				Width:  3,
+				Height: 3,
+				Data:   []byte("+-+| |+-+"),
+			},
+		},
+		{
+			name:    "no text",
+			data:    `{"version":2}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid",
+			data:    `+-+`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := DecodeASCIIFlow(strings.NewReader(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DecodeASCIIFlow() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.expected, c)
+		})
+	}
+}
+
+func TestEncodeASCIIFlow(t *testing.T) {
+	c := &canvas.Canvas{
+		Width:  3,
+		Height: 2,
+		Data:   []byte("\xda\xc4\xbf\xc0\xc4\xd9"),
+	}
+
+	buf := &bytes.Buffer{}
+	err := EncodeASCIIFlow(buf, c)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"version":2,"x":0,"y":0,"text":"┌─┐\n└─┘"}`+"\n", buf.String())
+
+	decoded, err := DecodeASCIIFlow(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, c, decoded)
+}
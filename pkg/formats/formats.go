@@ -0,0 +1,134 @@
+// Package formats converts canvases from and to the file formats of other drawing tools.
+//
+// Each format is identified by a short name, used in the `format` query parameter of the API,
+// and by the media type and file extension used to recognize uploaded content.
+package formats
+
+import (
+	"io"
+	"strings"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/ansi"
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+type Error string
+
+func (s Error) Error() string {
+	return string(s)
+}
+
+const InvalidContent = Error("invalid content")
+
+// DecodeFunc reads a canvas.
+type DecodeFunc func(r io.Reader) (*canvas.Canvas, error)
+
+// EncodeFunc writes a canvas.
+type EncodeFunc func(w io.Writer, c *canvas.Canvas) error
+
+// Format describes a file format.
+// Decode is nil for export-only formats, and Encode is nil for import-only formats.
+type Format struct {
+	Name       string
+	MediaType  string
+	Extensions []string
+	Decode     DecodeFunc
+	Encode     EncodeFunc
+}
+
+// CanDecode reports whether canvases can be imported from the format.
+func (f *Format) CanDecode() bool {
+	return f.Decode != nil
+}
+
+// CanEncode reports whether canvases can be exported to the format.
+func (f *Format) CanEncode() bool {
+	return f.Encode != nil
+}
+
+const (
+	Text      = "txt"
+	HTML      = "html"
+	ANSI      = "ans"
+	REXPaint  = "xp"
+	ASCIIFlow = "asciiflow"
+)
+
+var registry = []*Format{ //nolint:gochecknoglobals
+	{
+		Name:       Text,
+		MediaType:  "text/plain",
+		Extensions: []string{".txt", ".asc"},
+		Decode:     DecodeText,
+		Encode:     EncodeText,
+	},
+	{
+		Name:       HTML,
+		MediaType:  "text/html",
+		Extensions: []string{".html", ".htm"},
+		Encode:     func(w io.Writer, c *canvas.Canvas) error { return c.RenderHTML(w) },
+	},
+	{
+		Name:       ANSI,
+		MediaType:  "text/x-ansi",
+		Extensions: []string{".ans"},
+		Decode:     ansi.Decode,
+		Encode:     ansi.Encode,
+	},
+	{
+		Name:       REXPaint,
+		MediaType:  "application/x-rexpaint",
+		Extensions: []string{".xp"},
+		Decode:     DecodeXP,
+		Encode:     EncodeXP,
+	},
+	{
+		Name:       ASCIIFlow,
+		MediaType:  "application/x-asciiflow+json",
+		Extensions: []string{".asciiflow"},
+		Decode:     DecodeASCIIFlow,
+		Encode:     EncodeASCIIFlow,
+	},
+}
+
+// All returns the supported formats.
+func All() []*Format {
+	return registry
+}
+
+// Lookup returns the format with the given name.
+func Lookup(name string) (*Format, bool) {
+	for _, f := range registry {
+		if f.Name == name {
+			return f, true
+		}
+	}
+
+	return nil, false
+}
+
+// ByMediaType returns the format with the given media type.
+func ByMediaType(mediaType string) (*Format, bool) {
+	for _, f := range registry {
+		if f.MediaType == mediaType {
+			return f, true
+		}
+	}
+
+	return nil, false
+}
+
+// ByExtension returns the format of files with the given extension, including the leading dot.
+func ByExtension(ext string) (*Format, bool) {
+	ext = strings.ToLower(ext)
+
+	for _, f := range registry {
+		for _, e := range f.Extensions {
+			if e == ext {
+				return f, true
+			}
+		}
+	}
+
+	return nil, false
+}
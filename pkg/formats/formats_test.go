@@ -0,0 +1,36 @@
+package formats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookup(t *testing.T) {
+	f, ok := Lookup(REXPaint)
+	assert.True(t, ok)
+	assert.Equal(t, REXPaint, f.Name)
+
+	_, ok = Lookup("bmp")
+	assert.False(t, ok)
+}
+
+func TestByMediaType(t *testing.T) {
+	f, ok := ByMediaType("text/x-ansi")
+	assert.True(t, ok)
+	assert.Equal(t, ANSI, f.Name)
+
+	_, ok = ByMediaType("image/bmp")
+	assert.False(t, ok)
+}
+
+func TestByExtension(t *testing.T) {
+	f, ok := ByExtension(".XP")
+	assert.True(t, ok)
+	assert.Equal(t, REXPaint, f.Name)
+
+	f, ok = ByExtension(".html")
+	assert.True(t, ok)
+	assert.False(t, f.CanDecode())
+	assert.True(t, f.CanEncode())
+}
@@ -0,0 +1,43 @@
+package formats
+
+import (
+	"bytes"
+	"io"
+
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+	"github.com/hexbee-net/sketch-canvas/pkg/sauce"
+)
+
+// DecodeText reads a canvas from plain text lines.
+// Text files can have a SAUCE record too, only the title and author are relevant for them.
+func DecodeText(r io.Reader) (*canvas.Canvas, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read text content: %w", err)
+	}
+
+	rec, content := sauce.Split(data)
+
+	c, err := canvas.FromText(bytes.NewReader(content))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to decode text content: %w", err)
+	}
+
+	if rec != nil {
+		c.Name = rec.Title
+		c.Author = rec.Author
+	}
+
+	return c, nil
+}
+
+// EncodeText writes a canvas as UTF-8 text lines.
+func EncodeText(w io.Writer, c *canvas.Canvas) error {
+	if _, err := io.WriteString(w, c.Text()); err != nil {
+		return xerrors.Errorf("failed to write text content: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package formats
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"image/color"
+	"io"
+
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+// REXPaint .xp files are gzip-compressed and made of little-endian fields:
+//
+//	int32 version
+//	int32 number of layers
+//	for each layer:
+//		int32 width
+//		int32 height
+//		width*height cells, in column-major order:
+//			int32 code page 437 character
+//			uint8 foreground red, green, blue
+//			uint8 background red, green, blue
+//
+// Cells with the magenta background (255, 0, 255) are transparent and let the lower layers show through.
+
+const (
+	xpVersion   = -1
+	xpCellSize  = 10
+	xpMaxLayers = 9
+
+	// MaxCells is the maximum number of cells of an imported canvas.
+	MaxCells = 1 << 22
+)
+
+var xpTransparent = color.RGBA{R: 0xff, G: 0x00, B: 0xff, A: 0xff} //nolint:gochecknoglobals
+
+// DecodeXP reads a canvas from a REXPaint image, flattening its layers.
+func DecodeXP(r io.Reader) (*canvas.Canvas, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to decompress xp content: %w", err)
+	}
+
+	defer func() { _ = gz.Close() }()
+
+	var header struct {
+		Version int32
+		Layers  int32
+	}
+
+	if err := binary.Read(gz, binary.LittleEndian, &header); err != nil {
+		return nil, xerrors.Errorf("failed to read xp header: %w", err)
+	}
+
+	if header.Layers <= 0 || header.Layers > xpMaxLayers {
+		return nil, InvalidContent
+	}
+
+	var c *canvas.Canvas
+
+	for l := int32(0); l < header.Layers; l++ {
+		var size struct {
+			Width  int32
+			Height int32
+		}
+
+		if err := binary.Read(gz, binary.LittleEndian, &size); err != nil {
+			return nil, xerrors.Errorf("failed to read xp layer size: %w", err)
+		}
+
+		if c == nil {
+			if size.Width <= 0 || size.Height <= 0 || int64(size.Width)*int64(size.Height) > MaxCells {
+				return nil, InvalidContent
+			}
+
+			c = blankCanvas(uint(size.Width), uint(size.Height))
+		} else if uint(size.Width) != c.Width || uint(size.Height) != c.Height {
+			return nil, InvalidContent
+		}
+
+		cells := make([]byte, int(size.Width)*int(size.Height)*xpCellSize)
+		if _, err := io.ReadFull(gz, cells); err != nil {
+			return nil, xerrors.Errorf("failed to read xp layer: %w", err)
+		}
+
+		for i := 0; i < len(cells)/xpCellSize; i++ {
+			cell := cells[i*xpCellSize : (i+1)*xpCellSize]
+
+			fg := color.RGBA{R: cell[4], G: cell[5], B: cell[6], A: 0xff}
+			bg := color.RGBA{R: cell[7], G: cell[8], B: cell[9], A: 0xff}
+
+			if bg == xpTransparent {
+				continue
+			}
+
+			char := byte('?')
+			if code := binary.LittleEndian.Uint32(cell[0:4]); code <= 0xff {
+				char = byte(code)
+			}
+
+			offset := uint(i%int(size.Height))*c.Width + uint(i/int(size.Height))
+			c.Data[offset] = char
+			c.Attrs[offset] = canvas.Attr(nearestColor(fg), nearestColor(bg))
+		}
+	}
+
+	dropDefaultAttrs(c)
+
+	return c, nil
+}
+
+// EncodeXP writes a canvas as a single layer REXPaint image.
+func EncodeXP(w io.Writer, c *canvas.Canvas) error {
+	gz := gzip.NewWriter(w)
+	buf := bufio.NewWriter(gz)
+
+	header := []int32{xpVersion, 1, int32(c.Width), int32(c.Height)}
+	if err := binary.Write(buf, binary.LittleEndian, header); err != nil {
+		return xerrors.Errorf("failed to write xp header: %w", err)
+	}
+
+	lines := c.Split()
+	cell := make([]byte, xpCellSize)
+
+	for x := uint(0); x < c.Width; x++ {
+		for y := uint(0); y < c.Height; y++ {
+			fg, bg := canvas.AttrColors(c.GetAttr(x, y))
+			fgColor, bgColor := canvas.Palette[fg], canvas.Palette[bg]
+
+			binary.LittleEndian.PutUint32(cell[0:4], uint32(lines[y][x]))
+			cell[4], cell[5], cell[6] = fgColor.R, fgColor.G, fgColor.B
+			cell[7], cell[8], cell[9] = bgColor.R, bgColor.G, bgColor.B
+
+			_, _ = buf.Write(cell)
+		}
+	}
+
+	if err := buf.Flush(); err != nil {
+		return xerrors.Errorf("failed to write xp content: %w", err)
+	}
+
+	if err := gz.Close(); err != nil {
+		return xerrors.Errorf("failed to compress xp content: %w", err)
+	}
+
+	return nil
+}
+
+// blankCanvas returns a canvas filled with spaces, with a color layer.
+func blankCanvas(width, height uint) *canvas.Canvas {
+	c := &canvas.Canvas{
+		Width:  width,
+		Height: height,
+		Data:   make([]byte, width*height),
+		Attrs:  make([]byte, width*height),
+	}
+
+	for i := range c.Data {
+		c.Data[i] = ' '
+		c.Attrs[i] = canvas.DefaultAttr
+	}
+
+	return c
+}
+
+// dropDefaultAttrs removes the color layer of a canvas if all its cells have the default colors.
+func dropDefaultAttrs(c *canvas.Canvas) {
+	for _, a := range c.Attrs {
+		if a != canvas.DefaultAttr {
+			return
+		}
+	}
+
+	c.Attrs = nil
+}
+
+// nearestColor returns the index of the palette color closest to c.
+func nearestColor(c color.RGBA) byte {
+	var (
+		best     byte
+		bestDist = -1
+	)
+
+	for i, p := range canvas.Palette {
+		dr, dg, db := int(c.R)-int(p.R), int(c.G)-int(p.G), int(c.B)-int(p.B)
+
+		if dist := dr*dr + dg*dg + db*db; bestDist < 0 || dist < bestDist {
+			best, bestDist = byte(i), dist
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,108 @@
+package formats
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+type xpCell struct {
+	code   int32
+	fg, bg [3]byte
+}
+
+func xpImage(t *testing.T, width, height int32, layers ...[]xpCell) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+
+	_ = binary.Write(gz, binary.LittleEndian, []int32{xpVersion, int32(len(layers))})
+
+	for _, cells := range layers {
+		_ = binary.Write(gz, binary.LittleEndian, []int32{width, height})
+
+		for _, c := range cells {
+			_ = binary.Write(gz, binary.LittleEndian, c.code)
+			_, _ = gz.Write(c.fg[:])
+			_, _ = gz.Write(c.bg[:])
+		}
+	}
+
+	_ = gz.Close()
+
+	return buf.Bytes()
+}
+
+func TestDecodeXP(t *testing.T) {
+	white := [3]byte{0xff, 0xff, 0xff}
+	gray := [3]byte{0xaa, 0xaa, 0xaa}
+	black := [3]byte{0, 0, 0}
+	red := [3]byte{0xb0, 0x10, 0x10}
+	transparent := [3]byte{0xff, 0x00, 0xff}
+
+	t.Run("flattened layers", func(t *testing.T) {
+		// 2x2 image, cells in column-major order.
+		data := xpImage(t, 2, 2,
+			[]xpCell{{'a', gray, black}, {'c', gray, black}, {'b', gray, black}, {'d', gray, black}},
+			[]xpCell{{'X', white, red}, {0, black, transparent}, {0, black, transparent}, {0xdb, gray, black}},
+		)
+
+		c, err := DecodeXP(bytes.NewReader(data))
+		assert.NoError(t, err)
+		assert.Equal(t, &canvas.Canvas{
+			Width:  2,
+			Height: 2,
+			Data:   []byte("Xbc\xdb"),
+			Attrs:  []byte{canvas.Attr(15, 4), canvas.DefaultAttr, canvas.DefaultAttr, canvas.DefaultAttr},
+		}, c)
+	})
+
+	t.Run("no colors", func(t *testing.T) {
+		data := xpImage(t, 1, 1, []xpCell{{'a', gray, black}})
+
+		c, err := DecodeXP(bytes.NewReader(data))
+		assert.NoError(t, err)
+		assert.Nil(t, c.Attrs)
+	})
+
+	t.Run("mismatched layers", func(t *testing.T) {
+		raw := bytes.Buffer{}
+		gz := gzip.NewWriter(&raw)
+		_ = binary.Write(gz, binary.LittleEndian, []int32{xpVersion, 2, 1, 1})
+		_, _ = gz.Write(make([]byte, xpCellSize))
+		_ = binary.Write(gz, binary.LittleEndian, []int32{2, 1})
+		_, _ = gz.Write(make([]byte, 2*xpCellSize))
+		_ = gz.Close()
+
+		_, err := DecodeXP(bytes.NewReader(raw.Bytes()))
+		assert.ErrorIs(t, err, InvalidContent)
+	})
+
+	t.Run("not gzip", func(t *testing.T) {
+		_, err := DecodeXP(bytes.NewReader([]byte("invalid")))
+		assert.Error(t, err)
+	})
+}
+
+func TestEncodeXP(t *testing.T) {
+	c := &canvas.Canvas{
+		Width:  3,
+		Height: 2,
+		Data:   []byte("ab\xdbcd "),
+		Attrs:  []byte{canvas.DefaultAttr, canvas.Attr(12, 1), canvas.Attr(12, 1), canvas.Attr(0, 15), canvas.DefaultAttr, canvas.DefaultAttr},
+	}
+
+	buf := &bytes.Buffer{}
+	err := EncodeXP(buf, c)
+	assert.NoError(t, err)
+
+	decoded, err := DecodeXP(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, c, decoded)
+}
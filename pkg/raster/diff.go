@@ -0,0 +1,55 @@
+package raster
+
+import (
+	"image"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+// Colors of the diff overlays, as palette indexes.
+const (
+	changedBackground = 14 // yellow
+	regionOutline     = 12 // light red
+)
+
+// RenderDiff returns the image of the canvas a diff leads to, with the background of the changed cells highlighted
+// and the changed regions outlined. The cells outside of this canvas, removed by the change, are left blank.
+func RenderDiff(d *canvas.Diff) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, int(d.Width)*CellWidth, int(d.Height)*CellHeight), Palette)
+	lines := d.To.Split()
+
+	for y := uint(0); y < d.Height; y++ {
+		for x := uint(0); x < d.Width; x++ {
+			p := image.Pt(int(x)*CellWidth, int(y)*CellHeight)
+
+			if x >= d.To.Width || y >= d.To.Height {
+				fill(img, image.Rect(p.X, p.Y, p.X+CellWidth, p.Y+CellHeight), changedBackground)
+
+				continue
+			}
+
+			fg, bg := Colors(d.To, x, y)
+			if d.IsChanged(x, y) {
+				bg = changedBackground
+			}
+
+			DrawCell(img, p, lines[y][x], fg, bg)
+		}
+	}
+
+	for _, r := range d.Regions {
+		outline(img, CellBounds(image.Rect(
+			int(r.Origin.X), int(r.Origin.Y), int(r.Origin.X+r.Width), int(r.Origin.Y+r.Height),
+		)), regionOutline)
+	}
+
+	return img
+}
+
+// outline draws the one pixel border of a rectangle.
+func outline(img *image.Paletted, r image.Rectangle, index uint8) {
+	fill(img, image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+1), index)
+	fill(img, image.Rect(r.Min.X, r.Max.Y-1, r.Max.X, r.Max.Y), index)
+	fill(img, image.Rect(r.Min.X, r.Min.Y, r.Min.X+1, r.Max.Y), index)
+	fill(img, image.Rect(r.Max.X-1, r.Min.Y, r.Max.X, r.Max.Y), index)
+}
@@ -0,0 +1,170 @@
+package raster
+
+import (
+	"image"
+)
+
+// boxLine is the weight of a box drawing line: none, single or double.
+type boxLine uint8
+
+const (
+	none boxLine = iota
+	single
+	double
+)
+
+// boxChar holds the lines of a box drawing character, going from the center of the cell to its edges.
+type boxChar struct {
+	up, right, down, left boxLine
+}
+
+// boxChars lists the box drawing characters of code page 437.
+var boxChars = map[rune]boxChar{ //nolint:gochecknoglobals
+	'─': {none, single, none, single},
+	'│': {single, none, single, none},
+	'┌': {none, single, single, none},
+	'┐': {none, none, single, single},
+	'└': {single, single, none, none},
+	'┘': {single, none, none, single},
+	'├': {single, single, single, none},
+	'┤': {single, none, single, single},
+	'┬': {none, single, single, single},
+	'┴': {single, single, none, single},
+	'┼': {single, single, single, single},
+	'═': {none, double, none, double},
+	'║': {double, none, double, none},
+	'╒': {none, double, single, none},
+	'╓': {none, single, double, none},
+	'╔': {none, double, double, none},
+	'╕': {none, none, single, double},
+	'╖': {none, none, double, single},
+	'╗': {none, none, double, double},
+	'╘': {single, double, none, none},
+	'╙': {double, single, none, none},
+	'╚': {double, double, none, none},
+	'╛': {single, none, none, double},
+	'╜': {double, none, none, single},
+	'╝': {double, none, none, double},
+	'╞': {single, double, single, none},
+	'╟': {double, single, double, none},
+	'╠': {double, double, double, none},
+	'╡': {single, none, single, double},
+	'╢': {double, none, double, single},
+	'╣': {double, none, double, double},
+	'╤': {none, double, single, double},
+	'╥': {none, single, double, single},
+	'╦': {none, double, double, double},
+	'╧': {single, double, none, double},
+	'╨': {double, single, none, single},
+	'╩': {double, double, none, double},
+	'╪': {single, double, single, double},
+	'╫': {double, single, double, single},
+	'╬': {double, double, double, double},
+}
+
+// drawBox draws a box drawing character and reports whether r is one.
+// Double lines are drawn as two parallel lines around the center of the cell.
+func drawBox(img *image.Paletted, p image.Point, r rune, fg uint8) bool {
+	box, ok := boxChars[r]
+	if !ok {
+		return false
+	}
+
+	cx, cy := p.X+CellWidth/2, p.Y+CellHeight/2
+
+	vertical := func(l boxLine, y0, y1 int) {
+		switch l {
+		case single:
+			fill(img, image.Rect(cx, y0, cx+1, y1), fg)
+		case double:
+			fill(img, image.Rect(cx-1, y0, cx, y1), fg)
+			fill(img, image.Rect(cx+1, y0, cx+2, y1), fg)
+		case none:
+		}
+	}
+
+	horizontal := func(l boxLine, x0, x1 int) {
+		switch l {
+		case single:
+			fill(img, image.Rect(x0, cy, x1, cy+1), fg)
+		case double:
+			fill(img, image.Rect(x0, cy-1, x1, cy), fg)
+			fill(img, image.Rect(x0, cy+1, x1, cy+2), fg)
+		case none:
+		}
+	}
+
+	vertical(box.up, p.Y, cy+1)
+	vertical(box.down, cy, p.Y+CellHeight)
+	horizontal(box.left, p.X, cx+1)
+	horizontal(box.right, cx, p.X+CellWidth)
+
+	return true
+}
+
+// drawBlock draws a block or shade character and reports whether r is one.
+func drawBlock(img *image.Paletted, p image.Point, r rune, fg uint8) bool {
+	cell := image.Rect(p.X, p.Y, p.X+CellWidth, p.Y+CellHeight)
+
+	switch r {
+	case '█':
+		fill(img, cell, fg)
+	case '▀':
+		fill(img, image.Rect(p.X, p.Y, p.X+CellWidth, p.Y+CellHeight/2), fg)
+	case '▄':
+		fill(img, image.Rect(p.X, p.Y+CellHeight/2, p.X+CellWidth, p.Y+CellHeight), fg)
+	case '▌':
+		fill(img, image.Rect(p.X, p.Y, p.X+CellWidth/2, p.Y+CellHeight), fg)
+	case '▐':
+		fill(img, image.Rect(p.X+CellWidth/2, p.Y, p.X+CellWidth, p.Y+CellHeight), fg)
+	case '■':
+		fill(img, image.Rect(p.X+1, p.Y+3, p.X+CellWidth-1, p.Y+CellHeight-4), fg)
+	case '░', '▒', '▓':
+		// Shades are ordered dither patterns covering a quarter, half or three quarters of the cell.
+		level := map[rune]int{'░': 1, '▒': 2, '▓': 3}[r]
+		threshold := [2][2]int{{0, 2}, {3, 1}}
+
+		for y := cell.Min.Y; y < cell.Max.Y; y++ {
+			for x := cell.Min.X; x < cell.Max.X; x++ {
+				if threshold[y%2][x%2] < level {
+					img.SetColorIndex(x, y, fg)
+				}
+			}
+		}
+	default:
+		return false
+	}
+
+	return true
+}
+
+// drawArrow draws a triangle arrow head and reports whether r is one.
+func drawArrow(img *image.Paletted, p image.Point, r rune, fg uint8) bool {
+	const (
+		half = CellWidth / 2
+		top  = CellHeight/2 - half
+	)
+
+	switch r {
+	case '►', '▶':
+		for i := 0; i <= half; i++ {
+			fill(img, image.Rect(p.X+1+i, p.Y+top+i, p.X+2+i, p.Y+top+2*half-i+1), fg)
+		}
+	case '◄', '◀':
+		for i := 0; i <= half; i++ {
+			fill(img, image.Rect(p.X+CellWidth-2-i, p.Y+top+i, p.X+CellWidth-1-i, p.Y+top+2*half-i+1), fg)
+		}
+	case '▲':
+		for i := 0; i <= half; i++ {
+			fill(img, image.Rect(p.X+half-i, p.Y+top+i*2, p.X+half+i+1, p.Y+top+i*2+2), fg)
+		}
+	case '▼':
+		for i := 0; i <= half; i++ {
+			fill(img, image.Rect(p.X+half-i, p.Y+top+2*half-i*2, p.X+half+i+1, p.Y+top+2*half-i*2+2), fg)
+		}
+	default:
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,125 @@
+// Package raster renders canvases to paletted images.
+//
+// Every cell is drawn in a fixed size box with the 7x13 bitmap font for text characters.
+// Box drawing, block and arrow characters, common in diagrams but missing from the font,
+// are drawn procedurally so that lines join across cells.
+package raster
+
+import (
+	"image"
+	"image/color"
+
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+// Size of a cell in pixels.
+const (
+	CellWidth  = 7
+	CellHeight = 13
+)
+
+// Colors of the cells of canvases without a color layer, as palette indexes.
+const (
+	plainForeground = 0  // black
+	plainBackground = 15 // white
+)
+
+// Palette holds the colors of the rendered images, the palette of the canvas attributes.
+var Palette = func() color.Palette { //nolint:gochecknoglobals
+	p := make(color.Palette, len(canvas.Palette))
+	for i, c := range canvas.Palette {
+		p[i] = c
+	}
+
+	return p
+}()
+
+// Bounds returns the size in pixels of the rendering of a canvas.
+func Bounds(c *canvas.Canvas) image.Rectangle {
+	return image.Rect(0, 0, int(c.Width)*CellWidth, int(c.Height)*CellHeight)
+}
+
+// CellBounds returns the area of the rendering covered by the given cells.
+func CellBounds(cells image.Rectangle) image.Rectangle {
+	return image.Rect(cells.Min.X*CellWidth, cells.Min.Y*CellHeight, cells.Max.X*CellWidth, cells.Max.Y*CellHeight)
+}
+
+// Render returns the image of a canvas.
+func Render(c *canvas.Canvas) *image.Paletted {
+	img := image.NewPaletted(Bounds(c), Palette)
+	Draw(img, c, image.Rect(0, 0, int(c.Width), int(c.Height)))
+
+	return img
+}
+
+// Draw renders the given cells of a canvas on an image at their position.
+func Draw(img *image.Paletted, c *canvas.Canvas, cells image.Rectangle) {
+	lines := c.Split()
+
+	for y := cells.Min.Y; y < cells.Max.Y; y++ {
+		for x := cells.Min.X; x < cells.Max.X; x++ {
+			fg, bg := Colors(c, uint(x), uint(y))
+			DrawCell(img, image.Pt(x*CellWidth, y*CellHeight), lines[y][x], fg, bg)
+		}
+	}
+}
+
+// Colors returns the palette indexes of the foreground and background of a cell.
+func Colors(c *canvas.Canvas, x, y uint) (fg, bg uint8) {
+	if !c.HasAttrs() {
+		return plainForeground, plainBackground
+	}
+
+	return canvas.AttrColors(c.GetAttr(x, y))
+}
+
+// DrawCell draws a single cell with its top-left corner at p.
+func DrawCell(img *image.Paletted, p image.Point, char byte, fg, bg uint8) {
+	fill(img, image.Rect(p.X, p.Y, p.X+CellWidth, p.Y+CellHeight), bg)
+
+	r := canvas.DecodeRune(char)
+
+	switch {
+	case r == 0 || r == ' ' || r == ' ':
+		return
+	case drawBox(img, p, r, fg):
+		return
+	case drawBlock(img, p, r, fg):
+		return
+	case drawArrow(img, p, r, fg):
+		return
+	default:
+		drawGlyph(img, p, r, fg)
+	}
+}
+
+func drawGlyph(img *image.Paletted, p image.Point, r rune, fg uint8) {
+	face := basicfont.Face7x13
+	dot := fixed.P(p.X, p.Y+face.Ascent)
+
+	dr, mask, mp, _, ok := face.Glyph(dot, r)
+	if !ok {
+		return
+	}
+
+	for y := dr.Min.Y; y < dr.Max.Y; y++ {
+		for x := dr.Min.X; x < dr.Max.X; x++ {
+			if _, _, _, a := mask.At(mp.X+x-dr.Min.X, mp.Y+y-dr.Min.Y).RGBA(); a != 0 {
+				img.SetColorIndex(x, y, fg)
+			}
+		}
+	}
+}
+
+func fill(img *image.Paletted, r image.Rectangle, index uint8) {
+	r = r.Intersect(img.Rect)
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetColorIndex(x, y, index)
+		}
+	}
+}
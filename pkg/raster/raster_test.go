@@ -0,0 +1,107 @@
+package raster
+
+import (
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+func TestRender(t *testing.T) {
+	c := &canvas.Canvas{
+		Width:  3,
+		Height: 1,
+		Data:   []byte(" \xdbA"),
+	}
+
+	img := Render(c)
+	assert.Equal(t, image.Rect(0, 0, 3*CellWidth, CellHeight), img.Bounds())
+
+	// Blank cells are filled with the background, full blocks with the foreground.
+	assert.Equal(t, uint8(plainBackground), img.ColorIndexAt(CellWidth/2, CellHeight/2))
+	assert.Equal(t, uint8(plainForeground), img.ColorIndexAt(CellWidth+CellWidth/2, CellHeight/2))
+
+	// Text characters use the font.
+	inked := 0
+
+	for y := 0; y < CellHeight; y++ {
+		for x := 2 * CellWidth; x < 3*CellWidth; x++ {
+			if img.ColorIndexAt(x, y) == plainForeground {
+				inked++
+			}
+		}
+	}
+
+	assert.Greater(t, inked, 0)
+	assert.Less(t, inked, CellWidth*CellHeight)
+}
+
+func TestRender_Attrs(t *testing.T) {
+	c := &canvas.Canvas{
+		Width:  1,
+		Height: 1,
+		Data:   []byte(" "),
+		Attrs:  []byte{canvas.Attr(14, 1)},
+	}
+
+	img := Render(c)
+	assert.Equal(t, uint8(1), img.ColorIndexAt(0, 0))
+}
+
+func TestRender_BoxDrawing(t *testing.T) {
+	// ┌─ on the first line, │ below the corner: the lines join across cells.
+	c := &canvas.Canvas{
+		Width:  2,
+		Height: 2,
+		Data:   []byte("\xda\xc4\xb3 "),
+	}
+
+	img := Render(c)
+	cx, cy := CellWidth/2, CellHeight/2
+
+	assert.Equal(t, uint8(plainForeground), img.ColorIndexAt(CellWidth-1, cy), "corner reaches the right edge")
+	assert.Equal(t, uint8(plainForeground), img.ColorIndexAt(CellWidth, cy), "line starts at the left edge")
+	assert.Equal(t, uint8(plainForeground), img.ColorIndexAt(cx, CellHeight-1), "corner reaches the bottom edge")
+	assert.Equal(t, uint8(plainForeground), img.ColorIndexAt(cx, CellHeight), "vertical line starts at the top edge")
+	assert.Equal(t, uint8(plainBackground), img.ColorIndexAt(cx, 0), "corner doesn't go up")
+	assert.Equal(t, uint8(plainBackground), img.ColorIndexAt(0, cy), "corner doesn't go left")
+}
+
+func TestDraw_Region(t *testing.T) {
+	c := &canvas.Canvas{
+		Width:  4,
+		Height: 2,
+		Data:   []byte("\xdb\xdb\xdb\xdb\xdb\xdb\xdb\xdb"),
+	}
+
+	cells := image.Rect(1, 1, 3, 2)
+	img := image.NewPaletted(CellBounds(cells), Palette)
+	Draw(img, c, cells)
+
+	assert.Equal(t, image.Rect(CellWidth, CellHeight, 3*CellWidth, 2*CellHeight), img.Bounds())
+	assert.Equal(t, uint8(plainForeground), img.ColorIndexAt(CellWidth, CellHeight))
+}
+
+func TestRenderDiff(t *testing.T) {
+	from := &canvas.Canvas{Width: 3, Height: 2, Data: []byte("------")}
+	to := &canvas.Canvas{Width: 2, Height: 2, Data: []byte("-#--")}
+
+	d, err := canvas.Compare(from, to)
+	require.NoError(t, err)
+
+	img := RenderDiff(d)
+	assert.Equal(t, image.Rect(0, 0, 3*CellWidth, 2*CellHeight), img.Bounds())
+
+	// Unchanged cells are rendered as is.
+	assert.Equal(t, uint8(plainBackground), img.ColorIndexAt(0, CellHeight+1))
+
+	// Changed cells are highlighted, and their regions outlined.
+	assert.Equal(t, uint8(regionOutline), img.ColorIndexAt(CellWidth, 0))
+	assert.Equal(t, uint8(changedBackground), img.ColorIndexAt(CellWidth+1, 1))
+
+	// Removed cells are blank.
+	assert.Equal(t, uint8(changedBackground), img.ColorIndexAt(2*CellWidth+CellWidth/2, CellHeight/2))
+}
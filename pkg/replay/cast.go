@@ -0,0 +1,78 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/ansi"
+)
+
+const (
+	castVersion = 2
+	castOutput  = "o"
+
+	// clearScreen moves the cursor home and erases the screen before each frame.
+	clearScreen = "\x1b[H\x1b[2J"
+)
+
+// castHeader is the first line of an asciicast v2 recording.
+//
+// See https://github.com/asciinema/asciinema/blob/develop/doc/asciicast-v2.md for the format.
+type castHeader struct {
+	Version   int    `json:"version"`
+	Width     uint   `json:"width"`
+	Height    uint   `json:"height"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Title     string `json:"title,omitempty"`
+}
+
+// EncodeCast writes the frames as an asciicast v2 recording, one output event per frame every delay.
+// Each event redraws the whole screen.
+func EncodeCast(w io.Writer, frames []Frame, delay time.Duration, title string) error {
+	buf := bufio.NewWriter(w)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+
+	width, height := size(frames)
+	header := castHeader{
+		Version: castVersion,
+		Width:   width,
+		Height:  height,
+		Title:   title,
+	}
+
+	if len(frames) > 0 && !frames[0].Time.IsZero() {
+		header.Timestamp = frames[0].Time.Unix()
+	}
+
+	if err := enc.Encode(header); err != nil {
+		return xerrors.Errorf("failed to write cast header: %w", err)
+	}
+
+	screen := &bytes.Buffer{}
+
+	for i, f := range frames {
+		screen.Reset()
+		screen.WriteString(clearScreen)
+
+		if err := ansi.EncodeUTF8(screen, f.Canvas); err != nil {
+			return xerrors.Errorf("failed to render frame: %w", err)
+		}
+
+		event := []interface{}{(time.Duration(i) * delay).Seconds(), castOutput, screen.String()}
+		if err := enc.Encode(event); err != nil {
+			return xerrors.Errorf("failed to write cast event: %w", err)
+		}
+	}
+
+	if err := buf.Flush(); err != nil {
+		return xerrors.Errorf("failed to write cast: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package replay
+
+import (
+	"image"
+	"image/gif"
+	"io"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+	"github.com/hexbee-net/sketch-canvas/pkg/raster"
+)
+
+// lastFrameDelay is the time the final state of the canvas is displayed before the animation loops.
+const lastFrameDelay = 3 * time.Second
+
+// background is the palette index filling the area outside of canvases smaller than the animation.
+const background = 15
+
+// EncodeGIF writes the frames as a looping animated GIF, each frame displayed for delay.
+// Only the area changed by each operation is encoded, frames without change extend the previous one.
+func EncodeGIF(w io.Writer, frames []Frame, delay time.Duration) error {
+	width, height := size(frames)
+	screen := image.Rect(0, 0, int(width), int(height))
+
+	anim := &gif.GIF{
+		Config: image.Config{
+			ColorModel: raster.Palette,
+			Width:      raster.CellBounds(screen).Dx(),
+			Height:     raster.CellBounds(screen).Dy(),
+		},
+	}
+
+	var prev *canvas.Canvas
+
+	for _, f := range frames {
+		var img *image.Paletted
+
+		switch cells, full := changes(prev, f.Canvas); {
+		case full:
+			img = image.NewPaletted(raster.CellBounds(screen), raster.Palette)
+			for i := range img.Pix {
+				img.Pix[i] = background
+			}
+
+			raster.Draw(img, f.Canvas, image.Rect(0, 0, int(f.Canvas.Width), int(f.Canvas.Height)))
+		case cells.Empty():
+			anim.Delay[len(anim.Delay)-1] += hundredths(delay)
+
+			continue
+		default:
+			img = image.NewPaletted(raster.CellBounds(cells), raster.Palette)
+			raster.Draw(img, f.Canvas, cells)
+		}
+
+		anim.Image = append(anim.Image, img)
+		anim.Delay = append(anim.Delay, hundredths(delay))
+		anim.Disposal = append(anim.Disposal, gif.DisposalNone)
+		prev = f.Canvas
+	}
+
+	if len(anim.Delay) > 0 {
+		anim.Delay[len(anim.Delay)-1] += hundredths(lastFrameDelay)
+	}
+
+	if err := gif.EncodeAll(w, anim); err != nil {
+		return xerrors.Errorf("failed to encode gif: %w", err)
+	}
+
+	return nil
+}
+
+// changes returns the bounding box of the cells that differ between two canvases,
+// or full if the whole canvas needs to be drawn.
+func changes(prev, next *canvas.Canvas) (cells image.Rectangle, full bool) {
+	if prev == nil || prev.Width != next.Width || prev.Height != next.Height {
+		return image.Rectangle{}, true
+	}
+
+	prevLines, nextLines := prev.Split(), next.Split()
+
+	for y := range nextLines {
+		for x := 0; x < int(next.Width); x++ {
+			if prevLines[y][x] == nextLines[y][x] && prev.GetAttr(uint(x), uint(y)) == next.GetAttr(uint(x), uint(y)) {
+				continue
+			}
+
+			cells = cells.Union(image.Rect(x, y, x+1, y+1))
+		}
+	}
+
+	return cells, false
+}
+
+func hundredths(d time.Duration) int {
+	return int(d / (10 * time.Millisecond)) //nolint:gomnd
+}
@@ -0,0 +1,96 @@
+// Package replay renders the history of a canvas as an animation,
+// playing back the operations applied to it one frame at a time.
+package replay
+
+import (
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+const (
+	// MaxFrames is the maximum number of frames of a replay.
+	// The operations preceding the last MaxFrames ones are applied without producing frames.
+	MaxFrames = 1000
+
+	// DefaultDelay is the time each frame is displayed.
+	DefaultDelay = 500 * time.Millisecond
+)
+
+// Frame is the state of the canvas after an operation.
+type Frame struct {
+	Time   time.Time
+	Canvas *canvas.Canvas
+}
+
+// Frames applies the operations in order and returns the state of the canvas after each of them.
+// When the first operation holds the whole canvas, a snapshot or the checkpoint starting a trimmed journal,
+// the replay starts from its content, otherwise from a blank canvas the size of base.
+func Frames(base *canvas.Canvas, ops []*canvas.Operation) ([]Frame, error) {
+	current := &canvas.Canvas{
+		Width:  base.Width,
+		Height: base.Height,
+	}
+
+	var start time.Time
+
+	if len(ops) > 0 && ops[0].Canvas != nil {
+		current, start = ops[0].Canvas.Clone(), ops[0].Time
+		ops = ops[1:]
+	}
+
+	// The first frame shows the canvas before the remaining operations, one per frame.
+	if skip := len(ops) + 1 - MaxFrames; skip > 0 {
+		if err := apply(current, ops[:skip]); err != nil {
+			return nil, err
+		}
+
+		start = ops[skip-1].Time
+		ops = ops[skip:]
+	}
+
+	frames := make([]Frame, 0, len(ops)+1)
+	frames = append(frames, Frame{Time: start, Canvas: current.Clone()})
+
+	for _, op := range ops {
+		if err := apply(current, []*canvas.Operation{op}); err != nil {
+			return nil, err
+		}
+
+		frames = append(frames, Frame{Time: op.Time, Canvas: current.Clone()})
+	}
+
+	if frames[0].Time.IsZero() && len(frames) > 1 {
+		frames[0].Time = frames[1].Time
+	}
+
+	return frames, nil
+}
+
+// apply executes the operations in order on the canvas.
+func apply(c *canvas.Canvas, ops []*canvas.Operation) error {
+	for _, op := range ops {
+		if err := op.Apply(c); err != nil {
+			return xerrors.Errorf("failed to apply %s operation: %w", op.Type, err)
+		}
+	}
+
+	return nil
+}
+
+// size returns the largest dimensions of the canvases of the frames.
+func size(frames []Frame) (width, height uint) {
+	for _, f := range frames {
+		if f.Canvas.Width > width {
+			width = f.Canvas.Width
+		}
+
+		if f.Canvas.Height > height {
+			height = f.Canvas.Height
+		}
+	}
+
+	return width, height
+}
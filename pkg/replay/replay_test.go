@@ -0,0 +1,151 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/gif"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+	"github.com/hexbee-net/sketch-canvas/pkg/raster"
+)
+
+func history() []*canvas.Operation {
+	return []*canvas.Operation{
+		canvas.NewSnapshot(&canvas.Canvas{Name: "doc", Width: 4, Height: 3}),
+		canvas.NewRect(canvas.Rectangle{Origin: canvas.Point{X: 1, Y: 1}, Width: 2, Height: 1}, "#", ""),
+		canvas.NewRect(canvas.Rectangle{Origin: canvas.Point{X: 1, Y: 1}, Width: 2, Height: 1}, "#", ""),
+		canvas.NewFill(canvas.Point{X: 0, Y: 0}, "."),
+	}
+}
+
+func TestFrames(t *testing.T) {
+	frames, err := Frames(&canvas.Canvas{Width: 4, Height: 3}, history())
+	require.NoError(t, err)
+	require.Len(t, frames, 4)
+
+	assert.Equal(t, []string{"----", "----", "----"}, frames[0].Canvas.Split())
+	assert.Equal(t, []string{"----", "-##-", "----"}, frames[1].Canvas.Split())
+	assert.Equal(t, byte('.'), frames[3].Canvas.Split()[0][0])
+}
+
+func TestFrames_NoSnapshot(t *testing.T) {
+	ops := history()[1:]
+
+	frames, err := Frames(&canvas.Canvas{Width: 4, Height: 3}, ops)
+	require.NoError(t, err)
+	require.Len(t, frames, 4)
+
+	// The replay starts from a blank canvas.
+	assert.Equal(t, []string{"----", "----", "----"}, frames[0].Canvas.Split())
+	assert.Equal(t, ops[0].Time, frames[0].Time)
+}
+
+func TestFrames_Checkpoint(t *testing.T) {
+	ops := history()[1:]
+	ops[0].Canvas = &canvas.Canvas{Width: 4, Height: 3, Data: []byte("----*##-----")}
+
+	frames, err := Frames(&canvas.Canvas{Width: 4, Height: 3}, ops)
+	require.NoError(t, err)
+	require.Len(t, frames, 3)
+
+	// The replay of a trimmed journal starts from the content of its first checkpoint.
+	assert.Equal(t, []string{"----", "*##-", "----"}, frames[0].Canvas.Split())
+	assert.Equal(t, ops[0].Time, frames[0].Time)
+	assert.Equal(t, []string{"....", "*##.", "...."}, frames[2].Canvas.Split())
+}
+
+func TestFrames_Limit(t *testing.T) {
+	ops := make([]*canvas.Operation, 0, MaxFrames+10)
+	ops = append(ops, canvas.NewSnapshot(&canvas.Canvas{Width: 1, Height: 1}))
+
+	for i := 0; i < MaxFrames+9; i++ {
+		ops = append(ops, canvas.NewFill(canvas.Point{}, string(rune('a'+i%26))))
+	}
+
+	frames, err := Frames(&canvas.Canvas{Width: 1, Height: 1}, ops)
+	require.NoError(t, err)
+	assert.Len(t, frames, MaxFrames)
+	assert.Equal(t, []string{"j"}, frames[0].Canvas.Split())
+	assert.Equal(t, ops[10].Time, frames[0].Time)
+	assert.Equal(t, []string{string(rune('a' + (MaxFrames+8)%26))}, frames[len(frames)-1].Canvas.Split())
+
+	// The operations applied without producing frames can fail too.
+	ops[1] = canvas.NewRect(canvas.Rectangle{Width: 2, Height: 2}, "#", "")
+	_, err = Frames(&canvas.Canvas{Width: 1, Height: 1}, ops)
+	assert.ErrorIs(t, err, canvas.ObjectTooLarge)
+}
+
+func TestFrames_Error(t *testing.T) {
+	ops := []*canvas.Operation{
+		canvas.NewRect(canvas.Rectangle{Width: 10, Height: 10}, "#", ""),
+	}
+
+	_, err := Frames(&canvas.Canvas{Width: 4, Height: 3}, ops)
+	assert.ErrorIs(t, err, canvas.ObjectTooLarge)
+}
+
+func TestEncodeGIF(t *testing.T) {
+	frames, err := Frames(&canvas.Canvas{Width: 4, Height: 3}, history())
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	err = EncodeGIF(buf, frames, 200*time.Millisecond)
+	require.NoError(t, err)
+
+	anim, err := gif.DecodeAll(buf)
+	require.NoError(t, err)
+
+	assert.Equal(t, 4*raster.CellWidth, anim.Config.Width)
+	assert.Equal(t, 3*raster.CellHeight, anim.Config.Height)
+
+	// The repeated rectangle doesn't change the canvas, its delay is added to the previous frame.
+	require.Len(t, anim.Image, 3)
+	assert.Equal(t, []int{20, 40, 20 + 300}, anim.Delay)
+
+	// The first frame covers the whole canvas, the next ones only the changed cells.
+	assert.Equal(t, image.Rect(0, 0, 4*raster.CellWidth, 3*raster.CellHeight), anim.Image[0].Bounds())
+	assert.Equal(t, raster.CellBounds(image.Rect(1, 1, 3, 2)), anim.Image[1].Bounds())
+	assert.Equal(t, raster.CellBounds(image.Rect(0, 0, 4, 3)), anim.Image[2].Bounds())
+}
+
+func TestEncodeCast(t *testing.T) {
+	frames, err := Frames(&canvas.Canvas{Width: 4, Height: 3}, history())
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	err = EncodeCast(buf, frames, time.Second, "doc")
+	require.NoError(t, err)
+
+	scanner := bufio.NewScanner(buf)
+	require.True(t, scanner.Scan())
+
+	var header castHeader
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &header))
+	assert.Equal(t, castHeader{Version: 2, Width: 4, Height: 3, Timestamp: frames[0].Time.Unix(), Title: "doc"}, header)
+
+	var events [][]interface{}
+
+	for scanner.Scan() {
+		var event []interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+
+	require.Len(t, events, len(frames))
+
+	for i, event := range events {
+		assert.Equal(t, float64(i), event[0])
+		assert.Equal(t, "o", event[1])
+		assert.True(t, strings.HasPrefix(event[2].(string), clearScreen))
+	}
+
+	assert.Contains(t, events[1][2], "\r\n-##-\r\n")
+}
@@ -0,0 +1,210 @@
+// Package sauce reads the SAUCE (Standard Architecture for Universal Comment Extensions)
+// metadata records appended to ASCII and ANSI art files.
+//
+// See https://www.acid.org/info/sauce/sauce.htm for the specification.
+package sauce
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+const (
+	RecordSize  = 128
+	CommentSize = 64
+
+	recordID  = "SAUCE"
+	commentID = "COMNT"
+
+	// EOF is the character marking the end of the content of a file with a SAUCE record.
+	EOF = 0x1a
+)
+
+// Data types of the files described by a SAUCE record.
+const (
+	DataTypeNone      = 0
+	DataTypeCharacter = 1
+)
+
+// File types of character data.
+const (
+	FileTypeASCII      = 0
+	FileTypeANSI       = 1
+	FileTypeANSIMation = 2
+)
+
+// Record is the content of a SAUCE record.
+type Record struct {
+	Version  string
+	Title    string
+	Author   string
+	Group    string
+	Date     string
+	FileSize uint32
+	DataType byte
+	FileType byte
+	TInfo1   uint16
+	TInfo2   uint16
+	TInfo3   uint16
+	TInfo4   uint16
+	Flags    byte
+	TInfoS   string
+	Comments []string
+}
+
+// Width returns the width in characters of a character file, or 0 if it is not specified.
+func (r *Record) Width() uint {
+	if r.DataType != DataTypeCharacter {
+		return 0
+	}
+
+	return uint(r.TInfo1)
+}
+
+// Height returns the number of lines of a character file, or 0 if it is not specified.
+func (r *Record) Height() uint {
+	if r.DataType != DataTypeCharacter {
+		return 0
+	}
+
+	return uint(r.TInfo2)
+}
+
+// Bytes returns the binary form of the record, preceded by its comment block if it has comments.
+// Text fields are converted to code page 437 and truncated to their maximum size.
+func (r *Record) Bytes() []byte {
+	buf := &bytes.Buffer{}
+
+	if len(r.Comments) > 0 {
+		buf.WriteString(commentID)
+
+		for _, c := range r.Comments {
+			buf.Write(encodeField(c, CommentSize))
+		}
+	}
+
+	version := r.Version
+	if version == "" {
+		version = "00"
+	}
+
+	le := binary.LittleEndian
+	raw := make([]byte, RecordSize)
+
+	copy(raw[0:5], recordID)
+	copy(raw[5:7], version)
+	copy(raw[7:42], encodeField(r.Title, 35))   //nolint:gomnd
+	copy(raw[42:62], encodeField(r.Author, 20)) //nolint:gomnd
+	copy(raw[62:82], encodeField(r.Group, 20))  //nolint:gomnd
+	copy(raw[82:90], encodeField(r.Date, 8))    //nolint:gomnd
+	le.PutUint32(raw[90:94], r.FileSize)
+	raw[94] = r.DataType
+	raw[95] = r.FileType
+	le.PutUint16(raw[96:98], r.TInfo1)
+	le.PutUint16(raw[98:100], r.TInfo2)
+	le.PutUint16(raw[100:102], r.TInfo3)
+	le.PutUint16(raw[102:104], r.TInfo4)
+	raw[104] = byte(len(r.Comments))
+	raw[105] = r.Flags
+	copy(raw[106:128], r.TInfoS)
+
+	buf.Write(raw)
+
+	return buf.Bytes()
+}
+
+// Split separates the content of a file from its SAUCE record.
+// The record is nil if the file doesn't have one, in which case the content is returned unchanged.
+// The returned content stops before the EOF character preceding the record.
+func Split(data []byte) (*Record, []byte) {
+	if len(data) < RecordSize {
+		return nil, data
+	}
+
+	raw := data[len(data)-RecordSize:]
+	if string(raw[:5]) != recordID {
+		return nil, data
+	}
+
+	rec := parseRecord(raw)
+	content := data[:len(data)-RecordSize]
+
+	// The comment block is only valid if it is complete and correctly tagged.
+	if n := int(raw[104]); n > 0 {
+		size := len(commentID) + n*CommentSize
+		if size <= len(content) && string(content[len(content)-size:][:len(commentID)]) == commentID {
+			block := content[len(content)-size+len(commentID):]
+			for i := 0; i < n; i++ {
+				rec.Comments = append(rec.Comments, field(block[i*CommentSize:(i+1)*CommentSize]))
+			}
+
+			content = content[:len(content)-size]
+		}
+	}
+
+	if i := bytes.IndexByte(content, EOF); i >= 0 {
+		content = content[:i]
+	}
+
+	return rec, content
+}
+
+func parseRecord(raw []byte) *Record {
+	le := binary.LittleEndian
+
+	return &Record{
+		Version:  string(raw[5:7]),
+		Title:    field(raw[7:42]),
+		Author:   field(raw[42:62]),
+		Group:    field(raw[62:82]),
+		Date:     field(raw[82:90]),
+		FileSize: le.Uint32(raw[90:94]),
+		DataType: raw[94],
+		FileType: raw[95],
+		TInfo1:   le.Uint16(raw[96:98]),
+		TInfo2:   le.Uint16(raw[98:100]),
+		TInfo3:   le.Uint16(raw[100:102]),
+		TInfo4:   le.Uint16(raw[102:104]),
+		Flags:    raw[105],
+		TInfoS:   field(raw[106:128]),
+	}
+}
+
+// encodeField encodes a text field to code page 437, padded with spaces to its fixed size.
+func encodeField(s string, size int) []byte {
+	b := bytes.Repeat([]byte{' '}, size)
+
+	i := 0
+	for _, r := range s {
+		if i == size {
+			break
+		}
+
+		c, ok := canvas.EncodeRune(r)
+		if !ok {
+			c = '?'
+		}
+
+		b[i] = c
+		i++
+	}
+
+	return b
+}
+
+// field decodes a fixed size code page 437 text field, padded with spaces or null characters.
+func field(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+
+	var sb strings.Builder
+	for _, c := range b {
+		sb.WriteRune(canvas.DecodeRune(c))
+	}
+
+	return strings.TrimRight(sb.String(), " ")
+}
@@ -0,0 +1,100 @@
+package sauce
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testRecord(t *testing.T, title, author string, width, height uint16, comments ...string) []byte {
+	t.Helper()
+
+	pad := func(s string, n int) []byte {
+		b := bytes.Repeat([]byte{' '}, n)
+		copy(b, s)
+
+		return b
+	}
+
+	buf := &bytes.Buffer{}
+
+	if len(comments) > 0 {
+		buf.WriteString(commentID)
+
+		for _, c := range comments {
+			buf.Write(pad(c, CommentSize))
+		}
+	}
+
+	buf.WriteString(recordID)
+	buf.WriteString("00")
+	buf.Write(pad(title, 35))
+	buf.Write(pad(author, 20))
+	buf.Write(pad("group", 20))
+	buf.WriteString("20211024")
+	_ = binary.Write(buf, binary.LittleEndian, uint32(42))
+	buf.WriteByte(DataTypeCharacter)
+	buf.WriteByte(FileTypeANSI)
+	_ = binary.Write(buf, binary.LittleEndian, []uint16{width, height, 0, 0})
+	buf.WriteByte(byte(len(comments)))
+	buf.WriteByte(0)
+	buf.Write(make([]byte, 22))
+
+	return buf.Bytes()
+}
+
+func TestSplit(t *testing.T) {
+	t.Run("no record", func(t *testing.T) {
+		rec, content := Split([]byte("hello"))
+		assert.Nil(t, rec)
+		assert.Equal(t, []byte("hello"), content)
+	})
+
+	t.Run("record", func(t *testing.T) {
+		data := append([]byte("hello\x1a"), testRecord(t, "My \x80rt", "me", 40, 12)...)
+
+		rec, content := Split(data)
+		assert.Equal(t, []byte("hello"), content)
+		assert.Equal(t, "My Çrt", rec.Title)
+		assert.Equal(t, "me", rec.Author)
+		assert.Equal(t, "group", rec.Group)
+		assert.Equal(t, "20211024", rec.Date)
+		assert.Equal(t, uint(40), rec.Width())
+		assert.Equal(t, uint(12), rec.Height())
+		assert.Empty(t, rec.Comments)
+	})
+
+	t.Run("comments", func(t *testing.T) {
+		data := append([]byte("hello\x1a"), testRecord(t, "title", "me", 80, 0, "first", "second")...)
+
+		rec, content := Split(data)
+		assert.Equal(t, []byte("hello"), content)
+		assert.Equal(t, []string{"first", "second"}, rec.Comments)
+	})
+}
+
+func TestRecord_Bytes(t *testing.T) {
+	rec := &Record{
+		Title:    "Çrt",
+		Author:   "me",
+		Date:     "20211024",
+		DataType: DataTypeCharacter,
+		FileType: FileTypeANSI,
+		TInfo1:   80,
+		TInfo2:   25,
+		Comments: []string{"hello"},
+	}
+
+	data := append([]byte("content\x1a"), rec.Bytes()...)
+	parsed, content := Split(data)
+
+	assert.Equal(t, []byte("content"), content)
+	assert.Equal(t, "00", parsed.Version)
+	assert.Equal(t, rec.Title, parsed.Title)
+	assert.Equal(t, rec.Author, parsed.Author)
+	assert.Equal(t, uint(80), parsed.Width())
+	assert.Equal(t, uint(25), parsed.Height())
+	assert.Equal(t, rec.Comments, parsed.Comments)
+}
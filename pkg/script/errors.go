@@ -0,0 +1,53 @@
+package script
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Error string
+
+func (s Error) Error() string {
+	return string(s)
+}
+
+const (
+	UnknownCommand     = Error("unknown command")
+	MissingArgument    = Error("missing argument")
+	UnexpectedArgument = Error("unexpected argument")
+	UnknownOption      = Error("unknown option")
+	DuplicateOption    = Error("duplicate option")
+	MissingPattern     = Error("at least one of fill or outline is required")
+	InvalidNumber      = Error("expected a number")
+	InvalidCharacter   = Error("expected a single printable ASCII character")
+	InvalidText        = Error("expected a text of printable ASCII characters")
+	DiagonalLine       = Error("lines must be horizontal or vertical")
+	UnterminatedString = Error("unterminated string")
+)
+
+// PositionError is an error at a line and a column of a script, both starting at 1.
+type PositionError struct {
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *PositionError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %v", e.Line, e.Column, e.Err)
+}
+
+func (e *PositionError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorList is the list of the errors found in a script, in the order of the lines.
+type ErrorList []*PositionError
+
+func (l ErrorList) Error() string {
+	lines := make([]string, len(l))
+	for i, err := range l {
+		lines[i] = err.Error()
+	}
+
+	return strings.Join(lines, "\n")
+}
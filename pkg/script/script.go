@@ -0,0 +1,414 @@
+// Package script implements a small language describing a drawing as a list of commands, one per line:
+//
+//	# A box with a title, connected to the ground.
+//	RECT 2 1 12 5 fill=. outline=#
+//	TEXT 4 3 "server"
+//	LINE 8 6 8 9 |
+//	FILL 0 0 ~
+//
+// Coordinates are the column and the row of a cell, from 0. The commands are:
+//
+//	RECT x y width height [fill=c] [outline=c]   a rectangle, with at least one of fill and outline
+//	FILL x y c                                   a flood fill from a cell
+//	LINE x1 y1 x2 y2 c                           a horizontal or vertical line between two cells, ends included
+//	TEXT x y text                                a line of text, written to the right of the cell
+//
+// Command names are case insensitive. Arguments are separated by blanks, and can be double-quoted to hold
+// blanks, with \" and \\ as escapes. Characters and texts are printable ASCII. Lines starting with # are comments.
+package script
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+// Command is a drawing operation of a script, with the line it was read from.
+type Command struct {
+	Line int
+	Op   *canvas.Operation
+}
+
+// Script is a parsed script.
+type Script struct {
+	Commands []Command
+}
+
+// Parse reads a script. It returns an ErrorList with the position of each invalid command.
+func Parse(r io.Reader) (*Script, error) {
+	var (
+		s      = &Script{}
+		errs   ErrorList
+		reader = bufio.NewScanner(r)
+	)
+
+	for line := 1; reader.Scan(); line++ {
+		cmd, err := parseLine(reader.Text())
+		if err != nil {
+			err.Line = line
+			errs = append(errs, err)
+
+			continue
+		}
+
+		if cmd != nil {
+			s.Commands = append(s.Commands, Command{Line: line, Op: cmd})
+		}
+	}
+
+	if err := reader.Err(); err != nil {
+		return nil, xerrors.Errorf("failed to read script: %w", err)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return s, nil
+}
+
+// Operations returns the drawing operations of the script, in order.
+func (s *Script) Operations() []*canvas.Operation {
+	ops := make([]*canvas.Operation, len(s.Commands))
+	for i, cmd := range s.Commands {
+		ops[i] = cmd.Op
+	}
+
+	return ops
+}
+
+// Run applies the commands of the script to a canvas. When a command fails, it returns its error
+// with the line of the command, and the canvas holds the changes of the previous commands.
+func (s *Script) Run(c *canvas.Canvas) error {
+	for _, cmd := range s.Commands {
+		if err := cmd.Op.Apply(c); err != nil {
+			return &PositionError{Line: cmd.Line, Column: 1, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// parseLine returns the operation of a line, nil for blank lines and comments.
+// The line of the returned error is set by the caller.
+func parseLine(line string) (*canvas.Operation, *PositionError) {
+	if trimmed := strings.TrimSpace(line); trimmed == "" || trimmed[0] == '#' {
+		return nil, nil
+	}
+
+	tokens, err := tokenize(line)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, end: len(strings.TrimRightFunc(line, isBlank)) + 1}
+	name := tokens[0]
+
+	switch strings.ToUpper(name.text) {
+	case "RECT":
+		return p.rect()
+	case "FILL":
+		return p.fill()
+	case "LINE":
+		return p.line()
+	case "TEXT":
+		return p.text()
+	default:
+		return nil, name.errorf("%q: %w", name.text, UnknownCommand)
+	}
+}
+
+// token is an argument of a command. Eq is the index of the first = outside of quotes in text following a key,
+// -1 if there is none and the token is not a key=value option.
+type token struct {
+	text   string
+	column int
+	eq     int
+}
+
+func (t token) errorf(format string, args ...interface{}) *PositionError {
+	return &PositionError{Column: t.column, Err: xerrors.Errorf(format, args...)}
+}
+
+func isBlank(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\r'
+}
+
+// tokenize splits a line into blank separated tokens, removing the quotes and escapes of the quoted parts.
+func tokenize(line string) ([]token, *PositionError) {
+	var tokens []token
+
+	for i := 0; i < len(line); {
+		if isBlank(rune(line[i])) {
+			i++
+
+			continue
+		}
+
+		var (
+			sb     strings.Builder
+			tok    = token{column: i + 1, eq: -1}
+			quoted bool
+		)
+
+		for ; i < len(line) && (quoted || !isBlank(rune(line[i]))); i++ {
+			switch c := line[i]; {
+			case c == '"':
+				quoted = !quoted
+			case quoted && c == '\\' && i+1 < len(line) && (line[i+1] == '"' || line[i+1] == '\\'):
+				i++
+				sb.WriteByte(line[i])
+			case !quoted && c == '=' && tok.eq < 0 && sb.Len() > 0:
+				tok.eq = sb.Len()
+				sb.WriteByte(c)
+			default:
+				sb.WriteByte(c)
+			}
+		}
+
+		if quoted {
+			return nil, &PositionError{Column: tok.column, Err: UnterminatedString}
+		}
+
+		tok.text = sb.String()
+		tokens = append(tokens, tok)
+	}
+
+	return tokens, nil
+}
+
+// parser reads the arguments of a command, next is the index of the last token read.
+// End is the column following the last token of the line, where missing arguments are reported.
+type parser struct {
+	tokens []token
+	next   int
+	end    int
+}
+
+// arg returns the next positional argument.
+func (p *parser) arg(name string) (token, *PositionError) {
+	if p.next+1 >= len(p.tokens) {
+		return token{}, &PositionError{Column: p.end, Err: xerrors.Errorf("%s: %w", name, MissingArgument)}
+	}
+
+	// Options follow the positional arguments.
+	if tok := p.tokens[p.next+1]; tok.eq >= 0 {
+		return token{}, tok.errorf("%s: %w", name, MissingArgument)
+	}
+
+	p.next++
+
+	return p.tokens[p.next], nil
+}
+
+func (p *parser) number(name string) (uint, *PositionError) {
+	tok, err := p.arg(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return parseNumber(tok)
+}
+
+func (p *parser) char(name string) (string, *PositionError) {
+	tok, err := p.arg(name)
+	if err != nil {
+		return "", err
+	}
+
+	return parseChar(tok, tok.text)
+}
+
+func (p *parser) point(x, y string) (canvas.Point, *PositionError) {
+	px, err := p.number(x)
+	if err != nil {
+		return canvas.Point{}, err
+	}
+
+	py, err := p.number(y)
+	if err != nil {
+		return canvas.Point{}, err
+	}
+
+	return canvas.Point{X: px, Y: py}, nil
+}
+
+// options returns the key=value options following the positional arguments.
+func (p *parser) options(names ...string) (map[string]string, *PositionError) {
+	values := make(map[string]string)
+
+	for p.next++; p.next < len(p.tokens); p.next++ {
+		tok := p.tokens[p.next]
+
+		if tok.eq < 0 {
+			return nil, tok.errorf("%q: %w", tok.text, UnexpectedArgument)
+		}
+
+		key, value := strings.ToLower(tok.text[:tok.eq]), tok.text[tok.eq+1:]
+
+		if !contains(names, key) {
+			return nil, tok.errorf("%q: %w", key, UnknownOption)
+		}
+
+		if _, ok := values[key]; ok {
+			return nil, tok.errorf("%q: %w", key, DuplicateOption)
+		}
+
+		c, err := parseChar(tok, value)
+		if err != nil {
+			return nil, err
+		}
+
+		values[key] = c
+	}
+
+	return values, nil
+}
+
+func (p *parser) rect() (*canvas.Operation, *PositionError) {
+	origin, err := p.point("x", "y")
+	if err != nil {
+		return nil, err
+	}
+
+	width, err := p.number("width")
+	if err != nil {
+		return nil, err
+	}
+
+	height, err := p.number("height")
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := p.options("fill", "outline")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(options) == 0 {
+		return nil, &PositionError{Column: p.tokens[0].column, Err: MissingPattern}
+	}
+
+	rect := canvas.Rectangle{Origin: origin, Width: width, Height: height}
+
+	return canvas.NewRect(rect, options["fill"], options["outline"]), nil
+}
+
+func (p *parser) fill() (*canvas.Operation, *PositionError) {
+	origin, err := p.point("x", "y")
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := p.char("character")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.options(); err != nil {
+		return nil, err
+	}
+
+	return canvas.NewFill(origin, c), nil
+}
+
+// line returns a line as the rectangle one cell wide or high between its ends.
+func (p *parser) line() (*canvas.Operation, *PositionError) {
+	from, err := p.point("x1", "y1")
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := p.point("x2", "y2")
+	if err != nil {
+		return nil, err
+	}
+
+	end := p.tokens[p.next-1]
+
+	c, err := p.char("character")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.options(); err != nil {
+		return nil, err
+	}
+
+	if from.X != to.X && from.Y != to.Y {
+		return nil, &PositionError{Column: end.column, Err: DiagonalLine}
+	}
+
+	if to.X < from.X || to.Y < from.Y {
+		from, to = to, from
+	}
+
+	rect := canvas.Rectangle{Origin: from, Width: to.X - from.X + 1, Height: to.Y - from.Y + 1}
+
+	return canvas.NewRect(rect, c, ""), nil
+}
+
+func (p *parser) text() (*canvas.Operation, *PositionError) {
+	origin, err := p.point("x", "y")
+	if err != nil {
+		return nil, err
+	}
+
+	tok, err := p.arg("text")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.options(); err != nil {
+		return nil, err
+	}
+
+	if tok.text == "" || !isPrintable(tok.text) {
+		return nil, &PositionError{Column: tok.column, Err: InvalidText}
+	}
+
+	return canvas.NewText(origin, tok.text), nil
+}
+
+func parseNumber(tok token) (uint, *PositionError) {
+	n, err := strconv.ParseUint(tok.text, 10, 0)
+	if err != nil {
+		return 0, tok.errorf("%q: %w", tok.text, InvalidNumber)
+	}
+
+	return uint(n), nil
+}
+
+func parseChar(tok token, value string) (string, *PositionError) {
+	if len(value) != 1 || !isPrintable(value) {
+		return "", tok.errorf("%q: %w", value, InvalidCharacter)
+	}
+
+	return value, nil
+}
+
+func isPrintable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
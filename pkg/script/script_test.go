@@ -0,0 +1,99 @@
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+)
+
+func TestParse(t *testing.T) {
+	s, err := Parse(strings.NewReader(`# A box with a title.
+RECT 1 1 8 4 fill=. outline=#
+
+  text 2 2 "a \"b\""
+LINE 9 0 9 3 |
+line 0 5 3 5 =
+FILL 0 0 ~
+`))
+	require.NoError(t, err)
+
+	lines := make([]int, 0, len(s.Commands))
+	for _, cmd := range s.Commands {
+		lines = append(lines, cmd.Line)
+	}
+
+	assert.Equal(t, []int{2, 4, 5, 6, 7}, lines)
+	assert.Equal(t, canvas.NewText(canvas.Point{X: 2, Y: 2}, `a "b"`).Text, s.Commands[1].Op.Text)
+
+	// Lines are one cell wide or high rectangles, from either end.
+	assert.Equal(t, &canvas.Rectangle{Origin: canvas.Point{X: 9, Y: 0}, Width: 1, Height: 4}, s.Commands[2].Op.Rect)
+	assert.Equal(t, "=", s.Commands[3].Op.Fill)
+
+	c := &canvas.Canvas{Width: 10, Height: 6}
+	require.NoError(t, s.Run(c))
+	assert.Equal(t, []string{
+		"~~~~~~~~~|",
+		"~########|",
+		"~#a \"b\".#|",
+		"~#......#|",
+		"~########-",
+		"====------",
+	}, c.Split())
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		line   int
+		column int
+		err    error
+	}{
+		{name: "unknown command", script: "RECT 1 1 2 2 fill=#\n  CIRCLE 1 1", line: 2, column: 3, err: UnknownCommand},
+		{name: "missing argument", script: "FILL 1 1", line: 1, column: 9, err: MissingArgument},
+		{name: "missing argument before options", script: "RECT 1 2 3 fill=#", line: 1, column: 12, err: MissingArgument},
+		{name: "invalid number", script: "RECT 1 -2 3 4 fill=#", line: 1, column: 8, err: InvalidNumber},
+		{name: "unexpected argument", script: "FILL 1 1 @ @", line: 1, column: 12, err: UnexpectedArgument},
+		{name: "unknown option", script: "RECT 1 1 2 2 color=#", line: 1, column: 14, err: UnknownOption},
+		{name: "duplicate option", script: "RECT 1 1 2 2 fill=# FILL=@", line: 1, column: 21, err: DuplicateOption},
+		{name: "missing pattern", script: "RECT 1 1 2 2", line: 1, column: 1, err: MissingPattern},
+		{name: "invalid character", script: "RECT 1 1 2 2 fill=##", line: 1, column: 14, err: InvalidCharacter},
+		{name: "invalid text", script: "TEXT 1 1 \"\"", line: 1, column: 10, err: InvalidText},
+		{name: "diagonal line", script: "LINE 1 1 3 3 -", line: 1, column: 10, err: DiagonalLine},
+		{name: "unterminated string", script: "\nTEXT 1 1 \"abc", line: 2, column: 10, err: UnterminatedString},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.script))
+
+			var errs ErrorList
+			require.ErrorAs(t, err, &errs)
+			require.Len(t, errs, 1)
+			assert.Equal(t, tt.line, errs[0].Line)
+			assert.Equal(t, tt.column, errs[0].Column)
+			assert.ErrorIs(t, errs[0], tt.err)
+		})
+	}
+
+	// All the invalid lines are reported.
+	_, err := Parse(strings.NewReader("FILL 1\nFILL 1 1 @\nTEXT a 1 b"))
+	assert.EqualError(t, err, "line 1, column 7: y: missing argument\nline 3, column 6: \"a\": expected a number")
+}
+
+func TestScript_Run(t *testing.T) {
+	s, err := Parse(strings.NewReader("RECT 1 1 2 1 fill=#\n\nRECT 3 1 5 1 fill=@"))
+	require.NoError(t, err)
+
+	c := &canvas.Canvas{Width: 4, Height: 2}
+	err = s.Run(c)
+
+	var posErr *PositionError
+	require.ErrorAs(t, err, &posErr)
+	assert.Equal(t, 3, posErr.Line)
+	assert.ErrorIs(t, err, canvas.ObjectTooLarge)
+	assert.Equal(t, []string{"----", "-##-"}, c.Split())
+}
@@ -0,0 +1,327 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/apex/log"
+	"github.com/gorilla/mux"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+	"github.com/hexbee-net/sketch-canvas/pkg/datastore"
+)
+
+// maxBatchOperations is the maximum number of operations applied by a batch.
+const maxBatchOperations = 1000
+
+const (
+	EmptyBatch           = Error("ops must hold at least one operation")
+	BatchTooLarge        = Error("too many operations in the batch")
+	InvalidBatch         = Error("the batch holds invalid operations")
+	UnsupportedOperation = Error("unsupported operation type")
+	MissingRect          = Error("rect is required")
+	MissingOrigin        = Error("origin is required")
+	MissingPattern       = Error("at least one of fill or outline is required")
+	MissingFill          = Error("fill character is required")
+	MissingText          = Error("text is required")
+)
+
+// Statuses of the operations of a batch.
+const (
+	opApplied = "applied"
+	opFailed  = "failed"
+	opSkipped = "skipped"
+)
+
+// batchOperation is an operation of a batch request, with the fields of the rect or fill requests,
+// or the origin and the text of a text operation.
+type batchOperation struct {
+	Type    string            `json:"type"`
+	Rect    *canvas.Rectangle `json:"rect,omitempty"`
+	Origin  *canvas.Point     `json:"origin,omitempty"`
+	Fill    string            `json:"fill,omitempty"`
+	Outline string            `json:"outline,omitempty"`
+	Text    string            `json:"text,omitempty"`
+}
+
+// operation returns the drawing operation of a batch request, or the reason it is invalid.
+func (o *batchOperation) operation() (*canvas.Operation, error) {
+	switch o.Type {
+	case canvas.OpRect:
+		if o.Rect == nil {
+			return nil, MissingRect
+		}
+
+		if o.Fill == "" && o.Outline == "" {
+			return nil, MissingPattern
+		}
+
+		return canvas.NewRect(*o.Rect, o.Fill, o.Outline), nil
+	case canvas.OpFill:
+		if o.Origin == nil {
+			return nil, MissingOrigin
+		}
+
+		if o.Fill == "" {
+			return nil, MissingFill
+		}
+
+		return canvas.NewFill(*o.Origin, o.Fill), nil
+	case canvas.OpText:
+		if o.Origin == nil {
+			return nil, MissingOrigin
+		}
+
+		if o.Text == "" {
+			return nil, MissingText
+		}
+
+		return canvas.NewText(*o.Origin, o.Text), nil
+	default:
+		return nil, xerrors.Errorf("%q: %w", o.Type, UnsupportedOperation)
+	}
+}
+
+// opResult is the outcome of an operation of a batch. Region is the bounding rectangle of the cells it changed,
+// and Line the line of the command of a script.
+type opResult struct {
+	Index   int               `json:"index"`
+	Line    int               `json:"line,omitempty"`
+	Type    string            `json:"type"`
+	Status  string            `json:"status"`
+	Changed int               `json:"changed"`
+	Region  *canvas.Rectangle `json:"region,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// batchResponse is the response of a batch request. The document is only returned when the batch is applied.
+type batchResponse struct {
+	Results []opResult     `json:"results"`
+	Canvas  *canvas.Canvas `json:"canvas,omitempty"`
+}
+
+// applyOperations applies operations in order to a copy of doc. When an operation fails, it returns its error
+// and the following operations are skipped.
+func applyOperations(doc *canvas.Canvas, ops []*canvas.Operation) (*canvas.Canvas, []opResult, error) {
+	next := doc.Clone()
+	results := make([]opResult, len(ops))
+
+	var failed error
+
+	for i, op := range ops {
+		results[i] = opResult{Index: i, Type: op.Type, Status: opSkipped}
+
+		if failed != nil {
+			continue
+		}
+
+		before := next.Clone()
+
+		if err := op.Apply(next); err != nil {
+			results[i].Status, results[i].Error = opFailed, err.Error()
+			failed = xerrors.Errorf("operation %d failed: %w", i, err)
+
+			continue
+		}
+
+		results[i].Status = opApplied
+
+		if patch := canvas.NewPatch(before, next); patch != nil {
+			rect := patch.Rect
+			results[i].Region = &rect
+
+			for j := range patch.Before {
+				if patch.Before[j] != patch.After[j] {
+					results[i].Changed++
+				}
+			}
+		}
+	}
+
+	return next, results, failed
+}
+
+// applyBatch applies a list of drawing operations to a document, saved once when all of them succeed.
+// Without expected revision, the operations are attempted again if the document is modified in between.
+// Like drawDocument, the cells changed by the batch are recorded in the patch of op when its caller can undo it.
+func (s *Server) applyBatch(r *http.Request, store datastore.DataStore, docID string, op *canvas.Operation, revision uint64) (*canvas.Canvas, []opResult, error) {
+	op.Caller = callerID(r)
+
+	for attempt := 1; ; attempt++ {
+		current, err := store.GetDocument(docID, r.Context())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if revision != datastore.AnyRevision && current.Revision != revision {
+			return nil, nil, datastore.Conflict
+		}
+
+		doc, results, err := applyOperations(current, op.Ops)
+		if err != nil {
+			return nil, results, err
+		}
+
+		doc.Touch(op.Time)
+
+		err = store.CompareAndSwapDocument(docID, current.Revision, doc, r.Context())
+		if err == datastore.Conflict && revision == datastore.AnyRevision && attempt < maxDrawAttempts {
+			continue
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if op.Caller != "" && s.storeOptions.UndoDepth > 0 {
+			op.Patch = canvas.NewPatch(current, doc)
+		}
+
+		return doc, results, nil
+	}
+}
+
+func (s *Server) applyOperationBatch(w http.ResponseWriter, r *http.Request) {
+	var (
+		req struct {
+			Ops []batchOperation `json:"ops"`
+		}
+		store  = s.getStore(r)
+		vars   = mux.Vars(r)
+		docID  = vars["id"]
+		reqLog = log.
+			WithField("operation-id", "apply-ops").
+			WithField("request-id", s.getRequestID(r)).
+			WithField("doc-id", docID)
+	)
+
+	reqLog.Debug("received apply operations request")
+
+	dryRun, preview, err := parseDryRun(r)
+	if err != nil {
+		reqLog.WithError(err).Info("invalid dry run")
+		s.writeProblem(w, r, http.StatusBadRequest, err)
+
+		return
+	}
+
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		reqLog.WithField("body", r.Body).WithError(err).Infof("failed to decode request body")
+		s.writeProblem(w, r, http.StatusBadRequest, err)
+
+		return
+	}
+
+	switch {
+	case len(req.Ops) == 0:
+		reqLog.Info("no operation in the batch")
+		s.writeProblem(w, r, http.StatusBadRequest, EmptyBatch)
+
+		return
+	case len(req.Ops) > maxBatchOperations:
+		reqLog.WithField("count", len(req.Ops)).Info("too many operations in the batch")
+		s.writeProblem(w, r, http.StatusBadRequest, BatchTooLarge)
+
+		return
+	}
+
+	ops := make([]*canvas.Operation, len(req.Ops))
+	results := make([]opResult, len(req.Ops))
+	invalid := false
+
+	var fields []string
+
+	for i := range req.Ops {
+		op, err := req.Ops[i].operation()
+		results[i] = opResult{Index: i, Type: req.Ops[i].Type, Status: opSkipped}
+		ops[i] = op
+
+		if err != nil {
+			results[i].Status, results[i].Error = opFailed, err.Error()
+			fields = append(fields, operationFields(i, err)...)
+			invalid = true
+		}
+	}
+
+	if invalid {
+		reqLog.Info("invalid operations in the batch")
+
+		p := s.newProblem(r, http.StatusBadRequest, InvalidBatch, fields...)
+		p.Results = results
+		s.writeProblemDetails(w, p)
+
+		return
+	}
+
+	if dryRun {
+		s.previewOperations(w, r, store, docID, ops, nil, preview, reqLog)
+
+		return
+	}
+
+	revision, ok := s.expectedRevision(w, r, store, docID, reqLog)
+	if !ok {
+		return
+	}
+
+	batch := canvas.NewBatch(ops)
+
+	doc, results, err := s.applyBatch(r, store, docID, batch, revision)
+	if err != nil {
+		if results != nil {
+			reqLog.WithError(err).Info("failed to apply the batch")
+			s.writeBatchProblem(w, r, err, results)
+
+			return
+		}
+
+		s.writeUpdateError(w, r, err, reqLog)
+
+		return
+	}
+
+	s.recordOperation(w, r, store, docID, batch, doc, reqLog)
+
+	reqLog.WithField("count", len(ops)).Infof("operations applied")
+
+	w.Header().Set(headerETag, documentETag(doc.Revision, formatJSON))
+	s.writeBatchResponse(w, r, batchResponse{Results: results, Canvas: doc}, reqLog)
+}
+
+// operationFields returns the fields of the operation of a batch an error is about.
+func operationFields(index int, err error) []string {
+	kind, _ := lookupProblemKind(err)
+
+	fields := make([]string, len(kind.fields))
+	for i, field := range kind.fields {
+		fields[i] = fmt.Sprintf("ops[%d].%s", index, field)
+	}
+
+	return fields
+}
+
+// writeBatchProblem writes the response of a batch of operations which failed with err,
+// with the results of the operations.
+func (s *Server) writeBatchProblem(w http.ResponseWriter, r *http.Request, err error, results []opResult) {
+	p := s.newProblem(r, http.StatusConflict, err)
+	p.Results = results
+	s.writeProblemDetails(w, p)
+}
+
+func (s *Server) writeBatchResponse(w http.ResponseWriter, r *http.Request, resp batchResponse, reqLog *log.Entry) {
+	data, err := jsonMarshal(resp)
+	if err != nil {
+		reqLog.WithError(err).Error("failed to marshal response to json")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, err := w.Write(data); err != nil {
+		reqLog.WithError(err).Error("failed to write http response")
+	}
+}
@@ -0,0 +1,320 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/apex/log"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+	"github.com/hexbee-net/sketch-canvas/pkg/datastore"
+	"github.com/hexbee-net/sketch-canvas/pkg/raster"
+)
+
+// Formats of the diffs, besides JSON.
+const (
+	diffText = "txt"
+	diffPNG  = "png"
+)
+
+const MissingDiffDocuments = Error("a and b are required")
+
+// diffEncoder writes a diff in one of the supported formats.
+type diffEncoder struct {
+	contentType string
+	encode      func(w io.Writer, d *canvas.Diff) error
+}
+
+var diffEncoders = map[string]diffEncoder{ //nolint:gochecknoglobals
+	formatJSON: {
+		contentType: mediaTypeJSON,
+	},
+	diffText: {
+		contentType: "text/plain; charset=utf-8",
+		encode: func(w io.Writer, d *canvas.Diff) error {
+			return d.WriteText(w)
+		},
+	},
+	diffPNG: {
+		contentType: "image/png",
+		encode: func(w io.Writer, d *canvas.Diff) error {
+			return png.Encode(w, raster.RenderDiff(d)) //nolint:wrapcheck
+		},
+	},
+}
+
+// diffSide identifies one of the documents compared by a diff, or merged.
+type diffSide struct {
+	ID       string `json:"id"`
+	Revision uint64 `json:"revision"`
+	Href     string `json:"href"`
+}
+
+func newDiffSide(docID string, doc *canvas.Canvas) diffSide {
+	return diffSide{
+		ID:       docID,
+		Revision: doc.Revision,
+		Href:     path.Join("/v1/docs", docID) + "?" + paramRevision + "=" + strconv.FormatUint(doc.Revision, 10),
+	}
+}
+
+func (s *Server) getDocumentDiff(w http.ResponseWriter, r *http.Request) {
+	var (
+		store  = s.getStore(r)
+		vars   = mux.Vars(r)
+		docID  = vars["id"]
+		reqLog = log.
+			WithField("operation-id", "get-doc-diff").
+			WithField("request-id", s.getRequestID(r)).
+			WithField("doc-id", docID)
+	)
+
+	reqLog.Debug("received get document diff request")
+
+	// Parse query parameters
+	req := struct {
+		Format string  `schema:"format"`
+		From   *uint64 `schema:"from"`
+		To     *uint64 `schema:"to"`
+	}{
+		Format: formatJSON,
+	}
+
+	if err := r.ParseForm(); err != nil {
+		reqLog.WithError(err).Warnf("invalid request: %s", r.RequestURI)
+		s.writeProblem(w, r, http.StatusBadRequest, err)
+
+		return
+	}
+
+	if err := schema.NewDecoder().Decode(&req, r.Form); err != nil {
+		reqLog.WithError(err).Warnf("invalid request: %s", r.RequestURI)
+		s.writeProblem(w, r, http.StatusBadRequest, err)
+
+		return
+	}
+
+	encoder, ok := diffEncoders[req.Format]
+	if !ok {
+		reqLog.WithField("format", req.Format).Infof("unsupported diff format requested")
+		s.writeProblem(w, r, http.StatusNotAcceptable, UnsupportedFormat)
+
+		return
+	}
+
+	doc, err := store.GetDocument(docID, r.Context())
+	if err != nil {
+		switch err {
+		case datastore.NotFound:
+			reqLog.Info("document not found")
+			s.writeProblem(w, r, http.StatusNotFound, datastore.NotFound)
+		case err:
+			reqLog.WithError(err).Error("failed to retrieve document from store")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
+		}
+
+		return
+	}
+
+	// The diff defaults to the changes made by the last revision.
+	to := doc.Revision
+	if req.To != nil {
+		to = *req.To
+	}
+
+	from := to
+	if req.From != nil {
+		from = *req.From
+	} else if to > 0 {
+		from = to - 1
+	}
+
+	fromDoc, toDoc, err := revisionPair(store, docID, doc, from, to, r.Context())
+	if err != nil {
+		switch err {
+		case canvas.UnknownRevision:
+			reqLog.WithField("from", from).WithField("to", to).Info("revision not found")
+			s.writeProblem(w, r, http.StatusNotFound, err)
+		case err:
+			reqLog.WithError(err).Error("failed to rebuild document revision")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
+		}
+
+		return
+	}
+
+	d, err := canvas.Compare(fromDoc, toDoc)
+	if err != nil {
+		reqLog.WithError(err).Warn("malformed document revision")
+		s.writeProblem(w, r, http.StatusUnprocessableEntity, err)
+
+		return
+	}
+
+	s.writeDiff(w, r, encoder, newDiffSide(docID, fromDoc), newDiffSide(docID, toDoc), d, reqLog)
+}
+
+// revisionPair returns a document at two revisions, reading its journal at most once.
+func revisionPair(store datastore.DataStore, docID string, doc *canvas.Canvas, from, to uint64, ctx context.Context) (*canvas.Canvas, *canvas.Canvas, error) {
+	if from == doc.Revision && to == doc.Revision {
+		return doc, doc, nil
+	}
+
+	if from > doc.Revision || to > doc.Revision {
+		return nil, nil, canvas.UnknownRevision
+	}
+
+	oldest := from
+	if to < oldest {
+		oldest = to
+	}
+
+	ops, err := store.GetOperationsFrom(docID, oldest, ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	at := func(revision uint64) (*canvas.Canvas, error) {
+		if revision == doc.Revision {
+			return doc, nil
+		}
+
+		return canvas.AtRevision(ops, revision)
+	}
+
+	fromDoc, err := at(from)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	toDoc, err := at(to)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return fromDoc, toDoc, nil
+}
+
+func (s *Server) getDiff(w http.ResponseWriter, r *http.Request) {
+	var (
+		store  = s.getStore(r)
+		reqLog = log.
+			WithField("operation-id", "get-diff").
+			WithField("request-id", s.getRequestID(r))
+	)
+
+	reqLog.Debug("received get diff request")
+
+	// Parse query parameters
+	req := struct {
+		Format string `schema:"format"`
+		A      string `schema:"a"`
+		B      string `schema:"b"`
+	}{
+		Format: formatJSON,
+	}
+
+	if err := r.ParseForm(); err != nil {
+		reqLog.WithError(err).Warnf("invalid request: %s", r.RequestURI)
+		s.writeProblem(w, r, http.StatusBadRequest, err)
+
+		return
+	}
+
+	if err := schema.NewDecoder().Decode(&req, r.Form); err != nil {
+		reqLog.WithError(err).Warnf("invalid request: %s", r.RequestURI)
+		s.writeProblem(w, r, http.StatusBadRequest, err)
+
+		return
+	}
+
+	if req.A == "" || req.B == "" {
+		reqLog.Info("documents to compare are not specified")
+		s.writeProblem(w, r, http.StatusBadRequest, MissingDiffDocuments)
+
+		return
+	}
+
+	encoder, ok := diffEncoders[req.Format]
+	if !ok {
+		reqLog.WithField("format", req.Format).Infof("unsupported diff format requested")
+		s.writeProblem(w, r, http.StatusNotAcceptable, UnsupportedFormat)
+
+		return
+	}
+
+	docs := make([]*canvas.Canvas, 0, 2) //nolint:gomnd
+
+	for _, docID := range []string{req.A, req.B} {
+		doc, err := store.GetDocument(docID, r.Context())
+		if err != nil {
+			switch err {
+			case datastore.NotFound:
+				reqLog.WithField("doc-id", docID).Info("document not found")
+				s.writeProblem(w, r, http.StatusNotFound, datastore.NotFound)
+			case err:
+				reqLog.WithError(err).Error("failed to retrieve document from store")
+				s.writeProblem(w, r, http.StatusInternalServerError, nil)
+			}
+
+			return
+		}
+
+		docs = append(docs, doc)
+	}
+
+	d, err := canvas.Compare(docs[0], docs[1])
+	if err != nil {
+		reqLog.WithError(err).Warn("malformed document")
+		s.writeProblem(w, r, http.StatusUnprocessableEntity, err)
+
+		return
+	}
+
+	s.writeDiff(w, r, encoder, newDiffSide(req.A, docs[0]), newDiffSide(req.B, docs[1]), d, reqLog)
+}
+
+// writeDiff writes the response of a diff request with the given encoder, or as JSON with the compared documents.
+func (s *Server) writeDiff(w http.ResponseWriter, r *http.Request, encoder diffEncoder, from, to diffSide, d *canvas.Diff, reqLog *log.Entry) {
+	var (
+		data []byte
+		err  error
+	)
+
+	if encoder.encode != nil {
+		buffer := &bytes.Buffer{}
+		err = encoder.encode(buffer, d)
+		data = buffer.Bytes()
+	} else {
+		data, err = jsonMarshal(struct {
+			From diffSide `json:"from"`
+			To   diffSide `json:"to"`
+			*canvas.Diff
+		}{
+			From: from,
+			To:   to,
+			Diff: d,
+		})
+	}
+
+	if err != nil {
+		reqLog.WithError(err).Error("failed to encode diff")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", encoder.contentType)
+
+	if _, err := w.Write(data); err != nil {
+		reqLog.WithError(err).Error("failed to write http response")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/apex/log"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+	"github.com/hexbee-net/sketch-canvas/pkg/datastore"
+)
+
+const (
+	paramDryRun  = "dryRun"
+	paramPreview = "preview"
+)
+
+// Previews returned by a dry run, the whole document or only the cells changed by the operations.
+const (
+	previewCanvas = "canvas"
+	previewRegion = "region"
+)
+
+const (
+	InvalidDryRun  = Error("dryRun must be true or false")
+	InvalidPreview = Error("preview must be canvas or region")
+)
+
+// previewResponse is the response of a dry run. Region is the bounding rectangle of the cells changed by all
+// the operations, and Canvas the document as it would be after them, or only the region with preview=region.
+type previewResponse struct {
+	Results []opResult        `json:"results"`
+	Changed int               `json:"changed"`
+	Region  *canvas.Rectangle `json:"region,omitempty"`
+	Canvas  *canvas.Canvas    `json:"canvas,omitempty"`
+}
+
+// parseDryRun returns whether a drawing request is a dry run, and the preview it returns.
+func parseDryRun(r *http.Request) (bool, string, error) {
+	query := r.URL.Query()
+
+	value := query.Get(paramDryRun)
+	if value == "" {
+		return false, "", nil
+	}
+
+	dryRun, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, "", InvalidDryRun
+	}
+
+	switch preview := query.Get(paramPreview); preview {
+	case "", previewCanvas:
+		return dryRun, previewCanvas, nil
+	case previewRegion:
+		return dryRun, previewRegion, nil
+	default:
+		return false, "", InvalidPreview
+	}
+}
+
+// previewOperations writes the document as it would be after a list of drawing operations, applied to a copy
+// of the document which is never saved nor recorded in its history. Like the request it previews, it fails
+// if an operation fails or if the If-Match header doesn't match the document. Lines are the lines of the commands
+// of a script, if any.
+func (s *Server) previewOperations(w http.ResponseWriter, r *http.Request, store datastore.DataStore, docID string, ops []*canvas.Operation, lines []int, preview string, reqLog *log.Entry) {
+	current, err := store.GetDocument(docID, r.Context())
+	if err != nil {
+		switch err {
+		case datastore.NotFound:
+			reqLog.Info("document not found")
+			s.writeProblem(w, r, http.StatusNotFound, datastore.NotFound)
+		case err:
+			reqLog.WithError(err).Error("failed to retrieve document from store")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
+		}
+
+		return
+	}
+
+	if !s.checkIfMatch(w, r, current.Revision, reqLog) {
+		return
+	}
+
+	doc, results, err := applyOperations(current, ops)
+
+	for i := range lines {
+		results[i].Line = lines[i]
+	}
+
+	if err != nil {
+		reqLog.WithError(err).Info("dry run failed")
+		s.writeBatchProblem(w, r, err, results)
+
+		return
+	}
+
+	resp := previewResponse{Results: results}
+
+	if preview == previewCanvas {
+		resp.Canvas = doc
+	}
+
+	if patch := canvas.NewPatch(current, doc); patch != nil {
+		rect := patch.Rect
+		resp.Region = &rect
+
+		for i := range patch.Before {
+			if patch.Before[i] != patch.After[i] {
+				resp.Changed++
+			}
+		}
+
+		if preview == previewRegion {
+			resp.Canvas = &canvas.Canvas{Width: rect.Width, Height: rect.Height, Data: patch.After}
+		}
+	}
+
+	reqLog.
+		WithField("count", len(ops)).
+		WithField("changed", resp.Changed).
+		Infof("dry run applied")
+
+	s.writePreviewResponse(w, r, resp, reqLog)
+}
+
+func (s *Server) writePreviewResponse(w http.ResponseWriter, r *http.Request, resp previewResponse, reqLog *log.Entry) {
+	data, err := jsonMarshal(resp)
+	if err != nil {
+		reqLog.WithError(err).Error("failed to marshal response to json")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, err := w.Write(data); err != nil {
+		reqLog.WithError(err).Error("failed to write http response")
+	}
+}
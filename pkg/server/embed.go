@@ -0,0 +1,64 @@
+package server
+
+import (
+	"bytes"
+	"html/template"
+)
+
+const (
+	DefaultEmbedRefresh = 5
+	MinEmbedRefresh     = 1
+)
+
+// embedPart is the value of the `part` query parameter returning only the canvas fragment of the embed page.
+const embedPart = "canvas"
+
+// embedTemplate is the page served to iframes embedding a document.
+// The page polls the canvas fragment and only swaps the content when it changed.
+var embedTemplate = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>{{.Title}}</title>
+    <style>
+        html, body { margin: 0; padding: 0; background: #fff; }
+    </style>
+</head>
+<body>
+<div id="sketch-canvas">{{.Canvas}}</div>
+<script>
+    (function () {
+        var container = document.getElementById("sketch-canvas");
+        var current = {{.Canvas}};
+        var source = {{.Source}};
+
+        setInterval(function () {
+            fetch(source, {cache: "no-store"})
+                .then(function (r) { return r.ok ? r.text() : Promise.reject(r.status); })
+                .then(function (content) {
+                    if (content !== current) {
+                        current = content;
+                        container.innerHTML = content;
+                    }
+                })
+                .catch(function () {});
+        }, {{.Interval}});
+    })();
+</script>
+</body>
+</html>
+`)) //nolint:gochecknoglobals
+
+type embedPage struct {
+	Title    string
+	Canvas   template.HTML
+	Source   string
+	Interval uint // delay between two refreshes, in milliseconds
+}
+
+func (p *embedPage) render() ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	err := embedTemplate.Execute(buffer, p)
+
+	return buffer.Bytes(), err
+}
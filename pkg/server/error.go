@@ -0,0 +1,7 @@
+package server
+
+type Error string
+
+func (s Error) Error() string {
+	return string(s)
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	headerETag        = "ETag"
+	headerIfMatch     = "If-Match"
+	headerIfNoneMatch = "If-None-Match"
+)
+
+const RevisionMismatch = Error("the document revision doesn't match If-Match")
+
+// documentETag returns the entity tag of a representation of a document revision.
+// Representations other than JSON get their own tag since they are served under the same URL.
+func documentETag(revision uint64, format string) string {
+	tag := strconv.FormatUint(revision, 10)
+	if format != formatJSON {
+		tag += "-" + format
+	}
+
+	return `"` + tag + `"`
+}
+
+// etagRevision returns the document revision an entity tag was computed from.
+func etagRevision(etag string) (uint64, bool) {
+	tag := strings.TrimPrefix(etag, "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+
+	tag = tag[1 : len(tag)-1]
+	if i := strings.IndexByte(tag, '-'); i >= 0 {
+		tag = tag[:i]
+	}
+
+	revision, err := strconv.ParseUint(tag, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return revision, true
+}
+
+// hasIfMatch reports whether the request is conditioned by an If-Match header.
+func hasIfMatch(r *http.Request) bool {
+	return r.Header.Get(headerIfMatch) != ""
+}
+
+// ifMatch reports whether the If-Match header of a request, if any, matches a document revision.
+// Changes conditioned by If-Match apply to the revision the client has seen, whatever its representation,
+// so only the revision part of the entity tags is compared. Weak tags never match.
+func ifMatch(r *http.Request, revision uint64) bool {
+	header := r.Header.Get(headerIfMatch)
+	if header == "" {
+		return true
+	}
+
+	for _, etag := range splitETags(header) {
+		if etag == "*" {
+			return true
+		}
+
+		if strings.HasPrefix(etag, "W/") {
+			continue
+		}
+
+		if rev, ok := etagRevision(etag); ok && rev == revision {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ifNoneMatch reports whether the If-None-Match header of a request matches the entity tag of a representation,
+// in which case the client already holds it.
+func ifNoneMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get(headerIfNoneMatch)
+	if header == "" {
+		return false
+	}
+
+	for _, tag := range splitETags(header) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func splitETags(header string) []string {
+	etags := strings.Split(header, ",")
+	for i := range etags {
+		etags[i] = strings.TrimSpace(etags[i])
+	}
+
+	return etags
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/gorilla/mux"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+	"github.com/hexbee-net/sketch-canvas/pkg/datastore"
+)
+
+// paramTTL is the query parameter setting the lifetime of a new document, in seconds.
+const paramTTL = "ttl"
+
+// maxTTL is the longest lifetime of a document, in seconds, so that it fits in a time.Duration.
+const maxTTL = math.MaxInt64 / int64(time.Second)
+
+const (
+	InvalidTTL = Error("the ttl must be a number of seconds, 0 to keep the document forever")
+	MissingTTL = Error("ttl is required")
+)
+
+// parseTTL converts a lifetime in seconds to a retention.
+func parseTTL(ttl int64) (time.Duration, error) {
+	if ttl < 0 || ttl > maxTTL {
+		return 0, InvalidTTL
+	}
+
+	return time.Duration(ttl) * time.Second, nil
+}
+
+// retention returns the retention of a document created by a request: the ttl query parameter,
+// or the default retention of the store if it is missing.
+func (s *Server) retention(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get(paramTTL)
+	if value == "" {
+		return s.storeOptions.Retention, nil
+	}
+
+	ttl, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, InvalidTTL
+	}
+
+	return parseTTL(ttl)
+}
+
+// remainingTTL returns the number of seconds left before a document expires, nil if it never expires.
+func remainingTTL(doc *canvas.Canvas, now time.Time) *int64 {
+	if doc.Expires.IsZero() {
+		return nil
+	}
+
+	ttl := int64(doc.Expires.Sub(now).Seconds())
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	return &ttl
+}
+
+// setDocumentTTL sets the lifetime of a document from now, extending or shortening it.
+// A ttl of 0 keeps the document forever.
+func (s *Server) setDocumentTTL(w http.ResponseWriter, r *http.Request) {
+	type ttlRequest struct {
+		TTL *int64 `json:"ttl"`
+	}
+
+	var (
+		req    ttlRequest
+		store  = s.getStore(r)
+		vars   = mux.Vars(r)
+		docID  = vars["id"]
+		reqLog = log.
+			WithField("operation-id", "set-doc-ttl").
+			WithField("request-id", s.getRequestID(r)).
+			WithField("doc-id", docID)
+	)
+
+	reqLog.Debug("received set document ttl request")
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		reqLog.WithField("body", r.Body).WithError(err).Infof("failed to decode request body")
+		s.writeProblem(w, r, http.StatusBadRequest, err)
+
+		return
+	}
+
+	if req.TTL == nil {
+		reqLog.Infof("ttl is not specified")
+		s.writeProblem(w, r, http.StatusBadRequest, MissingTTL)
+
+		return
+	}
+
+	retention, err := parseTTL(*req.TTL)
+	if err != nil {
+		reqLog.WithError(err).Infof("invalid ttl")
+		s.writeProblem(w, r, http.StatusBadRequest, err)
+
+		return
+	}
+
+	doc, err := store.GetDocument(docID, r.Context())
+	if err != nil {
+		switch err {
+		case datastore.NotFound:
+			reqLog.Info("document not found")
+			s.writeProblem(w, r, http.StatusNotFound, datastore.NotFound)
+		case err:
+			reqLog.WithError(err).Error("failed to retrieve document from store")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
+		}
+
+		return
+	}
+
+	if !s.checkIfMatch(w, r, doc.Revision, reqLog) {
+		return
+	}
+
+	now := time.Now().UTC()
+	doc.Retention = retention
+	doc.Touch(now)
+
+	if err := store.CompareAndSwapDocument(docID, doc.Revision, doc, r.Context()); err != nil {
+		s.writeUpdateError(w, r, err, reqLog)
+
+		return
+	}
+
+	// The revision produced by the change is recorded like any other, so that the history has no gap.
+	s.recordOperation(w, r, store, docID, canvas.NewSnapshot(doc), doc, reqLog)
+
+	reqLog.WithField("ttl", *req.TTL).Infof("document ttl set")
+
+	data, err := jsonMarshal(struct {
+		TTL    *int64 `json:"ttl,omitempty"`
+		Canvas *canvas.Canvas
+	}{
+		TTL:    remainingTTL(doc, now),
+		Canvas: doc,
+	})
+	if err != nil {
+		reqLog.WithError(err).Error("failed to marshal response to json")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(headerETag, documentETag(doc.Revision, formatJSON))
+
+	if _, err := w.Write(data); err != nil {
+		reqLog.WithError(err).Error("failed to write http response")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+	}
+}
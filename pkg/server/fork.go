@@ -0,0 +1,229 @@
+package server
+
+import (
+	"net/http"
+	"path"
+
+	"github.com/apex/log"
+	"github.com/gorilla/mux"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+	"github.com/hexbee-net/sketch-canvas/pkg/datastore"
+)
+
+const NotAFork = Error("the document is not a fork")
+
+func (s *Server) forkDocument(w http.ResponseWriter, r *http.Request) {
+	var (
+		store  = s.getStore(r)
+		vars   = mux.Vars(r)
+		docID  = vars["id"]
+		reqLog = log.
+			WithField("operation-id", "fork-doc").
+			WithField("request-id", s.getRequestID(r)).
+			WithField("doc-id", docID)
+	)
+
+	reqLog.Debug("received fork document request")
+
+	revision, past, err := parseRevision(r)
+	if err != nil {
+		reqLog.WithError(err).Info("invalid revision")
+		s.writeProblem(w, r, http.StatusBadRequest, err)
+
+		return
+	}
+
+	retention, err := s.retention(r)
+	if err != nil {
+		reqLog.WithError(err).Infof("invalid ttl")
+		s.writeProblem(w, r, http.StatusBadRequest, err)
+
+		return
+	}
+
+	original, err := store.GetDocument(docID, r.Context())
+	if err != nil {
+		switch err {
+		case datastore.NotFound:
+			reqLog.Info("document not found")
+			s.writeProblem(w, r, http.StatusNotFound, datastore.NotFound)
+		case err:
+			reqLog.WithError(err).Error("failed to retrieve document from store")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
+		}
+
+		return
+	}
+
+	if !past {
+		revision = original.Revision
+	}
+
+	source, err := documentAt(store, docID, original, revision, r.Context())
+	if err != nil {
+		switch err {
+		case canvas.UnknownRevision:
+			reqLog.WithField("revision", revision).Info("revision not found")
+			s.writeProblem(w, r, http.StatusNotFound, err)
+		case err:
+			reqLog.WithError(err).Error("failed to rebuild document revision")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
+		}
+
+		return
+	}
+
+	forkID := s.keygen.Generate()
+	doc := source.Clone()
+	doc.Revision = 0
+	doc.Parent = &canvas.Parent{ID: docID, Revision: revision}
+	doc.Retention = retention
+	setCreated(doc, r)
+
+	if err := store.SetDocument(forkID, doc, r.Context()); err != nil {
+		reqLog.WithError(err).Error("failed to set document in store")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+
+		return
+	}
+
+	s.recordOperation(w, r, store, forkID, canvas.NewSnapshot(doc), doc, reqLog)
+
+	reqLog.
+		WithField("fork-id", forkID).
+		WithField("revision", revision).
+		Infof("document forked")
+
+	w.WriteHeader(http.StatusCreated)
+
+	if _, err := w.Write([]byte(path.Join("/v1/docs", forkID))); err != nil {
+		reqLog.WithField("doc-key", forkID).WithError(err).Error("failed to write http response")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+	}
+}
+
+// mergeDocument applies the changes of a fork since it was forked to its parent, see canvas.ThreeWayMerge.
+// The parent is only updated if some cells were merged, the conflicts are reported without failing the request.
+func (s *Server) mergeDocument(w http.ResponseWriter, r *http.Request) {
+	var (
+		store  = s.getStore(r)
+		vars   = mux.Vars(r)
+		docID  = vars["id"]
+		reqLog = log.
+			WithField("operation-id", "merge-doc").
+			WithField("request-id", s.getRequestID(r)).
+			WithField("doc-id", docID)
+	)
+
+	reqLog.Debug("received merge document request")
+
+	fork, err := store.GetDocument(docID, r.Context())
+	if err != nil {
+		switch err {
+		case datastore.NotFound:
+			reqLog.Info("document not found")
+			s.writeProblem(w, r, http.StatusNotFound, datastore.NotFound)
+		case err:
+			reqLog.WithError(err).Error("failed to retrieve document from store")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
+		}
+
+		return
+	}
+
+	if fork.Parent == nil {
+		reqLog.Info("document is not a fork")
+		s.writeProblem(w, r, http.StatusConflict, NotAFork)
+
+		return
+	}
+
+	parentID := fork.Parent.ID
+	reqLog = reqLog.WithField("parent-id", parentID)
+
+	parent, err := store.GetDocument(parentID, r.Context())
+	if err != nil {
+		switch err {
+		case datastore.NotFound:
+			reqLog.Info("parent document not found")
+			s.writeProblem(w, r, http.StatusNotFound, datastore.NotFound)
+		case err:
+			reqLog.WithError(err).Error("failed to retrieve document from store")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
+		}
+
+		return
+	}
+
+	base, err := documentAt(store, parentID, parent, fork.Parent.Revision, r.Context())
+	if err != nil {
+		switch err {
+		case canvas.UnknownRevision:
+			reqLog.WithField("revision", fork.Parent.Revision).Info("fork revision not found in the parent history")
+			s.writeProblem(w, r, http.StatusNotFound, err)
+		case err:
+			reqLog.WithError(err).Error("failed to rebuild document revision")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
+		}
+
+		return
+	}
+
+	merge, err := canvas.ThreeWayMerge(base, parent, fork)
+	if err != nil {
+		switch err {
+		case canvas.SizeMismatch:
+			reqLog.WithError(err).Info("failed to merge fork")
+			s.writeProblem(w, r, http.StatusConflict, err)
+		case err:
+			reqLog.WithError(err).Warn("malformed document")
+			s.writeProblem(w, r, http.StatusUnprocessableEntity, err)
+		}
+
+		return
+	}
+
+	doc := parent
+
+	if merge.Merged > 0 {
+		doc = merge.Canvas
+		setReplaced(doc, parent)
+
+		if err := store.CompareAndSwapDocument(parentID, parent.Revision, doc, r.Context()); err != nil {
+			s.writeUpdateError(w, r, err, reqLog)
+
+			return
+		}
+
+		s.recordOperation(w, r, store, parentID, canvas.NewMerge(doc, canvas.Parent{ID: docID, Revision: fork.Revision}), doc, reqLog)
+	}
+
+	reqLog.
+		WithField("merged", merge.Merged).
+		WithField("conflicts", len(merge.Conflicts)).
+		Infof("fork merged")
+
+	data, err := jsonMarshal(struct {
+		From diffSide `json:"from"`
+		Into diffSide `json:"into"`
+		*canvas.Merge
+	}{
+		From:  newDiffSide(docID, fork),
+		Into:  newDiffSide(parentID, doc),
+		Merge: merge,
+	})
+	if err != nil {
+		reqLog.WithError(err).Error("failed to marshal response to json")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, err := w.Write(data); err != nil {
+		reqLog.WithError(err).Error("failed to write http response")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+	}
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+	"github.com/hexbee-net/sketch-canvas/pkg/formats"
+)
+
+// formatJSON is the native representation of documents, handled by the server itself
+// since its responses carry the operations available on the document.
+const formatJSON = "json"
+
+const (
+	mediaTypeJSON      = "application/json"
+	mediaTypeMultipart = "multipart/form-data"
+)
+
+// uploadField is the name of the form field holding the file in multipart uploads.
+const uploadField = "file"
+
+// maxDocumentSize is the maximum size of the body of a request sending a document. It holds the JSON
+// representation of a canvas of canvas.MaxTextCells cells with its color layer.
+const maxDocumentSize = 16 << 20
+
+// headerAccept lists the media types of the representations accepted by the client.
+const headerAccept = "Accept"
+
+const (
+	UnsupportedFormat = Error("unsupported format")
+	NotAcceptable     = Error("none of the accepted media types is supported")
+	DocumentTooLarge  = Error("the document is too large")
+)
+
+// responseFormat returns the name of the document representation requested by the client,
+// either JSON or one of the formats package. The `format` query parameter takes precedence
+// over the Accept header, and JSON is used when the client doesn't express any preference.
+// NotAcceptable is returned when the Accept header lists none of the representations.
+func responseFormat(r *http.Request) (string, error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		if name == formatJSON {
+			return formatJSON, nil
+		}
+
+		if f, ok := formats.Lookup(name); ok && f.CanEncode() {
+			return f.Name, nil
+		}
+
+		return "", UnsupportedFormat
+	}
+
+	accept := r.Header.Get(headerAccept)
+	if accept == "" {
+		return formatJSON, nil
+	}
+
+	for _, mediaType := range parseAccept(accept) {
+		switch mediaType {
+		case "*/*", "application/*", mediaTypeJSON:
+			return formatJSON, nil
+		}
+
+		if f, ok := formats.ByMediaType(mediaType); ok && f.CanEncode() {
+			return f.Name, nil
+		}
+	}
+
+	return "", NotAcceptable
+}
+
+// readDocument decodes the document sent in the body of a request.
+// The body is either the document itself, in the representation given by its Content-Type header,
+// or a multipart form with the document in its `file` field, in the representation given by the
+// extension of the file name. The `name` query parameter overrides the name of the document,
+// uploaded files without a name in their content are named after the file.
+// The layers, description and tags of the document are validated. Bodies larger than maxDocumentSize
+// are rejected with DocumentTooLarge.
+func readDocument(w http.ResponseWriter, r *http.Request) (*canvas.Canvas, error) {
+	r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, maxDocumentSize), remaining: maxDocumentSize}
+
+	var (
+		body        io.Reader = r.Body
+		decode                = decodeJSON
+		defaultName string
+	)
+
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid content type: %w", err)
+		}
+
+		switch mediaType {
+		case mediaTypeJSON:
+			// Native representation, decoded by default.
+		case mediaTypeMultipart:
+			file, header, err := r.FormFile(uploadField)
+			if err != nil {
+				return nil, xerrors.Errorf("failed to read uploaded file: %w", err)
+			}
+
+			defer func() { _ = file.Close() }()
+
+			ext := filepath.Ext(header.Filename)
+
+			if decode, err = extensionDecoder(ext); err != nil {
+				return nil, err
+			}
+
+			body = file
+			defaultName = strings.TrimSuffix(filepath.Base(header.Filename), ext)
+		default:
+			f, ok := formats.ByMediaType(mediaType)
+			if !ok || !f.CanDecode() {
+				return nil, UnsupportedFormat
+			}
+
+			decode = f.Decode
+		}
+	}
+
+	doc, err := decode(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if name := r.URL.Query().Get("name"); name != "" {
+		doc.Name = name
+	} else if doc.Name == "" {
+		doc.Name = defaultName
+	}
+
+	if err := doc.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := validateMetadata(doc); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// extensionDecoder returns the decoder of uploaded files with the given extension.
+func extensionDecoder(ext string) (formats.DecodeFunc, error) {
+	if strings.EqualFold(ext, "."+formatJSON) {
+		return decodeJSON, nil
+	}
+
+	f, ok := formats.ByExtension(ext)
+	if !ok || !f.CanDecode() {
+		return nil, UnsupportedFormat
+	}
+
+	return f.Decode, nil
+}
+
+func decodeJSON(r io.Reader) (*canvas.Canvas, error) {
+	doc := &canvas.Canvas{}
+	if err := json.NewDecoder(r).Decode(doc); err != nil {
+		return nil, xerrors.Errorf("failed to decode json document: %w", err)
+	}
+
+	return doc, nil
+}
+
+// limitedBody reports the error of a body read through http.MaxBytesReader as DocumentTooLarge
+// once remaining, the limit of the reader, has been read.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+
+	if err != nil && err != io.EOF && b.remaining <= 0 {
+		return n, DocumentTooLarge
+	}
+
+	return n, err //nolint:wrapcheck
+}
+
+// decodeErrorStatus returns the HTTP status code matching a readDocument error.
+func decodeErrorStatus(err error) int {
+	switch {
+	case xerrors.Is(err, UnsupportedFormat):
+		return http.StatusUnsupportedMediaType
+	case xerrors.Is(err, DocumentTooLarge), xerrors.Is(err, canvas.ObjectTooLarge):
+		return http.StatusRequestEntityTooLarge
+	}
+
+	return http.StatusBadRequest
+}
+
+// parseAccept returns the media types listed in an Accept header, ordered by preference.
+func parseAccept(header string) []string {
+	type entry struct {
+		mediaType string
+		quality   float64
+	}
+
+	var entries []entry
+
+	for _, v := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(v))
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+
+		if quality > 0 {
+			entries = append(entries, entry{mediaType: mediaType, quality: quality})
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].quality > entries[j].quality
+	})
+
+	mediaTypes := make([]string, 0, len(entries))
+	for _, e := range entries {
+		mediaTypes = append(mediaTypes, e.mediaType)
+	}
+
+	return mediaTypes
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+	"github.com/hexbee-net/sketch-canvas/pkg/datastore"
+)
+
+// headerCaller identifies the caller of a request, recorded as the creator of the documents it creates.
+const headerCaller = "X-Caller-ID"
+
+// Limits of the metadata of the documents.
+const (
+	maxDescriptionLength = 1024
+	maxTags              = 32
+	maxTagLength         = 64
+)
+
+const (
+	DescriptionTooLong = Error("the description is too long")
+	TooManyTags        = Error("too many tags")
+	InvalidTag         = Error("tags must be 1 to 64 characters long, without spaces, commas or control characters")
+)
+
+// callerID returns the identifier of the caller of a request, empty if unknown.
+func callerID(r *http.Request) string {
+	return strings.TrimSpace(r.Header.Get(headerCaller))
+}
+
+// validateMetadata checks the description and tags of a document, and removes its duplicate tags.
+func validateMetadata(doc *canvas.Canvas) error {
+	if len(doc.Description) > maxDescriptionLength {
+		return DescriptionTooLong
+	}
+
+	if len(doc.Tags) > maxTags {
+		return TooManyTags
+	}
+
+	seen := make(map[string]bool, len(doc.Tags))
+	tags := doc.Tags[:0]
+
+	for _, tag := range doc.Tags {
+		if !validTag(tag) {
+			return InvalidTag
+		}
+
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+
+	doc.Tags = tags
+
+	return nil
+}
+
+func validTag(tag string) bool {
+	if tag == "" || len(tag) > maxTagLength {
+		return false
+	}
+
+	return strings.IndexFunc(tag, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r) || unicode.IsControl(r)
+	}) < 0
+}
+
+// setCreated sets the metadata maintained by the server on a new document, and its expiry from its retention.
+func setCreated(doc *canvas.Canvas, r *http.Request) {
+	now := time.Now().UTC()
+
+	doc.Created = now
+	doc.Creator = callerID(r)
+	doc.Touch(now)
+}
+
+// setReplaced sets the metadata of a document replacing current. The creation metadata, parent and retention are kept,
+// as well as the name, description and tags if the new content doesn't provide them.
+func setReplaced(doc, current *canvas.Canvas) {
+	doc.Created = current.Created
+	doc.Creator = current.Creator
+	doc.Retention = current.Retention
+	doc.Parent = current.Parent
+	doc.Touch(time.Now().UTC())
+
+	if doc.Name == "" {
+		doc.Name = current.Name
+	}
+
+	if doc.Description == "" {
+		doc.Description = current.Description
+	}
+
+	if doc.Tags == nil {
+		doc.Tags = current.Tags
+	}
+}
+
+// documentItem is a document of the document list.
+type documentItem struct {
+	ID          string     `json:"id"`
+	URI         string     `json:"uri"`
+	Name        string     `json:"name,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Creator     string     `json:"creator,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	Created     *time.Time `json:"created,omitempty"`
+	Updated     *time.Time `json:"updated,omitempty"`
+}
+
+func newDocumentItem(info *datastore.DocInfo, uri string) documentItem {
+	item := documentItem{
+		ID:          info.Key,
+		URI:         uri,
+		Name:        info.Name,
+		Description: info.Description,
+		Creator:     info.Creator,
+		Tags:        info.Tags,
+	}
+
+	// Documents created by previous versions have no timestamps.
+	if !info.Created.IsZero() {
+		item.Created = &info.Created
+	}
+
+	if !info.Updated.IsZero() {
+		item.Updated = &info.Updated
+	}
+
+	return item
+}
@@ -25,21 +25,14 @@ func MiddlewareRequestID(next http.Handler) http.Handler {
 	})
 }
 
-func MiddlewareRedisDatastore(storeOptions *datastore.RedisOptions) mux.MiddlewareFunc {
-	if storeOptions == nil {
-		log.Fatal("store options cannot be nil in middleware")
+// MiddlewareDatastore provides the same store instance to every request.
+func MiddlewareDatastore(store datastore.DataStore) mux.MiddlewareFunc {
+	if store == nil {
+		log.Fatal("store cannot be nil in middleware")
 	}
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			store, err := datastore.New(storeOptions, r.Context())
-			if err != nil {
-				log.WithError(err).Error("datastore is unreachable")
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-
-				return
-			}
-
 			ctx := context.WithValue(r.Context(), DatastoreContextKey, store)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/gorilla/schema"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+	"github.com/hexbee-net/sketch-canvas/pkg/datastore"
+)
+
+const mediaTypeProblem = "application/problem+json"
+
+// problemTypePrefix is the prefix of the URI identifying the type of a problem, followed by its code.
+const problemTypePrefix = "urn:sketch-canvas:problem:"
+
+// problem is an RFC 7807 problem details document, the body of the error responses.
+// Code is a stable identifier of the error, Fields the request fields, query parameters or headers it is about.
+// Results and Errors are the outcome of the operations of a failed batch and the errors of an invalid script.
+type problem struct {
+	Type      string        `json:"type"`
+	Title     string        `json:"title"`
+	Status    int           `json:"status"`
+	Detail    string        `json:"detail,omitempty"`
+	Instance  string        `json:"instance"`
+	Code      string        `json:"code"`
+	Fields    []string      `json:"fields,omitempty"`
+	RequestID int64         `json:"requestId"`
+	Results   []opResult    `json:"results,omitempty"`
+	Errors    []scriptError `json:"errors,omitempty"`
+}
+
+// problemKind is the stable code of a known error, and the request fields it is about.
+type problemKind struct {
+	code   string
+	fields []string
+}
+
+// problemKinds are the known errors reported to the clients. The other errors are identified by the status
+// of the response.
+var problemKinds = map[error]problemKind{
+	canvas.PointOutOfBound:  {code: "point-out-of-bound"},
+	canvas.ObjectTooLarge:   {code: "object-too-large"},
+	canvas.BadPattern:       {code: "bad-pattern"},
+	canvas.EmptyContent:     {code: "empty-content"},
+	canvas.PatchMismatch:    {code: "patch-mismatch"},
+	canvas.MalformedData:    {code: "malformed-data", fields: []string{"data"}},
+	canvas.MalformedAttrs:   {code: "malformed-attrs", fields: []string{"attrs"}},
+	canvas.InvalidEncoding:  {code: "invalid-encoding"},
+	canvas.UnknownRevision:  {code: "unknown-revision", fields: []string{paramRevision}},
+	canvas.SizeMismatch:     {code: "size-mismatch"},
+	canvas.UnknownOperation: {code: "unknown-operation"},
+	datastore.NotFound:      {code: "document-not-found"},
+	datastore.InvalidKey:    {code: "invalid-key"},
+	datastore.Conflict:      {code: "revision-conflict"},
+	datastore.UnknownSort:   {code: "unknown-sort", fields: []string{"sort"}},
+	datastore.InvalidCursor: {code: "invalid-cursor", fields: []string{"cursor"}},
+	datastore.Exists:        {code: "document-exists"},
+	UnsupportedFormat:       {code: "unsupported-format", fields: []string{"format"}},
+	NotAcceptable:           {code: "not-acceptable", fields: []string{headerAccept}},
+	DocumentTooLarge:        {code: "document-too-large"},
+	InvalidTTL:              {code: "invalid-ttl", fields: []string{paramTTL}},
+	MissingTTL:              {code: "missing-ttl", fields: []string{paramTTL}},
+	InvalidRevision:         {code: "invalid-revision", fields: []string{paramRevision}},
+	MissingRevision:         {code: "missing-revision", fields: []string{paramRevision}},
+	InvalidDryRun:           {code: "invalid-dry-run", fields: []string{paramDryRun}},
+	InvalidPreview:          {code: "invalid-preview", fields: []string{paramPreview}},
+	DescriptionTooLong:      {code: "description-too-long", fields: []string{"description"}},
+	TooManyTags:             {code: "too-many-tags", fields: []string{"tags"}},
+	InvalidTag:              {code: "invalid-tag", fields: []string{"tags"}},
+	MissingDiffDocuments:    {code: "missing-diff-documents", fields: []string{"a", "b"}},
+	MissingPattern:          {code: "missing-pattern", fields: []string{"fill", "outline"}},
+	MissingFill:             {code: "missing-fill", fields: []string{"fill"}},
+	MissingRect:             {code: "missing-rect", fields: []string{"rect"}},
+	MissingOrigin:           {code: "missing-origin", fields: []string{"origin"}},
+	MissingText:             {code: "missing-text", fields: []string{"text"}},
+	UnsupportedOperation:    {code: "unsupported-operation", fields: []string{"type"}},
+	EmptyBatch:              {code: "empty-batch"},
+	BatchTooLarge:           {code: "batch-too-large"},
+	InvalidBatch:            {code: "invalid-batch"},
+	InvalidScript:           {code: "invalid-script"},
+	NotAFork:                {code: "not-a-fork"},
+	NothingToUndo:           {code: "nothing-to-undo"},
+	NothingToRedo:           {code: "nothing-to-redo"},
+	UnknownCaller:           {code: "unknown-caller", fields: []string{headerCaller}},
+	RevisionMismatch:        {code: "revision-mismatch", fields: []string{headerIfMatch}},
+}
+
+// newProblem returns the problem reported for an error, identified by its first known error in its chain,
+// or by the status of the response. The message of the error is only reported for client errors.
+// Fields are added to the fields of the known error.
+func (s *Server) newProblem(r *http.Request, status int, err error, fields ...string) *problem {
+	p := &problem{
+		Title:     http.StatusText(status),
+		Status:    status,
+		Instance:  r.URL.Path,
+		Code:      strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "-")),
+		RequestID: s.getRequestID(r),
+	}
+
+	if err != nil && status < http.StatusInternalServerError {
+		p.Detail = err.Error()
+	}
+
+	if kind, ok := lookupProblemKind(err); ok {
+		p.Code = kind.code
+		p.Fields = append(p.Fields, kind.fields...)
+	}
+
+	p.Fields = append(p.Fields, fields...)
+	p.Fields = append(p.Fields, decodeErrorFields(err)...)
+	p.Type = problemTypePrefix + p.Code
+
+	return p
+}
+
+// lookupProblemKind returns the kind of the first known error in the chain of err.
+// Only the sentinel errors are looked up, the other errors may not be comparable.
+func lookupProblemKind(err error) (problemKind, bool) {
+	for e := err; e != nil; e = xerrors.Unwrap(e) {
+		switch e.(type) {
+		case Error, canvas.Error, datastore.StoreError:
+			if kind, ok := problemKinds[e]; ok {
+				return kind, true
+			}
+		}
+	}
+
+	return problemKind{}, false
+}
+
+// decodeErrorFields returns the fields of a request body or query that couldn't be decoded.
+func decodeErrorFields(err error) []string {
+	var typeErr *json.UnmarshalTypeError
+	if xerrors.As(err, &typeErr) && typeErr.Field != "" {
+		return []string{typeErr.Field}
+	}
+
+	var multiErr schema.MultiError
+	if xerrors.As(err, &multiErr) {
+		fields := make([]string, 0, len(multiErr))
+		for field := range multiErr {
+			fields = append(fields, field)
+		}
+
+		sort.Strings(fields)
+
+		return fields
+	}
+
+	return nil
+}
+
+// writeProblem writes an error response, see newProblem.
+func (s *Server) writeProblem(w http.ResponseWriter, r *http.Request, status int, err error, fields ...string) {
+	s.writeProblemDetails(w, s.newProblem(r, status, err, fields...))
+}
+
+// writeProblemDetails writes an error response with the given problem.
+func (s *Server) writeProblemDetails(w http.ResponseWriter, p *problem) {
+	data, err := jsonMarshal(p)
+	if err != nil {
+		log.WithError(err).Error("failed to marshal problem to json")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaTypeProblem)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+
+	if _, err := w.Write(data); err != nil {
+		log.WithError(err).Error("failed to write http response")
+	}
+}
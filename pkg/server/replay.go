@@ -0,0 +1,42 @@
+package server
+
+import (
+	"io"
+	"time"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+	"github.com/hexbee-net/sketch-canvas/pkg/replay"
+)
+
+// Formats of the document replays.
+const (
+	replayGIF  = "gif"
+	replayCast = "cast"
+)
+
+const (
+	// DefaultReplayDelay is the time in milliseconds each operation is displayed in a replay.
+	DefaultReplayDelay = uint(replay.DefaultDelay / time.Millisecond)
+	MinReplayDelay     = 10
+)
+
+// replayEncoder writes the frames of a replay in one of the supported formats.
+type replayEncoder struct {
+	contentType string
+	encode      func(w io.Writer, frames []replay.Frame, delay time.Duration, doc *canvas.Canvas) error
+}
+
+var replayEncoders = map[string]replayEncoder{ //nolint:gochecknoglobals
+	replayGIF: {
+		contentType: "image/gif",
+		encode: func(w io.Writer, frames []replay.Frame, delay time.Duration, _ *canvas.Canvas) error {
+			return replay.EncodeGIF(w, frames, delay)
+		},
+	},
+	replayCast: {
+		contentType: "application/x-asciicast",
+		encode: func(w io.Writer, frames []replay.Frame, delay time.Duration, doc *canvas.Canvas) error {
+			return replay.EncodeCast(w, frames, delay, doc.Name)
+		},
+	},
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/gorilla/mux"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+	"github.com/hexbee-net/sketch-canvas/pkg/datastore"
+)
+
+// paramRevision is the query parameter selecting a past revision of a document.
+const paramRevision = "rev"
+
+// checkpointInterval is the number of revisions between two checkpoints of the content of a document in its journal.
+const checkpointInterval = 50
+
+// historyLength is the number of past revisions kept in the journal of a document. The operations only needed by
+// older revisions are trimmed when a checkpoint is recorded.
+const historyLength = 1000
+
+// headerWarning flags the responses of the updates whose revision couldn't be recorded in the journal.
+const headerWarning = "Warning"
+
+const (
+	InvalidRevision = Error("rev must be a revision number")
+	MissingRevision = Error("rev is required")
+)
+
+// revisionItem is an entry of the history of a document, with the URI of its content at this revision.
+type revisionItem struct {
+	Revision uint64            `json:"revision"`
+	Type     string            `json:"type"`
+	Time     time.Time         `json:"time"`
+	Caller   string            `json:"caller,omitempty"`
+	Rect     *canvas.Rectangle `json:"rect,omitempty"`
+	Origin   *canvas.Point     `json:"origin,omitempty"`
+	Fill     string            `json:"fill,omitempty"`
+	Outline  string            `json:"outline,omitempty"`
+	Source   *uint64           `json:"source,omitempty"`
+	Fork     *canvas.Parent    `json:"fork,omitempty"`
+	Href     string            `json:"href"`
+}
+
+// parseRevision returns the revision selected by the rev query parameter, false if there is none.
+func parseRevision(r *http.Request) (uint64, bool, error) {
+	value := r.URL.Query().Get(paramRevision)
+	if value == "" {
+		return 0, false, nil
+	}
+
+	revision, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false, InvalidRevision
+	}
+
+	return revision, true, nil
+}
+
+// documentAt returns a document as it was at a revision, rebuilt from its journal unless doc is at this revision.
+// It returns canvas.UnknownRevision if the document never was at this revision, or if its history doesn't reach it.
+func documentAt(store datastore.DataStore, docID string, doc *canvas.Canvas, revision uint64, ctx context.Context) (*canvas.Canvas, error) {
+	if revision == doc.Revision {
+		return doc, nil
+	}
+
+	if revision > doc.Revision {
+		return nil, canvas.UnknownRevision
+	}
+
+	ops, err := store.GetOperationsFrom(docID, revision, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return canvas.AtRevision(ops, revision)
+}
+
+func (s *Server) getRevisions(w http.ResponseWriter, r *http.Request) {
+	var (
+		store  = s.getStore(r)
+		vars   = mux.Vars(r)
+		docID  = vars["id"]
+		docURL = path.Dir(r.URL.Path)
+		reqLog = log.
+			WithField("operation-id", "get-revisions").
+			WithField("request-id", s.getRequestID(r)).
+			WithField("doc-id", docID)
+	)
+
+	reqLog.Debug("received get revisions request")
+
+	// The journal of a missing document is empty, check the document exists to report it.
+	if _, err := store.GetDocument(docID, r.Context()); err != nil {
+		switch err {
+		case datastore.NotFound:
+			reqLog.Info("document not found")
+			s.writeProblem(w, r, http.StatusNotFound, datastore.NotFound)
+		case err:
+			reqLog.WithError(err).Error("failed to retrieve document from store")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
+		}
+
+		return
+	}
+
+	ops, err := store.GetOperations(docID, r.Context())
+	if err != nil {
+		reqLog.WithError(err).Error("failed to retrieve operations from store")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+
+		return
+	}
+
+	history := canvas.History(ops)
+	items := make([]revisionItem, 0, len(history))
+
+	for _, op := range history {
+		revision, _ := op.ProducedRevision()
+
+		items = append(items, revisionItem{
+			Revision: revision,
+			Type:     op.Type,
+			Time:     op.Time,
+			Caller:   op.Caller,
+			Rect:     op.Rect,
+			Origin:   op.Origin,
+			Fill:     op.Fill,
+			Outline:  op.Outline,
+			Source:   op.Source,
+			Fork:     op.Fork,
+			Href:     docURL + "?" + paramRevision + "=" + strconv.FormatUint(revision, 10),
+		})
+	}
+
+	data, err := jsonMarshal(struct {
+		Count int            `json:"count"`
+		Items []revisionItem `json:"items"`
+	}{
+		Count: len(items),
+		Items: items,
+	})
+	if err != nil {
+		reqLog.WithError(err).Error("failed to marshal response to json")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, err := w.Write(data); err != nil {
+		reqLog.WithError(err).Error("failed to write http response")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+	}
+}
+
+func (s *Server) revertDocument(w http.ResponseWriter, r *http.Request) {
+	var (
+		store  = s.getStore(r)
+		vars   = mux.Vars(r)
+		docID  = vars["id"]
+		reqLog = log.
+			WithField("operation-id", "revert-doc").
+			WithField("request-id", s.getRequestID(r)).
+			WithField("doc-id", docID)
+	)
+
+	reqLog.Debug("received revert document request")
+
+	revision, ok, err := parseRevision(r)
+	if err == nil && !ok {
+		err = MissingRevision
+	}
+
+	if err != nil {
+		reqLog.WithError(err).Info("invalid revision")
+		s.writeProblem(w, r, http.StatusBadRequest, err)
+
+		return
+	}
+
+	current, err := store.GetDocument(docID, r.Context())
+	if err != nil {
+		switch err {
+		case datastore.NotFound:
+			reqLog.Info("document not found")
+			s.writeProblem(w, r, http.StatusNotFound, datastore.NotFound)
+		case err:
+			reqLog.WithError(err).Error("failed to retrieve document from store")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
+		}
+
+		return
+	}
+
+	if !s.checkIfMatch(w, r, current.Revision, reqLog) {
+		return
+	}
+
+	past, err := documentAt(store, docID, current, revision, r.Context())
+	if err != nil {
+		switch err {
+		case canvas.UnknownRevision:
+			reqLog.WithField("revision", revision).Info("revision not found")
+			s.writeProblem(w, r, http.StatusNotFound, err)
+		case err:
+			reqLog.WithError(err).Error("failed to rebuild document revision")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
+		}
+
+		return
+	}
+
+	doc := past.Clone()
+	setReplaced(doc, current)
+
+	if err := store.CompareAndSwapDocument(docID, current.Revision, doc, r.Context()); err != nil {
+		s.writeUpdateError(w, r, err, reqLog)
+
+		return
+	}
+
+	s.recordOperation(w, r, store, docID, canvas.NewRevert(doc, revision), doc, reqLog)
+
+	reqLog.WithField("revision", revision).Infof("document reverted")
+
+	data, err := jsonMarshal(doc)
+	if err != nil {
+		reqLog.WithError(err).Error("failed to marshal response to json")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(headerETag, documentETag(doc.Revision, formatJSON))
+
+	if _, err := w.Write(data); err != nil {
+		reqLog.WithError(err).Error("failed to write http response")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+	}
+}
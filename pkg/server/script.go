@@ -0,0 +1,129 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/apex/log"
+	"github.com/gorilla/mux"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+	"github.com/hexbee-net/sketch-canvas/pkg/script"
+)
+
+const InvalidScript = Error("the script holds invalid commands")
+
+// scriptError is an error found in a script.
+type scriptError struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+// runScript applies the commands of a script to a document, like a batch of operations.
+func (s *Server) runScript(w http.ResponseWriter, r *http.Request) {
+	var (
+		store  = s.getStore(r)
+		vars   = mux.Vars(r)
+		docID  = vars["id"]
+		reqLog = log.
+			WithField("operation-id", "run-script").
+			WithField("request-id", s.getRequestID(r)).
+			WithField("doc-id", docID)
+	)
+
+	reqLog.Debug("received run script request")
+
+	dryRun, preview, err := parseDryRun(r)
+	if err != nil {
+		reqLog.WithError(err).Info("invalid dry run")
+		s.writeProblem(w, r, http.StatusBadRequest, err)
+
+		return
+	}
+
+	sc, err := script.Parse(r.Body)
+	if err != nil {
+		var errs script.ErrorList
+		if !xerrors.As(err, &errs) {
+			reqLog.WithError(err).Info("failed to read request body")
+			s.writeProblem(w, r, http.StatusBadRequest, err)
+
+			return
+		}
+
+		reqLog.WithField("errors", len(errs)).Info("invalid script")
+		s.writeScriptErrors(w, r, errs)
+
+		return
+	}
+
+	switch {
+	case len(sc.Commands) == 0:
+		reqLog.Info("no command in the script")
+		s.writeProblem(w, r, http.StatusBadRequest, EmptyBatch)
+
+		return
+	case len(sc.Commands) > maxBatchOperations:
+		reqLog.WithField("count", len(sc.Commands)).Info("too many commands in the script")
+		s.writeProblem(w, r, http.StatusBadRequest, BatchTooLarge)
+
+		return
+	}
+
+	if dryRun {
+		lines := make([]int, len(sc.Commands))
+		for i, cmd := range sc.Commands {
+			lines[i] = cmd.Line
+		}
+
+		s.previewOperations(w, r, store, docID, sc.Operations(), lines, preview, reqLog)
+
+		return
+	}
+
+	revision, ok := s.expectedRevision(w, r, store, docID, reqLog)
+	if !ok {
+		return
+	}
+
+	batch := canvas.NewBatch(sc.Operations())
+
+	doc, results, err := s.applyBatch(r, store, docID, batch, revision)
+
+	for i := range results {
+		results[i].Line = sc.Commands[i].Line
+	}
+
+	if err != nil {
+		if results != nil {
+			reqLog.WithError(err).Info("failed to run the script")
+			s.writeBatchProblem(w, r, err, results)
+
+			return
+		}
+
+		s.writeUpdateError(w, r, err, reqLog)
+
+		return
+	}
+
+	s.recordOperation(w, r, store, docID, batch, doc, reqLog)
+
+	reqLog.WithField("count", len(sc.Commands)).Infof("script applied")
+
+	w.Header().Set(headerETag, documentETag(doc.Revision, formatJSON))
+	s.writeBatchResponse(w, r, batchResponse{Results: results, Canvas: doc}, reqLog)
+}
+
+// writeScriptErrors writes the response of an invalid script, with the position of its errors.
+func (s *Server) writeScriptErrors(w http.ResponseWriter, r *http.Request, errs script.ErrorList) {
+	p := s.newProblem(r, http.StatusBadRequest, InvalidScript)
+
+	p.Errors = make([]scriptError, len(errs))
+	for i, err := range errs {
+		p.Errors[i] = scriptError{Line: err.Line, Column: err.Column, Message: err.Err.Error()}
+	}
+
+	s.writeProblemDetails(w, p)
+}
@@ -5,7 +5,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"html/template"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path"
@@ -19,7 +21,9 @@ import (
 
 	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
 	"github.com/hexbee-net/sketch-canvas/pkg/datastore"
+	"github.com/hexbee-net/sketch-canvas/pkg/formats"
 	"github.com/hexbee-net/sketch-canvas/pkg/keygen"
+	"github.com/hexbee-net/sketch-canvas/pkg/replay"
 )
 
 type contextKey int
@@ -42,25 +46,34 @@ type Server struct {
 	port         int
 	srv          *http.Server
 	router       *mux.Router
-	storeOptions *datastore.RedisOptions
+	storeOptions *datastore.Options
+	store        datastore.DataStore
 	keygen       keygen.KeyGen
 }
 
-func New(port int, storeOptions *datastore.RedisOptions) (*Server, error) {
+// New creates a server using the datastore selected by storeOptions.
+// The datastore is shared by all the requests and closed when the server shuts down.
+func New(port int, storeOptions *datastore.Options) (*Server, error) {
 	keyGen, err := keygen.New()
 	if err != nil {
 		return nil, xerrors.Errorf("failed to instantiate key generator: %w", err)
 	}
 
+	store, err := datastore.Open(storeOptions)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to set up datastore: %w", err)
+	}
+
 	s := Server{
 		port:         port,
 		storeOptions: storeOptions,
+		store:        store,
 		router:       mux.NewRouter(),
 		keygen:       keyGen,
 	}
 	s.router.Use(MiddlewareRequestID)
 
-	s.setupRoutes(s.router, MiddlewareRedisDatastore(s.storeOptions))
+	s.setupRoutes(s.router, MiddlewareDatastore(s.store))
 
 	s.srv = &http.Server{
 		Addr:         fmt.Sprintf("0.0.0.0:%d", s.port),
@@ -80,15 +93,34 @@ func (s *Server) setupRoutes(router *mux.Router, datastoreMiddleware mux.Middlew
 	v1.HandleFunc("/docs/", s.getDocumentList).Methods(http.MethodGet)
 	v1.HandleFunc("/docs/", s.createDocument).Methods(http.MethodPost)
 	v1.HandleFunc("/docs/{id}", s.getDocument).Methods(http.MethodGet)
+	v1.HandleFunc("/docs/{id}", s.replaceDocument).Methods(http.MethodPut)
 	v1.HandleFunc("/docs/{id}", s.deleteDocument).Methods(http.MethodDelete)
 	v1.HandleFunc("/docs/{id}/rect", s.addRectangle).Methods(http.MethodPost)
 	v1.HandleFunc("/docs/{id}/fill", s.addFloodFill).Methods(http.MethodPost)
+	v1.HandleFunc("/docs/{id}/ops", s.applyOperationBatch).Methods(http.MethodPost)
+	v1.HandleFunc("/docs/{id}/script", s.runScript).Methods(http.MethodPost)
+	v1.HandleFunc("/docs/{id}/undo", s.undoOperation).Methods(http.MethodPost)
+	v1.HandleFunc("/docs/{id}/redo", s.redoOperation).Methods(http.MethodPost)
+	v1.HandleFunc("/docs/{id}/revisions", s.getRevisions).Methods(http.MethodGet)
+	v1.HandleFunc("/docs/{id}/revert", s.revertDocument).Methods(http.MethodPost)
+	v1.HandleFunc("/docs/{id}/diff", s.getDocumentDiff).Methods(http.MethodGet)
+	v1.HandleFunc("/docs/{id}/fork", s.forkDocument).Methods(http.MethodPost)
+	v1.HandleFunc("/docs/{id}/merge", s.mergeDocument).Methods(http.MethodPost)
+	v1.HandleFunc("/docs/{id}/ttl", s.setDocumentTTL).Methods(http.MethodPut)
+	v1.HandleFunc("/docs/{id}/embed", s.getDocumentEmbed).Methods(http.MethodGet)
+	v1.HandleFunc("/docs/{id}/replay", s.getDocumentReplay).Methods(http.MethodGet)
+	v1.HandleFunc("/diff", s.getDiff).Methods(http.MethodGet)
+	v1.HandleFunc("/trash/", s.getTrash).Methods(http.MethodGet)
+	v1.HandleFunc("/trash/{id}", s.purgeDocument).Methods(http.MethodDelete)
+	v1.HandleFunc("/trash/{id}/restore", s.restoreDocument).Methods(http.MethodPost)
 	v1.Use(datastoreMiddleware)
 }
 
 func (s *Server) Start(ctx context.Context) {
-	if _, err := datastore.New(s.storeOptions, context.Background()); err != nil {
-		log.Warnf("failed to connect to Redis instance at %s", s.storeOptions.Addr)
+	if store, ok := s.store.(*datastore.RedisDataStore); ok {
+		if err := store.Ping(context.Background()); err != nil {
+			log.WithError(err).Warnf("failed to connect to Redis instance at %s", s.storeOptions.Redis.Addr)
+		}
 	}
 
 	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C)
@@ -114,8 +146,17 @@ func (s *Server) Start(ctx context.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), wait)
 	defer cancel()
 
+	s.shutdown(ctx)
+}
+
+// shutdown stops the http server and closes the datastore once the pending requests are done.
+func (s *Server) shutdown(ctx context.Context) {
 	// Doesn't block if no connections, but will otherwise wait until the timeout deadline.
 	_ = s.srv.Shutdown(ctx)
+
+	if err := s.store.Close(); err != nil {
+		log.WithError(err).Error("failed to close datastore")
+	}
 }
 
 func (s *Server) getVersions(w http.ResponseWriter, r *http.Request) {
@@ -144,7 +185,7 @@ func (s *Server) getVersions(w http.ResponseWriter, r *http.Request) {
 	data, err := jsonMarshal(versions)
 	if err != nil {
 		reqLog.WithError(err).Error("failed to marshal response to json")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
 
 		return
 	}
@@ -153,7 +194,7 @@ func (s *Server) getVersions(w http.ResponseWriter, r *http.Request) {
 
 	if _, err := w.Write(data); err != nil {
 		reqLog.WithError(err).Error("failed to write http response")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
 	}
 }
 
@@ -170,23 +211,25 @@ func (s *Server) getDocumentList(w http.ResponseWriter, r *http.Request) {
 
 	// Parse query parameters
 	req := struct {
-		Cursor uint64 `schema:"q"`
+		Cursor string `schema:"q"`
 		Limit  int64  `schema:"limit"`
+		Sort   string `schema:"sort"`
+		Tag    string `schema:"tag"`
+		Name   string `schema:"name"`
 	}{
-		Cursor: 0,
-		Limit:  DefaultPageLimit,
+		Limit: DefaultPageLimit,
 	}
 
 	if err := r.ParseForm(); err != nil {
 		reqLog.WithError(err).Warnf("invalid request: %s", r.RequestURI)
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		s.writeProblem(w, r, http.StatusBadRequest, err)
 
 		return
 	}
 
 	if err := schema.NewDecoder().Decode(&req, r.Form); err != nil {
 		reqLog.WithError(err).Warnf("invalid request: %s", r.RequestURI)
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		s.writeProblem(w, r, http.StatusBadRequest, err)
 
 		return
 	}
@@ -194,24 +237,39 @@ func (s *Server) getDocumentList(w http.ResponseWriter, r *http.Request) {
 	reqLog.
 		WithField("cursor", req.Cursor).
 		WithField("limit", req.Limit).
+		WithField("sort", req.Sort).
+		WithField("tag", req.Tag).
+		WithField("name", req.Name).
 		Debug("request parameter")
 
-	// Retrieve a page of keys from the store.
-	keys, cursor, err := store.GetDocList(req.Cursor, req.Limit, r.Context())
+	// Retrieve a page of documents from the store.
+	infos, cursor, err := store.ListDocuments(&datastore.ListQuery{
+		Cursor:     req.Cursor,
+		Count:      req.Limit,
+		Sort:       req.Sort,
+		Tag:        req.Tag,
+		NamePrefix: req.Name,
+	}, r.Context())
 	if err != nil {
-		reqLog.WithError(err).Error("failed to get document list from redis store")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		switch err {
+		case datastore.UnknownSort, datastore.InvalidCursor:
+			reqLog.WithError(err).Info("invalid list query")
+			s.writeProblem(w, r, http.StatusBadRequest, err)
+		case err:
+			reqLog.WithError(err).Error("failed to get document list from store")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
+		}
 
 		return
 	}
 
 	var next string
 
-	// If the cursor is not 0, there are more values to retrieve.
+	// If a cursor is returned, there are more documents to retrieve.
 	// Format the URI of the next page.
-	if cursor != 0 {
+	if cursor != "" {
 		queryValues := url.Query()
-		queryValues.Set("q", strconv.FormatUint(cursor, 10))       //nolint: gomnd
+		queryValues.Set("q", cursor)
 		queryValues.Set("limit", strconv.FormatInt(req.Limit, 10)) //nolint: gomnd
 		url.RawQuery = queryValues.Encode()
 		next = url.String()
@@ -224,11 +282,14 @@ func (s *Server) getDocumentList(w http.ResponseWriter, r *http.Request) {
 		reqLog.WithError(err).Error("failed to retrieve number of keys in store")
 	}
 
-	// Convert the list of keys to a map with the URI of each document.
-	//TODO: retrieve the document names instead of using the ID.
-	docs := make(map[string]string, len(keys))
-	for _, k := range keys {
-		docs[k] = path.Join(url.Path, k)
+	// The documents are listed in order in items, and docs maps their ID to their URI.
+	docs := make(map[string]string, len(infos))
+	items := make([]documentItem, 0, len(infos))
+
+	for _, info := range infos {
+		uri := path.Join(url.Path, info.Key)
+		docs[info.Key] = uri
+		items = append(items, newDocumentItem(info, uri))
 	}
 
 	data, err := jsonMarshal(struct {
@@ -236,15 +297,17 @@ func (s *Server) getDocumentList(w http.ResponseWriter, r *http.Request) {
 		Count int               `json:"count"`
 		Total int64             `json:"total,omitempty"`
 		Docs  map[string]string `json:"docs"`
+		Items []documentItem    `json:"items"`
 	}{
 		Next:  next,
-		Count: len(keys),
+		Count: len(infos),
 		Total: dbSize,
 		Docs:  docs,
+		Items: items,
 	})
 	if err != nil {
 		reqLog.WithError(err).Error("failed to marshal response to json")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
 
 		return
 	}
@@ -253,13 +316,12 @@ func (s *Server) getDocumentList(w http.ResponseWriter, r *http.Request) {
 
 	if _, err := w.Write(data); err != nil {
 		reqLog.WithError(err).Error("failed to write http response")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
 	}
 }
 
 func (s *Server) createDocument(w http.ResponseWriter, r *http.Request) {
 	var (
-		doc    = &canvas.Canvas{}
 		store  = s.getStore(r)
 		reqLog = log.
 			WithField("operation-id", "create-doc").
@@ -268,37 +330,49 @@ func (s *Server) createDocument(w http.ResponseWriter, r *http.Request) {
 
 	reqLog.Debug("received create document request")
 
-	if err := json.NewDecoder(r.Body).Decode(doc); err != nil {
+	doc, err := readDocument(w, r)
+	if err != nil {
 		reqLog.WithField("body", r.Body).WithError(err).Infof("failed to decode request body")
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		s.writeProblem(w, r, decodeErrorStatus(err), err)
+
+		return
+	}
+
+	if doc.Retention, err = s.retention(r); err != nil {
+		reqLog.WithError(err).Infof("invalid ttl")
+		s.writeProblem(w, r, http.StatusBadRequest, err)
 
 		return
 	}
 
 	docID := s.keygen.Generate()
+	doc.Revision = 0
+	setCreated(doc, r)
 
 	if err := store.SetDocument(docID, doc, r.Context()); err != nil {
 		reqLog.WithError(err).Error("failed to set document in redis store")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
 
 		return
 	}
 
+	s.recordOperation(w, r, store, docID, canvas.NewSnapshot(doc), doc, reqLog)
+
 	reqLog.
 		WithField("doc-id", docID).
 		Infof("document created")
 
 	w.WriteHeader(http.StatusCreated)
 
-	if _, err := w.Write([]byte(path.Join(r.RequestURI, docID))); err != nil {
+	if _, err := w.Write([]byte(path.Join(r.URL.Path, docID))); err != nil {
 		reqLog.WithField("doc-key", docID).WithError(err).Error("failed to write http response")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
 	}
 }
 
 func (s *Server) getDocument(w http.ResponseWriter, r *http.Request) {
 	var (
-		url    = r.URL.String()
+		url    = r.URL.Path
 		store  = s.getStore(r)
 		vars   = mux.Vars(r)
 		docID  = vars["id"]
@@ -310,44 +384,377 @@ func (s *Server) getDocument(w http.ResponseWriter, r *http.Request) {
 
 	reqLog.Debug("received get document request")
 
+	format, err := responseFormat(r)
+	if err != nil {
+		reqLog.WithError(err).Infof("unsupported format requested")
+		s.writeProblem(w, r, http.StatusNotAcceptable, err)
+
+		return
+	}
+
+	revision, past, err := parseRevision(r)
+	if err != nil {
+		reqLog.WithError(err).Info("invalid revision")
+		s.writeProblem(w, r, http.StatusBadRequest, err)
+
+		return
+	}
+
 	doc, err := store.GetDocument(docID, r.Context())
 	if err != nil {
 		switch err {
 		case datastore.NotFound:
 			reqLog.Info("document not found")
-			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			s.writeProblem(w, r, http.StatusNotFound, datastore.NotFound)
 		case err:
 			reqLog.WithError(err).Error("failed to marshal response to json")
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
 		}
 
 		return
 	}
 
-	data, err := jsonMarshal(struct {
-		Operations map[string]string `json:"operations"`
-		Canvas     *canvas.Canvas
+	var ttl *int64
+
+	if past {
+		doc, err = documentAt(store, docID, doc, revision, r.Context())
+		if err != nil {
+			switch err {
+			case canvas.UnknownRevision:
+				reqLog.WithField("revision", revision).Info("revision not found")
+				s.writeProblem(w, r, http.StatusNotFound, err)
+			case err:
+				reqLog.WithError(err).Error("failed to rebuild document revision")
+				s.writeProblem(w, r, http.StatusInternalServerError, nil)
+			}
+
+			return
+		}
+	} else {
+		ttl = remainingTTL(doc, time.Now())
+	}
+
+	etag := documentETag(doc.Revision, format)
+
+	w.Header().Set(headerETag, etag)
+	w.Header().Set("Vary", headerAccept)
+
+	if ifNoneMatch(r, etag) {
+		reqLog.Debug("document not modified")
+		w.WriteHeader(http.StatusNotModified)
+
+		return
+	}
+
+	var (
+		data        []byte
+		contentType = mediaTypeJSON
+	)
+
+	if f, ok := formats.Lookup(format); ok {
+		buffer := &bytes.Buffer{}
+		err = f.Encode(buffer, doc)
+		data = buffer.Bytes()
+		contentType = f.MediaType
+	} else {
+		data, err = jsonMarshal(struct {
+			Operations map[string]string `json:"operations"`
+			TTL        *int64            `json:"ttl,omitempty"`
+			Canvas     *canvas.Canvas
+		}{
+			TTL: ttl,
+			Operations: map[string]string{
+				"delete-doc":     url,
+				"replace-doc":    url,
+				"add-rect":       path.Join(url, "rect"),
+				"add-flood-fill": path.Join(url, "fill"),
+				"apply-ops":      path.Join(url, "ops"),
+				"run-script":     path.Join(url, "script"),
+				"embed":          path.Join(url, "embed"),
+				"replay":         path.Join(url, "replay"),
+				"set-ttl":        path.Join(url, "ttl"),
+				"revisions":      path.Join(url, "revisions"),
+				"diff":           path.Join(url, "diff"),
+				"revert":         path.Join(url, "revert"),
+				"fork":           path.Join(url, "fork"),
+				"merge":          path.Join(url, "merge"),
+			},
+			Canvas: doc,
+		})
+	}
+
+	if err != nil {
+		reqLog.WithError(err).Errorf("failed to encode response to %s", format)
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+
+	if _, err := w.Write(data); err != nil {
+		reqLog.WithError(err).Error("failed to write http response")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+	}
+}
+
+func (s *Server) getDocumentEmbed(w http.ResponseWriter, r *http.Request) {
+	var (
+		store  = s.getStore(r)
+		vars   = mux.Vars(r)
+		docID  = vars["id"]
+		reqLog = log.
+			WithField("operation-id", "get-doc-embed").
+			WithField("request-id", s.getRequestID(r)).
+			WithField("doc-id", docID)
+	)
+
+	reqLog.Debug("received get document embed request")
+
+	// Parse query parameters
+	req := struct {
+		Part    string `schema:"part"`
+		Refresh uint   `schema:"refresh"`
 	}{
-		Operations: map[string]string{
-			"delete-doc":     url,
-			"add-rect":       path.Join(url, "rect"),
-			"add-flood-fill": path.Join(url, "fill"),
-		},
-		Canvas: doc,
-	})
+		Refresh: DefaultEmbedRefresh,
+	}
+
+	if err := r.ParseForm(); err != nil {
+		reqLog.WithError(err).Warnf("invalid request: %s", r.RequestURI)
+		s.writeProblem(w, r, http.StatusBadRequest, err)
+
+		return
+	}
+
+	if err := schema.NewDecoder().Decode(&req, r.Form); err != nil {
+		reqLog.WithError(err).Warnf("invalid request: %s", r.RequestURI)
+		s.writeProblem(w, r, http.StatusBadRequest, err)
+
+		return
+	}
+
+	if req.Refresh < MinEmbedRefresh {
+		req.Refresh = MinEmbedRefresh
+	}
+
+	doc, err := store.GetDocument(docID, r.Context())
+	if err != nil {
+		switch err {
+		case datastore.NotFound:
+			reqLog.Info("document not found")
+			s.writeProblem(w, r, http.StatusNotFound, datastore.NotFound)
+		case err:
+			reqLog.WithError(err).Error("failed to retrieve document from store")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
+		}
+
+		return
+	}
+
+	block := &bytes.Buffer{}
+	if err := doc.RenderHTML(block); err != nil {
+		reqLog.WithError(err).Error("failed to render document to html")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+
+		return
+	}
+
+	data := block.Bytes()
+
+	// The full page is only sent on the initial load,
+	// the refresh script only needs the canvas block.
+	if req.Part != embedPart {
+		title := doc.Name
+		if title == "" {
+			title = docID
+		}
+
+		source := *r.URL
+		source.RawQuery = url.Values{"part": []string{embedPart}}.Encode()
+
+		page := embedPage{
+			Title:    title,
+			Canvas:   template.HTML(data), //nolint:gosec // RenderHTML escapes the content of the canvas.
+			Source:   source.String(),
+			Interval: req.Refresh * uint(time.Second/time.Millisecond),
+		}
+
+		if data, err = page.render(); err != nil {
+			reqLog.WithError(err).Error("failed to render embed page")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
+
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if _, err := w.Write(data); err != nil {
+		reqLog.WithError(err).Error("failed to write http response")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+	}
+}
+
+func (s *Server) getDocumentReplay(w http.ResponseWriter, r *http.Request) {
+	var (
+		store  = s.getStore(r)
+		vars   = mux.Vars(r)
+		docID  = vars["id"]
+		reqLog = log.
+			WithField("operation-id", "get-doc-replay").
+			WithField("request-id", s.getRequestID(r)).
+			WithField("doc-id", docID)
+	)
+
+	reqLog.Debug("received get document replay request")
+
+	// Parse query parameters
+	req := struct {
+		Format string `schema:"format"`
+		Delay  uint   `schema:"delay"`
+	}{
+		Format: replayGIF,
+		Delay:  DefaultReplayDelay,
+	}
+
+	if err := r.ParseForm(); err != nil {
+		reqLog.WithError(err).Warnf("invalid request: %s", r.RequestURI)
+		s.writeProblem(w, r, http.StatusBadRequest, err)
+
+		return
+	}
+
+	if err := schema.NewDecoder().Decode(&req, r.Form); err != nil {
+		reqLog.WithError(err).Warnf("invalid request: %s", r.RequestURI)
+		s.writeProblem(w, r, http.StatusBadRequest, err)
+
+		return
+	}
+
+	encoder, ok := replayEncoders[req.Format]
+	if !ok {
+		reqLog.WithField("format", req.Format).Infof("unsupported replay format requested")
+		s.writeProblem(w, r, http.StatusNotAcceptable, UnsupportedFormat)
+
+		return
+	}
+
+	if req.Delay < MinReplayDelay {
+		req.Delay = MinReplayDelay
+	}
+
+	doc, err := store.GetDocument(docID, r.Context())
+	if err != nil {
+		switch err {
+		case datastore.NotFound:
+			reqLog.Info("document not found")
+			s.writeProblem(w, r, http.StatusNotFound, datastore.NotFound)
+		case err:
+			reqLog.WithError(err).Error("failed to retrieve document from store")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
+		}
+
+		return
+	}
 
+	ops, err := store.GetOperations(docID, r.Context())
+	if err != nil {
+		reqLog.WithError(err).Error("failed to retrieve document operations from store")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+
+		return
+	}
+
+	frames, err := replay.Frames(doc, ops)
+	if err != nil {
+		reqLog.WithError(err).Error("failed to replay document operations")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+
+		return
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := encoder.encode(buffer, frames, time.Duration(req.Delay)*time.Millisecond, doc); err != nil {
+		reqLog.WithError(err).Errorf("failed to encode replay to %s", req.Format)
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", encoder.contentType)
+
+	if _, err := w.Write(buffer.Bytes()); err != nil {
+		reqLog.WithError(err).Error("failed to write http response")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+	}
+}
+
+func (s *Server) replaceDocument(w http.ResponseWriter, r *http.Request) {
+	var (
+		store  = s.getStore(r)
+		vars   = mux.Vars(r)
+		docID  = vars["id"]
+		reqLog = log.
+			WithField("operation-id", "replace-doc").
+			WithField("request-id", s.getRequestID(r)).
+			WithField("doc-id", docID)
+	)
+
+	reqLog.Debug("received replace document request")
+
+	current, err := store.GetDocument(docID, r.Context())
+	if err != nil {
+		switch err {
+		case datastore.NotFound:
+			reqLog.Info("document not found")
+			s.writeProblem(w, r, http.StatusNotFound, datastore.NotFound)
+		case err:
+			reqLog.WithError(err).Error("failed to retrieve document from store")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
+		}
+
+		return
+	}
+
+	if !s.checkIfMatch(w, r, current.Revision, reqLog) {
+		return
+	}
+
+	doc, err := readDocument(w, r)
+	if err != nil {
+		reqLog.WithField("body", r.Body).WithError(err).Infof("failed to decode request body")
+		s.writeProblem(w, r, decodeErrorStatus(err), err)
+
+		return
+	}
+
+	setReplaced(doc, current)
+
+	if err := store.CompareAndSwapDocument(docID, current.Revision, doc, r.Context()); err != nil {
+		s.writeUpdateError(w, r, err, reqLog)
+
+		return
+	}
+
+	s.recordOperation(w, r, store, docID, canvas.NewSnapshot(doc), doc, reqLog)
+
+	reqLog.Infof("document replaced")
+
+	data, err := jsonMarshal(doc)
 	if err != nil {
 		reqLog.WithError(err).Error("failed to marshal response to json")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
 
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(headerETag, documentETag(doc.Revision, formatJSON))
 
 	if _, err := w.Write(data); err != nil {
 		reqLog.WithError(err).Error("failed to write http response")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
 	}
 }
 
@@ -364,23 +771,48 @@ func (s *Server) deleteDocument(w http.ResponseWriter, r *http.Request) {
 
 	reqLog.Debug("received delete document request")
 
-	err := store.DeleteDocument(docID, r.Context())
+	if hasIfMatch(r) {
+		doc, err := store.GetDocument(docID, r.Context())
+		if err != nil {
+			switch err {
+			case datastore.NotFound:
+				reqLog.Info("document not found")
+				s.writeProblem(w, r, http.StatusNotFound, datastore.NotFound)
+			case err:
+				reqLog.WithError(err).Error("failed to retrieve document from store")
+				s.writeProblem(w, r, http.StatusInternalServerError, nil)
+			}
+
+			return
+		}
+
+		if !s.checkIfMatch(w, r, doc.Revision, reqLog) {
+			return
+		}
+	}
+
+	// Deleted documents are moved to the trash, from where they can be restored until they are purged.
+	err := store.TrashDocument(docID, time.Now().UTC(), r.Context())
 	if err != nil {
 		switch err {
 		case datastore.NotFound:
 			reqLog.Info("document not found")
-			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			s.writeProblem(w, r, http.StatusNotFound, datastore.NotFound)
 		case err:
-			reqLog.WithError(err).Error("failed to marshal response to json")
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			reqLog.WithError(err).Error("failed to move document to trash")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
 		}
+
+		return
 	}
 
+	reqLog.Infof("document moved to trash")
+
 	w.WriteHeader(http.StatusNoContent)
 
 	if _, err := w.Write([]byte("removed")); err != nil {
 		reqLog.WithError(err).Error("failed to write http response")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
 	}
 }
 
@@ -404,62 +836,65 @@ func (s *Server) addRectangle(w http.ResponseWriter, r *http.Request) {
 
 	reqLog.Debug("received draw rectangle request")
 
-	doc, err := store.GetDocument(docID, r.Context())
+	dryRun, preview, err := parseDryRun(r)
 	if err != nil {
-		switch err {
-		case datastore.NotFound:
-			reqLog.Info("document not found")
-			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
-		case err:
-			reqLog.WithError(err).Error("failed to marshal response to json")
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		}
+		reqLog.WithError(err).Info("invalid dry run")
+		s.writeProblem(w, r, http.StatusBadRequest, err)
 
 		return
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		reqLog.WithField("body", r.Body).WithError(err).Infof("failed to decode request body")
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		s.writeProblem(w, r, http.StatusBadRequest, err)
 
 		return
 	}
 
 	if req.Fill == "" && req.Outline == "" {
-		reqLog.WithError(err).Infof("none of fill our outline is specified")
-		http.Error(w, "at least one of fill or outline is required", http.StatusBadRequest)
+		reqLog.Infof("none of fill our outline is specified")
+		s.writeProblem(w, r, http.StatusBadRequest, MissingPattern)
 
 		return
 	}
 
-	if err := doc.DrawRect(&req.Rect, req.Fill, req.Outline); err != nil {
-		reqLog.WithError(err).Infof("failed to update doc content")
-		http.Error(w, err.Error(), http.StatusConflict)
+	op := canvas.NewRect(req.Rect, req.Fill, req.Outline)
+
+	if dryRun {
+		s.previewOperations(w, r, store, docID, []*canvas.Operation{op}, nil, preview, reqLog)
 
 		return
 	}
 
-	if err := store.SetDocument(docID, doc, r.Context()); err != nil {
-		reqLog.WithError(err).Error("failed to set document in redis store")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	revision, ok := s.expectedRevision(w, r, store, docID, reqLog)
+	if !ok {
+		return
+	}
+
+	doc, err := s.drawDocument(r, store, docID, op, revision)
+	if err != nil {
+		s.writeUpdateError(w, r, err, reqLog)
 
 		return
 	}
 
+	s.recordOperation(w, r, store, docID, op, doc, reqLog)
+
 	data, err := jsonMarshal(doc)
 
 	if err != nil {
 		reqLog.WithError(err).Error("failed to marshal response to json")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
 
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(headerETag, documentETag(doc.Revision, formatJSON))
 
 	if _, err := w.Write(data); err != nil {
 		reqLog.WithError(err).Error("failed to write http response")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
 	}
 }
 
@@ -482,62 +917,161 @@ func (s *Server) addFloodFill(w http.ResponseWriter, r *http.Request) {
 
 	reqLog.Debug("received add flood fill request")
 
-	doc, err := store.GetDocument(docID, r.Context())
+	dryRun, preview, err := parseDryRun(r)
 	if err != nil {
-		switch err {
-		case datastore.NotFound:
-			reqLog.Info("document not found")
-			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
-		case err:
-			reqLog.WithError(err).Error("failed to marshal response to json")
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		}
+		reqLog.WithError(err).Info("invalid dry run")
+		s.writeProblem(w, r, http.StatusBadRequest, err)
 
 		return
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		reqLog.WithField("body", r.Body).WithError(err).Infof("failed to decode request body")
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		s.writeProblem(w, r, http.StatusBadRequest, err)
 
 		return
 	}
 
 	if req.Fill == "" {
-		reqLog.WithError(err).Infof("fill is not specified")
-		http.Error(w, "fill character is required", http.StatusBadRequest)
+		reqLog.Infof("fill is not specified")
+		s.writeProblem(w, r, http.StatusBadRequest, MissingFill)
 
 		return
 	}
 
-	if err := doc.FloodFill(&req.Origin, req.Fill); err != nil {
-		reqLog.WithError(err).Infof("failed to update doc content")
-		http.Error(w, err.Error(), http.StatusConflict)
+	op := canvas.NewFill(req.Origin, req.Fill)
+
+	if dryRun {
+		s.previewOperations(w, r, store, docID, []*canvas.Operation{op}, nil, preview, reqLog)
 
 		return
 	}
 
-	if err := store.SetDocument(docID, doc, r.Context()); err != nil {
-		reqLog.WithError(err).Error("failed to set document in redis store")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	revision, ok := s.expectedRevision(w, r, store, docID, reqLog)
+	if !ok {
+		return
+	}
+
+	doc, err := s.drawDocument(r, store, docID, op, revision)
+	if err != nil {
+		s.writeUpdateError(w, r, err, reqLog)
 
 		return
 	}
 
+	s.recordOperation(w, r, store, docID, op, doc, reqLog)
+
 	data, err := jsonMarshal(doc)
 
 	if err != nil {
 		reqLog.WithError(err).Error("failed to marshal response to json")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
 
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(headerETag, documentETag(doc.Revision, formatJSON))
 
 	if _, err := w.Write(data); err != nil {
 		reqLog.WithError(err).Error("failed to write http response")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+	}
+}
+
+// expectedRevision returns the revision a document must be at for a drawing request to apply,
+// the current revision if it matches the If-Match header of the request, or any revision without this header.
+// The response is written if the precondition doesn't hold.
+func (s *Server) expectedRevision(w http.ResponseWriter, r *http.Request, store datastore.DataStore, docID string, reqLog *log.Entry) (uint64, bool) {
+	if !hasIfMatch(r) {
+		return datastore.AnyRevision, true
+	}
+
+	doc, err := store.GetDocument(docID, r.Context())
+	if err != nil {
+		switch err {
+		case datastore.NotFound:
+			reqLog.Info("document not found")
+			s.writeProblem(w, r, http.StatusNotFound, datastore.NotFound)
+		case err:
+			reqLog.WithError(err).Error("failed to retrieve document from store")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
+		}
+
+		return 0, false
+	}
+
+	if !s.checkIfMatch(w, r, doc.Revision, reqLog) {
+		return 0, false
+	}
+
+	return doc.Revision, true
+}
+
+// checkIfMatch checks the If-Match precondition of a request against the current revision of a document,
+// writing a 412 response if it doesn't hold.
+func (s *Server) checkIfMatch(w http.ResponseWriter, r *http.Request, revision uint64, reqLog *log.Entry) bool {
+	if ifMatch(r, revision) {
+		return true
+	}
+
+	reqLog.WithField("if-match", r.Header.Get(headerIfMatch)).Info("document revision doesn't match")
+	s.writeProblem(w, r, http.StatusPreconditionFailed, RevisionMismatch)
+
+	return false
+}
+
+// writeUpdateError writes the response of a failed document update.
+func (s *Server) writeUpdateError(w http.ResponseWriter, r *http.Request, err error, reqLog *log.Entry) {
+	var canvasErr canvas.Error
+
+	switch {
+	case xerrors.As(err, &canvasErr):
+		reqLog.WithError(err).Infof("failed to update doc content")
+		s.writeProblem(w, r, http.StatusConflict, err)
+	case err == datastore.Conflict && hasIfMatch(r):
+		reqLog.WithError(err).Info("document modified since the If-Match revision")
+		s.writeProblem(w, r, http.StatusPreconditionFailed, RevisionMismatch)
+	case err == datastore.Conflict:
+		reqLog.WithError(err).Info("document modified concurrently")
+		s.writeProblem(w, r, http.StatusConflict, err)
+	case err == datastore.NotFound:
+		reqLog.Info("document not found")
+		s.writeProblem(w, r, http.StatusNotFound, datastore.NotFound)
+	default:
+		reqLog.WithError(err).Error("failed to set document in store")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+	}
+}
+
+// recordOperation appends an operation to the journal of a document, with its caller and the revision of doc it produced.
+// Every checkpointInterval revisions, the content of the document is recorded along the operation to bound the replays
+// of its history, and the journal is trimmed to the last historyLength revisions. The journal is used to read past
+// revisions and to undo operations. The document is already updated when the operation is recorded, so failing to
+// record it doesn't fail the request: the gap in the history is logged, and flagged by a Warning header on the response.
+func (s *Server) recordOperation(w http.ResponseWriter, r *http.Request, store datastore.DataStore, docID string, op *canvas.Operation, doc *canvas.Canvas, reqLog *log.Entry) {
+	if op.Caller == "" {
+		op.Caller = callerID(r)
+	}
+
+	op.Revision = doc.Revision
+
+	checkpoint := doc.Revision%checkpointInterval == 0
+	if checkpoint && op.Canvas == nil {
+		op.Canvas = doc.Clone()
+	}
+
+	if err := store.AddOperation(docID, op, r.Context()); err != nil {
+		reqLog.WithError(err).WithField("op", op.Type).WithField("revision", doc.Revision).Error("failed to record operation")
+		w.Header().Set(headerWarning, fmt.Sprintf(`199 - "revision %d is missing from the document history"`, doc.Revision))
+
+		return
+	}
+
+	if checkpoint && doc.Revision > historyLength {
+		if _, err := store.TrimOperations(docID, doc.Revision-historyLength, r.Context()); err != nil {
+			reqLog.WithError(err).Warn("failed to trim operations")
+		}
 	}
 }
 
@@ -1,24 +1,33 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"image/png"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"path"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/xerrors"
 
 	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
 	"github.com/hexbee-net/sketch-canvas/pkg/datastore"
 	datastoreMocks "github.com/hexbee-net/sketch-canvas/pkg/datastore/mocks"
 	keygenMocks "github.com/hexbee-net/sketch-canvas/pkg/keygen/mocks"
+	"github.com/hexbee-net/sketch-canvas/pkg/raster"
 )
 
 type testSrv struct {
@@ -37,7 +46,8 @@ func testServer(t *testing.T) testSrv {
 		port:         0,
 		srv:          &http.Server{},
 		router:       mux.NewRouter(),
-		storeOptions: &datastore.RedisOptions{},
+		storeOptions: &datastore.Options{},
+		store:        storeMock,
 		keygen:       keyGen,
 	}
 
@@ -61,6 +71,26 @@ func toJson(t *testing.T, v canvas.Canvas) []byte {
 	return ret
 }
 
+// matchDocument matches the documents equal to v, apart from their timestamps set by the server,
+// and passes other values through.
+func matchDocument(v interface{}) interface{} {
+	want, ok := v.(*canvas.Canvas)
+	if !ok {
+		return v
+	}
+
+	return mock.MatchedBy(func(doc *canvas.Canvas) bool {
+		if doc.Updated.IsZero() {
+			return false
+		}
+
+		got := *doc
+		got.Created, got.Updated = want.Created, want.Updated
+
+		return assert.ObjectsAreEqual(want, &got)
+	})
+}
+
 func MiddlewareMockDatastore(t *testing.T) (mux.MiddlewareFunc, *datastoreMocks.DataStore) {
 	t.Helper()
 
@@ -81,8 +111,9 @@ func TestServer_createDocument(t *testing.T) {
 		ret   error
 	}
 	type args struct {
-		body []byte
-		cmd  storeCommand
+		contentType string
+		body        []byte
+		cmd         storeCommand
 	}
 	type response struct {
 		code int
@@ -127,16 +158,85 @@ func TestServer_createDocument(t *testing.T) {
 				code: http.StatusInternalServerError,
 			},
 			checkBody: false,
+		},
+		{
+			name: "plain text",
+			args: args{
+				contentType: "text/plain; charset=utf-8",
+				body:        []byte("+-+\n| |\n+-+\n"),
+				cmd:         storeCommand{key: "123", value: &canvas.Canvas{Width: 3, Height: 3, Data: []byte("+-+| |+-+")}, ret: nil},
+			},
+			response: response{
+				code: http.StatusCreated,
+				body: "/v1/docs/123",
+			},
+			checkBody: true,
+		},
+		{
+			name: "empty plain text",
+			args: args{
+				contentType: "text/plain",
+				body:        []byte(""),
+				cmd:         storeCommand{key: mock.Anything, value: mock.Anything, ret: nil},
+			},
+			response: response{
+				code: http.StatusBadRequest,
+			},
+			checkBody: false,
+		},
+		{
+			name: "ansi",
+			args: args{
+				contentType: "text/x-ansi",
+				body:        []byte("\x1b[31mab"),
+				cmd:         storeCommand{key: "123", value: mock.Anything, ret: nil},
+			},
+			response: response{
+				code: http.StatusCreated,
+				body: "/v1/docs/123",
+			},
+			checkBody: true,
+		},
+		{
+			name: "asciiflow",
+			args: args{
+				contentType: "application/x-asciiflow+json",
+				body:        []byte(`{"version":2,"x":0,"y":0,"text":"+-+"}`),
+				cmd:         storeCommand{key: "123", value: &canvas.Canvas{Width: 3, Height: 1, Data: []byte("+-+")}, ret: nil},
+			},
+			response: response{
+				code: http.StatusCreated,
+				body: "/v1/docs/123",
+			},
+			checkBody: true,
+		},
+		{
+			name: "unsupported content type",
+			args: args{
+				contentType: "image/png",
+				body:        []byte(""),
+				cmd:         storeCommand{key: mock.Anything, value: mock.Anything, ret: nil},
+			},
+			response: response{
+				code: http.StatusUnsupportedMediaType,
+			},
+			checkBody: false,
 		}}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			testSrv := testServer(t)
 
 			testSrv.keyGenMock.On("Generate").Return("123")
-			testSrv.storeMock.On("SetDocument", tt.args.cmd.key, tt.args.cmd.value, mock.Anything).Return(tt.args.cmd.ret)
+			testSrv.storeMock.On("SetDocument", tt.args.cmd.key, matchDocument(tt.args.cmd.value), mock.Anything).Return(tt.args.cmd.ret)
+			testSrv.storeMock.On("AddOperation", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 			w := httptest.NewRecorder()
 
-			testSrv.server.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/docs/", strings.NewReader(string(tt.args.body))))
+			req := httptest.NewRequest(http.MethodPost, "/v1/docs/", strings.NewReader(string(tt.args.body)))
+			if tt.args.contentType != "" {
+				req.Header.Set("Content-Type", tt.args.contentType)
+			}
+
+			testSrv.server.router.ServeHTTP(w, req)
 
 			assert.Equal(t, tt.response.code, w.Code)
 			if tt.checkBody {
@@ -147,12 +247,13 @@ func TestServer_createDocument(t *testing.T) {
 }
 
 func TestServer_getDocumentList(t *testing.T) {
-	type storeGetList struct {
-		cursor    uint64
-		count     int64
-		keys      []string
-		newCursor uint64
-		err       error
+	created := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	type storeListDocuments struct {
+		query  *datastore.ListQuery
+		infos  []*datastore.DocInfo
+		cursor string
+		err    error
 	}
 	type storeGetSize struct {
 		size int64
@@ -162,46 +263,85 @@ func TestServer_getDocumentList(t *testing.T) {
 		query string
 	}
 	type response struct {
-		dbSize uint64
-		code   int
-		body   string
+		code int
+		body string
 	}
 	tests := []struct {
-		name         string
-		args         args
-		storeGetList storeGetList
-		storeGetSize storeGetSize
-		response     response
-		checkBody    bool
+		name               string
+		args               args
+		storeListDocuments storeListDocuments
+		storeGetSize       storeGetSize
+		response           response
+		checkBody          bool
 	}{
 		{
 			name: "ok",
 			args: args{
-				query: "?q=0&limit=5",
+				query: "?limit=5",
 			},
-			storeGetList: storeGetList{
-				cursor:    0,
-				count:     5,
-				keys:      []string{"123", "456"},
-				newCursor: 7,
-				err:       nil,
+			storeListDocuments: storeListDocuments{
+				query: &datastore.ListQuery{Count: 5},
+				infos: []*datastore.DocInfo{
+					{Key: "456", Name: "doc2", Creator: "me", Tags: []string{"a"}, Created: created, Updated: created},
+					{Key: "123"},
+				},
+				cursor: "MTIz",
 			},
 			storeGetSize: storeGetSize{
 				size: 2,
-				err:  nil,
 			},
 			response: response{
 				code: http.StatusOK,
-				body: `{"next":"/v1/docs/?limit=5&q=7","count":2,"total":2,"docs":{"123":"/v1/docs/123","456":"/v1/docs/456"}}`,
+				body: `{"next":"/v1/docs/?limit=5&q=MTIz","count":2,"total":2,"docs":{"123":"/v1/docs/123","456":"/v1/docs/456"},` +
+					`"items":[{"id":"456","uri":"/v1/docs/456","name":"doc2","creator":"me","tags":["a"],"created":"2021-03-04T05:06:07Z","updated":"2021-03-04T05:06:07Z"},` +
+					`{"id":"123","uri":"/v1/docs/123"}]}`,
+			},
+			checkBody: true,
+		},
+		{
+			name: "filters",
+			args: args{
+				query: "?q=MTIz&sort=updated&tag=a&name=do",
+			},
+			storeListDocuments: storeListDocuments{
+				query: &datastore.ListQuery{Cursor: "MTIz", Count: DefaultPageLimit, Sort: "updated", Tag: "a", NamePrefix: "do"},
+				infos: []*datastore.DocInfo{},
+			},
+			response: response{
+				code: http.StatusOK,
+				body: `{"count":0,"docs":{},"items":[]}`,
 			},
 			checkBody: true,
 		},
+		{
+			name: "invalid cursor",
+			args: args{
+				query: "?q=xyz",
+			},
+			storeListDocuments: storeListDocuments{
+				query: &datastore.ListQuery{Cursor: "xyz", Count: DefaultPageLimit},
+				err:   datastore.InvalidCursor,
+			},
+			response: response{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			name: "store error",
+			storeListDocuments: storeListDocuments{
+				query: &datastore.ListQuery{Count: DefaultPageLimit},
+				err:   xerrors.New("store error"),
+			},
+			response: response{
+				code: http.StatusInternalServerError,
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			testSrv := testServer(t)
 
-			testSrv.storeMock.On("GetDocList", tt.storeGetList.cursor, tt.storeGetList.count, mock.Anything).Return(tt.storeGetList.keys, tt.storeGetList.newCursor, tt.storeGetList.err)
+			testSrv.storeMock.On("ListDocuments", tt.storeListDocuments.query, mock.Anything).Return(tt.storeListDocuments.infos, tt.storeListDocuments.cursor, tt.storeListDocuments.err)
 			testSrv.storeMock.On("GetSize", mock.Anything).Return(tt.storeGetSize.size, tt.storeGetSize.err)
 			w := httptest.NewRecorder()
 
@@ -221,12 +361,18 @@ func TestServer_getDocument(t *testing.T) {
 		doc   *canvas.Canvas
 		err   error
 	}
+	type args struct {
+		query  string
+		accept string
+	}
 	type response struct {
-		code int
-		body string
+		code        int
+		contentType string
+		body        string
 	}
 	tests := []struct {
 		name             string
+		args             args
 		storeGetDocument storeGetDocument
 		response         response
 		checkBody        bool
@@ -239,11 +385,108 @@ func TestServer_getDocument(t *testing.T) {
 				err:   nil,
 			},
 			response: response{
-				code: http.StatusOK,
-				body: `{"operations":{"add-flood-fill":"/v1/docs/123/fill","add-rect":"/v1/docs/123/rect","delete-doc":"/v1/docs/123"},"Canvas":{"name":"doc1","width":80,"height":50}}`,
+				code:        http.StatusOK,
+				contentType: "application/json",
+				body:        `{"operations":{"add-flood-fill":"/v1/docs/123/fill","add-rect":"/v1/docs/123/rect","apply-ops":"/v1/docs/123/ops","delete-doc":"/v1/docs/123","diff":"/v1/docs/123/diff","embed":"/v1/docs/123/embed","fork":"/v1/docs/123/fork","merge":"/v1/docs/123/merge","replace-doc":"/v1/docs/123","replay":"/v1/docs/123/replay","revert":"/v1/docs/123/revert","revisions":"/v1/docs/123/revisions","run-script":"/v1/docs/123/script","set-ttl":"/v1/docs/123/ttl"},"Canvas":{"name":"doc1","width":80,"height":50}}`,
+			},
+			checkBody: true,
+		},
+		{
+			name: "accept text",
+			args: args{
+				accept: "text/html;q=0.5, text/plain",
+			},
+			storeGetDocument: storeGetDocument{
+				docID: "123",
+				doc:   &canvas.Canvas{Name: "doc1", Width: 3, Height: 2, Data: []byte("abcdef")},
+				err:   nil,
+			},
+			response: response{
+				code:        http.StatusOK,
+				contentType: "text/plain",
+				body:        "abc\ndef",
+			},
+			checkBody: true,
+		},
+		{
+			name: "accept any application type",
+			args: args{
+				accept: "application/*",
+			},
+			storeGetDocument: storeGetDocument{
+				docID: "123",
+				doc:   &canvas.Canvas{Name: "doc1", Width: 3, Height: 2},
+				err:   nil,
+			},
+			response: response{
+				code:        http.StatusOK,
+				contentType: "application/json",
+			},
+		},
+		{
+			name: "accept unsupported",
+			args: args{
+				accept: "image/png",
+			},
+			storeGetDocument: storeGetDocument{
+				docID: "123",
+				doc:   &canvas.Canvas{Name: "doc1", Width: 3, Height: 2},
+				err:   nil,
+			},
+			response: response{
+				code: http.StatusNotAcceptable,
+			},
+		},
+		{
+			name: "format text",
+			args: args{
+				query:  "?format=txt",
+				accept: "application/json",
+			},
+			storeGetDocument: storeGetDocument{
+				docID: "123",
+				doc:   &canvas.Canvas{Name: "doc1", Width: 3, Height: 2, Data: []byte("abcdef")},
+				err:   nil,
+			},
+			response: response{
+				code:        http.StatusOK,
+				contentType: "text/plain",
+				body:        "abc\ndef",
+			},
+			checkBody: true,
+		},
+		{
+			name: "format asciiflow",
+			args: args{
+				query: "?format=asciiflow",
+			},
+			storeGetDocument: storeGetDocument{
+				docID: "123",
+				doc:   &canvas.Canvas{Name: "doc1", Width: 3, Height: 1, Data: []byte("\xc4\xc4\x10")},
+				err:   nil,
+			},
+			response: response{
+				code:        http.StatusOK,
+				contentType: "application/x-asciiflow+json",
+				body:        `{"version":2,"x":0,"y":0,"text":"──►"}`,
 			},
 			checkBody: true,
 		},
+		{
+			name: "unsupported format",
+			args: args{
+				query: "?format=bmp",
+			},
+			storeGetDocument: storeGetDocument{
+				docID: "123",
+				doc:   &canvas.Canvas{Name: "doc1", Width: 3, Height: 2},
+				err:   nil,
+			},
+			response: response{
+				code: http.StatusNotAcceptable,
+			},
+			checkBody: false,
+		},
 		{
 			name: "not found",
 			storeGetDocument: storeGetDocument{
@@ -278,180 +521,1827 @@ func TestServer_getDocument(t *testing.T) {
 			testSrv.storeMock.On("GetDocument", tt.storeGetDocument.docID, mock.Anything).Return(tt.storeGetDocument.doc, tt.storeGetDocument.err)
 			w := httptest.NewRecorder()
 
-			testSrv.server.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/docs/123", strings.NewReader("")))
+			req := httptest.NewRequest(http.MethodGet, "/v1/docs/123"+tt.args.query, strings.NewReader(""))
+			if tt.args.accept != "" {
+				req.Header.Set("Accept", tt.args.accept)
+			}
+
+			testSrv.server.router.ServeHTTP(w, req)
 
 			assert.Equal(t, tt.response.code, w.Code)
 			if tt.checkBody {
+				assert.Equal(t, tt.response.contentType, w.Header().Get("Content-Type"))
 				assert.Equal(t, tt.response.body+"\n", w.Body.String())
 			}
 		})
 	}
 }
 
-func TestServer_deleteDocument(t *testing.T) {
-	type storeDeleteDocument struct {
-		docID string
-		err   error
+func TestServer_getDocumentEmbed(t *testing.T) {
+	type storeGetDocument struct {
+		doc *canvas.Canvas
+		err error
+	}
+	type response struct {
+		code     int
+		contains []string
 	}
 	tests := []struct {
-		name                string
-		storeDeleteDocument storeDeleteDocument
-		response            int
-		checkBody           bool
+		name             string
+		query            string
+		storeGetDocument storeGetDocument
+		response         response
 	}{
 		{
-			name: "ok",
-			storeDeleteDocument: storeDeleteDocument{
-				docID: "123",
-				err:   nil,
+			name:  "page",
+			query: "?refresh=2",
+			storeGetDocument: storeGetDocument{
+				doc: &canvas.Canvas{Name: "<doc>", Width: 3, Height: 1, Data: []byte("a<b")},
+			},
+			response: response{
+				code: http.StatusOK,
+				contains: []string{
+					"<title>&lt;doc&gt;</title>",
+					`<div id="sketch-canvas"><pre class="sketch-canvas"`,
+					"a&lt;b</pre></div>",
+					`"/v1/docs/123/embed?part=canvas"`,
+					"2000",
+				},
 			},
-			response:  http.StatusNoContent,
-			checkBody: true,
 		},
 		{
-			name: "not found",
-			storeDeleteDocument: storeDeleteDocument{
-				docID: "123",
-				err:   datastore.NotFound,
+			name:  "canvas part",
+			query: "?part=canvas",
+			storeGetDocument: storeGetDocument{
+				doc: &canvas.Canvas{Width: 3, Height: 1, Data: []byte("a<b")},
+			},
+			response: response{
+				code:     http.StatusOK,
+				contains: []string{"a&lt;b</pre>"},
 			},
-			response:  http.StatusNotFound,
-			checkBody: false,
 		},
 		{
-			name: "store error",
-			storeDeleteDocument: storeDeleteDocument{
-				docID: "123",
-				err:   xerrors.New("FAILED"),
+			name: "not found",
+			storeGetDocument: storeGetDocument{
+				err: datastore.NotFound,
+			},
+			response: response{
+				code: http.StatusNotFound,
 			},
-			response:  http.StatusInternalServerError,
-			checkBody: false,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			testSrv := testServer(t)
 
-			testSrv.storeMock.On("DeleteDocument", tt.storeDeleteDocument.docID, mock.Anything).Return(tt.storeDeleteDocument.err)
+			testSrv.storeMock.On("GetDocument", "123", mock.Anything).Return(tt.storeGetDocument.doc, tt.storeGetDocument.err)
 			w := httptest.NewRecorder()
 
-			testSrv.server.router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/v1/docs/123", strings.NewReader("")))
+			testSrv.server.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/docs/123/embed"+tt.query, strings.NewReader("")))
 
-			assert.Equal(t, tt.response, w.Code)
+			assert.Equal(t, tt.response.code, w.Code)
+			for _, c := range tt.response.contains {
+				assert.Contains(t, w.Body.String(), c)
+			}
 		})
 	}
 }
 
-func TestServer_Operations(t *testing.T) {
-	type storeGetCommand struct {
-		docID string
-		doc   *canvas.Canvas
-		err   error
-	}
-	type storeSetCommand struct {
-		docID string
-		doc   interface{}
-		err   error
-	}
-	type args struct {
-		operation string
-		body      string
-	}
+func TestServer_getDocumentReplay(t *testing.T) {
 	type response struct {
-		code int
+		code        int
+		contentType string
+		prefix      string
 	}
 	tests := []struct {
-		name       string
-		args       args
-		getCommand storeGetCommand
-		setCommand storeSetCommand
-		response   response
-		checkBody  bool
+		name     string
+		query    string
+		storeErr error
+		opsErr   error
+		response response
 	}{
 		{
-			name: "rect ok",
-			args: args{
-				operation: "rect",
-				body:      `{"rect":{"origin":{"x":2,"y":3},"width":4,"height":5},"fill":"X","outline":"@"}`,
-			},
-			getCommand: storeGetCommand{
-				docID: mock.Anything,
-				doc: &canvas.Canvas{
-					Name:   "doc1",
-					Width:  10,
-					Height: 10,
-					Data:   nil,
-				},
-				err: nil,
-			},
-			setCommand: storeSetCommand{
-				docID: mock.Anything,
-				doc:   mock.Anything,
-				err:   nil,
-			},
+			name: "gif",
 			response: response{
-				code: http.StatusOK,
+				code:        http.StatusOK,
+				contentType: "image/gif",
+				prefix:      "GIF89a",
 			},
-			checkBody: true,
 		},
 		{
-			name: "rect - missing parameters",
-			args: args{
-				operation: "rect",
-				body:      `{"rect":{"origin":{"x":5,"y":5},"width":10,"height":4}}`,
-			},
-			getCommand: storeGetCommand{
-				docID: mock.Anything,
-				doc:   &canvas.Canvas{},
-				err:   nil,
-			},
-			setCommand: storeSetCommand{
-				docID: mock.Anything,
-				doc:   mock.Anything,
-				err:   nil,
-			},
+			name:  "cast",
+			query: "?format=cast",
 			response: response{
-				code: http.StatusBadRequest,
+				code:        http.StatusOK,
+				contentType: "application/x-asciicast",
+				prefix:      `{"version":2,"width":3,"height":2,`,
 			},
-			checkBody: true,
 		},
 		{
-			name: "fill ok",
-			args: args{
-				operation: "fill",
-				body:      `{"origin":{"x":5,"y":5},"fill":"X"}`,
-			},
-			getCommand: storeGetCommand{
-				docID: mock.Anything,
-				doc: &canvas.Canvas{
-					Name:   "doc1",
-					Width:  10,
-					Height: 10,
-					Data:   nil,
-				},
-				err: nil,
+			name:  "unsupported format",
+			query: "?format=mp4",
+			response: response{
+				code: http.StatusNotAcceptable,
 			},
-			setCommand: storeSetCommand{
-				docID: mock.Anything,
-				doc:   mock.Anything,
-				err:   nil,
+		},
+		{
+			name:     "not found",
+			storeErr: datastore.NotFound,
+			response: response{
+				code: http.StatusNotFound,
 			},
+		},
+		{
+			name:   "journal error",
+			opsErr: xerrors.New("FAILED"),
 			response: response{
-				code: http.StatusOK,
+				code: http.StatusInternalServerError,
 			},
-			checkBody: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			testSrv := testServer(t)
 
-			testSrv.storeMock.On("GetDocument", tt.getCommand.docID, mock.Anything).Return(tt.getCommand.doc, tt.getCommand.err)
-			testSrv.storeMock.On("SetDocument", tt.setCommand.docID, tt.setCommand.doc, mock.Anything).Return(tt.setCommand.err)
+			doc := &canvas.Canvas{Name: "doc", Width: 3, Height: 2}
+			ops := []*canvas.Operation{
+				canvas.NewSnapshot(doc),
+				canvas.NewRect(canvas.Rectangle{Origin: canvas.Point{X: 1, Y: 1}, Width: 2, Height: 1}, "#", ""),
+			}
+
+			testSrv.storeMock.On("GetDocument", "123", mock.Anything).Return(doc, tt.storeErr)
+			testSrv.storeMock.On("GetOperations", "123", mock.Anything).Return(ops, tt.opsErr)
 			w := httptest.NewRecorder()
 
-			testSrv.server.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path.Join("/v1/docs/123/", tt.args.operation), strings.NewReader(tt.args.body)))
+			testSrv.server.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/docs/123/replay"+tt.query, strings.NewReader("")))
 
 			assert.Equal(t, tt.response.code, w.Code)
+			if tt.response.code == http.StatusOK {
+				assert.Equal(t, tt.response.contentType, w.Header().Get("Content-Type"))
+				assert.True(t, strings.HasPrefix(w.Body.String(), tt.response.prefix))
+			}
+		})
+	}
+}
+
+func multipartBody(t *testing.T, filename string, content string) (string, *bytes.Buffer) {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+
+	part, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = part.Write([]byte(content))
+	_ = mw.Close()
+
+	return mw.FormDataContentType(), body
+}
+
+func TestServer_replaceDocument(t *testing.T) {
+	type storeGetDocument struct {
+		doc *canvas.Canvas
+		err error
+	}
+	type storeSetDocument struct {
+		doc interface{}
+		err error
+	}
+	type args struct {
+		contentType string
+		body        string
+		filename    string
+	}
+	tests := []struct {
+		name             string
+		args             args
+		storeGetDocument storeGetDocument
+		storeSetDocument storeSetDocument
+		response         int
+	}{
+		{
+			name: "json keeps name",
+			args: args{
+				contentType: "application/json",
+				body:        `{"width":2,"height":1,"data":"YWI="}`,
+			},
+			storeGetDocument: storeGetDocument{
+				doc: &canvas.Canvas{Name: "doc1", Width: 80, Height: 50},
+			},
+			storeSetDocument: storeSetDocument{
+				doc: &canvas.Canvas{Name: "doc1", Width: 2, Height: 1, Data: []byte("ab")},
+			},
+			response: http.StatusOK,
+		},
+		{
+			name: "json keeps metadata",
+			args: args{
+				contentType: "application/json",
+				body:        `{"name":"doc2","width":1,"height":1,"data":"YQ==","tags":["b","b"]}`,
+			},
+			storeGetDocument: storeGetDocument{
+				doc: &canvas.Canvas{Name: "doc1", Width: 80, Height: 50, Created: time.Unix(1, 0), Creator: "me", Description: "desc", Tags: []string{"a"}},
+			},
+			storeSetDocument: storeSetDocument{
+				doc: &canvas.Canvas{Name: "doc2", Width: 1, Height: 1, Data: []byte("a"), Created: time.Unix(1, 0), Creator: "me", Description: "desc", Tags: []string{"b"}},
+			},
+			response: http.StatusOK,
+		},
+		{
+			name: "invalid tag",
+			args: args{
+				contentType: "application/json",
+				body:        `{"width":1,"height":1,"tags":["a b"]}`,
+			},
+			storeGetDocument: storeGetDocument{
+				doc: &canvas.Canvas{Name: "doc1", Width: 80, Height: 50},
+			},
+			storeSetDocument: storeSetDocument{
+				doc: mock.Anything,
+			},
+			response: http.StatusBadRequest,
+		},
+		{
+			name: "text upload",
+			args: args{
+				body:     "ab\nc\n",
+				filename: "diagram.txt",
+			},
+			storeGetDocument: storeGetDocument{
+				doc: &canvas.Canvas{Name: "doc1", Width: 80, Height: 50},
+			},
+			storeSetDocument: storeSetDocument{
+				doc: &canvas.Canvas{Name: "diagram", Width: 2, Height: 2, Data: []byte("abc-")},
+			},
+			response: http.StatusOK,
+		},
+		{
+			name: "unsupported upload",
+			args: args{
+				body:     "GIF89a",
+				filename: "diagram.gif",
+			},
+			storeGetDocument: storeGetDocument{
+				doc: &canvas.Canvas{Name: "doc1", Width: 80, Height: 50},
+			},
+			storeSetDocument: storeSetDocument{
+				doc: mock.Anything,
+			},
+			response: http.StatusUnsupportedMediaType,
+		},
+		{
+			name: "concurrent update",
+			args: args{
+				contentType: "text/plain",
+				body:        "ab",
+			},
+			storeGetDocument: storeGetDocument{
+				doc: &canvas.Canvas{Name: "doc1", Width: 80, Height: 50, Revision: 4},
+			},
+			storeSetDocument: storeSetDocument{
+				doc: mock.Anything,
+				err: datastore.Conflict,
+			},
+			response: http.StatusConflict,
+		},
+		{
+			name: "not found",
+			args: args{
+				contentType: "text/plain",
+				body:        "ab",
+			},
+			storeGetDocument: storeGetDocument{
+				err: datastore.NotFound,
+			},
+			storeSetDocument: storeSetDocument{
+				doc: mock.Anything,
+			},
+			response: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testSrv := testServer(t)
+
+			testSrv.storeMock.On("GetDocument", "123", mock.Anything).Return(tt.storeGetDocument.doc, tt.storeGetDocument.err)
+			testSrv.storeMock.On("CompareAndSwapDocument", "123", mock.Anything, matchDocument(tt.storeSetDocument.doc), mock.Anything).Return(tt.storeSetDocument.err)
+			testSrv.storeMock.On("AddOperation", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+			w := httptest.NewRecorder()
+
+			contentType, body := tt.args.contentType, bytes.NewBufferString(tt.args.body)
+			if tt.args.filename != "" {
+				contentType, body = multipartBody(t, tt.args.filename, tt.args.body)
+			}
+
+			req := httptest.NewRequest(http.MethodPut, "/v1/docs/123", body)
+			req.Header.Set("Content-Type", contentType)
+
+			testSrv.server.router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.response, w.Code)
+		})
+	}
+}
+
+func TestServer_deleteDocument(t *testing.T) {
+	type storeDeleteDocument struct {
+		docID string
+		err   error
+	}
+	tests := []struct {
+		name                string
+		storeDeleteDocument storeDeleteDocument
+		response            int
+		checkBody           bool
+	}{
+		{
+			name: "ok",
+			storeDeleteDocument: storeDeleteDocument{
+				docID: "123",
+				err:   nil,
+			},
+			response:  http.StatusNoContent,
+			checkBody: true,
+		},
+		{
+			name: "not found",
+			storeDeleteDocument: storeDeleteDocument{
+				docID: "123",
+				err:   datastore.NotFound,
+			},
+			response:  http.StatusNotFound,
+			checkBody: false,
+		},
+		{
+			name: "store error",
+			storeDeleteDocument: storeDeleteDocument{
+				docID: "123",
+				err:   xerrors.New("FAILED"),
+			},
+			response:  http.StatusInternalServerError,
+			checkBody: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testSrv := testServer(t)
+
+			testSrv.storeMock.On("TrashDocument", tt.storeDeleteDocument.docID, mock.Anything, mock.Anything).Return(tt.storeDeleteDocument.err)
+			w := httptest.NewRecorder()
+
+			testSrv.server.router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/v1/docs/123", strings.NewReader("")))
+
+			assert.Equal(t, tt.response, w.Code)
+		})
+	}
+}
+
+func TestServer_restoreDocument(t *testing.T) {
+	tests := []struct {
+		name     string
+		doc      *canvas.Canvas
+		err      error
+		response int
+	}{
+		{name: "ok", doc: &canvas.Canvas{Width: 1, Height: 1, Revision: 2}, response: http.StatusOK},
+		{name: "not in trash", err: datastore.NotFound, response: http.StatusNotFound},
+		{name: "key in use", err: datastore.Exists, response: http.StatusConflict},
+		{name: "store error", err: xerrors.New("FAILED"), response: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testSrv := testServer(t)
+
+			testSrv.storeMock.On("RestoreDocument", "123", mock.Anything, mock.Anything).Return(tt.doc, tt.err)
+			w := httptest.NewRecorder()
+
+			testSrv.server.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/trash/123/restore", strings.NewReader("")))
+
+			assert.Equal(t, tt.response, w.Code)
 		})
 	}
 }
+
+func TestServer_Operations(t *testing.T) {
+	type storeUpdateCommand struct {
+		doc *canvas.Canvas
+		err error
+		// addErr is returned when the operation is recorded.
+		addErr error
+	}
+	type args struct {
+		operation string
+		body      string
+	}
+	type response struct {
+		code    int
+		warning string
+	}
+	tests := []struct {
+		name          string
+		args          args
+		updateCommand *storeUpdateCommand
+		response      response
+	}{
+		{
+			name: "rect ok",
+			args: args{
+				operation: "rect",
+				body:      `{"rect":{"origin":{"x":2,"y":3},"width":4,"height":5},"fill":"X","outline":"@"}`,
+			},
+			updateCommand: &storeUpdateCommand{
+				doc: &canvas.Canvas{
+					Name:     "doc1",
+					Width:    10,
+					Height:   10,
+					Revision: 1,
+				},
+				err: nil,
+			},
+			response: response{
+				code: http.StatusOK,
+			},
+		},
+		{
+			name: "rect - missing parameters",
+			args: args{
+				operation: "rect",
+				body:      `{"rect":{"origin":{"x":5,"y":5},"width":10,"height":4}}`,
+			},
+			updateCommand: nil,
+			response: response{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			name: "rect - too large",
+			args: args{
+				operation: "rect",
+				body:      `{"rect":{"origin":{"x":5,"y":5},"width":10,"height":4},"fill":"X"}`,
+			},
+			updateCommand: &storeUpdateCommand{
+				err: canvas.ObjectTooLarge,
+			},
+			response: response{
+				code: http.StatusConflict,
+			},
+		},
+		{
+			name: "rect - not found",
+			args: args{
+				operation: "rect",
+				body:      `{"rect":{"origin":{"x":2,"y":3},"width":4,"height":5},"fill":"X"}`,
+			},
+			updateCommand: &storeUpdateCommand{
+				err: datastore.NotFound,
+			},
+			response: response{
+				code: http.StatusNotFound,
+			},
+		},
+		{
+			name: "rect - concurrent updates",
+			args: args{
+				operation: "rect",
+				body:      `{"rect":{"origin":{"x":2,"y":3},"width":4,"height":5},"fill":"X"}`,
+			},
+			updateCommand: &storeUpdateCommand{
+				err: datastore.Conflict,
+			},
+			response: response{
+				code: http.StatusConflict,
+			},
+		},
+		{
+			name: "rect - journal error",
+			args: args{
+				operation: "rect",
+				body:      `{"rect":{"origin":{"x":2,"y":3},"width":4,"height":5},"fill":"X"}`,
+			},
+			updateCommand: &storeUpdateCommand{
+				doc:    &canvas.Canvas{Width: 10, Height: 10, Revision: 1},
+				addErr: xerrors.New("FAILED"),
+			},
+			response: response{
+				code:    http.StatusOK,
+				warning: `199 - "revision 1 is missing from the document history"`,
+			},
+		},
+		{
+			name: "rect - store error",
+			args: args{
+				operation: "rect",
+				body:      `{"rect":{"origin":{"x":2,"y":3},"width":4,"height":5},"fill":"X"}`,
+			},
+			updateCommand: &storeUpdateCommand{
+				err: xerrors.New("FAILED"),
+			},
+			response: response{
+				code: http.StatusInternalServerError,
+			},
+		},
+		{
+			name: "fill ok",
+			args: args{
+				operation: "fill",
+				body:      `{"origin":{"x":5,"y":5},"fill":"X"}`,
+			},
+			updateCommand: &storeUpdateCommand{
+				doc: &canvas.Canvas{
+					Name:     "doc1",
+					Width:    10,
+					Height:   10,
+					Revision: 1,
+				},
+				err: nil,
+			},
+			response: response{
+				code: http.StatusOK,
+			},
+		},
+		{
+			name: "fill - missing fill",
+			args: args{
+				operation: "fill",
+				body:      `{"origin":{"x":5,"y":5}}`,
+			},
+			updateCommand: nil,
+			response: response{
+				code: http.StatusBadRequest,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testSrv := testServer(t)
+
+			if tt.updateCommand != nil {
+				testSrv.storeMock.On("UpdateDocument", "123", mock.Anything, datastore.AnyRevision, mock.Anything).Return(tt.updateCommand.doc, tt.updateCommand.err).Once()
+
+				if tt.updateCommand.err == nil {
+					testSrv.storeMock.On("AddOperation", "123", mock.Anything, mock.Anything).Return(tt.updateCommand.addErr).Once()
+				}
+			}
+			w := httptest.NewRecorder()
+
+			testSrv.server.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path.Join("/v1/docs/123/", tt.args.operation), strings.NewReader(tt.args.body)))
+
+			assert.Equal(t, tt.response.code, w.Code)
+			assert.Equal(t, tt.response.warning, w.Header().Get(headerWarning))
+			testSrv.storeMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestServer_shutdown(t *testing.T) {
+	testSrv := testServer(t)
+
+	testSrv.storeMock.On("Close").Return(nil).Once()
+
+	testSrv.server.shutdown(context.Background())
+
+	testSrv.storeMock.AssertExpectations(t)
+}
+
+func TestNew_UnknownDriver(t *testing.T) {
+	_, err := New(0, &datastore.Options{Driver: "nosql"})
+	assert.ErrorIs(t, err, datastore.UnknownDriver)
+}
+
+func TestServer_EmbeddedDatastores(t *testing.T) {
+	for _, driver := range []string{datastore.DriverMemory, datastore.DriverFile, datastore.DriverSQLite} {
+		t.Run(driver, func(t *testing.T) {
+			srv, err := New(0, &datastore.Options{Driver: driver, Path: filepath.Join(t.TempDir(), "store")})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			t.Cleanup(func() { _ = srv.store.Close() })
+
+			testDocumentLifecycle(t, srv)
+		})
+	}
+}
+
+func TestServer_ConcurrentDraws(t *testing.T) {
+	srv, err := New(0, &datastore.Options{Driver: datastore.DriverMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/docs/", strings.NewReader(`{"width":10,"height":3}`)))
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	docURL := w.Body.String()
+
+	// The draws are applied atomically by the store, none of them is lost.
+	var wg sync.WaitGroup
+
+	for i := 1; i <= 8; i++ {
+		wg.Add(1)
+
+		go func(x int) {
+			defer wg.Done()
+
+			body := fmt.Sprintf(`{"rect":{"origin":{"x":%d,"y":1},"width":1,"height":1},"fill":"#"}`, x)
+			w := httptest.NewRecorder()
+			srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path.Join(docURL, "rect"), strings.NewReader(body)))
+			assert.Equal(t, http.StatusOK, w.Code)
+		}(i)
+	}
+
+	wg.Wait()
+
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, docURL+"?format=txt", nil))
+	assert.Equal(t, "----------\n-########-\n----------\n", w.Body.String())
+}
+
+func TestServer_ConditionalRequests(t *testing.T) {
+	srv, err := New(0, &datastore.Options{Driver: datastore.DriverMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, target, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	w := do(http.MethodPost, "/v1/docs/", `{"width":4,"height":3}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	docURL := w.Body.String()
+	rect := `{"rect":{"origin":{"x":1,"y":1},"width":2,"height":1},"fill":"#"}`
+	fill := `{"origin":{"x":1,"y":1},"fill":"X"}`
+
+	w = do(http.MethodGet, docURL, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"0"`, w.Header().Get("ETag"))
+
+	w = do(http.MethodGet, docURL, "", "If-None-Match", `"0"`)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	w = do(http.MethodGet, docURL+"?format=txt", "", "If-None-Match", `"0"`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"0-txt"`, w.Header().Get("ETag"))
+
+	w = do(http.MethodPost, path.Join(docURL, "rect"), rect, "If-Match", `"0"`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+
+	w = do(http.MethodGet, docURL, "", "If-None-Match", `"0"`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = do(http.MethodPost, path.Join(docURL, "rect"), rect, "If-Match", `"0"`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = do(http.MethodPost, path.Join(docURL, "fill"), fill, "If-Match", `W/"1"`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = do(http.MethodPost, path.Join(docURL, "fill"), fill, "If-Match", `"5", "1-txt"`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+	w = do(http.MethodDelete, docURL, "", "If-Match", `"1"`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = do(http.MethodDelete, docURL, "", "If-Match", "*")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = do(http.MethodDelete, docURL, "", "If-Match", "*")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestServer_DocumentMetadata(t *testing.T) {
+	srv, err := New(0, &datastore.Options{Driver: datastore.DriverMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	create := func(body, caller string) string {
+		req := httptest.NewRequest(http.MethodPost, "/v1/docs/", strings.NewReader(body))
+		req.Header.Set("X-Caller-ID", caller)
+
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		return path.Base(w.Body.String())
+	}
+
+	list := func(query string) (int, []documentItem) {
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/docs/"+query, nil))
+
+		var page struct {
+			Items []documentItem `json:"items"`
+		}
+
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		}
+
+		return w.Code, page.Items
+	}
+
+	first := create(`{"name":"plan","width":2,"height":1,"tags":["draft","infra"],"description":"network"}`, "alice")
+	second := create(`{"name":"notes","width":2,"height":1,"tags":["draft"]}`, "bob")
+
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/docs/"+first+"/fill", strings.NewReader(`{"origin":{"x":0,"y":0},"fill":"#"}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	code, items := list("")
+	assert.Equal(t, http.StatusOK, code)
+
+	if assert.Len(t, items, 2) {
+		assert.Equal(t, first, items[0].ID)
+		assert.Equal(t, "plan", items[0].Name)
+		assert.Equal(t, "alice", items[0].Creator)
+		assert.Equal(t, "network", items[0].Description)
+		assert.Equal(t, []string{"draft", "infra"}, items[0].Tags)
+		assert.NotNil(t, items[0].Created)
+		assert.True(t, items[0].Updated.After(*items[0].Created))
+	}
+
+	code, items = list("?sort=updated&limit=1")
+	assert.Equal(t, http.StatusOK, code)
+
+	if assert.Len(t, items, 1) {
+		assert.Equal(t, first, items[0].ID)
+	}
+
+	_, items = list("?tag=infra")
+	assert.Len(t, items, 1)
+
+	_, items = list("?name=no")
+	if assert.Len(t, items, 1) {
+		assert.Equal(t, second, items[0].ID)
+	}
+
+	code, _ = list("?sort=name")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = list("?q=%21")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/docs/", strings.NewReader(`{"width":1,"height":1,"tags":[""]}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestServer_DocumentTTL(t *testing.T) {
+	srv, err := New(0, &datastore.Options{Driver: datastore.DriverMemory, Retention: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+
+		return w
+	}
+
+	ttl := func(docURL string) *int64 {
+		var resp struct {
+			TTL *int64 `json:"ttl"`
+		}
+
+		w := do(http.MethodGet, docURL, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+
+		return resp.TTL
+	}
+
+	// Documents are created with the default retention of the server, or the requested ttl.
+	w := do(http.MethodPost, "/v1/docs/", `{"width":2,"height":1}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	docURL := w.Body.String()
+	if remaining := ttl(docURL); assert.NotNil(t, remaining) {
+		assert.InDelta(t, 24*60*60, *remaining, 1)
+	}
+
+	w = do(http.MethodPost, "/v1/docs/?ttl=60", `{"width":2,"height":1}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	scratchURL := w.Body.String()
+	if remaining := ttl(scratchURL); assert.NotNil(t, remaining) {
+		assert.InDelta(t, 60, *remaining, 1)
+	}
+
+	w = do(http.MethodPost, "/v1/docs/?ttl=-1", `{"width":2,"height":1}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// The lifetime can be extended, or removed.
+	w = do(http.MethodPut, path.Join(scratchURL, "ttl"), `{"ttl":3600}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"ttl":3600`)
+
+	if remaining := ttl(scratchURL); assert.NotNil(t, remaining) {
+		assert.InDelta(t, 3600, *remaining, 1)
+	}
+
+	w = do(http.MethodPut, path.Join(docURL, "ttl"), `{"ttl":0}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, ttl(docURL))
+
+	// Replacing a document keeps its retention.
+	w = do(http.MethodPut, scratchURL, `{"width":3,"height":1}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotNil(t, ttl(scratchURL))
+
+	w = do(http.MethodPut, path.Join(docURL, "ttl"), `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do(http.MethodPut, "/v1/docs/missing/ttl", `{"ttl":10}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestServer_Trash(t *testing.T) {
+	srv, err := New(0, &datastore.Options{Driver: datastore.DriverMemory, PurgeDelay: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader("")))
+
+		return w
+	}
+
+	trash := func() []trashItem {
+		var page struct {
+			Items []trashItem `json:"items"`
+		}
+
+		w := do(http.MethodGet, "/v1/trash/")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+
+		return page.Items
+	}
+
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/docs/", strings.NewReader(`{"name":"plan","width":2,"height":1}`)))
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	docURL := w.Body.String()
+	docID := path.Base(docURL)
+
+	assert.Empty(t, trash())
+
+	// Deleted documents are moved to the trash.
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, docURL).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, docURL).Code)
+
+	items := trash()
+	if assert.Len(t, items, 1) {
+		assert.Equal(t, docID, items[0].ID)
+		assert.Equal(t, "plan", items[0].Name)
+		assert.Equal(t, "/v1/trash/"+docID+"/restore", items[0].Restore)
+		assert.False(t, items[0].Deleted.IsZero())
+
+		if assert.NotNil(t, items[0].Purge) {
+			assert.Equal(t, items[0].Deleted.Add(24*time.Hour), *items[0].Purge)
+		}
+	}
+
+	// And restored from it.
+	w = do(http.MethodPost, "/v1/trash/"+docID+"/restore")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, docURL, w.Header().Get("Location"))
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, docURL).Code)
+	assert.Empty(t, trash())
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/v1/trash/"+docID+"/restore").Code)
+
+	// Or purged.
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, docURL).Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/v1/trash/"+docID).Code)
+	assert.Empty(t, trash())
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/v1/trash/"+docID).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/v1/trash/"+docID+"/restore").Code)
+}
+
+func TestServer_Undo(t *testing.T) {
+	srv, err := New(0, &datastore.Options{Driver: datastore.DriverMemory, UndoDepth: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(caller, method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+
+		if caller != "" {
+			r.Header.Set(headerCaller, caller)
+		}
+
+		srv.router.ServeHTTP(w, r)
+
+		return w
+	}
+
+	rows := func(w *httptest.ResponseRecorder) []string {
+		var doc canvas.Canvas
+
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+
+		return doc.Split()
+	}
+
+	rect := func(x uint, fill string) string {
+		return fmt.Sprintf(`{"rect":{"origin":{"x":%d,"y":1},"width":2,"height":1},"fill":%q}`, x, fill)
+	}
+
+	w := do("alice", http.MethodPost, "/v1/docs/", `{"name":"plan","width":6,"height":2}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	docURL := w.Body.String()
+
+	assert.Equal(t, http.StatusConflict, do("alice", http.MethodPost, docURL+"/undo", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do("", http.MethodPost, docURL+"/undo", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("alice", http.MethodPost, "/v1/docs/unknown/undo", "").Code)
+
+	assert.Equal(t, []string{"------", "-aa---"}, rows(do("alice", http.MethodPost, docURL+"/rect", rect(1, "a"))))
+	assert.Equal(t, []string{"------", "-abb--"}, rows(do("bob", http.MethodPost, docURL+"/rect", rect(2, "b"))))
+
+	// Undoing an operation keeps the cells changed since by others.
+	assert.Equal(t, []string{"------", "--bb--"}, rows(do("alice", http.MethodPost, docURL+"/undo", "")))
+	assert.Equal(t, http.StatusConflict, do("alice", http.MethodPost, docURL+"/undo", "").Code)
+
+	assert.Equal(t, []string{"------", "-abb--"}, rows(do("alice", http.MethodPost, docURL+"/redo", "")))
+	assert.Equal(t, http.StatusConflict, do("alice", http.MethodPost, docURL+"/redo", "").Code)
+
+	assert.Equal(t, []string{"------", "-aa---"}, rows(do("bob", http.MethodPost, docURL+"/undo", "")))
+
+	// A new operation clears the operations to redo.
+	assert.Equal(t, []string{"------", "-aa-cc"}, rows(do("bob", http.MethodPost, docURL+"/rect", rect(4, "c"))))
+	assert.Equal(t, http.StatusConflict, do("bob", http.MethodPost, docURL+"/redo", "").Code)
+
+	// Only the last operations can be undone.
+	assert.Equal(t, []string{"------", "-dd-cc"}, rows(do("alice", http.MethodPost, docURL+"/rect", rect(1, "d"))))
+	assert.Equal(t, []string{"------", "-ee-cc"}, rows(do("alice", http.MethodPost, docURL+"/rect", rect(1, "e"))))
+	assert.Equal(t, []string{"------", "-dd-cc"}, rows(do("alice", http.MethodPost, docURL+"/undo", "")))
+	assert.Equal(t, []string{"------", "-aa-cc"}, rows(do("alice", http.MethodPost, docURL+"/undo", "")))
+	assert.Equal(t, http.StatusConflict, do("alice", http.MethodPost, docURL+"/undo", "").Code)
+
+	// Replacing the document clears the operations to undo.
+	assert.Equal(t, http.StatusOK, do("bob", http.MethodPut, docURL, `{"name":"plan","width":6,"height":2}`).Code)
+	assert.Equal(t, http.StatusConflict, do("bob", http.MethodPost, docURL+"/undo", "").Code)
+}
+
+func TestCallerHistory_Trimmed(t *testing.T) {
+	draw := func(caller string, revision uint64) *canvas.Operation {
+		op := canvas.NewFill(canvas.Point{}, "x")
+		op.Caller, op.Revision, op.Patch = caller, revision, &canvas.Patch{Before: []byte("-"), After: []byte("x")}
+
+		return op
+	}
+
+	first, second := draw("alice", 1), draw("alice", 2)
+
+	// The undo is built from the journal before a concurrent write trims its first operation.
+	undo := canvas.NewUndo(1, second)
+	ops := []*canvas.Operation{second, draw("bob", 3), undo, canvas.NewUndo(1, second)}
+
+	h := callerHistory(ops, "alice", 2)
+	assert.Empty(t, h.done)
+	assert.Equal(t, []int{0}, h.undone)
+
+	// Operations recorded without revision by a previous version are referenced by index.
+	first.Revision, second.Revision = 0, 0
+	ops = []*canvas.Operation{first, second, canvas.NewUndo(1, second)}
+
+	h = callerHistory(ops, "alice", 2)
+	assert.Equal(t, []int{0}, h.done)
+	assert.Equal(t, []int{1}, h.undone)
+}
+
+func TestServer_Revisions(t *testing.T) {
+	srv, err := New(0, &datastore.Options{Driver: datastore.DriverMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(caller, method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set(headerCaller, caller)
+		srv.router.ServeHTTP(w, r)
+
+		return w
+	}
+
+	rows := func(w *httptest.ResponseRecorder) []string {
+		var doc struct {
+			Canvas canvas.Canvas
+		}
+
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+
+		return doc.Canvas.Split()
+	}
+
+	w := do("alice", http.MethodPost, "/v1/docs/", `{"name":"plan","width":4,"height":2}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	docURL := w.Body.String()
+
+	require.Equal(t, http.StatusOK, do("alice", http.MethodPost, docURL+"/rect", `{"rect":{"origin":{"x":1,"y":1},"width":2,"height":1},"fill":"#"}`).Code)
+	require.Equal(t, http.StatusOK, do("bob", http.MethodPost, docURL+"/fill", `{"origin":{"x":1,"y":1},"fill":"@"}`).Code)
+
+	assert.Equal(t, []string{"----", "-@@-"}, rows(do("", http.MethodGet, docURL, "")))
+	assert.Equal(t, []string{"----", "-##-"}, rows(do("", http.MethodGet, docURL+"?rev=1", "")))
+	assert.Equal(t, []string{"----", "----"}, rows(do("", http.MethodGet, docURL+"?rev=0", "")))
+
+	w = do("", http.MethodGet, docURL+"?rev=1", "")
+	assert.Equal(t, `"1"`, w.Header().Get(headerETag))
+	assert.NotContains(t, w.Body.String(), `"ttl"`)
+
+	assert.Equal(t, http.StatusNotFound, do("", http.MethodGet, docURL+"?rev=3", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do("", http.MethodGet, docURL+"?rev=last", "").Code)
+
+	// Reverting records a new revision with the content of the past one.
+	w = do("carol", http.MethodPost, docURL+"/revert?rev=1", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"3"`, w.Header().Get(headerETag))
+	assert.Equal(t, []string{"----", "-##-"}, rows(do("", http.MethodGet, docURL, "")))
+
+	assert.Equal(t, http.StatusBadRequest, do("carol", http.MethodPost, docURL+"/revert", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("carol", http.MethodPost, docURL+"/revert?rev=9", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("carol", http.MethodPost, "/v1/docs/unknown/revert?rev=0", "").Code)
+
+	var page struct {
+		Count int            `json:"count"`
+		Items []revisionItem `json:"items"`
+	}
+
+	w = do("", http.MethodGet, docURL+"/revisions", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Equal(t, 4, page.Count)
+
+	for i, want := range []struct{ op, caller string }{
+		{canvas.OpSnapshot, "alice"},
+		{canvas.OpRect, "alice"},
+		{canvas.OpFill, "bob"},
+		{canvas.OpRevert, "carol"},
+	} {
+		assert.Equal(t, uint64(i), page.Items[i].Revision)
+		assert.Equal(t, want.op, page.Items[i].Type)
+		assert.Equal(t, want.caller, page.Items[i].Caller)
+		assert.Equal(t, fmt.Sprintf("%s?rev=%d", docURL, i), page.Items[i].Href)
+	}
+
+	if assert.NotNil(t, page.Items[3].Source) {
+		assert.Equal(t, uint64(1), *page.Items[3].Source)
+	}
+
+	// Changing the ttl records a revision too.
+	w = do("dave", http.MethodPut, docURL+"/ttl", `{"ttl":3600}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"4"`, w.Header().Get(headerETag))
+
+	w = do("", http.MethodGet, docURL+"/revisions", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Equal(t, 5, page.Count)
+	assert.Equal(t, canvas.OpSnapshot, page.Items[4].Type)
+	assert.Equal(t, "dave", page.Items[4].Caller)
+	assert.Equal(t, []string{"----", "-##-"}, rows(do("", http.MethodGet, docURL+"?rev=4", "")))
+
+	assert.Equal(t, http.StatusNotFound, do("", http.MethodGet, "/v1/docs/unknown/revisions", "").Code)
+}
+
+func TestServer_RevisionsTrimmed(t *testing.T) {
+	srv, err := New(0, &datastore.Options{Driver: datastore.DriverMemory, UndoDepth: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set(headerCaller, "alice")
+		srv.router.ServeHTTP(w, r)
+
+		return w
+	}
+
+	w := do(http.MethodPost, "/v1/docs/", `{"name":"plan","width":1,"height":1}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	docURL := w.Body.String()
+	last := historyLength + checkpointInterval + 1
+
+	for i := 1; i <= last; i++ {
+		fill := string(rune('a' + i%26))
+		require.Equal(t, http.StatusOK, do(http.MethodPost, docURL+"/fill", `{"origin":{"x":0,"y":0},"fill":"`+fill+`"}`).Code)
+	}
+
+	// The journal is trimmed at the checkpoint preceding the last historyLength revisions.
+	oldest := last - 1 - historyLength
+
+	w = do(http.MethodGet, fmt.Sprintf("%s?rev=%d&format=txt", docURL, oldest), "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, string(rune('a'+oldest%26))+"\n", w.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, fmt.Sprintf("%s?rev=%d", docURL, oldest-1), "").Code)
+
+	var page struct {
+		Count int `json:"count"`
+	}
+
+	w = do(http.MethodGet, docURL+"/revisions", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, last-oldest+1, page.Count)
+
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, docURL+"/replay?format=cast", "").Code)
+
+	// The operations kept can still be undone.
+	w = do(http.MethodPost, docURL+"/undo", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = do(http.MethodGet, docURL+"?format=txt", "")
+	assert.Equal(t, string(rune('a'+(last-1)%26))+"\n", w.Body.String())
+}
+
+func TestServer_RevisionsTrimmedBySnapshot(t *testing.T) {
+	srv, err := New(0, &datastore.Options{Driver: datastore.DriverMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+
+		return w
+	}
+
+	w := do(http.MethodPost, "/v1/docs/", `{"name":"plan","width":1,"height":1}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	docURL := w.Body.String()
+	last := historyLength + checkpointInterval
+
+	for i := 1; i < last; i++ {
+		require.Equal(t, http.StatusOK, do(http.MethodPost, docURL+"/fill", `{"origin":{"x":0,"y":0},"fill":"#"}`).Code)
+	}
+
+	// A snapshot landing on a checkpoint revision trims the journal too.
+	require.Equal(t, http.StatusOK, do(http.MethodPut, docURL, `{"name":"plan","width":1,"height":1}`).Code)
+
+	var page struct {
+		Count int `json:"count"`
+	}
+
+	w = do(http.MethodGet, docURL+"/revisions", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, historyLength+1, page.Count)
+}
+
+func TestServer_Diff(t *testing.T) {
+	srv, err := New(0, &datastore.Options{Driver: datastore.DriverMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+
+		return w
+	}
+
+	type diff struct {
+		From    diffSide           `json:"from"`
+		To      diffSide           `json:"to"`
+		Changed int                `json:"changed"`
+		Regions []canvas.Rectangle `json:"regions"`
+	}
+
+	get := func(target string) diff {
+		var d diff
+
+		w := do(http.MethodGet, target, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, mediaTypeJSON, w.Header().Get("Content-Type"))
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &d))
+
+		return d
+	}
+
+	w := do(http.MethodPost, "/v1/docs/", `{"name":"plan","width":6,"height":3}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	docURL := w.Body.String()
+	docID := path.Base(docURL)
+
+	require.Equal(t, http.StatusOK, do(http.MethodPost, docURL+"/rect", `{"rect":{"origin":{"x":1,"y":1},"width":2,"height":1},"fill":"#"}`).Code)
+	require.Equal(t, http.StatusOK, do(http.MethodPost, docURL+"/rect", `{"rect":{"origin":{"x":4,"y":1},"width":1,"height":2},"fill":"@"}`).Code)
+
+	// The last revision by default.
+	d := get(docURL + "/diff")
+	assert.Equal(t, uint64(1), d.From.Revision)
+	assert.Equal(t, uint64(2), d.To.Revision)
+	assert.Equal(t, docURL+"?rev=1", d.From.Href)
+	assert.Equal(t, []canvas.Rectangle{{Origin: canvas.Point{X: 4, Y: 1}, Width: 1, Height: 2}}, d.Regions)
+
+	d = get(docURL + "/diff?from=0&to=2")
+	assert.Equal(t, 4, d.Changed)
+	assert.Len(t, d.Regions, 2)
+
+	w = do(http.MethodGet, docURL+"/diff?from=0&to=1&format=txt", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "@@ x=1 y=1 width=2 height=1 @@\n---\n+##\n", w.Body.String())
+
+	w = do(http.MethodGet, docURL+"/diff?format=png", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+
+	img, err := png.Decode(w.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 6*raster.CellWidth, img.Bounds().Dx())
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, docURL+"/diff?from=0&to=3", "").Code)
+	assert.Equal(t, http.StatusNotAcceptable, do(http.MethodGet, docURL+"/diff?format=bmp", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, docURL+"/diff?from=first", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/v1/docs/unknown/diff", "").Code)
+
+	// Documents are compared with each other.
+	w = do(http.MethodPost, "/v1/docs/", `{"name":"copy","width":6,"height":3}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	otherID := path.Base(w.Body.String())
+
+	d = get("/v1/diff?a=" + otherID + "&b=" + docID)
+	assert.Equal(t, otherID, d.From.ID)
+	assert.Equal(t, docID, d.To.ID)
+	assert.Equal(t, 4, d.Changed)
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/v1/diff?a="+docID, "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/v1/diff?a="+docID+"&b=unknown", "").Code)
+}
+
+func TestServer_ForkMerge(t *testing.T) {
+	srv, err := New(0, &datastore.Options{Driver: datastore.DriverMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+
+		return w
+	}
+
+	text := func(docURL string) string {
+		w := do(http.MethodGet, docURL+"?format=txt", "")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		return w.Body.String()
+	}
+
+	w := do(http.MethodPost, "/v1/docs/", `{"name":"plan","width":6,"height":3}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	docURL := w.Body.String()
+	docID := path.Base(docURL)
+
+	require.Equal(t, http.StatusOK, do(http.MethodPost, docURL+"/rect", `{"rect":{"origin":{"x":1,"y":1},"width":1,"height":1},"fill":"a"}`).Code)
+
+	// Forks are copies of the original, at its current or a past revision.
+	w = do(http.MethodPost, docURL+"/fork?rev=0", "")
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "------\n------\n------\n", text(w.Body.String()))
+
+	w = do(http.MethodPost, docURL+"/fork", "")
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	forkURL := w.Body.String()
+	forkID := path.Base(forkURL)
+
+	var fork struct {
+		Canvas canvas.Canvas
+	}
+
+	w = do(http.MethodGet, forkURL, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &fork))
+	assert.Equal(t, "plan", fork.Canvas.Name)
+	assert.Equal(t, &canvas.Parent{ID: docID, Revision: 1}, fork.Canvas.Parent)
+
+	// Both sides change the document, one cell differently.
+	require.Equal(t, http.StatusOK, do(http.MethodPost, forkURL+"/rect", `{"rect":{"origin":{"x":2,"y":1},"width":3,"height":1},"fill":"#"}`).Code)
+	require.Equal(t, http.StatusOK, do(http.MethodPost, docURL+"/rect", `{"rect":{"origin":{"x":4,"y":1},"width":1,"height":2},"fill":"@"}`).Code)
+
+	w = do(http.MethodPost, forkURL+"/merge", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var merge struct {
+		From      diffSide           `json:"from"`
+		Into      diffSide           `json:"into"`
+		Merged    int                `json:"merged"`
+		Conflicts []canvas.Rectangle `json:"conflicts"`
+	}
+
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &merge))
+	assert.Equal(t, forkID, merge.From.ID)
+	assert.Equal(t, docID, merge.Into.ID)
+	assert.Equal(t, uint64(3), merge.Into.Revision)
+	assert.Equal(t, 2, merge.Merged)
+	assert.Equal(t, []canvas.Rectangle{{Origin: canvas.Point{X: 4, Y: 1}, Width: 1, Height: 1}}, merge.Conflicts)
+	assert.Equal(t, "------\n-a##@-\n----@-\n", text(docURL))
+	assert.Equal(t, "------\n-a###-\n------\n", text(forkURL))
+
+	// The merge is in the history of the original.
+	w = do(http.MethodGet, docURL+"/revisions", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"type":"merge"`)
+
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, docURL+"/merge", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, docURL+"/fork?rev=9", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/v1/docs/unknown/fork", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/v1/docs/unknown/merge", "").Code)
+}
+
+func TestServer_Batch(t *testing.T) {
+	srv, err := New(0, &datastore.Options{Driver: datastore.DriverMemory, UndoDepth: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(headerCaller, "alice")
+		srv.router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	text := func(docURL string) string {
+		w := do(http.MethodGet, docURL+"?format=txt", "")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		return w.Body.String()
+	}
+
+	type response struct {
+		Results []opResult     `json:"results"`
+		Canvas  *canvas.Canvas `json:"canvas"`
+	}
+
+	w := do(http.MethodPost, "/v1/docs/", `{"name":"plan","width":5,"height":4}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	docURL := w.Body.String()
+
+	w = do(http.MethodPost, docURL+"/ops", `{"ops":[
+		{"type":"rect","rect":{"origin":{"x":1,"y":1},"width":3,"height":3},"outline":"#"},
+		{"type":"fill","origin":{"x":2,"y":2},"fill":"."},
+		{"type":"rect","rect":{"origin":{"x":2,"y":1},"width":1,"height":1},"fill":"@"}
+	]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp response
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Results, 3)
+	assert.Equal(t, opResult{Index: 1, Type: canvas.OpFill, Status: opApplied, Changed: 1,
+		Region: &canvas.Rectangle{Origin: canvas.Point{X: 2, Y: 2}, Width: 1, Height: 1}}, resp.Results[1])
+	assert.Equal(t, uint64(1), resp.Canvas.Revision)
+	assert.Equal(t, `"1"`, w.Header().Get(headerETag))
+	assert.Equal(t, "-----\n-#@#-\n-#.#-\n-###-\n", text(docURL))
+
+	// A failing operation rolls back the whole batch.
+	w = do(http.MethodPost, docURL+"/ops", `{"ops":[
+		{"type":"fill","origin":{"x":2,"y":2},"fill":"~"},
+		{"type":"rect","rect":{"origin":{"x":3,"y":1},"width":5,"height":1},"fill":"*"},
+		{"type":"fill","origin":{"x":2,"y":1},"fill":"+"}
+	]}`)
+	require.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+
+	resp = response{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Nil(t, resp.Canvas)
+	assert.Equal(t, []string{opApplied, opFailed, opSkipped},
+		[]string{resp.Results[0].Status, resp.Results[1].Status, resp.Results[2].Status})
+	assert.Equal(t, canvas.ObjectTooLarge.Error(), resp.Results[1].Error)
+	assert.Equal(t, "-----\n-#@#-\n-#.#-\n-###-\n", text(docURL))
+
+	// Invalid operations are reported before anything is applied.
+	w = do(http.MethodPost, docURL+"/ops", `{"ops":[{"type":"fill","origin":{"x":0,"y":0}},{"type":"erase"}]}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	resp = response{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, opFailed, resp.Results[0].Status)
+	assert.Equal(t, `"erase": unsupported operation type`, resp.Results[1].Error)
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, docURL+"/ops", `{"ops":[]}`).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/v1/docs/unknown/ops", `{"ops":[{"type":"fill","origin":{"x":0,"y":0},"fill":"."}]}`).Code)
+
+	// The batch is undone at once.
+	require.Equal(t, http.StatusOK, do(http.MethodPost, docURL+"/undo", "").Code)
+	assert.Equal(t, "-----\n-----\n-----\n-----\n", text(docURL))
+}
+
+func TestServer_Script(t *testing.T) {
+	srv, err := New(0, &datastore.Options{Driver: datastore.DriverMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+
+		return w
+	}
+
+	w := do(http.MethodPost, "/v1/docs/", `{"name":"plan","width":8,"height":4}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	docURL := w.Body.String()
+
+	w = do(http.MethodPost, docURL+"/script", "# The box.\nRECT 0 0 8 3 outline=#\nTEXT 2 1 \"api\"\n\nLINE 0 3 7 3 =\n")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp struct {
+		Results []opResult     `json:"results"`
+		Canvas  *canvas.Canvas `json:"canvas"`
+	}
+
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Results, 3)
+	assert.Equal(t, []int{2, 3, 5}, []int{resp.Results[0].Line, resp.Results[1].Line, resp.Results[2].Line})
+	assert.Equal(t, []string{"########", "#-api--#", "########", "========"}, resp.Canvas.Split())
+
+	// Syntax errors are reported with their position, and nothing is applied.
+	w = do(http.MethodPost, docURL+"/script", "FILL 1 1 @\nRECT 1 1 x 1 fill=#\nDRAW 1 1")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	var prob problem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &prob))
+	assert.Equal(t, "invalid-script", prob.Code)
+	assert.Equal(t, []scriptError{
+		{Line: 2, Column: 10, Message: `"x": expected a number`},
+		{Line: 3, Column: 1, Message: `"DRAW": unknown command`},
+	}, prob.Errors)
+
+	// Failing commands roll back the whole script.
+	w = do(http.MethodPost, docURL+"/script", "FILL 1 1 .\nTEXT 4 1 \"too long\"")
+	require.Equal(t, http.StatusConflict, w.Code)
+
+	resp.Results = nil
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, opResult{Index: 1, Line: 2, Type: canvas.OpText, Status: opFailed, Error: canvas.ObjectTooLarge.Error()}, resp.Results[1])
+
+	w = do(http.MethodGet, docURL+"?format=txt", "")
+	assert.Equal(t, "########\n#-api--#\n########\n========\n", w.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, docURL+"/script", "# nothing to do").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/v1/docs/unknown/script", "FILL 1 1 .").Code)
+}
+
+func TestServer_DryRun(t *testing.T) {
+	srv, err := New(0, &datastore.Options{Driver: datastore.DriverMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+
+		srv.router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	w := do(http.MethodPost, "/v1/docs/", `{"name":"plan","width":5,"height":4}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	docURL := w.Body.String()
+	rect := `{"rect":{"origin":{"x":1,"y":1},"width":3,"height":3},"outline":"#"}`
+
+	w = do(http.MethodPost, docURL+"/rect?dryRun=true", rect)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp previewResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 8, resp.Changed)
+	assert.Equal(t, &canvas.Rectangle{Origin: canvas.Point{X: 1, Y: 1}, Width: 3, Height: 3}, resp.Region)
+	assert.Equal(t, []string{"-----", "-###-", "-#-#-", "-###-"}, resp.Canvas.Split())
+	assert.Empty(t, w.Header().Get(headerETag))
+
+	// Only the changed region.
+	w = do(http.MethodPost, docURL+"/fill?dryRun=true&preview=region", `{"origin":{"x":0,"y":0},"fill":"."}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	resp = previewResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 20, resp.Changed)
+	assert.Equal(t, []string{".....", ".....", ".....", "....."}, resp.Canvas.Split())
+
+	w = do(http.MethodPost, docURL+"/script?dryRun=true", "FILL 0 0 .\n\nTEXT 4 1 \"too long\"")
+	require.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+
+	resp = previewResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Nil(t, resp.Canvas)
+	assert.Equal(t, opResult{Index: 1, Line: 3, Type: canvas.OpText, Status: opFailed, Error: canvas.ObjectTooLarge.Error()}, resp.Results[1])
+
+	w = do(http.MethodPost, docURL+"/ops?dryRun=1", `{"ops":[{"type":"text","origin":{"x":0,"y":0},"text":"hi"}]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// Nothing is saved.
+	w = do(http.MethodGet, docURL+"?format=txt", "")
+	assert.Equal(t, "-----\n-----\n-----\n-----\n", w.Body.String())
+	assert.Equal(t, `"0-txt"`, w.Header().Get(headerETag))
+
+	assert.Equal(t, http.StatusPreconditionFailed, do(http.MethodPost, docURL+"/rect?dryRun=true", rect, headerIfMatch, `"3"`).Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, docURL+"/rect?dryRun=true", rect, headerIfMatch, `"0"`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, docURL+"/rect?dryRun=maybe", rect).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, docURL+"/rect?dryRun=true&preview=diff", rect).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/v1/docs/unknown/rect?dryRun=true", rect).Code)
+
+	// Without dry run, the operation is applied.
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, docURL+"/rect?dryRun=false", rect).Code)
+
+	w = do(http.MethodGet, docURL+"?format=txt", "")
+	assert.Equal(t, "-----\n-###-\n-#-#-\n-###-\n", w.Body.String())
+}
+
+func TestServer_Problems(t *testing.T) {
+	srv, err := New(0, &datastore.Options{Driver: datastore.DriverMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+
+		srv.router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	w := do(http.MethodPost, "/v1/docs/", `{"name":"plan","width":5,"height":4}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	docURL := w.Body.String()
+
+	// Documents stored before their layers were validated may be malformed.
+	legacy := &canvas.Canvas{Name: "legacy", Width: 5, Height: 4, Data: []byte("-----"), Parent: &canvas.Parent{ID: path.Base(docURL)}}
+	require.NoError(t, srv.store.SetDocument("legacy", legacy, context.Background()))
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		body    string
+		headers []string
+		status  int
+		code    string
+		fields  []string
+	}{
+		{
+			name:   "point out of bound",
+			target: docURL + "/rect",
+			body:   `{"rect":{"origin":{"x":9,"y":1},"width":1,"height":1},"fill":"#"}`,
+			status: http.StatusConflict,
+			code:   "point-out-of-bound",
+		},
+		{
+			name:   "object too large",
+			target: docURL + "/rect",
+			body:   `{"rect":{"origin":{"x":1,"y":1},"width":9,"height":1},"fill":"#"}`,
+			status: http.StatusConflict,
+			code:   "object-too-large",
+		},
+		{
+			name:   "missing pattern",
+			target: docURL + "/rect",
+			body:   `{"rect":{"origin":{"x":1,"y":1},"width":1,"height":1}}`,
+			status: http.StatusBadRequest,
+			code:   "missing-pattern",
+			fields: []string{"fill", "outline"},
+		},
+		{
+			name:   "malformed data",
+			target: "/v1/docs/",
+			body:   `{"width":3,"height":2,"data":"YWI="}`,
+			status: http.StatusBadRequest,
+			code:   "malformed-data",
+			fields: []string{"data"},
+		},
+		{
+			name:   "malformed attrs",
+			target: "/v1/docs/",
+			body:   `{"width":3,"height":2,"data":"YWJjZGVm","attrs":"Bw=="}`,
+			status: http.StatusBadRequest,
+			code:   "malformed-attrs",
+			fields: []string{"attrs"},
+		},
+		{
+			name:   "malformed data on replace",
+			method: http.MethodPut,
+			target: docURL,
+			body:   `{"width":5,"height":4,"data":"YWJjZGVm"}`,
+			status: http.StatusBadRequest,
+			code:   "malformed-data",
+			fields: []string{"data"},
+		},
+		{
+			name:   "invalid field type",
+			target: docURL + "/fill",
+			body:   `{"origin":{"x":"one","y":1},"fill":"#"}`,
+			status: http.StatusBadRequest,
+			code:   "bad-request",
+			fields: []string{"origin.x"},
+		},
+		{
+			name:   "invalid query parameter",
+			target: docURL + "/fill?dryRun=maybe",
+			body:   `{"origin":{"x":1,"y":1},"fill":"#"}`,
+			status: http.StatusBadRequest,
+			code:   "invalid-dry-run",
+			fields: []string{"dryRun"},
+		},
+		{
+			name:   "invalid batch",
+			target: docURL + "/ops",
+			body:   `{"ops":[{"type":"fill","origin":{"x":0,"y":0}},{"type":"rect","rect":{"origin":{"x":0,"y":0},"width":1,"height":1},"fill":"#"},{"type":"erase"}]}`,
+			status: http.StatusBadRequest,
+			code:   "invalid-batch",
+			fields: []string{"ops[0].fill", "ops[2].type"},
+		},
+		{
+			name:    "revision mismatch",
+			target:  docURL + "/fill",
+			body:    `{"origin":{"x":1,"y":1},"fill":"#"}`,
+			headers: []string{headerIfMatch, `"7"`},
+			status:  http.StatusPreconditionFailed,
+			code:    "revision-mismatch",
+			fields:  []string{headerIfMatch},
+		},
+		{
+			name:   "document not found",
+			method: http.MethodGet,
+			target: "/v1/docs/unknown",
+			status: http.StatusNotFound,
+			code:   "document-not-found",
+		},
+		{
+			name:   "malformed document compared",
+			method: http.MethodGet,
+			target: "/v1/diff?a=legacy&b=" + path.Base(docURL),
+			status: http.StatusUnprocessableEntity,
+			code:   "malformed-data",
+			fields: []string{"data"},
+		},
+		{
+			name:   "malformed document merged",
+			target: "/v1/docs/legacy/merge",
+			status: http.StatusUnprocessableEntity,
+			code:   "malformed-data",
+			fields: []string{"data"},
+		},
+		{
+			name:    "text too large",
+			target:  "/v1/docs/",
+			body:    strings.Repeat("\n", 1<<20) + strings.Repeat("-", 1<<16),
+			headers: []string{"Content-Type", "text/plain"},
+			status:  http.StatusRequestEntityTooLarge,
+			code:    "object-too-large",
+		},
+		{
+			name:    "text line too long",
+			target:  "/v1/docs/",
+			body:    strings.Repeat("-", canvas.MaxTextCells+1),
+			headers: []string{"Content-Type", "text/plain"},
+			status:  http.StatusRequestEntityTooLarge,
+			code:    "object-too-large",
+		},
+		{
+			name:   "document too large",
+			target: "/v1/docs/",
+			body:   `{"width":1,"height":1,"name":"` + strings.Repeat("a", maxDocumentSize) + `"}`,
+			status: http.StatusRequestEntityTooLarge,
+			code:   "document-too-large",
+		},
+		{
+			name:    "not acceptable",
+			method:  http.MethodGet,
+			target:  docURL,
+			headers: []string{headerAccept, "image/png, application/pdf;q=0.5"},
+			status:  http.StatusNotAcceptable,
+			code:    "not-acceptable",
+			fields:  []string{headerAccept},
+		},
+		{
+			name:   "unknown revision",
+			method: http.MethodGet,
+			target: docURL + "?rev=3",
+			status: http.StatusNotFound,
+			code:   "unknown-revision",
+			fields: []string{paramRevision},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+
+			w := do(method, tt.target, tt.body, tt.headers...)
+			require.Equal(t, tt.status, w.Code, w.Body.String())
+			assert.Equal(t, mediaTypeProblem, w.Header().Get("Content-Type"))
+
+			var p problem
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+			assert.Equal(t, tt.status, p.Status)
+			assert.Equal(t, tt.code, p.Code)
+			assert.Equal(t, problemTypePrefix+tt.code, p.Type)
+			assert.Equal(t, tt.fields, p.Fields)
+			assert.Equal(t, http.StatusText(tt.status), p.Title)
+			assert.Equal(t, strings.SplitN(tt.target, "?", 2)[0], p.Instance)
+			assert.NotZero(t, p.RequestID)
+			assert.NotEmpty(t, p.Detail)
+		})
+	}
+}
+
+func testDocumentLifecycle(t *testing.T, srv *Server) {
+	t.Helper()
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+
+		return w
+	}
+
+	w := do(http.MethodPost, "/v1/docs/", `{"name":"doc","width":4,"height":3}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	docURL := w.Body.String()
+
+	w = do(http.MethodPost, path.Join(docURL, "rect"), `{"rect":{"origin":{"x":1,"y":1},"width":2,"height":1},"fill":"#"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = do(http.MethodGet, docURL+"?format=txt", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "----\n-##-\n----\n", w.Body.String())
+
+	w = do(http.MethodGet, "/v1/docs/", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"count":1`)
+
+	w = do(http.MethodGet, path.Join(docURL, "replay")+"?format=cast", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 3, strings.Count(w.Body.String(), "\n"))
+
+	w = do(http.MethodDelete, docURL, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = do(http.MethodGet, docURL, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/gorilla/mux"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/datastore"
+)
+
+// trashItem is a document of the trash, with the URI restoring it.
+type trashItem struct {
+	documentItem
+	Restore string    `json:"restore"`
+	Deleted time.Time `json:"deleted"`
+	// Purge is when the document will be purged, missing if the trash is only purged explicitly.
+	Purge *time.Time `json:"purge,omitempty"`
+}
+
+func (s *Server) getTrash(w http.ResponseWriter, r *http.Request) {
+	var (
+		url    = r.URL.Path
+		store  = s.getStore(r)
+		reqLog = log.
+			WithField("operation-id", "get-trash").
+			WithField("request-id", s.getRequestID(r))
+	)
+
+	reqLog.Debug("received get trash request")
+
+	infos, err := store.ListTrash(r.Context())
+	if err != nil {
+		reqLog.WithError(err).Error("failed to get trash from store")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+
+		return
+	}
+
+	items := make([]trashItem, 0, len(infos))
+
+	for _, info := range infos {
+		uri := path.Join(url, info.Key)
+		item := trashItem{
+			documentItem: newDocumentItem(info, uri),
+			Restore:      path.Join(uri, "restore"),
+			Deleted:      info.Deleted,
+		}
+
+		if delay := s.storeOptions.PurgeDelay; delay > 0 {
+			purge := info.Deleted.Add(delay)
+			item.Purge = &purge
+		}
+
+		items = append(items, item)
+	}
+
+	data, err := jsonMarshal(struct {
+		Count int         `json:"count"`
+		Items []trashItem `json:"items"`
+	}{
+		Count: len(items),
+		Items: items,
+	})
+	if err != nil {
+		reqLog.WithError(err).Error("failed to marshal response to json")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, err := w.Write(data); err != nil {
+		reqLog.WithError(err).Error("failed to write http response")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+	}
+}
+
+func (s *Server) restoreDocument(w http.ResponseWriter, r *http.Request) {
+	var (
+		store  = s.getStore(r)
+		vars   = mux.Vars(r)
+		docID  = vars["id"]
+		reqLog = log.
+			WithField("operation-id", "restore-doc").
+			WithField("request-id", s.getRequestID(r)).
+			WithField("doc-id", docID)
+	)
+
+	reqLog.Debug("received restore document request")
+
+	doc, err := store.RestoreDocument(docID, time.Now().UTC(), r.Context())
+	if err != nil {
+		switch err {
+		case datastore.NotFound:
+			reqLog.Info("document not found in trash")
+			s.writeProblem(w, r, http.StatusNotFound, datastore.NotFound)
+		case datastore.Exists, datastore.Conflict:
+			reqLog.WithError(err).Info("failed to restore document")
+			s.writeProblem(w, r, http.StatusConflict, err)
+		case err:
+			reqLog.WithError(err).Error("failed to restore document in store")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
+		}
+
+		return
+	}
+
+	reqLog.Infof("document restored")
+
+	data, err := jsonMarshal(doc)
+	if err != nil {
+		reqLog.WithError(err).Error("failed to marshal response to json")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", path.Join("/v1/docs", docID))
+	w.Header().Set(headerETag, documentETag(doc.Revision, formatJSON))
+
+	if _, err := w.Write(data); err != nil {
+		reqLog.WithError(err).Error("failed to write http response")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+	}
+}
+
+func (s *Server) purgeDocument(w http.ResponseWriter, r *http.Request) {
+	var (
+		store  = s.getStore(r)
+		vars   = mux.Vars(r)
+		docID  = vars["id"]
+		reqLog = log.
+			WithField("operation-id", "purge-doc").
+			WithField("request-id", s.getRequestID(r)).
+			WithField("doc-id", docID)
+	)
+
+	reqLog.Debug("received purge document request")
+
+	if err := store.PurgeDocument(docID, r.Context()); err != nil {
+		switch err {
+		case datastore.NotFound:
+			reqLog.Info("document not found in trash")
+			s.writeProblem(w, r, http.StatusNotFound, datastore.NotFound)
+		case err:
+			reqLog.WithError(err).Error("failed to purge document from store")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
+		}
+
+		return
+	}
+
+	reqLog.Infof("document purged")
+
+	w.WriteHeader(http.StatusNoContent)
+}
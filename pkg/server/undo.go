@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/apex/log"
+	"github.com/gorilla/mux"
+
+	"github.com/hexbee-net/sketch-canvas/pkg/canvas"
+	"github.com/hexbee-net/sketch-canvas/pkg/datastore"
+)
+
+// DefaultUndoDepth is the number of their last drawing operations the callers can undo on a document.
+const DefaultUndoDepth = 100
+
+// maxDrawAttempts is the number of times a drawing operation is attempted when the document is modified
+// between the read of its content and its update.
+const maxDrawAttempts = 5
+
+const (
+	NothingToUndo = Error("no operation to undo")
+	NothingToRedo = Error("no operation to redo")
+	UnknownCaller = Error("the X-Caller-ID header is required to undo and redo operations")
+)
+
+// history holds the undo and redo stacks of a caller on a document, as indexes of operations in its journal.
+type history struct {
+	done   []int
+	undone []int
+}
+
+// callerHistory rebuilds the undo and redo stacks of a caller from the journal of a document.
+// Only the operations of the caller are considered: a new operation clears its redo stack, and only its last
+// depth operations can be undone. Replacing, reverting or merging the content of the document clears all the stacks.
+func callerHistory(ops []*canvas.Operation, caller string, depth int) history {
+	var h history
+
+	for i, op := range ops {
+		switch {
+		case op.Type == canvas.OpSnapshot || op.Type == canvas.OpRevert || op.Type == canvas.OpMerge:
+			h = history{}
+		case op.Caller != caller:
+			continue
+		case op.Type == canvas.OpUndo:
+			// Concurrent requests can record an undo twice, only the first one moves the operation.
+			if n := len(h.done); n > 0 && op.Targets(h.done[n-1], ops[h.done[n-1]]) {
+				h.done, h.undone = h.done[:n-1], append(h.undone, h.done[n-1])
+			}
+		case op.Type == canvas.OpRedo:
+			if n := len(h.undone); n > 0 && op.Targets(h.undone[n-1], ops[h.undone[n-1]]) {
+				h.undone, h.done = h.undone[:n-1], append(h.done, h.undone[n-1])
+			}
+		case op.Patch != nil:
+			h.done, h.undone = append(h.done, i), nil
+			if len(h.done) > depth {
+				h.done = h.done[len(h.done)-depth:]
+			}
+		}
+	}
+
+	return h
+}
+
+// drawDocument applies a drawing operation to a document.
+// When the caller of the request can undo it, the document is read before the update to record the cells changed
+// by the operation in its patch, and the stores drawing in place don't read it back, see datastore.UpdateDocumentFrom.
+// Without expected revision, the operation is attempted again if the document is modified in between.
+func (s *Server) drawDocument(r *http.Request, store datastore.DataStore, docID string, op *canvas.Operation, revision uint64) (*canvas.Canvas, error) {
+	op.Caller = callerID(r)
+
+	if op.Caller == "" || s.storeOptions.UndoDepth <= 0 {
+		return store.UpdateDocument(docID, op, revision, r.Context())
+	}
+
+	for attempt := 1; ; attempt++ {
+		before, err := store.GetDocument(docID, r.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		if revision != datastore.AnyRevision && before.Revision != revision {
+			return nil, datastore.Conflict
+		}
+
+		doc, err := datastore.UpdateDocumentFrom(store, docID, op, before, r.Context())
+		if err == datastore.Conflict && revision == datastore.AnyRevision && attempt < maxDrawAttempts {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		op.Patch = canvas.NewPatch(before, doc)
+
+		return doc, nil
+	}
+}
+
+func (s *Server) undoOperation(w http.ResponseWriter, r *http.Request) {
+	s.moveOperation(w, r, canvas.OpUndo)
+}
+
+func (s *Server) redoOperation(w http.ResponseWriter, r *http.Request) {
+	s.moveOperation(w, r, canvas.OpRedo)
+}
+
+// moveOperation undoes the last operation of the caller of the request, or redoes its last undone operation.
+// The cells changed since by other callers are left untouched.
+func (s *Server) moveOperation(w http.ResponseWriter, r *http.Request, opType string) {
+	var (
+		store  = s.getStore(r)
+		vars   = mux.Vars(r)
+		docID  = vars["id"]
+		caller = callerID(r)
+		reqLog = log.
+			WithField("operation-id", opType).
+			WithField("request-id", s.getRequestID(r)).
+			WithField("doc-id", docID)
+	)
+
+	reqLog.Debugf("received %s request", opType)
+
+	if caller == "" {
+		reqLog.Info("caller of the request is unknown")
+		s.writeProblem(w, r, http.StatusBadRequest, UnknownCaller)
+
+		return
+	}
+
+	revision, ok := s.expectedRevision(w, r, store, docID, reqLog)
+	if !ok {
+		return
+	}
+
+	op, err := s.nextMove(store, docID, caller, opType, r.Context())
+	if err != nil {
+		switch err {
+		case datastore.NotFound:
+			reqLog.Info("document not found")
+			s.writeProblem(w, r, http.StatusNotFound, datastore.NotFound)
+		case NothingToUndo, NothingToRedo:
+			reqLog.WithField("caller", caller).Info(err.Error())
+			s.writeProblem(w, r, http.StatusConflict, err)
+		case err:
+			reqLog.WithError(err).Error("failed to retrieve operations from store")
+			s.writeProblem(w, r, http.StatusInternalServerError, nil)
+		}
+
+		return
+	}
+
+	doc, err := store.UpdateDocument(docID, op, revision, r.Context())
+	if err != nil {
+		s.writeUpdateError(w, r, err, reqLog)
+
+		return
+	}
+
+	s.recordOperation(w, r, store, docID, op, doc, reqLog)
+
+	data, err := jsonMarshal(doc)
+	if err != nil {
+		reqLog.WithError(err).Error("failed to marshal response to json")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(headerETag, documentETag(doc.Revision, formatJSON))
+
+	if _, err := w.Write(data); err != nil {
+		reqLog.WithError(err).Error("failed to write http response")
+		s.writeProblem(w, r, http.StatusInternalServerError, nil)
+	}
+}
+
+// nextMove returns the operation undoing or redoing the last operation of a caller on a document.
+func (s *Server) nextMove(store datastore.DataStore, docID, caller, opType string, ctx context.Context) (*canvas.Operation, error) {
+	// The journal of a missing document is empty, check the document exists to report it.
+	if _, err := store.GetDocument(docID, ctx); err != nil {
+		return nil, err
+	}
+
+	ops, err := store.GetOperations(docID, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	h := callerHistory(ops, caller, s.storeOptions.UndoDepth)
+
+	if opType == canvas.OpUndo {
+		if len(h.done) == 0 {
+			return nil, NothingToUndo
+		}
+
+		ref := h.done[len(h.done)-1]
+
+		return canvas.NewUndo(ref, ops[ref]), nil
+	}
+
+	if len(h.undone) == 0 {
+		return nil, NothingToRedo
+	}
+
+	ref := h.undone[len(h.undone)-1]
+
+	return canvas.NewRedo(ref, ops[ref]), nil
+}